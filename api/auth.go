@@ -0,0 +1,73 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"image-converting-server/auth"
+)
+
+// RequireAuth wraps the router so API requests need a key with the scope of
+// their endpoint; the key is passed on in the request context. / and /health
+// stay public, and so do /img/ and /api/image while URL signing is enabled,
+// since signed URLs are meant for browsers. It returns next only when
+// auth.disabled opened the API.
+func (h *Handler) RequireAuth(authn *auth.Authenticator, next http.Handler) http.Handler {
+	if authn.Disabled() {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scope, public := h.requiredScope(r)
+		if public {
+			next.ServeHTTP(w, r)
+			return
+		}
+		key, err := authn.Authenticate(r)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+			if errors.Is(err, auth.ErrMissingKey) {
+				h.sendError(w, http.StatusUnauthorized, "missing_api_key", "An API key is required (Authorization: Bearer or X-API-Key)")
+				return
+			}
+			h.sendError(w, http.StatusUnauthorized, "invalid_api_key", "The API key is invalid")
+			return
+		}
+		if !key.Allows(scope) {
+			h.sendError(w, http.StatusForbidden, "insufficient_scope", fmt.Sprintf("The API key lacks the '%s' scope", scope))
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(auth.NewContext(r.Context(), key)))
+	})
+}
+
+// requiredScope returns the scope the endpoint of r needs, or public for
+// endpoints open to everyone
+func (h *Handler) requiredScope(r *http.Request) (scope auth.Scope, public bool) {
+	path := r.URL.Path
+	switch {
+	case path == "/" || path == "/health":
+		return "", true
+	case path == "/api/image" || strings.HasPrefix(path, "/img/"):
+		if h.signer != nil {
+			return "", true
+		}
+		return auth.ScopeRead, false
+	case path == "/api/info" || path == "/api/compare":
+		return auth.ScopeRead, false
+	case strings.HasPrefix(path, "/api/jobs/") && r.Method == http.MethodGet:
+		return auth.ScopeRead, false
+	}
+	// Everything else may write to the bucket
+	return auth.ScopeConvert, false
+}
+
+// authorizeObject rejects R2 keys outside the prefixes of the request's API key
+func authorizeObject(ctx context.Context, objectKey string) *apiError {
+	if key, ok := auth.FromContext(ctx); ok && !key.AllowsObject(objectKey) {
+		return newAPIError(http.StatusForbidden, "key_not_allowed", fmt.Sprintf("The API key may not access '%s'", objectKey))
+	}
+	return nil
+}
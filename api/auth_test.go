@@ -0,0 +1,204 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"image-converting-server/auth"
+	"image-converting-server/config"
+	"image-converting-server/jobs"
+	"image-converting-server/processor"
+)
+
+func TestRequireAuth(t *testing.T) {
+	var buf bytes.Buffer
+	png.Encode(&buf, image.NewNRGBA(image.Rect(0, 0, 40, 20)))
+	imgData := buf.Bytes()
+
+	cfg := &config.Config{
+		R2: config.R2Config{Bucket: "test-bucket"},
+		Conversion: config.ConversionConfig{
+			Formats:   []string{"png"},
+			Quality:   80,
+			MaxSizeMB: 1,
+		},
+		Batch:     config.BatchConfig{MaxItems: 5, Concurrency: 1},
+		Jobs:      config.JobsConfig{MaxItems: 5},
+		Transform: config.TransformConfig{CachePrefix: "_variants", MaxDimension: 1000},
+	}
+	var mu sync.Mutex
+	var uploads []string
+	mockStorage := &mockStorageClient{
+		downloadFunc: func(ctx context.Context, key string) ([]byte, error) {
+			return imgData, nil
+		},
+		uploadFunc: func(ctx context.Context, key string, data []byte, contentType string) error {
+			mu.Lock()
+			defer mu.Unlock()
+			uploads = append(uploads, key)
+			return nil
+		},
+	}
+	h := NewHandler(mockStorage, processor.NewProcessor(*cfg), cfg)
+	queue, err := jobs.NewQueue(t.TempDir(), 1, 10, time.Hour, h.RunJob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.SetJobQueue(queue)
+	queue.Start()
+	defer queue.Stop()
+
+	authn, err := auth.New(config.AuthConfig{Keys: []config.APIKeyConfig{
+		{Name: "uploader", Hash: auth.HashKey("uploader-key"), Scopes: []string{"convert"}, Prefixes: []string{"users/1/"}},
+		{Name: "other", Hash: auth.HashKey("other-key"), Scopes: []string{"convert"}},
+		{Name: "viewer", Hash: auth.HashKey("viewer-key"), Scopes: []string{"read"}},
+		{Name: "ops", Hash: auth.HashKey("ops-key"), Scopes: []string{"admin"}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", h.HandleIndex)
+	mux.HandleFunc("/health", h.HandleHealth)
+	mux.HandleFunc("/api/convert", h.HandleConvert)
+	mux.HandleFunc("/api/convert/batch", h.HandleBatch)
+	mux.HandleFunc("/api/info", h.HandleInfo)
+	mux.HandleFunc("/api/jobs", h.HandleJobs)
+	mux.HandleFunc("/api/jobs/", h.HandleJob)
+	mux.HandleFunc("/img/", h.HandleTransform)
+	server := h.RequireAuth(authn, mux)
+
+	serve := func(method, target, key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		if key != "" {
+			req.Header.Set("Authorization", "Bearer "+key)
+		}
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+		return w
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		key    string
+		body   string
+		status int
+		code   string
+	}{
+		{"health is public", "GET", "/health", "", "", http.StatusOK, "ok"},
+		{"missing key", "GET", "/api/info?source=r2://b/users/1/a.png", "", "", http.StatusUnauthorized, "missing_api_key"},
+		{"invalid key", "GET", "/api/info?source=r2://b/users/1/a.png", "guess", "", http.StatusUnauthorized, "invalid_api_key"},
+		{"read scope reads", "GET", "/api/info?source=r2://b/a.png", "viewer-key", "", http.StatusOK, "width"},
+		{"read scope cannot convert", "GET", "/api/convert?source=r2://b/a.png", "viewer-key", "", http.StatusForbidden, "insufficient_scope"},
+		{"transform needs a key without URL signing", "GET", "/img/a.png", "", "", http.StatusUnauthorized, "missing_api_key"},
+		{"convert within prefix", "GET", "/api/convert?source=r2://b/users/1/a.png", "uploader-key", "", http.StatusOK, "users/1/a.webp"},
+		{"source outside prefix", "GET", "/api/convert?source=r2://b/users/2/a.png", "uploader-key", "", http.StatusForbidden, "key_not_allowed"},
+		{"info outside prefix", "GET", "/api/info?source=r2://b/users/2/a.png", "uploader-key", "", http.StatusForbidden, "key_not_allowed"},
+		{"transform outside prefix", "GET", "/img/users/2/a.png?w=10", "uploader-key", "", http.StatusForbidden, "key_not_allowed"},
+		{"batch destination outside prefix", "POST", "/api/convert/batch", "uploader-key",
+			`{"items": [{"source": "r2://b/users/1/a.png", "key": "users/2/a.webp"}]}`, http.StatusOK, "key_not_allowed"},
+		{"admin converts anywhere", "GET", "/api/convert?source=r2://b/users/2/a.png", "ops-key", "", http.StatusOK, "users/2/a.webp"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(tt.method, tt.target, tt.key, tt.body)
+			if w.Code != tt.status || !strings.Contains(w.Body.String(), tt.code) {
+				t.Errorf("expected %d %s, got %d: %s", tt.status, tt.code, w.Code, w.Body.String())
+			}
+		})
+	}
+	for _, key := range uploads {
+		if !strings.HasPrefix(key, "users/1/") && key != "users/2/a.webp" {
+			t.Errorf("unexpected upload %s", key)
+		}
+	}
+
+	t.Run("jobs", func(t *testing.T) {
+		w := serve("POST", "/api/jobs", "uploader-key", `{"items": [
+			{"source": "r2://b/users/1/a.png"},
+			{"source": "r2://b/users/2/a.png"}
+		]}`)
+		if w.Code != http.StatusAccepted {
+			t.Fatalf("expected 202, got %d: %s", w.Code, w.Body.String())
+		}
+		// The owning key and its prefixes stay internal
+		for _, body := range []string{w.Body.String(), serve("GET", "/api/jobs/"+jobID(t, w.Body.Bytes()), "uploader-key", "").Body.String()} {
+			if strings.Contains(body, `"owner"`) || strings.Contains(body, `"prefixes"`) || strings.Contains(body, "uploader") {
+				t.Errorf("expected no owner or prefixes in the job response: %s", body)
+			}
+		}
+		var submitted JobResponse
+		json.NewDecoder(w.Body).Decode(&submitted)
+
+		// Other keys do not see the job; admin keys do
+		if w := serve("GET", "/api/jobs/"+submitted.ID, "other-key", ""); w.Code != http.StatusNotFound {
+			t.Errorf("expected 404 for another key's job, got %d", w.Code)
+		}
+		if w := serve("DELETE", "/api/jobs/"+submitted.ID, "other-key", ""); w.Code != http.StatusNotFound {
+			t.Errorf("expected 404 canceling another key's job, got %d", w.Code)
+		}
+		if w := serve("GET", "/api/jobs/"+submitted.ID, "ops-key", ""); w.Code != http.StatusOK {
+			t.Errorf("expected 200 for an admin key, got %d", w.Code)
+		}
+
+		var job JobResponse
+		deadline := time.Now().Add(5 * time.Second)
+		for !job.Status.Finished() && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+			job = JobResponse{}
+			json.NewDecoder(serve("GET", "/api/jobs/"+submitted.ID, "uploader-key", "").Body).Decode(&job)
+		}
+		var res BatchResponse
+		json.Unmarshal(job.Result, &res)
+		// The prefixes of the submitting key still apply when the job runs
+		if res.Succeeded != 1 || len(res.Results) != 2 || res.Results[1].Error != "key_not_allowed" {
+			t.Errorf("unexpected job result: %+v", res)
+		}
+	})
+}
+
+func jobID(t *testing.T, body []byte) string {
+	t.Helper()
+	var job JobResponse
+	if err := json.Unmarshal(body, &job); err != nil || job.ID == "" {
+		t.Fatalf("expected a job, got %s", body)
+	}
+	return job.ID
+}
+
+func TestRequireAuth_Disabled(t *testing.T) {
+	h := NewHandler(&mockStorageClient{}, nil, &config.Config{})
+	mux := http.NewServeMux()
+	authn, _ := auth.New(config.AuthConfig{Disabled: true})
+	if got := h.RequireAuth(authn, mux); got != http.Handler(mux) {
+		t.Error("expected the router unchanged with auth.disabled")
+	}
+}
+
+func TestRequireAuth_SignedURLs(t *testing.T) {
+	h := NewHandler(&mockStorageClient{}, nil, &config.Config{Transform: config.TransformConfig{SigningSecret: "secret"}})
+	authn, _ := auth.New(config.AuthConfig{Keys: []config.APIKeyConfig{{Name: "app", Hash: auth.HashKey("app-key"), Scopes: []string{"read"}}}})
+	reached := 0
+	server := h.RequireAuth(authn, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { reached++ }))
+
+	// Signed endpoints are left to the signature check of their handlers
+	for _, target := range []string{"/img/a.png", "/api/image?source=r2://b/a.png"} {
+		server.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", target, nil))
+	}
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest("GET", "/api/info", nil))
+	if reached != 2 || w.Code != http.StatusUnauthorized {
+		t.Errorf("expected only the signed endpoints to pass without a key, reached %d, got %d", reached, w.Code)
+	}
+}
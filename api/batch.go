@@ -0,0 +1,202 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// BatchItem is one conversion of a batch: a ConvertRequest with its own
+// /api/convert query parameters and destination
+type BatchItem struct {
+	ConvertRequest
+	Params map[string]string `json:"params,omitempty"` // e.g. {"width": "800", "format": "avif"}
+	Key    string            `json:"key,omitempty"`    // Destination key; derived from the source when empty
+}
+
+// BatchRequest represents the JSON body for POST /api/convert/batch
+type BatchRequest struct {
+	Items []BatchItem `json:"items"`
+	// CallbackURL receives the completion webhook of a job; /api/jobs only
+	CallbackURL string `json:"callback_url,omitempty"`
+}
+
+// BatchItemResult is the outcome of one item, in request order
+type BatchItemResult struct {
+	Index   int              `json:"index"`
+	Status  int              `json:"status"` // HTTP status the item would have had on /api/convert
+	Success bool             `json:"success"`
+	Error   string           `json:"error,omitempty"`
+	Message string           `json:"message,omitempty"`
+	Result  *ConvertResponse `json:"result,omitempty"`
+}
+
+// BatchResponse represents the response for /api/convert/batch
+type BatchResponse struct {
+	Success   bool              `json:"success"` // Whether every item succeeded
+	Succeeded int               `json:"succeeded"`
+	Failed    int               `json:"failed"`
+	Results   []BatchItemResult `json:"results"`
+}
+
+// HandleBatch handles POST /api/convert/batch.
+// It converts up to batch.max_items items, batch.concurrency at a time. Items fail
+// independently; the response is 200 with a result per item unless the request
+// itself is invalid. Query parameters apply to every item unless its params override them.
+func (h *Handler) HandleBatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		h.sendError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+		return
+	}
+
+	limits := h.config.Batch
+	req, ok := h.decodeBatch(w, r, limits.MaxItems)
+	if !ok {
+		return
+	}
+	if req.CallbackURL != "" {
+		h.sendError(w, http.StatusBadRequest, "invalid_callback_url", "'callback_url' is only supported by /api/jobs")
+		return
+	}
+
+	// A batch runs longer than a single conversion, so it gets its own write timeout
+	ctx := r.Context()
+	if limits.TimeoutSeconds > 0 {
+		timeout := time.Duration(limits.TimeoutSeconds) * time.Second
+		// Fails only for writers without deadlines, such as test recorders
+		_ = http.NewResponseController(w).SetWriteDeadline(time.Now().Add(timeout))
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	res := newBatchResponse(h.convertItems(ctx, req.Items, r.URL.Query(), limits.Concurrency, nil))
+	log.Printf("[INFO] Batch converted %d of %d items", res.Succeeded, len(res.Results))
+	h.sendJSON(w, http.StatusOK, res)
+}
+
+// decodeBatch reads a BatchRequest of 1 to maxItems items, sending the error
+// response and returning false when it is invalid
+func (h *Handler) decodeBatch(w http.ResponseWriter, r *http.Request, maxItems int) (*BatchRequest, bool) {
+	var req BatchRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBodyBytes)).Decode(&req); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			h.sendError(w, http.StatusRequestEntityTooLarge, "request_too_large", "Request body is too large")
+			return nil, false
+		}
+		h.sendError(w, http.StatusBadRequest, "invalid_request", "Failed to parse JSON body")
+		return nil, false
+	}
+	if len(req.Items) == 0 {
+		h.sendError(w, http.StatusBadRequest, "invalid_batch", "The 'items' array is empty")
+		return nil, false
+	}
+	if maxItems > 0 && len(req.Items) > maxItems {
+		h.sendError(w, http.StatusBadRequest, "invalid_batch",
+			fmt.Sprintf("%d items given, the maximum is %d", len(req.Items), maxItems))
+		return nil, false
+	}
+	return &req, true
+}
+
+// convertItems converts items, concurrency at a time, and calls onDone, if set,
+// with the number of finished items after each one. Items that have not started
+// when ctx is done fail without running.
+func (h *Handler) convertItems(ctx context.Context, items []BatchItem, defaults url.Values, concurrency int, onDone func(done int)) []BatchItemResult {
+	results := make([]BatchItemResult, len(items))
+	sem := make(chan struct{}, max(concurrency, 1))
+	var mu sync.Mutex
+	done := 0
+	var wg sync.WaitGroup
+	for i, item := range items {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+				results[i] = h.convertItemRecover(ctx, i, item, defaults)
+			case <-ctx.Done():
+				if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+					results[i] = itemError(i, newAPIError(http.StatusGatewayTimeout, "batch_timeout", "The batch timed out before this item started"))
+				} else {
+					results[i] = itemError(i, newAPIError(http.StatusServiceUnavailable, "canceled", "The request was canceled before this item started"))
+				}
+			}
+			if onDone != nil {
+				mu.Lock()
+				done++
+				onDone(done)
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+// convertItemRecover runs convertItem and reports a panic as a failed item. Item
+// goroutines are outside the recovery of net/http and the job queue, so a panic
+// would otherwise take down the server.
+func (h *Handler) convertItemRecover(ctx context.Context, index int, item BatchItem, defaults url.Values) (result BatchItemResult) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("[ERROR] Batch item %d (%s) panicked: %v\n%s", index, item.Source, r, debug.Stack())
+			result = itemError(index, newAPIError(http.StatusInternalServerError, "internal_error", "The item failed unexpectedly"))
+		}
+	}()
+	return h.convertItem(ctx, index, item, defaults)
+}
+
+func newBatchResponse(results []BatchItemResult) BatchResponse {
+	res := BatchResponse{Success: true, Results: results}
+	for _, result := range results {
+		if result.Success {
+			res.Succeeded++
+		} else {
+			res.Failed++
+			res.Success = false
+		}
+	}
+	return res
+}
+
+// convertItem converts one batch item with the batch query parameters as defaults
+func (h *Handler) convertItem(ctx context.Context, index int, item BatchItem, defaults url.Values) BatchItemResult {
+	if item.Source == "" {
+		return itemError(index, newAPIError(http.StatusBadRequest, "missing_source", "The 'source' field is required"))
+	}
+	if item.Key != "" && !isValidKey(item.Key) {
+		return itemError(index, newAPIError(http.StatusBadRequest, "invalid_key", "Invalid 'key' (must be a relative R2 key without '.' or '..' segments)"))
+	}
+	query := url.Values{}
+	for name, values := range defaults {
+		query[name] = values
+	}
+	for name, value := range item.Params {
+		query.Set(name, value)
+	}
+
+	options, variantSet, apiErr := h.parseConvertOptions(query, item.Operations)
+	if apiErr != nil {
+		return itemError(index, apiErr)
+	}
+	res, apiErr := h.convert(ctx, item.Source, item.Key, options, variantSet)
+	if apiErr != nil {
+		return itemError(index, apiErr)
+	}
+	return BatchItemResult{Index: index, Status: http.StatusOK, Success: true, Result: res}
+}
+
+func itemError(index int, e *apiError) BatchItemResult {
+	return BatchItemResult{Index: index, Status: e.status, Error: e.code, Message: e.message}
+}
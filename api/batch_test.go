@@ -0,0 +1,176 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"image-converting-server/config"
+	"image-converting-server/processor"
+)
+
+func TestHandleBatch(t *testing.T) {
+	var buf bytes.Buffer
+	png.Encode(&buf, image.NewNRGBA(image.Rect(0, 0, 200, 100)))
+	imgData := buf.Bytes()
+
+	cfg := &config.Config{
+		R2: config.R2Config{Bucket: "test-bucket"},
+		Conversion: config.ConversionConfig{
+			Formats:   []string{"png"},
+			Quality:   80,
+			MaxSizeMB: 1,
+		},
+		Batch: config.BatchConfig{MaxItems: 5, Concurrency: 2, TimeoutSeconds: 60},
+	}
+
+	var mu sync.Mutex
+	uploads := make(map[string][]byte)
+	var running, peak atomic.Int32
+	mockStorage := &mockStorageClient{
+		downloadFunc: func(ctx context.Context, key string) ([]byte, error) {
+			n := running.Add(1)
+			defer running.Add(-1)
+			for {
+				p := peak.Load()
+				if n <= p || peak.CompareAndSwap(p, n) {
+					break
+				}
+			}
+			time.Sleep(20 * time.Millisecond)
+			if key == "missing.png" {
+				return nil, fmt.Errorf("NoSuchKey: not found")
+			}
+			if key == "panic.png" {
+				panic("corrupt image")
+			}
+			return imgData, nil
+		},
+		uploadFunc: func(ctx context.Context, key string, data []byte, contentType string) error {
+			mu.Lock()
+			defer mu.Unlock()
+			uploads[key] = data
+			return nil
+		},
+	}
+	h := NewHandler(mockStorage, processor.NewProcessor(*cfg), cfg)
+
+	batch := func(query, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/convert/batch"+query, strings.NewReader(body))
+		w := httptest.NewRecorder()
+		h.HandleBatch(w, req)
+		return w
+	}
+
+	t.Run("partial failure", func(t *testing.T) {
+		w := batch("?width=100", `{"items": [
+			{"source": "r2://test-bucket/a.png"},
+			{"source": "r2://test-bucket/b.png", "params": {"width": "50"}, "key": "out/b.webp"},
+			{"source": "r2://test-bucket/missing.png"},
+			{"source": "r2://test-bucket/c.png", "params": {"quality": "500"}},
+			{}
+		]}`)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+		var res BatchResponse
+		if err := json.NewDecoder(w.Body).Decode(&res); err != nil {
+			t.Fatal(err)
+		}
+		if res.Success || res.Succeeded != 2 || res.Failed != 3 || len(res.Results) != 5 {
+			t.Fatalf("unexpected summary: %+v", res)
+		}
+		for i, result := range res.Results {
+			if result.Index != i {
+				t.Errorf("expected results in request order, got index %d at %d", result.Index, i)
+			}
+		}
+
+		if r := res.Results[0].Result; r == nil || r.Width != 100 || r.Destination != "r2://test-bucket/a.webp" {
+			t.Errorf("expected the batch query to apply to item 0, got %+v", res.Results[0])
+		}
+		if r := res.Results[1].Result; r == nil || r.Width != 50 || r.Destination != "r2://test-bucket/out/b.webp" {
+			t.Errorf("expected item params and key to win for item 1, got %+v", res.Results[1])
+		}
+		expected := []struct {
+			status int
+			code   string
+		}{
+			{http.StatusNotFound, "image_not_found"},
+			{http.StatusBadRequest, "invalid_quality"},
+			{http.StatusBadRequest, "missing_source"},
+		}
+		for i, e := range expected {
+			r := res.Results[i+2]
+			if r.Success || r.Status != e.status || r.Error != e.code {
+				t.Errorf("item %d: expected %d %s, got %+v", i+2, e.status, e.code, r)
+			}
+		}
+		if _, ok := uploads["out/b.webp"]; !ok {
+			t.Errorf("expected an upload at the item key, got %v", len(uploads))
+		}
+	})
+
+	t.Run("panicking item", func(t *testing.T) {
+		w := batch("", `{"items": [{"source": "r2://test-bucket/panic.png"}, {"source": "r2://test-bucket/a.png"}]}`)
+		var res BatchResponse
+		if err := json.NewDecoder(w.Body).Decode(&res); err != nil {
+			t.Fatal(err)
+		}
+		if r := res.Results[0]; r.Success || r.Status != http.StatusInternalServerError || r.Error != "internal_error" {
+			t.Errorf("expected the panic as a 500 for item 0, got %+v", r)
+		}
+		if !res.Results[1].Success {
+			t.Errorf("expected the other item to convert, got %+v", res.Results[1])
+		}
+	})
+
+	t.Run("bounded concurrency", func(t *testing.T) {
+		peak.Store(0)
+		items := make([]string, 5)
+		for i := range items {
+			items[i] = fmt.Sprintf(`{"source": "r2://test-bucket/%d.png"}`, i)
+		}
+		w := batch("", `{"items": [`+strings.Join(items, ",")+`]}`)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+		if p := peak.Load(); p > 2 {
+			t.Errorf("expected at most 2 items at a time, got %d", p)
+		}
+	})
+
+	invalid := []struct {
+		name   string
+		method string
+		body   string
+		status int
+		code   string
+	}{
+		{"wrong method", "GET", "", http.StatusMethodNotAllowed, "method_not_allowed"},
+		{"malformed body", "POST", `{"items": `, http.StatusBadRequest, "invalid_request"},
+		{"no items", "POST", `{"items": []}`, http.StatusBadRequest, "invalid_batch"},
+		{"too many items", "POST", `{"items": [{}, {}, {}, {}, {}, {}]}`, http.StatusBadRequest, "invalid_batch"},
+		{"callback url", "POST", `{"items": [{}], "callback_url": "https://example.com/hook"}`, http.StatusBadRequest, "invalid_callback_url"},
+	}
+	for _, tt := range invalid {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/api/convert/batch", strings.NewReader(tt.body))
+			w := httptest.NewRecorder()
+			h.HandleBatch(w, req)
+			if w.Code != tt.status || !strings.Contains(w.Body.String(), tt.code) {
+				t.Errorf("expected %d %s, got %d: %s", tt.status, tt.code, w.Code, w.Body.String())
+			}
+		})
+	}
+}
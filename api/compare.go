@@ -0,0 +1,137 @@
+package api
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"log"
+	"net/http"
+
+	"image-converting-server/metrics"
+)
+
+// CompareRequest represents the JSON body for POST /api/compare
+type CompareRequest struct {
+	Original  string `json:"original"`
+	Converted string `json:"converted"`
+}
+
+// CompareResponse represents the success response for /api/compare
+type CompareResponse struct {
+	Success       bool   `json:"success"`
+	Original      string `json:"original"`
+	Converted     string `json:"converted"`
+	OriginalSize  int    `json:"original_size"`
+	ConvertedSize int    `json:"converted_size"`
+	Width         int    `json:"width"` // Size compared at, which is the converted image's
+	Height        int    `json:"height"`
+	Resized       bool   `json:"resized"` // Whether the original was resized to the converted size
+
+	PSNR    float64 `json:"psnr"` // dB, at most metrics.MaxPSNR
+	SSIM    float64 `json:"ssim"`
+	MSSSIM  float64 `json:"ms_ssim"`
+	Heatmap string  `json:"heatmap,omitempty"` // PNG data URI; omitted with heatmap=false
+}
+
+// HandleCompare handles GET and POST /api/compare.
+// It scores a converted image against its original and renders where they differ.
+func (h *Handler) HandleCompare(w http.ResponseWriter, r *http.Request) {
+	var req CompareRequest
+	switch r.Method {
+	case http.MethodGet:
+		req.Original = r.URL.Query().Get("original")
+		req.Converted = r.URL.Query().Get("converted")
+	case http.MethodPost:
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBodyBytes)).Decode(&req); err != nil {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				h.sendError(w, http.StatusRequestEntityTooLarge, "request_too_large", "Request body is too large")
+				return
+			}
+			h.sendError(w, http.StatusBadRequest, "invalid_request", "Failed to parse JSON body")
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		h.sendError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+		return
+	}
+
+	if req.Original == "" || req.Converted == "" {
+		h.sendError(w, http.StatusBadRequest, "missing_source", "The 'original' and 'converted' parameters are required")
+		return
+	}
+
+	original, originalSize, apiErr := h.loadImage(r, req.Original)
+	if apiErr != nil {
+		h.sendAPIError(w, apiErr)
+		return
+	}
+	converted, convertedSize, apiErr := h.loadImage(r, req.Converted)
+	if apiErr != nil {
+		h.sendAPIError(w, apiErr)
+		return
+	}
+
+	// Converted images are often resized, so compare at the converted size
+	osize, csize := original.Bounds().Size(), converted.Bounds().Size()
+	resized := osize != csize
+	if resized {
+		// Allow the rounding of an aspect-preserving resize, but not a crop or stretch
+		if diff := osize.Y*csize.X - csize.Y*osize.X; max(diff, -diff) > max(osize.X, osize.Y) {
+			h.sendError(w, http.StatusBadRequest, "size_mismatch",
+				fmt.Sprintf("Images have different aspect ratios: %dx%d and %dx%d", osize.X, osize.Y, csize.X, csize.Y))
+			return
+		}
+		original = h.processor.ResizeImage(original, csize.X, csize.Y)
+	}
+
+	res := CompareResponse{
+		Success:       true,
+		Original:      req.Original,
+		Converted:     req.Converted,
+		OriginalSize:  originalSize,
+		ConvertedSize: convertedSize,
+		Width:         csize.X,
+		Height:        csize.Y,
+		Resized:       resized,
+	}
+	// Sizes match at this point, so the metrics cannot fail
+	res.PSNR, _ = metrics.PSNR(original, converted)
+	ol, cl := metrics.NewLuma(original), metrics.NewLuma(converted)
+	res.SSIM, _ = ol.SSIM(cl)
+	res.MSSSIM, _ = ol.MSSSIM(cl)
+
+	if r.URL.Query().Get("heatmap") != "false" {
+		heatmap, _ := metrics.Heatmap(original, converted)
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, heatmap); err != nil {
+			h.sendError(w, http.StatusInternalServerError, "internal_error", "Failed to encode heatmap")
+			return
+		}
+		res.Heatmap = "data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes())
+	}
+
+	h.sendJSON(w, http.StatusOK, res)
+}
+
+// loadImage downloads and decodes a source, returning the image and its encoded size
+func (h *Handler) loadImage(r *http.Request, source string) (image.Image, int, *apiError) {
+	data, _, apiErr := h.loadSource(r.Context(), source)
+	if apiErr != nil {
+		return nil, 0, apiErr
+	}
+	img, err := h.processor.Decode(data)
+	if err != nil {
+		log.Printf("Failed to decode %s: %v", source, err)
+		if apiErr := h.processError(err); apiErr != nil {
+			return nil, 0, apiErr
+		}
+		return nil, 0, newAPIError(http.StatusBadRequest, "invalid_image", fmt.Sprintf("Failed to decode %s", source))
+	}
+	return img, len(data), nil
+}
@@ -0,0 +1,143 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"image-converting-server/config"
+	"image-converting-server/metrics"
+	"image-converting-server/processor"
+)
+
+func TestHandleCompare(t *testing.T) {
+	original := image.NewNRGBA(image.Rect(0, 0, 64, 32))
+	for i := range original.Pix {
+		original.Pix[i] = uint8(i * 31 % 256)
+	}
+	for i := 3; i < len(original.Pix); i += 4 {
+		original.Pix[i] = 255
+	}
+	encode := func(img image.Image) []byte {
+		var buf bytes.Buffer
+		png.Encode(&buf, img)
+		return buf.Bytes()
+	}
+	cfg := &config.Config{
+		R2:         config.R2Config{Bucket: "test-bucket"},
+		Conversion: config.ConversionConfig{Formats: []string{"png"}, Quality: 85},
+	}
+	proc := processor.NewProcessor(*cfg)
+	webpData, err := proc.ConvertToWebP(original)
+	if err != nil {
+		t.Fatal(err)
+	}
+	objects := map[string][]byte{
+		"original.png": encode(original),
+		"same.png":     encode(original),
+		"photo.webp":   webpData,
+		"half.png":     encode(image.NewNRGBA(image.Rect(0, 0, 32, 16))),
+		"square.png":   encode(image.NewNRGBA(image.Rect(0, 0, 32, 32))),
+		"broken.png":   []byte("not an image"),
+	}
+	mockStorage := &mockStorageClient{
+		downloadFunc: func(ctx context.Context, key string) ([]byte, error) {
+			data, ok := objects[key]
+			if !ok {
+				return nil, errors.New("not found")
+			}
+			return data, nil
+		},
+	}
+	h := NewHandler(mockStorage, proc, cfg)
+
+	compare := func(method, query string, body *CompareRequest) *httptest.ResponseRecorder {
+		var req *http.Request
+		if body != nil {
+			data, _ := json.Marshal(body)
+			req = httptest.NewRequest(method, "/api/compare"+query, bytes.NewReader(data))
+		} else {
+			req = httptest.NewRequest(method, "/api/compare"+query, nil)
+		}
+		w := httptest.NewRecorder()
+		h.HandleCompare(w, req)
+		return w
+	}
+
+	t.Run("identical", func(t *testing.T) {
+		w := compare("GET", "?original=r2://test-bucket/original.png&converted=r2://test-bucket/same.png&heatmap=false", nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+		var res CompareResponse
+		json.NewDecoder(w.Body).Decode(&res)
+		if res.PSNR != metrics.MaxPSNR || res.SSIM < 0.9999 || res.MSSSIM < 0.9999 || res.Heatmap != "" || res.Resized {
+			t.Errorf("unexpected response for identical images: %+v", res)
+		}
+	})
+
+	t.Run("webp against its original", func(t *testing.T) {
+		w := compare("POST", "", &CompareRequest{Original: "r2://test-bucket/original.png", Converted: "r2://test-bucket/photo.webp"})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+		var res CompareResponse
+		json.NewDecoder(w.Body).Decode(&res)
+		if res.PSNR <= 0 || res.PSNR >= metrics.MaxPSNR || res.SSIM <= 0 || res.SSIM >= 1 || res.MSSSIM <= 0 || res.MSSSIM >= 1 {
+			t.Errorf("unexpected scores: %+v", res)
+		}
+		if res.Width != 64 || res.Height != 32 || res.ConvertedSize != len(webpData) {
+			t.Errorf("unexpected sizes: %+v", res)
+		}
+		data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(res.Heatmap, "data:image/png;base64,"))
+		if err != nil {
+			t.Fatalf("invalid heatmap data URI: %v", err)
+		}
+		heatmap, err := png.Decode(bytes.NewReader(data))
+		if err != nil || heatmap.Bounds().Size() != image.Pt(64, 32) {
+			t.Errorf("expected a 64x32 PNG heatmap (%v)", err)
+		}
+	})
+
+	t.Run("resized", func(t *testing.T) {
+		w := compare("GET", "?original=r2://test-bucket/original.png&converted=r2://test-bucket/half.png", nil)
+		var res CompareResponse
+		json.NewDecoder(w.Body).Decode(&res)
+		if w.Code != http.StatusOK || !res.Resized || res.Width != 32 || res.Height != 16 {
+			t.Errorf("expected a comparison at 32x16, got %d: %+v", w.Code, res)
+		}
+	})
+
+	errorTests := []struct {
+		name, query string
+		status      int
+		code        string
+	}{
+		{"missing converted", "?original=r2://test-bucket/original.png", http.StatusBadRequest, "missing_source"},
+		{"different aspect ratio", "?original=r2://test-bucket/original.png&converted=r2://test-bucket/square.png", http.StatusBadRequest, "size_mismatch"},
+		{"undecodable", "?original=r2://test-bucket/original.png&converted=r2://test-bucket/broken.png", http.StatusBadRequest, "invalid_image"},
+		{"not found", "?original=r2://test-bucket/missing.png&converted=r2://test-bucket/same.png", http.StatusNotFound, "image_not_found"},
+	}
+	for _, tt := range errorTests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := compare("GET", tt.query, nil)
+			var res ErrorResponse
+			json.NewDecoder(w.Body).Decode(&res)
+			if w.Code != tt.status || res.Error != tt.code {
+				t.Errorf("expected %d %s, got %d %s", tt.status, tt.code, w.Code, res.Error)
+			}
+		})
+	}
+
+	if w := compare("DELETE", "", nil); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected 405, got %d", w.Code)
+	}
+}
@@ -1,10 +1,12 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"log"
+	"math"
 	"net/http"
 	"net/url"
 	"path/filepath"
@@ -12,13 +14,23 @@ import (
 	"strings"
 
 	"image-converting-server/config"
+	"image-converting-server/fetch"
+	"image-converting-server/jobs"
+	"image-converting-server/metrics"
 	"image-converting-server/processor"
 	"image-converting-server/r2"
+	"image-converting-server/responsive"
+	"image-converting-server/signer"
+	"image-converting-server/webhook"
 )
 
+// maxRequestBodyBytes limits JSON request bodies, which only carry parameters
+const maxRequestBodyBytes = 1 << 20
+
 // ConvertRequest represents the JSON body for POST /api/convert
 type ConvertRequest struct {
-	Source string `json:"source"`
+	Source     string   `json:"source"`
+	Operations []string `json:"operations,omitempty"` // Overrides the 'ops' query parameter
 }
 
 // ConvertResponse represents the success response for /api/convert
@@ -31,6 +43,17 @@ type ConvertResponse struct {
 	ConvertedSize int    `json:"converted_size"`
 	Width         int    `json:"width,omitempty"`
 	Height        int    `json:"height,omitempty"`
+
+	// Set when the quality search chose the encoder quality
+	Quality int     `json:"quality,omitempty"`
+	SSIM    float64 `json:"ssim,omitempty"` // Of the output against the processed image, before encoding
+
+	// Set when placeholders were selected; also stored as object metadata
+	Placeholders *processor.Placeholders `json:"placeholders,omitempty"`
+
+	// Set when a responsive variant set was requested; Destination is then the manifest
+	Variants []responsive.Variant `json:"variants,omitempty"`
+	Srcset   map[string]string    `json:"srcset,omitempty"` // By output format
 }
 
 // ErrorResponse represents the error response
@@ -45,15 +68,31 @@ type Handler struct {
 	storageClient r2.StorageClient
 	processor     *processor.Processor
 	config        *config.Config
+	signer        *signer.Signer
+	fetcher       *fetch.Fetcher
+	jobs          *jobs.Queue
+	webhook       *webhook.Dispatcher
 }
 
 // NewHandler creates a new Handler instance
 func NewHandler(storageClient r2.StorageClient, processor *processor.Processor, config *config.Config) *Handler {
-	return &Handler{
+	h := &Handler{
 		storageClient: storageClient,
 		processor:     processor,
 		config:        config,
 	}
+	if config != nil && config.Transform.SigningSecret != "" {
+		h.signer = signer.New(config.Transform.SigningSecret)
+	}
+	if config != nil {
+		fetcher, err := fetch.New(config.Fetch, config.Conversion.MaxSizeBytes())
+		if err != nil {
+			// Validate rejects bad CIDRs, so this only happens with hand-built configs
+			log.Printf("[ERROR] Invalid fetch config, URL sources are disabled: %v", err)
+		}
+		h.fetcher = fetcher
+	}
+	return h
 }
 
 // HandleIndex handles GET /
@@ -73,19 +112,29 @@ func (h *Handler) HandleHealth(w http.ResponseWriter, r *http.Request) {
 // HandleConvert handles GET and POST /api/convert
 func (h *Handler) HandleConvert(w http.ResponseWriter, r *http.Request) {
 	var source string
-	var options processor.ProcessOptions
+	var operations []string
 
 	// 1. Parse request based on method
 	switch r.Method {
 	case http.MethodGet:
 		source = r.URL.Query().Get("source")
 	case http.MethodPost:
+		if isUpload(r) {
+			h.convertUpload(w, r)
+			return
+		}
 		var req ConvertRequest
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBodyBytes)).Decode(&req); err != nil {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				h.sendError(w, http.StatusRequestEntityTooLarge, "request_too_large", "Request body is too large")
+				return
+			}
 			h.sendError(w, http.StatusBadRequest, "invalid_request", "Failed to parse JSON body")
 			return
 		}
 		source = req.Source
+		operations = req.Operations
 	default:
 		w.Header().Set("Allow", "GET, POST")
 		h.sendError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
@@ -98,149 +147,457 @@ func (h *Handler) HandleConvert(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// 2. Parse resizing parameters from query string
-	query := r.URL.Query()
-	if widthStr := query.Get("width"); widthStr != "" {
-		width, err := strconv.Atoi(widthStr)
-		if err != nil || width < 0 {
-			h.sendError(w, http.StatusBadRequest, "invalid_resize_params", "Invalid 'width' parameter")
-			return
-		}
-		options.Width = width
-	}
-	if heightStr := query.Get("height"); heightStr != "" {
-		height, err := strconv.Atoi(heightStr)
-		if err != nil || height < 0 {
-			h.sendError(w, http.StatusBadRequest, "invalid_resize_params", "Invalid 'height' parameter")
-			return
-		}
-		options.Height = height
-	}
-	if preset := query.Get("preset"); preset != "" {
-		if _, ok := h.config.Resize.Presets[preset]; !ok {
-			h.sendError(w, http.StatusBadRequest, "invalid_preset", fmt.Sprintf("Preset '%s' not found", preset))
-			return
-		}
-		options.Preset = preset
+	options, variantSet, apiErr := h.parseConvertOptions(r.URL.Query(), operations)
+	if apiErr != nil {
+		h.sendAPIError(w, apiErr)
+		return
 	}
 
-	// 3. Download image
-	var data []byte
-	var err error
-	var r2Key string
+	res, apiErr := h.convert(r.Context(), source, "", options, variantSet)
+	if apiErr != nil {
+		h.sendAPIError(w, apiErr)
+		return
+	}
+	h.sendJSON(w, http.StatusOK, res)
+}
 
-	if strings.HasPrefix(source, "r2://") {
-		// Format: r2://bucket/key
-		r2Key = strings.TrimPrefix(source, "r2://")
-		parts := strings.SplitN(r2Key, "/", 2)
-		if len(parts) < 2 {
-			h.sendError(w, http.StatusBadRequest, "invalid_source_format", "Invalid R2 source format. Expected r2://bucket/key")
-			return
-		}
-		// In this version, we ignore the bucket name and use the configured one
-		// but we keep the key part
-		r2Key = parts[1]
-		data, err = h.storageClient.DownloadImage(r.Context(), r2Key)
-		if err != nil {
-			log.Printf("Failed to download from R2: %v", err)
-			h.sendError(w, http.StatusNotFound, "image_not_found", "Image not found in R2 bucket")
-			return
-		}
-	} else if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
-		data, err = h.downloadFromURL(source)
+// parseConvertOptions parses the query parameters of /api/convert, with operations
+// from a request body replacing 'ops' when not nil
+func (h *Handler) parseConvertOptions(query url.Values, operations []string) (processor.ProcessOptions, *config.VariantSetConfig, *apiError) {
+	options, apiErr := h.parseProcessOptions(query)
+	if apiErr != nil {
+		return options, nil, apiErr
+	}
+	variantSet, apiErr := h.parseVariantSet(query)
+	if apiErr != nil {
+		return options, nil, apiErr
+	}
+	if operations != nil {
+		ops, err := processor.ParseOperationList(operations)
 		if err != nil {
-			log.Printf("Failed to download from URL: %v", err)
-			h.sendError(w, http.StatusNotFound, "url_not_accessible", "Source URL is not accessible")
-			return
+			return options, nil, newAPIError(http.StatusBadRequest, "invalid_operation", err.Error())
 		}
-	} else {
-		h.sendError(w, http.StatusBadRequest, "invalid_source_format", "Source must be either r2://bucket/key or http(s):// URL")
-		return
+		options.Operations = ops
 	}
+	return options, variantSet, nil
+}
 
-	originalSize := len(data)
-
-	// 4. Process image
-	webpData, _, err := h.processor.Process(data, options)
-	if err != nil {
-		log.Printf("Conversion failed: %v", err)
-		h.sendError(w, http.StatusInternalServerError, "conversion_failed", fmt.Sprintf("Failed to convert image: %v", err))
-		return
+// convert downloads source, converts it and stores the result. destKey replaces the
+// key derived from the source; for variant sets it is the base of the variant keys.
+func (h *Handler) convert(ctx context.Context, source, destKey string, options processor.ProcessOptions,
+	set *config.VariantSetConfig) (*ConvertResponse, *apiError) {
+	// 3. Download image
+	data, r2Key, apiErr := h.loadSource(ctx, source)
+	if apiErr != nil {
+		return nil, apiErr
 	}
 
-	// 5. Upload to R2 (if it was an R2 source, replace it; if URL, create a new one based on URL path)
+	// Destination keys are derived from the R2 key, or from the URL path for URL sources
 	if r2Key == "" {
-		// For URL source, generate a key
 		u, _ := url.Parse(source)
 		r2Key = strings.TrimLeft(u.Path, "/")
 		if r2Key == "" {
 			r2Key = "downloaded_image"
 		}
 	}
+	if destKey != "" {
+		r2Key = destKey
+	}
+	return h.convertData(ctx, source, r2Key, destKey, data, options, set)
+}
+
+// convertData converts data and stores the result under destKey, or when empty under
+// key with the output format's extension. Variant sets are stored under key.
+func (h *Handler) convertData(ctx context.Context, source, key, destKey string, data []byte,
+	options processor.ProcessOptions, set *config.VariantSetConfig) (*ConvertResponse, *apiError) {
+	options.Context = ctx
+	if set != nil {
+		return h.convertVariants(ctx, source, key, data, options, *set)
+	}
 
-	// Change extension to .webp
-	ext := filepath.Ext(r2Key)
-	destKey := strings.TrimSuffix(r2Key, ext) + ".webp"
+	// 4. Process image
+	encoder, err := h.processor.Encoder(options.Format)
+	if err != nil {
+		return nil, newAPIError(http.StatusBadRequest, "invalid_format", err.Error())
+	}
+	// The destination is the source key (if it was an R2 source, replace it; if URL,
+	// create a new one based on URL path) with the output format's extension
+	if destKey == "" {
+		destKey = strings.TrimSuffix(key, filepath.Ext(key)) + encoder.Extension()
+	}
+	if apiErr := authorizeObject(ctx, destKey); apiErr != nil {
+		return nil, apiErr
+	}
+	result, err := h.processor.ProcessResult(data, options)
+	if err != nil {
+		return nil, h.conversionError(err)
+	}
 
-	err = h.storageClient.UploadImage(r.Context(), destKey, webpData, "image/webp")
+	// 5. Upload to R2
+	err = h.storageClient.UploadImageWithMetadata(ctx, destKey, result.Data, encoder.ContentType(), result.Placeholders.Metadata())
 	if err != nil {
 		log.Printf("Upload failed: %v", err)
-		h.sendError(w, http.StatusInternalServerError, "upload_failed", "Failed to upload converted image to R2")
-		return
+		return nil, newAPIError(http.StatusInternalServerError, "upload_failed", "Failed to upload converted image to R2")
 	}
 
 	// 6. Delete original image if it was from R2 and destination is different
 	/*
-		if strings.HasPrefix(source, "r2://") && r2Key != destKey {
-			err = h.storageClient.DeleteObject(r.Context(), r2Key)
+		if strings.HasPrefix(source, "r2://") && key != destKey {
+			err = h.storageClient.DeleteObject(ctx, key)
 			if err != nil {
-				log.Printf("[WARN] Failed to delete original image %s: %v", r2Key, err)
+				log.Printf("[WARN] Failed to delete original image %s: %v", key, err)
 				// Don't return error here, as conversion was successful
 			} else {
-				log.Printf("[INFO] Deleted original image: %s", r2Key)
+				log.Printf("[INFO] Deleted original image: %s", key)
 			}
 		}
 	*/
 
 	// 7. Return response
-	res := ConvertResponse{
+	return &ConvertResponse{
 		Success:       true,
 		Message:       "Image converted successfully",
 		Source:        source,
 		Destination:   fmt.Sprintf("r2://%s/%s", h.config.R2.Bucket, destKey),
-		OriginalSize:  originalSize,
-		ConvertedSize: len(webpData),
+		OriginalSize:  len(data),
+		ConvertedSize: len(result.Data),
+		Width:         result.Width,
+		Height:        result.Height,
+		Quality:       result.Quality,
+		SSIM:          result.SSIM,
+		Placeholders:  result.Placeholders,
+	}, nil
+}
+
+// conversionError maps a ProcessResult error to an API error
+func (h *Handler) conversionError(err error) *apiError {
+	log.Printf("Conversion failed: %v", err)
+	if apiErr := h.processError(err); apiErr != nil {
+		return apiErr
 	}
+	return newAPIError(http.StatusInternalServerError, "conversion_failed", fmt.Sprintf("Failed to convert image: %v", err))
+}
 
-	// Add actual dimensions if possible
-	// (Though processor.Process doesn't return them currently, we could decode config again)
-	// For simplicity, we just use the options if they were set
-	if options.Width > 0 {
-		res.Width = options.Width
+// convertVariants renders and stores every variant of set, and describes the manifest
+func (h *Handler) convertVariants(ctx context.Context, source, key string, data []byte,
+	options processor.ProcessOptions, set config.VariantSetConfig) (*ConvertResponse, *apiError) {
+	variants, err := responsive.Generate(h.processor, key, data, options, set)
+	if err != nil {
+		return nil, h.conversionError(err)
+	}
+	if apiErr := authorizeObject(ctx, responsive.ManifestKey(key)); apiErr != nil {
+		return nil, apiErr
 	}
-	if options.Height > 0 {
-		res.Height = options.Height
-	} else if options.Preset != "" {
-		if p, ok := h.config.Resize.Presets[options.Preset]; ok {
-			res.Width = p.Width
-			res.Height = p.Height
+	for _, v := range variants {
+		if apiErr := authorizeObject(ctx, v.Key); apiErr != nil {
+			return nil, apiErr
 		}
 	}
+	manifest, err := responsive.Store(ctx, h.storageClient, source, key, variants, h.config.Responsive.BaseURL)
+	if err != nil {
+		log.Printf("Upload failed: %v", err)
+		return nil, newAPIError(http.StatusInternalServerError, "upload_failed", "Failed to upload converted images to R2")
+	}
 
-	h.sendJSON(w, http.StatusOK, res)
+	convertedSize := 0
+	for _, v := range variants {
+		convertedSize += v.Size
+	}
+	return &ConvertResponse{
+		Success:       true,
+		Message:       fmt.Sprintf("Image converted to %d variants", len(variants)),
+		Source:        source,
+		Destination:   fmt.Sprintf("r2://%s/%s", h.config.R2.Bucket, responsive.ManifestKey(key)),
+		OriginalSize:  len(data),
+		ConvertedSize: convertedSize,
+		Variants:      manifest.Variants,
+		Srcset:        manifest.Srcset,
+		Placeholders:  manifest.Placeholders,
+	}, nil
+}
+
+// parseVariantSet returns the responsive set requested with 'variants' (a configured
+// set name) or 'widths' and 'formats', or nil if none was requested
+func (h *Handler) parseVariantSet(query url.Values) (*config.VariantSetConfig, *apiError) {
+	var set config.VariantSetConfig
+	if name := query.Get("variants"); name != "" {
+		configured, ok := h.config.Responsive.Sets[name]
+		if !ok {
+			return nil, newAPIError(http.StatusBadRequest, "invalid_variants", fmt.Sprintf("Variant set '%s' not found", name))
+		}
+		set = configured
+	} else if widths := query.Get("widths"); widths != "" {
+		for _, s := range strings.Split(widths, ",") {
+			width, err := strconv.Atoi(strings.TrimSpace(s))
+			if err != nil || width <= 0 || (h.config.Transform.MaxDimension > 0 && width > h.config.Transform.MaxDimension) {
+				return nil, newAPIError(http.StatusBadRequest, "invalid_variants", fmt.Sprintf("Invalid width in 'widths': %s", s))
+			}
+			set.Widths = append(set.Widths, width)
+		}
+		if formats := query.Get("formats"); formats != "" {
+			set.Formats = strings.Split(formats, ",")
+		}
+	} else {
+		return nil, nil
+	}
+	if err := responsive.Check(h.processor, set); err != nil {
+		return nil, newAPIError(http.StatusBadRequest, "invalid_variants", fmt.Sprintf("Invalid variant set: %v", err))
+	}
+	return &set, nil
+}
+
+// parseProcessOptions parses the resizing and output parameters shared by the
+// conversion endpoints
+func (h *Handler) parseProcessOptions(query url.Values) (processor.ProcessOptions, *apiError) {
+	var options processor.ProcessOptions
+	maxDimension := h.config.Transform.MaxDimension
+
+	// Dimensions share transform.max_dimension with /img/, since the output is allocated up front
+	for _, d := range []struct {
+		name  string
+		value *int
+	}{{"width", &options.Width}, {"height", &options.Height}} {
+		value := query.Get(d.name)
+		if value == "" {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 || (maxDimension > 0 && n > maxDimension) {
+			return options, newAPIError(http.StatusBadRequest, "invalid_resize_params",
+				fmt.Sprintf("Invalid '%s' parameter (must be between 0 and %d)", d.name, maxDimension))
+		}
+		*d.value = n
+	}
+	if preset := query.Get("preset"); preset != "" {
+		if _, ok := h.config.Resize.Presets[preset]; !ok {
+			return options, newAPIError(http.StatusBadRequest, "invalid_preset", fmt.Sprintf("Preset '%s' not found", preset))
+		}
+		options.Preset = preset
+	}
+	if apiErr := parseFitParams(&options, query.Get("fit"), query.Get("gravity"), query.Get("background"), query.Get("fx"), query.Get("fy")); apiErr != nil {
+		return options, apiErr
+	}
+	if format := query.Get("format"); format != "" {
+		if !processor.IsOutputFormat(format) {
+			return options, newAPIError(http.StatusBadRequest, "invalid_format", fmt.Sprintf("Output format '%s' is not supported", format))
+		}
+		options.Format = format
+	}
+	if apiErr := parseQualityParams(&options, query); apiErr != nil {
+		return options, apiErr
+	}
+	if metadata := query.Get("metadata"); metadata != "" {
+		if !processor.IsMetadataMode(metadata) {
+			return options, newAPIError(http.StatusBadRequest, "invalid_metadata", "Invalid 'metadata' parameter (must be strip or preserve)")
+		}
+		options.Metadata = metadata
+	}
+	if pageStr := query.Get("page"); pageStr != "" {
+		page, err := strconv.Atoi(pageStr)
+		if err != nil || page < 1 || page > processor.MaxPage {
+			return options, newAPIError(http.StatusBadRequest, "invalid_page",
+				fmt.Sprintf("Invalid 'page' parameter (must be between 1 and %d)", processor.MaxPage))
+		}
+		options.Page = page
+	}
+	if apiErr := parseOperations(&options, query.Get("ops")); apiErr != nil {
+		return options, apiErr
+	}
+	if watermark := query.Get("watermark"); watermark != "" {
+		if _, ok := h.config.Watermarks[watermark]; !ok {
+			return options, newAPIError(http.StatusBadRequest, "invalid_watermark", fmt.Sprintf("Watermark '%s' not found", watermark))
+		}
+		options.Watermark = watermark
+	}
+	if placeholders := query.Get("placeholders"); placeholders != "" {
+		cfg, err := processor.ParsePlaceholders(placeholders)
+		if err != nil {
+			return options, newAPIError(http.StatusBadRequest, "invalid_placeholders", fmt.Sprintf("Invalid 'placeholders' parameter: %v", err))
+		}
+		options.Placeholders = &cfg
+	}
+
+	return options, nil
 }
 
-func (h *Handler) downloadFromURL(urlStr string) ([]byte, error) {
-	resp, err := http.Get(urlStr)
+// parseQualityParams parses a fixed 'quality' or the quality search parameters
+// 'target_ssim', 'target_dssim', 'max_bytes', 'min_quality' and 'max_quality'
+func parseQualityParams(options *processor.ProcessOptions, query url.Values) *apiError {
+	invalid := func(name, rule string) *apiError {
+		return newAPIError(http.StatusBadRequest, "invalid_quality", fmt.Sprintf("Invalid '%s' parameter (%s)", name, rule))
+	}
+	parseInt := func(name string, lo, hi int) (int, *apiError) {
+		value := query.Get(name)
+		if value == "" {
+			return 0, nil
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < lo || n > hi {
+			return 0, invalid(name, fmt.Sprintf("must be between %d and %d", lo, hi))
+		}
+		return n, nil
+	}
+	parseFloat := func(name string, hi float64) (float64, *apiError) {
+		value := query.Get(name)
+		if value == "" {
+			return 0, nil
+		}
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil || f <= 0 || f >= hi {
+			return 0, invalid(name, fmt.Sprintf("must be greater than 0 and less than %g", hi))
+		}
+		return f, nil
+	}
+
+	var apiErr *apiError
+	if options.Quality, apiErr = parseInt("quality", 1, 100); apiErr != nil {
+		return apiErr
+	}
+	var search processor.QualitySearch
+	if search.TargetSSIM, apiErr = parseFloat("target_ssim", 1); apiErr != nil {
+		return apiErr
+	}
+	dssim, apiErr := parseFloat("target_dssim", 0.5)
+	if apiErr != nil {
+		return apiErr
+	}
+	if dssim > 0 {
+		if search.TargetSSIM > 0 {
+			return invalid("target_dssim", "cannot be combined with target_ssim")
+		}
+		search.TargetSSIM = metrics.SSIMFromDSSIM(dssim)
+	}
+	if search.MaxBytes, apiErr = parseInt("max_bytes", 1, math.MaxInt32); apiErr != nil {
+		return apiErr
+	}
+	if search.MinQuality, apiErr = parseInt("min_quality", 1, 100); apiErr != nil {
+		return apiErr
+	}
+	if search.MaxQuality, apiErr = parseInt("max_quality", 1, 100); apiErr != nil {
+		return apiErr
+	}
+	if search.MinQuality > 0 && search.MaxQuality > 0 && search.MinQuality > search.MaxQuality {
+		return invalid("min_quality", "must not exceed max_quality")
+	}
+	if search.Enabled() {
+		if options.Quality > 0 {
+			return invalid("quality", "cannot be combined with a quality search")
+		}
+		options.QualitySearch = &search
+	}
+	return nil
+}
+
+// parseOperations parses a pipeline such as "rotate:90|grayscale" into options
+func parseOperations(options *processor.ProcessOptions, ops string) *apiError {
+	parsed, err := processor.ParseOperations(ops)
 	if err != nil {
-		return nil, err
+		return newAPIError(http.StatusBadRequest, "invalid_operation", err.Error())
 	}
-	defer resp.Body.Close()
+	options.Operations = parsed
+	return nil
+}
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("bad status: %s", resp.Status)
+// parseFitParams validates the fit mode, gravity, letterbox background and focal point,
+// which share names across endpoints but not query parameter names
+func parseFitParams(options *processor.ProcessOptions, fit, gravity, background, fx, fy string) *apiError {
+	if fit != "" {
+		if !processor.IsFitMode(fit) {
+			return newAPIError(http.StatusBadRequest, "invalid_resize_params", fmt.Sprintf("Invalid fit mode: %s", fit))
+		}
+		options.Fit = fit
+	}
+	if gravity != "" {
+		if !processor.IsGravity(gravity) {
+			return newAPIError(http.StatusBadRequest, "invalid_resize_params", fmt.Sprintf("Invalid gravity: %s", gravity))
+		}
+		options.Gravity = gravity
+	}
+	if background != "" {
+		c, err := processor.ParseColor(background)
+		if err != nil {
+			return newAPIError(http.StatusBadRequest, "invalid_resize_params", fmt.Sprintf("Invalid background color: %s", background))
+		}
+		options.Background = c
+	}
+	if fx != "" || fy != "" {
+		x, errX := strconv.ParseFloat(fx, 64)
+		y, errY := strconv.ParseFloat(fy, 64)
+		if errX != nil || errY != nil || x < 0 || x > 1 || y < 0 || y > 1 {
+			return newAPIError(http.StatusBadRequest, "invalid_resize_params", "Invalid focal point: 'fx' and 'fy' must both be between 0 and 1")
+		}
+		options.Focal = &processor.FocalPoint{X: x, Y: y}
 	}
+	return nil
+}
 
-	return io.ReadAll(resp.Body)
+// loadSource downloads the image referenced by source (r2://bucket/key or http(s) URL).
+// For R2 sources it also returns the object key.
+func (h *Handler) loadSource(ctx context.Context, source string) ([]byte, string, *apiError) {
+	if strings.HasPrefix(source, "r2://") {
+		// Format: r2://bucket/key
+		parts := strings.SplitN(strings.TrimPrefix(source, "r2://"), "/", 2)
+		if len(parts) < 2 {
+			return nil, "", newAPIError(http.StatusBadRequest, "invalid_source_format", "Invalid R2 source format. Expected r2://bucket/key")
+		}
+		// In this version, we ignore the bucket name and use the configured one
+		// but we keep the key part
+		r2Key := parts[1]
+		if apiErr := authorizeObject(ctx, r2Key); apiErr != nil {
+			return nil, "", apiErr
+		}
+		data, err := h.storageClient.DownloadImage(ctx, r2Key)
+		if err != nil {
+			log.Printf("Failed to download from R2: %v", err)
+			if errors.Is(err, r2.ErrObjectTooLarge) {
+				return nil, "", h.tooLargeError()
+			}
+			return nil, "", newAPIError(http.StatusNotFound, "image_not_found", "Image not found in R2 bucket")
+		}
+		return data, r2Key, nil
+	}
+
+	if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+		if h.fetcher == nil {
+			return nil, "", newAPIError(http.StatusForbidden, "source_not_allowed", "URL sources are disabled")
+		}
+		data, err := h.fetcher.Fetch(ctx, source)
+		if err != nil {
+			log.Printf("Failed to download from URL: %v", err)
+			switch {
+			case errors.Is(err, fetch.ErrTooLarge):
+				return nil, "", h.tooLargeError()
+			case errors.Is(err, fetch.ErrBlocked):
+				return nil, "", newAPIError(http.StatusForbidden, "source_not_allowed", "Source URL points to a host or address that is not allowed")
+			case errors.Is(err, fetch.ErrNotImage):
+				return nil, "", newAPIError(http.StatusUnsupportedMediaType, "source_not_image", "Source URL did not return an image")
+			}
+			return nil, "", newAPIError(http.StatusNotFound, "url_not_accessible", "Source URL is not accessible")
+		}
+		return data, "", nil
+	}
+
+	return nil, "", newAPIError(http.StatusBadRequest, "invalid_source_format", "Source must be either r2://bucket/key or http(s):// URL")
+}
+
+// tooLargeError is returned for inputs over conversion.max_size_mb or conversion.max_pixels
+func (h *Handler) tooLargeError() *apiError {
+	return newAPIError(http.StatusRequestEntityTooLarge, "image_too_large",
+		fmt.Sprintf("Image exceeds the size limit (%d MB, %d pixels)", h.config.Conversion.MaxSizeMB, h.config.Conversion.MaxPixels))
+}
+
+// processError maps Process errors caused by the input or the request to an API error.
+// It returns nil for other failures, which the caller reports as a conversion failure.
+func (h *Handler) processError(err error) *apiError {
+	switch {
+	case errors.Is(err, processor.ErrImageTooLarge):
+		return h.tooLargeError()
+	case errors.Is(err, processor.ErrPageOutOfRange):
+		return newAPIError(http.StatusBadRequest, "invalid_page", "The requested page does not exist in the image")
+	case errors.Is(err, processor.ErrInvalidOperation):
+		return newAPIError(http.StatusBadRequest, "invalid_operation", err.Error())
+	}
+	return nil
 }
 
 func (h *Handler) sendJSON(w http.ResponseWriter, status int, data interface{}) {
@@ -256,3 +613,18 @@ func (h *Handler) sendError(w http.ResponseWriter, status int, code, message str
 		Message: message,
 	})
 }
+
+// apiError carries an HTTP status and error code from a helper back to its handler
+type apiError struct {
+	status  int
+	code    string
+	message string
+}
+
+func newAPIError(status int, code, message string) *apiError {
+	return &apiError{status: status, code: code, message: message}
+}
+
+func (h *Handler) sendAPIError(w http.ResponseWriter, e *apiError) {
+	h.sendError(w, e.status, e.code, e.message)
+}
@@ -4,16 +4,20 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"fmt"
 	"image"
 	"image/color"
 	"image/png"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"strings"
 	"testing"
 	"time"
 
 	"image-converting-server/config"
 	"image-converting-server/processor"
+	"image-converting-server/r2"
 )
 
 // mockStorageClient is a mock implementation of the r2.StorageClient interface
@@ -22,6 +26,7 @@ type mockStorageClient struct {
 	uploadFunc   func(ctx context.Context, key string, data []byte, contentType string) error
 	listFunc     func(ctx context.Context, since time.Time) ([]string, error)
 	testFunc     func(ctx context.Context) error
+	metadata     map[string]map[string]string // Metadata of uploads, by key; recorded when non-nil
 }
 
 func (m *mockStorageClient) DownloadImage(ctx context.Context, key string) ([]byte, error) {
@@ -32,6 +37,13 @@ func (m *mockStorageClient) UploadImage(ctx context.Context, key string, data []
 	return m.uploadFunc(ctx, key, data, contentType)
 }
 
+func (m *mockStorageClient) UploadImageWithMetadata(ctx context.Context, key string, data []byte, contentType string, metadata map[string]string) error {
+	if m.metadata != nil {
+		m.metadata[key] = metadata
+	}
+	return m.uploadFunc(ctx, key, data, contentType)
+}
+
 func (m *mockStorageClient) ListObjects(ctx context.Context, since time.Time) ([]string, error) {
 	return m.listFunc(ctx, since)
 }
@@ -167,6 +179,28 @@ func TestHandleConvert_InvalidSource(t *testing.T) {
 	}
 }
 
+func TestHandleConvert_InvalidWatermark(t *testing.T) {
+	cfg := &config.Config{
+		Watermarks: map[string]config.WatermarkConfig{"logo": {File: "logo.png"}},
+	}
+	h := NewHandler(nil, nil, cfg)
+
+	req := httptest.NewRequest("GET", "/api/convert?source=r2://test-bucket/test.png&watermark=stamp", nil)
+	w := httptest.NewRecorder()
+
+	h.HandleConvert(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d, got %d", http.StatusBadRequest, w.Code)
+	}
+
+	var resp ErrorResponse
+	json.NewDecoder(w.Body).Decode(&resp)
+	if resp.Error != "invalid_watermark" {
+		t.Errorf("expected error invalid_watermark, got %s", resp.Error)
+	}
+}
+
 func TestHandleConvert_GET(t *testing.T) {
 	// Setup 1x1 pixel PNG
 	img := image.NewRGBA(image.Rect(0, 0, 1, 1))
@@ -211,6 +245,307 @@ func TestHandleConvert_GET(t *testing.T) {
 	}
 }
 
+func TestHandleConvert_Operations(t *testing.T) {
+	var buf bytes.Buffer
+	png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 40, 20)))
+	imgData := buf.Bytes()
+
+	cfg := &config.Config{
+		R2: config.R2Config{Bucket: "test-bucket"},
+		Conversion: config.ConversionConfig{
+			Formats: []string{"png"},
+			Quality: 80,
+		},
+	}
+	var uploaded []byte
+	mockStorage := &mockStorageClient{
+		downloadFunc: func(ctx context.Context, key string) ([]byte, error) {
+			return imgData, nil
+		},
+		uploadFunc: func(ctx context.Context, key string, data []byte, contentType string) error {
+			uploaded = data
+			return nil
+		},
+	}
+	h := NewHandler(mockStorage, processor.NewProcessor(*cfg), cfg)
+
+	tests := []struct {
+		name    string
+		target  string
+		request ConvertRequest
+		status  int
+		size    image.Point
+	}{
+		{"query string", "/api/convert?ops=rotate:90", ConvertRequest{}, http.StatusOK, image.Pt(20, 40)},
+		{"JSON array", "/api/convert", ConvertRequest{Operations: []string{"crop:0,0,10,10", "grayscale"}}, http.StatusOK, image.Pt(10, 10)},
+		{"JSON overrides query", "/api/convert?ops=rotate:90", ConvertRequest{Operations: []string{"flip:v"}}, http.StatusOK, image.Pt(40, 20)},
+		{"invalid query", "/api/convert?ops=rotate", ConvertRequest{}, http.StatusBadRequest, image.Point{}},
+		{"invalid JSON", "/api/convert", ConvertRequest{Operations: []string{"spin:90"}}, http.StatusBadRequest, image.Point{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.request.Source = "r2://test-bucket/test.png"
+			body, _ := json.Marshal(tt.request)
+			req := httptest.NewRequest("POST", tt.target, bytes.NewReader(body))
+			w := httptest.NewRecorder()
+			h.HandleConvert(w, req)
+
+			if w.Code != tt.status {
+				t.Fatalf("expected status %d, got %d, body: %s", tt.status, w.Code, w.Body.String())
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			decoded, _, err := image.Decode(bytes.NewReader(uploaded))
+			if err != nil {
+				t.Fatalf("failed to decode upload: %v", err)
+			}
+			if size := decoded.Bounds().Size(); size != tt.size {
+				t.Errorf("expected %v, got %v", tt.size, size)
+			}
+		})
+	}
+}
+
+func TestHandleConvert_QualitySearch(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 48, 48))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i * 7919 % 251)
+	}
+	var buf bytes.Buffer
+	png.Encode(&buf, img)
+	imgData := buf.Bytes()
+
+	cfg := &config.Config{
+		R2: config.R2Config{Bucket: "test-bucket"},
+		Conversion: config.ConversionConfig{
+			Formats: []string{"png"},
+			Quality: 80,
+		},
+	}
+	var uploaded []byte
+	mockStorage := &mockStorageClient{
+		downloadFunc: func(ctx context.Context, key string) ([]byte, error) {
+			return imgData, nil
+		},
+		uploadFunc: func(ctx context.Context, key string, data []byte, contentType string) error {
+			uploaded = data
+			return nil
+		},
+	}
+	h := NewHandler(mockStorage, processor.NewProcessor(*cfg), cfg)
+
+	tests := []struct {
+		name   string
+		query  string
+		status int
+		search bool
+	}{
+		{"ssim target", "format=jpeg&target_ssim=0.9", http.StatusOK, true},
+		{"dssim target with bounds", "format=webp&target_dssim=0.02&min_quality=40&max_quality=90", http.StatusOK, true},
+		{"byte budget", "format=jpeg&max_bytes=3000", http.StatusOK, true},
+		{"fixed quality", "format=jpeg&quality=50", http.StatusOK, false},
+		{"lossless format", "format=png&target_ssim=0.9", http.StatusOK, false},
+		{"ssim out of range", "target_ssim=1.5", http.StatusBadRequest, false},
+		{"both targets", "target_ssim=0.9&target_dssim=0.01", http.StatusBadRequest, false},
+		{"inverted bounds", "max_bytes=1000&min_quality=90&max_quality=10", http.StatusBadRequest, false},
+		{"quality with search", "quality=50&max_bytes=1000", http.StatusBadRequest, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/api/convert?source=r2://test-bucket/test.png&"+tt.query, nil)
+			w := httptest.NewRecorder()
+			h.HandleConvert(w, req)
+
+			if w.Code != tt.status {
+				t.Fatalf("expected status %d, got %d, body: %s", tt.status, w.Code, w.Body.String())
+			}
+			if tt.status != http.StatusOK {
+				var res ErrorResponse
+				json.NewDecoder(w.Body).Decode(&res)
+				if res.Error != "invalid_quality" {
+					t.Errorf("expected invalid_quality, got %s", res.Error)
+				}
+				return
+			}
+			var res ConvertResponse
+			json.NewDecoder(w.Body).Decode(&res)
+			if !tt.search {
+				if res.Quality != 0 || res.SSIM != 0 {
+					t.Errorf("expected no search, got quality %d and SSIM %v", res.Quality, res.SSIM)
+				}
+				return
+			}
+			if res.Quality < 1 || res.SSIM <= 0 || res.SSIM > 1 {
+				t.Errorf("expected the chosen quality and score, got quality %d and SSIM %v", res.Quality, res.SSIM)
+			}
+			if res.ConvertedSize != len(uploaded) {
+				t.Errorf("expected converted_size %d, got %d", len(uploaded), res.ConvertedSize)
+			}
+		})
+	}
+}
+
+func TestHandleConvert_Placeholders(t *testing.T) {
+	var buf bytes.Buffer
+	png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 40, 30)))
+	imgData := buf.Bytes()
+
+	cfg := &config.Config{
+		R2: config.R2Config{Bucket: "test-bucket"},
+		Conversion: config.ConversionConfig{
+			Formats:      []string{"png"},
+			Quality:      80,
+			Placeholders: config.PlaceholderConfig{BlurHash: true, PreviewSize: 20},
+		},
+	}
+	mockStorage := &mockStorageClient{
+		downloadFunc: func(ctx context.Context, key string) ([]byte, error) {
+			return imgData, nil
+		},
+		uploadFunc: func(ctx context.Context, key string, data []byte, contentType string) error {
+			return nil
+		},
+	}
+	h := NewHandler(mockStorage, processor.NewProcessor(*cfg), cfg)
+
+	tests := []struct {
+		name      string
+		query     string
+		status    int
+		blurHash  bool
+		thumbHash bool
+		preview   bool
+	}{
+		{"configured", "", http.StatusOK, true, false, false},
+		{"requested", "placeholders=thumbhash,preview", http.StatusOK, false, true, true},
+		{"none", "placeholders=none", http.StatusOK, false, false, false},
+		{"unknown kind", "placeholders=lqip", http.StatusBadRequest, false, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockStorage.metadata = map[string]map[string]string{}
+			req := httptest.NewRequest("GET", "/api/convert?source=r2://test-bucket/test.png&"+tt.query, nil)
+			w := httptest.NewRecorder()
+			h.HandleConvert(w, req)
+
+			if w.Code != tt.status {
+				t.Fatalf("expected status %d, got %d, body: %s", tt.status, w.Code, w.Body.String())
+			}
+			if tt.status != http.StatusOK {
+				var res ErrorResponse
+				json.NewDecoder(w.Body).Decode(&res)
+				if res.Error != "invalid_placeholders" {
+					t.Errorf("expected invalid_placeholders, got %s", res.Error)
+				}
+				return
+			}
+			var res ConvertResponse
+			json.NewDecoder(w.Body).Decode(&res)
+			p := res.Placeholders
+			if p == nil {
+				p = &processor.Placeholders{}
+			}
+			if (p.BlurHash != "") != tt.blurHash || (p.ThumbHash != "") != tt.thumbHash || (p.Preview != "") != tt.preview {
+				t.Fatalf("unexpected placeholders: %+v", res.Placeholders)
+			}
+			if p.Preview != "" && !strings.HasPrefix(p.Preview, "data:image/webp;base64,") {
+				t.Errorf("expected a WebP data URI, got %s", p.Preview)
+			}
+			metadata := mockStorage.metadata["test.webp"]
+			if metadata["blurhash"] != p.BlurHash || metadata["thumbhash"] != p.ThumbHash || metadata["preview"] != p.Preview {
+				t.Errorf("expected metadata to match the response, got %v", metadata)
+			}
+		})
+	}
+}
+
+func TestHandleConvert_Variants(t *testing.T) {
+	var buf bytes.Buffer
+	png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 200, 100)))
+	imgData := buf.Bytes()
+
+	cfg := &config.Config{
+		R2: config.R2Config{Bucket: "test-bucket"},
+		Conversion: config.ConversionConfig{
+			Formats: []string{"png"},
+			Quality: 80,
+		},
+		Responsive: config.ResponsiveConfig{
+			BaseURL: "https://cdn.example.com",
+			Sets:    map[string]config.VariantSetConfig{"web": {Widths: []int{50, 100}}},
+		},
+		Transform: config.TransformConfig{MaxDimension: 1000},
+	}
+	uploads := make(map[string]string)
+	mockStorage := &mockStorageClient{
+		downloadFunc: func(ctx context.Context, key string) ([]byte, error) {
+			return imgData, nil
+		},
+		uploadFunc: func(ctx context.Context, key string, data []byte, contentType string) error {
+			uploads[key] = contentType
+			return nil
+		},
+	}
+	h := NewHandler(mockStorage, processor.NewProcessor(*cfg), cfg)
+
+	req := httptest.NewRequest("GET", "/api/convert?source=r2://test-bucket/photos/cat.png&widths=50,100&formats=webp,png", nil)
+	w := httptest.NewRecorder()
+	h.HandleConvert(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d, body: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	var resp ConvertResponse
+	json.NewDecoder(w.Body).Decode(&resp)
+	if len(resp.Variants) != 4 {
+		t.Fatalf("expected 4 variants, got %d", len(resp.Variants))
+	}
+	if resp.Destination != "r2://test-bucket/photos/cat.variants.json" {
+		t.Errorf("unexpected destination: %s", resp.Destination)
+	}
+	if resp.Srcset["webp"] != "https://cdn.example.com/photos/cat.w50.webp 50w, https://cdn.example.com/photos/cat.w100.webp 100w" {
+		t.Errorf("unexpected webp srcset: %s", resp.Srcset["webp"])
+	}
+	for _, key := range []string{"photos/cat.w50.webp", "photos/cat.w100.webp", "photos/cat.w50.png", "photos/cat.w100.png", "photos/cat.variants.json"} {
+		if _, ok := uploads[key]; !ok {
+			t.Errorf("expected %s to be uploaded", key)
+		}
+	}
+	for _, v := range resp.Variants {
+		if v.Width == 100 && v.Height != 50 {
+			t.Errorf("%s: expected height 50, got %d", v.Key, v.Height)
+		}
+	}
+
+	// A configured set by name
+	req = httptest.NewRequest("GET", "/api/convert?source=r2://test-bucket/photos/cat.png&variants=web", nil)
+	w = httptest.NewRecorder()
+	h.HandleConvert(w, req)
+	resp = ConvertResponse{}
+	json.NewDecoder(w.Body).Decode(&resp)
+	if w.Code != http.StatusOK || len(resp.Variants) != 2 {
+		t.Errorf("expected 2 variants from the web set, got %d (status %d)", len(resp.Variants), w.Code)
+	}
+
+	invalid := []string{
+		"variants=print",
+		"widths=50,abc",
+		"widths=5000",
+		"widths=50&formats=heic",
+	}
+	for _, query := range invalid {
+		req := httptest.NewRequest("GET", "/api/convert?source=r2://test-bucket/photos/cat.png&"+query, nil)
+		w := httptest.NewRecorder()
+		h.HandleConvert(w, req)
+		var errResp ErrorResponse
+		json.NewDecoder(w.Body).Decode(&errResp)
+		if w.Code != http.StatusBadRequest || errResp.Error != "invalid_variants" {
+			t.Errorf("%s: expected 400 invalid_variants, got %d %s", query, w.Code, errResp.Error)
+		}
+	}
+}
+
 func TestHandleConvert_URL(t *testing.T) {
 	// Setup a mock image server
 	img := image.NewRGBA(image.Rect(0, 0, 1, 1))
@@ -231,6 +566,8 @@ func TestHandleConvert_URL(t *testing.T) {
 			Formats: []string{"png"},
 			Quality: 80,
 		},
+		// The test server listens on loopback, which is blocked by default
+		Fetch: config.FetchConfig{AllowedCIDRs: []string{"127.0.0.0/8", "::1/128"}},
 	}
 
 	mockStorage := &mockStorageClient{
@@ -256,3 +593,94 @@ func TestHandleConvert_URL(t *testing.T) {
 		t.Errorf("expected status %d, got %d, body: %s", http.StatusOK, w.Code, w.Body.String())
 	}
 }
+
+func TestHandleConvert_TooLarge(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 200, 200))
+	var buf bytes.Buffer
+	png.Encode(&buf, img)
+	imgData := buf.Bytes()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(make([]byte, 2<<20))
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{
+		R2: config.R2Config{Bucket: "test-bucket"},
+		Conversion: config.ConversionConfig{
+			Formats:   []string{"png"},
+			Quality:   80,
+			MaxSizeMB: 1,
+			MaxPixels: 100 * 100,
+		},
+		Fetch: config.FetchConfig{AllowedCIDRs: []string{"127.0.0.0/8", "::1/128"}},
+	}
+
+	mockStorage := &mockStorageClient{
+		downloadFunc: func(ctx context.Context, key string) ([]byte, error) {
+			if key == "huge.png" {
+				return nil, fmt.Errorf("%w (key: %s)", r2.ErrObjectTooLarge, key)
+			}
+			return imgData, nil
+		},
+		uploadFunc: func(ctx context.Context, key string, data []byte, contentType string) error {
+			t.Errorf("unexpected upload of %s", key)
+			return nil
+		},
+	}
+	h := NewHandler(mockStorage, processor.NewProcessor(*cfg), cfg)
+
+	sources := map[string]string{
+		"R2 object over max_size_mb": "r2://test-bucket/huge.png",
+		"URL over max_size_mb":       server.URL + "/huge.png",
+		"Over max_pixels":            "r2://test-bucket/wide.png",
+	}
+	for name, source := range sources {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/api/convert?source="+url.QueryEscape(source), nil)
+			w := httptest.NewRecorder()
+			h.HandleConvert(w, req)
+
+			if w.Code != http.StatusRequestEntityTooLarge {
+				t.Fatalf("expected status %d, got %d, body: %s", http.StatusRequestEntityTooLarge, w.Code, w.Body.String())
+			}
+			var resp ErrorResponse
+			json.NewDecoder(w.Body).Decode(&resp)
+			if resp.Error != "image_too_large" {
+				t.Errorf("expected error image_too_large, got %s", resp.Error)
+			}
+		})
+	}
+}
+
+func TestHandleConvert_URLBlocked(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("blocked destination should not be contacted")
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{
+		Conversion: config.ConversionConfig{Formats: []string{"png"}},
+	}
+	h := NewHandler(&mockStorageClient{}, processor.NewProcessor(*cfg), cfg)
+
+	for _, source := range []string{server.URL + "/image.png", "http://169.254.169.254/latest/meta-data"} {
+		reqBody := ConvertRequest{Source: source}
+		body, _ := json.Marshal(reqBody)
+		req := httptest.NewRequest("POST", "/api/convert", bytes.NewReader(body))
+		w := httptest.NewRecorder()
+
+		h.HandleConvert(w, req)
+
+		if w.Code != http.StatusForbidden {
+			t.Errorf("%s: expected status %d, got %d, body: %s", source, http.StatusForbidden, w.Code, w.Body.String())
+			continue
+		}
+		var resp ErrorResponse
+		json.NewDecoder(w.Body).Decode(&resp)
+		if resp.Error != "source_not_allowed" {
+			t.Errorf("%s: expected error source_not_allowed, got %s", source, resp.Error)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"image-converting-server/processor"
+)
+
+// negotiableFormats lists the formats HandleImage can choose between, in order of preference
+var negotiableFormats = []struct {
+	format    string
+	mediaType string
+}{
+	{processor.FormatAVIF, "image/avif"},
+	{processor.FormatWebP, "image/webp"},
+	{processor.FormatJPEG, "image/jpeg"},
+}
+
+// HandleImage handles GET /api/image.
+// It converts the source image and returns the bytes directly, choosing
+// AVIF, WebP or JPEG based on the client's Accept header.
+func (h *Handler) HandleImage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		h.sendError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+		return
+	}
+
+	if apiErr := h.verifySignature(r); apiErr != nil {
+		h.sendAPIError(w, apiErr)
+		return
+	}
+
+	query := r.URL.Query()
+	source := query.Get("source")
+	if source == "" {
+		h.sendError(w, http.StatusBadRequest, "missing_source", "The 'source' parameter is required")
+		return
+	}
+
+	options, apiErr := h.parseProcessOptions(query)
+	if apiErr != nil {
+		h.sendAPIError(w, apiErr)
+		return
+	}
+	// An explicit format wins; otherwise negotiate from the Accept header
+	if options.Format == "" {
+		options.Format = h.negotiateFormat(r.Header.Get("Accept"))
+	}
+
+	encoder, err := h.processor.Encoder(options.Format)
+	if err != nil {
+		h.sendError(w, http.StatusBadRequest, "invalid_format", err.Error())
+		return
+	}
+
+	data, _, apiErr := h.loadSource(r.Context(), source)
+	if apiErr != nil {
+		h.sendAPIError(w, apiErr)
+		return
+	}
+
+	options.Context = r.Context()
+	converted, _, err := h.processor.Process(data, options)
+	if err != nil {
+		log.Printf("Conversion failed: %v", err)
+		if apiErr := h.processError(err); apiErr != nil {
+			h.sendAPIError(w, apiErr)
+			return
+		}
+		h.sendError(w, http.StatusInternalServerError, "conversion_failed", "Failed to convert image")
+		return
+	}
+
+	w.Header().Set("Vary", "Accept")
+	h.sendImage(w, converted, encoder.ContentType(), "")
+}
+
+// negotiateFormat picks the best output format for an Accept header.
+// AVIF and WebP are only chosen when the client lists them explicitly, since
+// wildcards are also sent by browsers that cannot decode them. JPEG is the fallback.
+func (h *Handler) negotiateFormat(accept string) string {
+	accepted := parseAccept(accept)
+
+	best := processor.FormatJPEG
+	bestQ := 0.0
+	for _, candidate := range negotiableFormats {
+		q, ok := accepted[candidate.mediaType]
+		if !ok || q <= bestQ {
+			continue
+		}
+		if !h.processor.CanEncode(candidate.format) {
+			continue
+		}
+		best, bestQ = candidate.format, q
+	}
+	return best
+}
+
+// parseAccept returns the quality value of each explicitly listed media type
+func parseAccept(accept string) map[string]float64 {
+	accepted := make(map[string]float64)
+	for _, part := range strings.Split(accept, ",") {
+		fields := strings.Split(part, ";")
+		mediaType := strings.ToLower(strings.TrimSpace(fields[0]))
+		if mediaType == "" {
+			continue
+		}
+		q := 1.0
+		for _, param := range fields[1:] {
+			key, value, found := strings.Cut(strings.TrimSpace(param), "=")
+			if found && strings.EqualFold(key, "q") {
+				if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+		accepted[mediaType] = q
+	}
+	return accepted
+}
@@ -0,0 +1,130 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"image-converting-server/config"
+	"image-converting-server/processor"
+)
+
+func newImageTestHandler(t *testing.T, avifEncoderPath string) *Handler {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	img.Set(0, 0, color.RGBA{255, 0, 0, 255})
+	var buf bytes.Buffer
+	png.Encode(&buf, img)
+	imgData := buf.Bytes()
+
+	cfg := &config.Config{
+		R2: config.R2Config{Bucket: "test-bucket"},
+		Conversion: config.ConversionConfig{
+			Formats: []string{"png"},
+			Quality: 80,
+			AVIF:    config.AVIFConfig{Quality: 50, Speed: 6, EncoderPath: avifEncoderPath},
+		},
+		Transform: config.TransformConfig{MaxDimension: 4096},
+	}
+	mockStorage := &mockStorageClient{
+		downloadFunc: func(ctx context.Context, key string) ([]byte, error) {
+			return imgData, nil
+		},
+	}
+	return NewHandler(mockStorage, processor.NewProcessor(*cfg), cfg)
+}
+
+func TestHandleImage_Negotiation(t *testing.T) {
+	// Fake avifenc that writes a recognizable payload to its output path
+	dir := t.TempDir()
+	fakeAVIF := filepath.Join(dir, "avifenc")
+	script := "#!/bin/sh\nfor last; do :; done\nprintf 'fake-avif' > \"$last\"\n"
+	if err := os.WriteFile(fakeAVIF, []byte(script), 0755); err != nil {
+		t.Fatalf("failed to write fake encoder: %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		accept      string
+		avifPath    string
+		contentType string
+	}{
+		{"AVIF preferred", "image/avif,image/webp,*/*", fakeAVIF, "image/avif"},
+		{"AVIF unavailable", "image/avif,image/webp,*/*", filepath.Join(dir, "missing"), "image/webp"},
+		{"WebP only", "image/webp,*/*;q=0.8", fakeAVIF, "image/webp"},
+		{"Quality values", "image/avif;q=0.5,image/webp;q=0.9", fakeAVIF, "image/webp"},
+		{"Wildcard only", "*/*", fakeAVIF, "image/jpeg"},
+		{"No header", "", fakeAVIF, "image/jpeg"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newImageTestHandler(t, tt.avifPath)
+			req := httptest.NewRequest("GET", "/api/image?source=r2://test-bucket/test.png", nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			w := httptest.NewRecorder()
+
+			h.HandleImage(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("expected status %d, got %d, body: %s", http.StatusOK, w.Code, w.Body.String())
+			}
+			if got := w.Header().Get("Content-Type"); got != tt.contentType {
+				t.Errorf("expected content type %s, got %s", tt.contentType, got)
+			}
+			if got := w.Header().Get("Vary"); got != "Accept" {
+				t.Errorf("expected Vary: Accept, got %q", got)
+			}
+			if w.Body.Len() == 0 {
+				t.Error("expected image bytes in response body")
+			}
+		})
+	}
+}
+
+func TestHandleImage_ExplicitFormat(t *testing.T) {
+	h := newImageTestHandler(t, "")
+	req := httptest.NewRequest("GET", "/api/image?source=r2://test-bucket/test.png&format=png", nil)
+	req.Header.Set("Accept", "image/webp")
+	w := httptest.NewRecorder()
+
+	h.HandleImage(w, req)
+
+	if got := w.Header().Get("Content-Type"); got != "image/png" {
+		t.Errorf("expected explicit format to win, got %s", got)
+	}
+}
+
+func TestHandleImage_InvalidParams(t *testing.T) {
+	h := newImageTestHandler(t, "")
+	tests := []struct {
+		query string
+		code  string
+	}{
+		{"page=0", "invalid_page"},
+		{"page=1099511627776", "invalid_page"},
+		{"width=60000&height=60000", "invalid_resize_params"},
+		{"height=5000", "invalid_resize_params"},
+		{"width=-1", "invalid_resize_params"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/api/image?source=r2://test-bucket/test.png&"+tt.query, nil)
+			w := httptest.NewRecorder()
+			h.HandleImage(w, req)
+			if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), tt.code) {
+				t.Errorf("expected 400 %s, got %d: %s", tt.code, w.Code, w.Body.String())
+			}
+		})
+	}
+}
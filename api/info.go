@@ -0,0 +1,67 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"image-converting-server/processor"
+)
+
+// defaultInfoColors is the palette size returned when 'colors' is not given
+const defaultInfoColors = 5
+
+// InfoResponse represents the success response for /api/info
+type InfoResponse struct {
+	Success bool   `json:"success"`
+	Source  string `json:"source"`
+	Size    int    `json:"size"` // Encoded size in bytes
+	processor.Info
+}
+
+// HandleInfo handles GET /api/info.
+// It describes the source image without converting it. With colors=0 only the
+// header is read; otherwise the pixels are decoded for the dominant colors.
+func (h *Handler) HandleInfo(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		h.sendError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+		return
+	}
+
+	query := r.URL.Query()
+	source := query.Get("source")
+	if source == "" {
+		h.sendError(w, http.StatusBadRequest, "missing_source", "The 'source' parameter is required")
+		return
+	}
+	colors := defaultInfoColors
+	if s := query.Get("colors"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 || n > processor.MaxPaletteColors {
+			h.sendError(w, http.StatusBadRequest, "invalid_colors",
+				fmt.Sprintf("Invalid 'colors' parameter (must be between 0 and %d)", processor.MaxPaletteColors))
+			return
+		}
+		colors = n
+	}
+
+	data, _, apiErr := h.loadSource(r.Context(), source)
+	if apiErr != nil {
+		h.sendAPIError(w, apiErr)
+		return
+	}
+	info, err := h.processor.ReadInfo(data, colors)
+	if err != nil {
+		log.Printf("Failed to read %s: %v", source, err)
+		if apiErr := h.processError(err); apiErr != nil {
+			h.sendAPIError(w, apiErr)
+			return
+		}
+		h.sendError(w, http.StatusBadRequest, "invalid_image", fmt.Sprintf("Failed to read %s", source))
+		return
+	}
+
+	h.sendJSON(w, http.StatusOK, InfoResponse{Success: true, Source: source, Size: len(data), Info: *info})
+}
@@ -0,0 +1,109 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"image-converting-server/config"
+	"image-converting-server/processor"
+)
+
+func TestHandleInfo(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 30, 20))
+	for i := 0; i < len(img.Pix); i += 4 {
+		img.Pix[i], img.Pix[i+3] = 255, 255
+	}
+	img.Set(0, 0, color.NRGBA{})
+	var buf bytes.Buffer
+	png.Encode(&buf, img)
+	objects := map[string][]byte{
+		"photo.png":  buf.Bytes(),
+		"broken.png": []byte("not an image"),
+	}
+
+	cfg := &config.Config{
+		R2:         config.R2Config{Bucket: "test-bucket"},
+		Conversion: config.ConversionConfig{Formats: []string{"png"}, Quality: 80},
+	}
+	mockStorage := &mockStorageClient{
+		downloadFunc: func(ctx context.Context, key string) ([]byte, error) {
+			data, ok := objects[key]
+			if !ok {
+				return nil, errors.New("not found")
+			}
+			return data, nil
+		},
+	}
+	h := NewHandler(mockStorage, processor.NewProcessor(*cfg), cfg)
+
+	info := func(method, query string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.HandleInfo(w, httptest.NewRequest(method, "/api/info"+query, nil))
+		return w
+	}
+
+	t.Run("full", func(t *testing.T) {
+		w := info("GET", "?source=r2://test-bucket/photo.png")
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+		var res InfoResponse
+		json.NewDecoder(w.Body).Decode(&res)
+		if !res.Success || res.Format != "png" || res.MimeType != "image/png" || res.Size != len(objects["photo.png"]) {
+			t.Errorf("unexpected response: %+v", res)
+		}
+		if res.Width != 30 || res.Height != 20 || !res.HasAlpha || res.Frames != 1 {
+			t.Errorf("expected a transparent 30x20 still image, got %+v", res)
+		}
+		if len(res.DominantColors) == 0 || res.DominantColors[0].Hex != "#ff0000" {
+			t.Errorf("expected red to dominate, got %v", res.DominantColors)
+		}
+	})
+
+	t.Run("header only", func(t *testing.T) {
+		w := info("GET", "?source=r2://test-bucket/photo.png&colors=0")
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+		var res map[string]any
+		json.NewDecoder(w.Body).Decode(&res)
+		if _, ok := res["dominant_colors"]; ok || res["width"] != 30.0 {
+			t.Errorf("expected dimensions without colors, got %v", res)
+		}
+	})
+
+	tests := []struct {
+		name   string
+		method string
+		query  string
+		status int
+		code   string
+	}{
+		{"missing source", "GET", "", http.StatusBadRequest, "missing_source"},
+		{"invalid colors", "GET", "?source=r2://test-bucket/photo.png&colors=99", http.StatusBadRequest, "invalid_colors"},
+		{"not an image", "GET", "?source=r2://test-bucket/broken.png", http.StatusBadRequest, "invalid_image"},
+		{"not found", "GET", "?source=r2://test-bucket/missing.png", http.StatusNotFound, "image_not_found"},
+		{"wrong method", "POST", "?source=r2://test-bucket/photo.png", http.StatusMethodNotAllowed, "method_not_allowed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := info(tt.method, tt.query)
+			if w.Code != tt.status {
+				t.Fatalf("expected %d, got %d: %s", tt.status, w.Code, w.Body.String())
+			}
+			var res ErrorResponse
+			json.NewDecoder(w.Body).Decode(&res)
+			if res.Error != tt.code {
+				t.Errorf("expected %s, got %s", tt.code, res.Error)
+			}
+		})
+	}
+}
@@ -0,0 +1,227 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"image-converting-server/auth"
+	"image-converting-server/jobs"
+	"image-converting-server/webhook"
+)
+
+// JobResponse represents the response for /api/jobs. The request is the
+// BatchRequest and the result is a BatchResponse.
+type JobResponse struct {
+	Success bool `json:"success"`
+	jobs.Job
+}
+
+// newJobResponse returns the response for job. The stored request also names
+// the owning key and its prefixes, which are left out.
+func newJobResponse(job jobs.Job) JobResponse {
+	var req jobRequest
+	if err := json.Unmarshal(job.Request, &req); err == nil {
+		job.Request, _ = json.Marshal(req.BatchRequest)
+	}
+	return JobResponse{Success: true, Job: job}
+}
+
+// jobRequest is the stored request of a job: the batch and the API key that
+// submitted it, whose prefixes apply when the job runs
+type jobRequest struct {
+	BatchRequest
+	Owner    string   `json:"owner,omitempty"`
+	Prefixes []string `json:"prefixes,omitempty"`
+}
+
+// SetJobQueue enables /api/jobs with the queue; the queue's runner should be RunJob
+func (h *Handler) SetJobQueue(queue *jobs.Queue) {
+	h.jobs = queue
+}
+
+// SetWebhook enables job completion events and the callback_url of /api/jobs.
+// Set NotifyJob as the queue's OnFinish function to send them.
+func (h *Handler) SetWebhook(dispatcher *webhook.Dispatcher) {
+	h.webhook = dispatcher
+}
+
+// NotifyJob sends the job.finished event of a job to its callback_url, or to
+// webhook.url when it has none
+func (h *Handler) NotifyJob(job jobs.Job) {
+	if h.webhook == nil {
+		return
+	}
+	var req BatchRequest
+	var res BatchResponse
+	json.Unmarshal(job.Request, &req)
+	if job.Result != nil {
+		json.Unmarshal(job.Result, &res)
+	}
+
+	event := webhook.Event{
+		Type:   webhook.EventJobFinished,
+		JobID:  job.ID,
+		Status: string(job.Status),
+		Error:  job.Error,
+		Items:  make([]webhook.Item, len(req.Items)),
+	}
+	for i, item := range req.Items {
+		event.Items[i].Source = item.Source
+		if i >= len(res.Results) {
+			continue
+		}
+		if result := res.Results[i]; !result.Success {
+			event.Items[i].Error = result.Message
+		} else if converted := result.Result; converted != nil {
+			event.Items[i].Destination = converted.Destination
+			event.Items[i].OriginalSize = converted.OriginalSize
+			event.Items[i].ConvertedSize = converted.ConvertedSize
+			for _, v := range converted.Variants {
+				event.Items[i].Variants = append(event.Items[i].Variants, v.Key)
+			}
+		}
+	}
+	if err := h.webhook.Send(req.CallbackURL, event); err != nil {
+		log.Printf("[ERROR] Failed to queue webhook for job %s: %v", job.ID, err)
+	}
+}
+
+// RunJob is the jobs.Runner for conversion jobs. It converts the items of a
+// stored BatchRequest like /api/convert/batch and reports one unit of progress
+// per item. Items failing on their own do not fail the job.
+func (h *Handler) RunJob(ctx context.Context, request json.RawMessage, progress func(done int)) (json.RawMessage, error) {
+	var req jobRequest
+	if err := json.Unmarshal(request, &req); err != nil {
+		return nil, fmt.Errorf("invalid job request: %w", err)
+	}
+	if req.Owner != "" {
+		ctx = auth.NewContext(ctx, &auth.Key{Name: req.Owner, Prefixes: req.Prefixes})
+	}
+	res := newBatchResponse(h.convertItems(ctx, req.Items, nil, h.config.Batch.Concurrency, progress))
+	log.Printf("[INFO] Job converted %d of %d items", res.Succeeded, len(res.Results))
+	return json.Marshal(res)
+}
+
+// HandleJobs handles POST /api/jobs.
+// It takes the same body as /api/convert/batch, queues it and responds 202 with
+// the job. Query parameters are merged into the items when the job is queued.
+func (h *Handler) HandleJobs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		h.sendError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+		return
+	}
+	if h.jobs == nil {
+		h.sendError(w, http.StatusServiceUnavailable, "jobs_unavailable", "The job queue is not running")
+		return
+	}
+
+	req, ok := h.decodeBatch(w, r, h.config.Jobs.MaxItems)
+	if !ok {
+		return
+	}
+	if req.CallbackURL != "" {
+		if h.webhook == nil || !h.webhook.Enabled() {
+			h.sendError(w, http.StatusBadRequest, "invalid_callback_url", "Callbacks are disabled (webhook.secret is not set)")
+			return
+		}
+		u, err := url.Parse(req.CallbackURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			h.sendError(w, http.StatusBadRequest, "invalid_callback_url", "Invalid 'callback_url' (must be an absolute http(s) URL)")
+			return
+		}
+	}
+	// The query is gone by the time the job runs, so store it with each item
+	for i, item := range req.Items {
+		params := make(map[string]string)
+		for name, values := range r.URL.Query() {
+			if len(values) > 0 {
+				params[name] = values[0]
+			}
+		}
+		for name, value := range item.Params {
+			params[name] = value
+		}
+		if len(params) > 0 {
+			req.Items[i].Params = params
+		}
+	}
+	stored := jobRequest{BatchRequest: *req}
+	if key, ok := auth.FromContext(r.Context()); ok {
+		stored.Owner = key.Name
+		stored.Prefixes = key.Prefixes
+	}
+	request, err := json.Marshal(stored)
+	if err != nil {
+		h.sendError(w, http.StatusInternalServerError, "internal_error", "Failed to encode job")
+		return
+	}
+
+	job, err := h.jobs.Submit(request, len(req.Items))
+	if err != nil {
+		if errors.Is(err, jobs.ErrQueueFull) {
+			h.sendError(w, http.StatusServiceUnavailable, "queue_full", "Too many jobs are waiting, try again later")
+			return
+		}
+		log.Printf("[ERROR] Failed to queue job: %v", err)
+		h.sendError(w, http.StatusInternalServerError, "internal_error", "Failed to queue job")
+		return
+	}
+	log.Printf("[INFO] Queued job %s with %d items", job.ID, len(req.Items))
+	w.Header().Set("Location", "/api/jobs/"+job.ID)
+	h.sendJSON(w, http.StatusAccepted, newJobResponse(job))
+}
+
+// HandleJob handles GET and DELETE /api/jobs/{id}.
+// GET reports the status, progress and result of a job; DELETE cancels it.
+func (h *Handler) HandleJob(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodDelete {
+		w.Header().Set("Allow", "GET, DELETE")
+		h.sendError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+		return
+	}
+	if h.jobs == nil {
+		h.sendError(w, http.StatusServiceUnavailable, "jobs_unavailable", "The job queue is not running")
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, "/api/jobs/")
+	if !jobs.IsValidID(id) {
+		h.sendError(w, http.StatusNotFound, "job_not_found", "Job not found")
+		return
+	}
+
+	job, err := h.jobs.Get(id)
+	if err == nil && !ownsJob(r.Context(), job) {
+		err = jobs.ErrNotFound
+	}
+	if err == nil && r.Method == http.MethodDelete {
+		job, err = h.jobs.Cancel(id)
+	}
+	switch {
+	case errors.Is(err, jobs.ErrNotFound):
+		h.sendError(w, http.StatusNotFound, "job_not_found", "Job not found")
+	case errors.Is(err, jobs.ErrFinished):
+		h.sendError(w, http.StatusConflict, "job_finished", fmt.Sprintf("Job has already %s", job.Status))
+	default:
+		h.sendJSON(w, http.StatusOK, newJobResponse(job))
+	}
+}
+
+// ownsJob reports whether the request's API key may see the job: admin keys see
+// every job and other keys the jobs they submitted
+func ownsJob(ctx context.Context, job jobs.Job) bool {
+	key, ok := auth.FromContext(ctx)
+	if !ok || key.Allows(auth.ScopeAdmin) {
+		return true
+	}
+	var req jobRequest
+	json.Unmarshal(job.Request, &req)
+	return req.Owner == key.Name
+}
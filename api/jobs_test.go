@@ -0,0 +1,198 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"image"
+	"image/png"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"image-converting-server/config"
+	"image-converting-server/jobs"
+	"image-converting-server/processor"
+	"image-converting-server/webhook"
+)
+
+func TestHandleJobs(t *testing.T) {
+	var buf bytes.Buffer
+	png.Encode(&buf, image.NewNRGBA(image.Rect(0, 0, 200, 100)))
+	imgData := buf.Bytes()
+
+	cfg := &config.Config{
+		R2: config.R2Config{Bucket: "test-bucket"},
+		Conversion: config.ConversionConfig{
+			Formats:   []string{"png"},
+			Quality:   80,
+			MaxSizeMB: 1,
+		},
+		Batch: config.BatchConfig{Concurrency: 2},
+		Jobs:  config.JobsConfig{MaxItems: 3},
+	}
+	var mu sync.Mutex
+	uploads := make(map[string][]byte)
+	mockStorage := &mockStorageClient{
+		downloadFunc: func(ctx context.Context, key string) ([]byte, error) {
+			return imgData, nil
+		},
+		uploadFunc: func(ctx context.Context, key string, data []byte, contentType string) error {
+			mu.Lock()
+			defer mu.Unlock()
+			uploads[key] = data
+			return nil
+		},
+	}
+	h := NewHandler(mockStorage, processor.NewProcessor(*cfg), cfg)
+
+	serve := func(method, target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		w := httptest.NewRecorder()
+		if target == "/api/jobs" || strings.HasPrefix(target, "/api/jobs?") {
+			h.HandleJobs(w, req)
+		} else {
+			h.HandleJob(w, req)
+		}
+		return w
+	}
+
+	if w := serve("POST", "/api/jobs", `{"items": [{"source": "r2://test-bucket/a.png"}]}`); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 without a queue, got %d", w.Code)
+	}
+
+	events := make(chan webhook.Event, 1)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if err := webhook.Verify("secret", r.Header, body, time.Now()); err != nil {
+			t.Errorf("callback failed verification: %v", err)
+		}
+		var event webhook.Event
+		json.Unmarshal(body, &event)
+		events <- event
+	}))
+	defer receiver.Close()
+	// The default client skips the fetch destination checks, so the local receiver is reachable
+	dispatcher, err := webhook.NewDispatcher(t.TempDir(), config.WebhookConfig{Secret: "secret", MaxAttempts: 1, TimeoutSeconds: 5}, http.DefaultClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dispatcher.Start()
+	defer dispatcher.Stop()
+	h.SetWebhook(dispatcher)
+
+	queue, err := jobs.NewQueue(t.TempDir(), 1, 10, time.Hour, h.RunJob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	queue.OnFinish(h.NotifyJob)
+	h.SetJobQueue(queue)
+	queue.Start()
+	defer queue.Stop()
+
+	t.Run("submit and poll", func(t *testing.T) {
+		w := serve("POST", "/api/jobs?width=100", `{"items": [
+			{"source": "r2://test-bucket/a.png"},
+			{"source": "r2://test-bucket/b.png", "params": {"width": "50"}},
+			{"source": "r2://test-bucket/c.png", "params": {"quality": "500"}}
+		]}`)
+		if w.Code != http.StatusAccepted {
+			t.Fatalf("expected 202, got %d: %s", w.Code, w.Body.String())
+		}
+		var submitted JobResponse
+		json.NewDecoder(w.Body).Decode(&submitted)
+		if w.Header().Get("Location") != "/api/jobs/"+submitted.ID || submitted.Progress.Total != 3 {
+			t.Fatalf("unexpected submission: %+v, Location %q", submitted, w.Header().Get("Location"))
+		}
+		var stored BatchRequest
+		json.Unmarshal(submitted.Request, &stored)
+		if stored.Items[0].Params["width"] != "100" || stored.Items[1].Params["width"] != "50" {
+			t.Errorf("expected the query merged into the stored items, got %+v", stored.Items)
+		}
+
+		var job JobResponse
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			w = serve("GET", "/api/jobs/"+submitted.ID, "")
+			if w.Code != http.StatusOK {
+				t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+			}
+			job = JobResponse{}
+			json.NewDecoder(w.Body).Decode(&job)
+			if job.Status.Finished() || time.Now().After(deadline) {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		if job.Status != jobs.StatusSucceeded || job.Progress.Done != 3 {
+			t.Fatalf("expected a succeeded job with 3 items done, got %+v", job)
+		}
+		var res BatchResponse
+		json.Unmarshal(job.Result, &res)
+		if res.Succeeded != 2 || res.Failed != 1 || res.Results[2].Error != "invalid_quality" {
+			t.Errorf("unexpected job result: %+v", res)
+		}
+		if r := res.Results[0].Result; r == nil || r.Width != 100 {
+			t.Errorf("expected the stored query to apply, got %+v", res.Results[0])
+		}
+
+		if w := serve("DELETE", "/api/jobs/"+submitted.ID, ""); w.Code != http.StatusConflict {
+			t.Errorf("expected 409 canceling a finished job, got %d: %s", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("callback", func(t *testing.T) {
+		w := serve("POST", "/api/jobs", `{"items": [
+			{"source": "r2://test-bucket/a.png", "key": "out/a.webp"},
+			{"source": "r2://test-bucket/b.png", "params": {"format": "tiff"}}
+		], "callback_url": "`+receiver.URL+`/hook"}`)
+		if w.Code != http.StatusAccepted {
+			t.Fatalf("expected 202, got %d: %s", w.Code, w.Body.String())
+		}
+		var submitted JobResponse
+		json.NewDecoder(w.Body).Decode(&submitted)
+
+		var event webhook.Event
+		select {
+		case event = <-events:
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for the callback")
+		}
+		if event.Type != webhook.EventJobFinished || event.JobID != submitted.ID || event.Status != "succeeded" || len(event.Items) != 2 {
+			t.Fatalf("unexpected event: %+v", event)
+		}
+		if ok := event.Items[0]; ok.Destination != "r2://test-bucket/out/a.webp" || ok.OriginalSize != len(imgData) || ok.ConvertedSize == 0 {
+			t.Errorf("unexpected success item: %+v", ok)
+		}
+		if failed := event.Items[1]; failed.Source != "r2://test-bucket/b.png" || failed.Error == "" || failed.Destination != "" {
+			t.Errorf("unexpected failure item: %+v", failed)
+		}
+	})
+
+	invalid := []struct {
+		name   string
+		method string
+		target string
+		body   string
+		status int
+		code   string
+	}{
+		{"wrong method", "PUT", "/api/jobs", "", http.StatusMethodNotAllowed, "method_not_allowed"},
+		{"too many items", "POST", "/api/jobs", `{"items": [{}, {}, {}, {}]}`, http.StatusBadRequest, "invalid_batch"},
+		{"relative callback", "POST", "/api/jobs", `{"items": [{}], "callback_url": "/hook"}`, http.StatusBadRequest, "invalid_callback_url"},
+		{"malformed id", "GET", "/api/jobs/../state", "", http.StatusNotFound, "job_not_found"},
+		{"unknown id", "DELETE", "/api/jobs/0123456789abcdef0123456789abcdef", "", http.StatusNotFound, "job_not_found"},
+	}
+	for _, tt := range invalid {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(tt.method, tt.target, tt.body)
+			if w.Code != tt.status || !strings.Contains(w.Body.String(), tt.code) {
+				t.Errorf("expected %d %s, got %d: %s", tt.status, tt.code, w.Code, w.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,219 @@
+package api
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"image/color"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"image-converting-server/processor"
+	"image-converting-server/r2"
+	"image-converting-server/signer"
+)
+
+// HandleTransform handles GET /img/{key}?w=&h=&fit=&g=&fx=&fy=&bg=&q=&format=&page=&ops=
+// The original is read from R2 and the transformed result is stored under a
+// deterministic variant key, so repeat requests are served from R2 directly.
+func (h *Handler) HandleTransform(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		h.sendError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+		return
+	}
+
+	if apiErr := h.verifySignature(r); apiErr != nil {
+		h.sendAPIError(w, apiErr)
+		return
+	}
+
+	key := strings.TrimPrefix(r.URL.Path, "/img/")
+	if key == "" || key == r.URL.Path {
+		h.sendError(w, http.StatusBadRequest, "missing_source", "The image key is required: /img/{key}")
+		return
+	}
+	if apiErr := authorizeObject(r.Context(), key); apiErr != nil {
+		h.sendAPIError(w, apiErr)
+		return
+	}
+
+	options, apiErr := h.parseTransformOptions(r.URL.Query())
+	if apiErr != nil {
+		h.sendAPIError(w, apiErr)
+		return
+	}
+	if options.Format == "" {
+		options.Format = h.negotiateFormat(r.Header.Get("Accept"))
+		w.Header().Set("Vary", "Accept")
+	}
+
+	encoder, err := h.processor.Encoder(options.Format)
+	if err != nil {
+		h.sendError(w, http.StatusBadRequest, "invalid_format", err.Error())
+		return
+	}
+	variantKey := h.variantKey(key, options, encoder.Extension())
+
+	// Serve the cached variant if it already exists
+	if cached, err := h.storageClient.DownloadImage(r.Context(), variantKey); err == nil {
+		h.sendImage(w, cached, encoder.ContentType(), "HIT")
+		return
+	}
+
+	data, err := h.storageClient.DownloadImage(r.Context(), key)
+	if err != nil {
+		log.Printf("Failed to download from R2: %v", err)
+		if errors.Is(err, r2.ErrObjectTooLarge) {
+			h.sendAPIError(w, h.tooLargeError())
+			return
+		}
+		h.sendError(w, http.StatusNotFound, "image_not_found", "Image not found in R2 bucket")
+		return
+	}
+
+	options.Context = r.Context()
+	converted, _, err := h.processor.Process(data, options)
+	if err != nil {
+		log.Printf("Conversion failed: %v", err)
+		if apiErr := h.processError(err); apiErr != nil {
+			h.sendAPIError(w, apiErr)
+			return
+		}
+		h.sendError(w, http.StatusInternalServerError, "conversion_failed", "Failed to convert image")
+		return
+	}
+
+	// A failed cache write should not fail the request
+	if err := h.storageClient.UploadImage(r.Context(), variantKey, converted, encoder.ContentType()); err != nil {
+		log.Printf("[WARN] Failed to cache variant %s: %v", variantKey, err)
+	}
+
+	h.sendImage(w, converted, encoder.ContentType(), "MISS")
+}
+
+// verifySignature checks the sig and exp query parameters when URL signing is enabled
+func (h *Handler) verifySignature(r *http.Request) *apiError {
+	if h.signer == nil {
+		return nil
+	}
+	err := h.signer.Verify(r.URL.Path, r.URL.Query(), time.Now())
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, signer.ErrMissingSignature):
+		return newAPIError(http.StatusForbidden, "missing_signature", "This URL must be signed with the 'sig' parameter")
+	case errors.Is(err, signer.ErrExpired):
+		return newAPIError(http.StatusForbidden, "signature_expired", "The signed URL has expired")
+	default:
+		return newAPIError(http.StatusForbidden, "invalid_signature", "The URL signature is invalid")
+	}
+}
+
+// parseTransformOptions parses the short query parameters used by /img/{key}
+func (h *Handler) parseTransformOptions(query url.Values) (processor.ProcessOptions, *apiError) {
+	var options processor.ProcessOptions
+	maxDimension := h.config.Transform.MaxDimension
+
+	parseDimension := func(name string) (int, *apiError) {
+		value := query.Get(name)
+		if value == "" {
+			return 0, nil
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 || (maxDimension > 0 && n > maxDimension) {
+			return 0, newAPIError(http.StatusBadRequest, "invalid_resize_params",
+				fmt.Sprintf("Invalid '%s' parameter (must be between 0 and %d)", name, maxDimension))
+		}
+		return n, nil
+	}
+
+	var apiErr *apiError
+	if options.Width, apiErr = parseDimension("w"); apiErr != nil {
+		return options, apiErr
+	}
+	if options.Height, apiErr = parseDimension("h"); apiErr != nil {
+		return options, apiErr
+	}
+	if apiErr := parseFitParams(&options, query.Get("fit"), query.Get("g"), query.Get("bg"), query.Get("fx"), query.Get("fy")); apiErr != nil {
+		return options, apiErr
+	}
+	if q := query.Get("q"); q != "" {
+		quality, err := strconv.Atoi(q)
+		if err != nil || quality < 1 || quality > 100 {
+			return options, newAPIError(http.StatusBadRequest, "invalid_quality", "Invalid 'q' parameter (must be between 1 and 100)")
+		}
+		options.Quality = quality
+	}
+	if format := query.Get("format"); format != "" {
+		if !processor.IsOutputFormat(format) {
+			return options, newAPIError(http.StatusBadRequest, "invalid_format", fmt.Sprintf("Output format '%s' is not supported", format))
+		}
+		options.Format = format
+	}
+	if p := query.Get("page"); p != "" {
+		page, err := strconv.Atoi(p)
+		if err != nil || page < 1 || page > processor.MaxPage {
+			return options, newAPIError(http.StatusBadRequest, "invalid_page",
+				fmt.Sprintf("Invalid 'page' parameter (must be between 1 and %d)", processor.MaxPage))
+		}
+		options.Page = page
+	}
+	if apiErr := parseOperations(&options, query.Get("ops")); apiErr != nil {
+		return options, apiErr
+	}
+
+	return options, nil
+}
+
+// variantKey builds the deterministic R2 key for a transformed variant.
+// Every parameter that affects the output is part of the key.
+func (h *Handler) variantKey(key string, options processor.ProcessOptions, ext string) string {
+	fit := options.Fit
+	if fit == "" {
+		fit = processor.FitFill
+	}
+	quality := "auto"
+	if options.Quality > 0 {
+		quality = strconv.Itoa(options.Quality)
+	}
+	// Optional parameters only appear in the key when set, so existing keys stay valid
+	var extra strings.Builder
+	if options.Gravity != "" && options.Gravity != processor.GravityCenter {
+		fmt.Fprintf(&extra, "_g%s", options.Gravity)
+	}
+	if options.Focal != nil {
+		fmt.Fprintf(&extra, "_fx%s_fy%s", strconv.FormatFloat(options.Focal.X, 'f', -1, 64), strconv.FormatFloat(options.Focal.Y, 'f', -1, 64))
+	}
+	if options.Background != nil {
+		c := color.NRGBAModel.Convert(options.Background).(color.NRGBA)
+		fmt.Fprintf(&extra, "_bg%02x%02x%02x%02x", c.R, c.G, c.B, c.A)
+	}
+	if options.Page > 1 {
+		fmt.Fprintf(&extra, "_p%d", options.Page)
+	}
+	if len(options.Operations) > 0 {
+		// Pipelines can be long and contain characters awkward in keys, so use a digest
+		sum := sha256.Sum256([]byte(processor.FormatOperations(options.Operations)))
+		fmt.Fprintf(&extra, "_ops%x", sum[:6])
+	}
+	name := fmt.Sprintf("w%d_h%d_%s_q%s%s_%s%s",
+		options.Width, options.Height, fit, quality, extra.String(), h.processor.OutputFormat(options), ext)
+	return path.Join(h.config.Transform.CachePrefix, key, name)
+}
+
+// sendImage writes image bytes with the given content type and cache status
+func (h *Handler) sendImage(w http.ResponseWriter, data []byte, contentType, cacheStatus string) {
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	if cacheStatus != "" {
+		w.Header().Set("X-Cache", cacheStatus)
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
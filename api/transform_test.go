@@ -0,0 +1,236 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"image-converting-server/config"
+	"image-converting-server/processor"
+	"image-converting-server/signer"
+)
+
+func newTransformTestHandler(t *testing.T, objects map[string][]byte) (*Handler, *int) {
+	t.Helper()
+	cfg := &config.Config{
+		R2: config.R2Config{Bucket: "test-bucket"},
+		Conversion: config.ConversionConfig{
+			Formats: []string{"png"},
+			Quality: 80,
+		},
+		Transform: config.TransformConfig{CachePrefix: "_variants", MaxDimension: 1000},
+	}
+	uploads := 0
+	mockStorage := &mockStorageClient{
+		downloadFunc: func(ctx context.Context, key string) ([]byte, error) {
+			if data, ok := objects[key]; ok {
+				return data, nil
+			}
+			return nil, errors.New("not found")
+		},
+		uploadFunc: func(ctx context.Context, key string, data []byte, contentType string) error {
+			uploads++
+			objects[key] = data
+			return nil
+		},
+	}
+	return NewHandler(mockStorage, processor.NewProcessor(*cfg), cfg), &uploads
+}
+
+func TestHandleTransform_VariantCache(t *testing.T) {
+	var buf bytes.Buffer
+	png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 200, 100)))
+	objects := map[string][]byte{"photos/cat.png": buf.Bytes()}
+	h, uploads := newTransformTestHandler(t, objects)
+
+	request := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/img/photos/cat.png?w=50&h=50&fit=cover&q=70&format=webp", nil)
+		w := httptest.NewRecorder()
+		h.HandleTransform(w, req)
+		return w
+	}
+
+	// First request converts and stores the variant
+	w := request()
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d, body: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	if w.Header().Get("X-Cache") != "MISS" {
+		t.Errorf("expected cache miss, got %q", w.Header().Get("X-Cache"))
+	}
+	if w.Header().Get("Content-Type") != "image/webp" {
+		t.Errorf("expected image/webp, got %s", w.Header().Get("Content-Type"))
+	}
+	variantKey := "_variants/photos/cat.png/w50_h50_cover_q70_webp.webp"
+	if _, ok := objects[variantKey]; !ok {
+		t.Fatalf("expected variant stored at %s", variantKey)
+	}
+	decoded, _, err := image.Decode(bytes.NewReader(w.Body.Bytes()))
+	if err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if decoded.Bounds().Dx() != 50 || decoded.Bounds().Dy() != 50 {
+		t.Errorf("expected 50x50, got %v", decoded.Bounds())
+	}
+
+	// Second request is served from R2
+	w = request()
+	if w.Header().Get("X-Cache") != "HIT" {
+		t.Errorf("expected cache hit, got %q", w.Header().Get("X-Cache"))
+	}
+	if *uploads != 1 {
+		t.Errorf("expected exactly 1 upload, got %d", *uploads)
+	}
+}
+
+func TestHandleTransform_InvalidParams(t *testing.T) {
+	h, _ := newTransformTestHandler(t, map[string][]byte{})
+
+	tests := []struct {
+		url  string
+		code string
+	}{
+		{"/img/", "missing_source"},
+		{"/img/a.png?w=abc", "invalid_resize_params"},
+		{"/img/a.png?w=5000", "invalid_resize_params"},
+		{"/img/a.png?fit=squash", "invalid_resize_params"},
+		{"/img/a.png?q=0", "invalid_quality"},
+		{"/img/a.png?format=heic", "invalid_format"},
+		{"/img/a.png?page=0", "invalid_page"},
+		{"/img/a.png?page=1099511627776", "invalid_page"},
+		{"/img/a.png?g=up", "invalid_resize_params"},
+		{"/img/a.png?bg=white", "invalid_resize_params"},
+		{"/img/a.png?fx=0.5", "invalid_resize_params"},
+		{"/img/a.png?fx=1.5&fy=0.5", "invalid_resize_params"},
+		{"/img/a.png?ops=spin:90", "invalid_operation"},
+		{"/img/a.png?ops=blur:1|crop:0,0", "invalid_operation"},
+		{"/img/a.png?ops=" + strings.Repeat("sharpen:100|", 16) + "blur:100", "invalid_operation"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", tt.url, nil)
+		w := httptest.NewRecorder()
+		h.HandleTransform(w, req)
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), tt.code) {
+			t.Errorf("%s: expected 400 %s, got %d %s", tt.url, tt.code, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestHandleTransform_PageOutOfRange(t *testing.T) {
+	var buf bytes.Buffer
+	png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 10, 10)))
+	h, _ := newTransformTestHandler(t, map[string][]byte{"a.png": buf.Bytes()})
+
+	req := httptest.NewRequest("GET", "/img/a.png?page=2", nil)
+	w := httptest.NewRecorder()
+	h.HandleTransform(w, req)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "invalid_page") {
+		t.Errorf("expected 400 invalid_page, got %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestHandleTransform_Operations(t *testing.T) {
+	var buf bytes.Buffer
+	png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 200, 100)))
+	objects := map[string][]byte{"a.png": buf.Bytes()}
+	h, _ := newTransformTestHandler(t, objects)
+
+	req := httptest.NewRequest("GET", "/img/a.png?ops="+url.QueryEscape("crop:0,0,40,20|rotate:90")+"&format=png", nil)
+	w := httptest.NewRecorder()
+	h.HandleTransform(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d, body: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	decoded, _, err := image.Decode(bytes.NewReader(w.Body.Bytes()))
+	if err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if decoded.Bounds().Dx() != 20 || decoded.Bounds().Dy() != 40 {
+		t.Errorf("expected 20x40, got %v", decoded.Bounds())
+	}
+
+	// The pipeline is part of the variant key
+	options, _ := h.parseTransformOptions(url.Values{"ops": {"crop:0,0,40,20|rotate:90"}, "format": {"png"}})
+	key := h.variantKey("a.png", options, ".png")
+	if _, ok := objects[key]; !ok || !strings.Contains(key, "_ops") {
+		t.Errorf("expected variant stored at %s", key)
+	}
+	other, _ := h.parseTransformOptions(url.Values{"ops": {"rotate:90|crop:0,0,40,20"}, "format": {"png"}})
+	if h.variantKey("a.png", other, ".png") == key {
+		t.Error("expected a different key for a different operation order")
+	}
+
+	// A crop outside the image is a client error
+	req = httptest.NewRequest("GET", "/img/a.png?ops=crop:500,500,10,10", nil)
+	w = httptest.NewRecorder()
+	h.HandleTransform(w, req)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "invalid_operation") {
+		t.Errorf("expected 400 invalid_operation, got %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestHandleTransform_SignedURLs(t *testing.T) {
+	var buf bytes.Buffer
+	png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 20, 20)))
+	objects := map[string][]byte{"a.png": buf.Bytes()}
+
+	cfg := &config.Config{
+		Conversion: config.ConversionConfig{Formats: []string{"png"}, Quality: 80},
+		Transform:  config.TransformConfig{CachePrefix: "_variants", SigningSecret: "test-secret"},
+	}
+	mockStorage := &mockStorageClient{
+		downloadFunc: func(ctx context.Context, key string) ([]byte, error) {
+			if data, ok := objects[key]; ok {
+				return data, nil
+			}
+			return nil, errors.New("not found")
+		},
+		uploadFunc: func(ctx context.Context, key string, data []byte, contentType string) error {
+			return nil
+		},
+	}
+	h := NewHandler(mockStorage, processor.NewProcessor(*cfg), cfg)
+	urlSigner := signer.New("test-secret")
+
+	signed, _ := urlSigner.SignURL("/img/a.png?w=10&format=webp", time.Time{})
+	expired, _ := urlSigner.SignURL("/img/a.png?w=10&format=webp", time.Now().Add(-time.Minute))
+
+	tests := []struct {
+		name   string
+		url    string
+		status int
+		code   string
+	}{
+		{"Signed", signed, http.StatusOK, ""},
+		{"Unsigned", "/img/a.png?w=10&format=webp", http.StatusForbidden, "missing_signature"},
+		{"Tampered", strings.Replace(signed, "w=10", "w=999", 1), http.StatusForbidden, "invalid_signature"},
+		{"Expired", expired, http.StatusForbidden, "signature_expired"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.url, nil)
+			w := httptest.NewRecorder()
+			h.HandleTransform(w, req)
+
+			if w.Code != tt.status {
+				t.Fatalf("expected status %d, got %d, body: %s", tt.status, w.Code, w.Body.String())
+			}
+			if tt.code != "" {
+				var resp ErrorResponse
+				json.NewDecoder(w.Body).Decode(&resp)
+				if resp.Error != tt.code {
+					t.Errorf("expected error %s, got %s", tt.code, resp.Error)
+				}
+			}
+		})
+	}
+}
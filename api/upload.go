@@ -0,0 +1,169 @@
+package api
+
+import (
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// uploadSource is reported as the source of uploaded images
+const uploadSource = "upload"
+
+// multipartOverhead allows for the boundaries and small fields of a multipart body
+const multipartOverhead = 64 << 10
+
+// maxKeyLength is the longest R2 object key
+const maxKeyLength = 1024
+
+// isUpload reports whether a POST /api/convert request carries the image itself:
+// multipart/form-data with a 'file' field, or a raw image/* body
+func isUpload(r *http.Request) bool {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return mediaType == "multipart/form-data" || strings.HasPrefix(mediaType, "image/")
+}
+
+// convertUpload converts an uploaded image with the query parameters of
+// /api/convert. With a 'key' the result is stored in R2 under exactly that key;
+// otherwise the converted image is returned as the response body.
+func (h *Handler) convertUpload(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	options, variantSet, apiErr := h.parseConvertOptions(query, nil)
+	if apiErr != nil {
+		h.sendAPIError(w, apiErr)
+		return
+	}
+
+	data, formKey, apiErr := h.readUpload(w, r)
+	if apiErr != nil {
+		h.sendAPIError(w, apiErr)
+		return
+	}
+	key := query.Get("key")
+	if key == "" {
+		key = formKey
+	}
+	if key != "" && !isValidKey(key) {
+		h.sendError(w, http.StatusBadRequest, "invalid_key", "Invalid 'key' parameter (must be a relative R2 key without '.' or '..' segments)")
+		return
+	}
+
+	if key != "" {
+		res, apiErr := h.convertData(r.Context(), uploadSource, key, key, data, options, variantSet)
+		if apiErr != nil {
+			h.sendAPIError(w, apiErr)
+			return
+		}
+		h.sendJSON(w, http.StatusOK, res)
+		return
+	}
+	if variantSet != nil {
+		h.sendError(w, http.StatusBadRequest, "invalid_key", "The 'key' parameter is required for variant sets")
+		return
+	}
+
+	encoder, err := h.processor.Encoder(options.Format)
+	if err != nil {
+		h.sendError(w, http.StatusBadRequest, "invalid_format", err.Error())
+		return
+	}
+	options.Context = r.Context()
+	result, err := h.processor.ProcessResult(data, options)
+	if err != nil {
+		h.sendAPIError(w, h.conversionError(err))
+		return
+	}
+	h.sendImage(w, result.Data, encoder.ContentType(), "")
+}
+
+// readUpload reads the image of an upload request, within conversion.max_size_mb,
+// and the 'key' form field of multipart requests
+func (h *Handler) readUpload(w http.ResponseWriter, r *http.Request) ([]byte, string, *apiError) {
+	maxBytes := h.config.Conversion.MaxSizeBytes()
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	if mediaType != "multipart/form-data" {
+		body := io.Reader(r.Body)
+		if maxBytes > 0 {
+			body = http.MaxBytesReader(w, r.Body, maxBytes)
+		}
+		data, err := io.ReadAll(body)
+		if err != nil {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				return nil, "", h.tooLargeError()
+			}
+			return nil, "", newAPIError(http.StatusBadRequest, "invalid_request", "Failed to read request body")
+		}
+		if len(data) == 0 {
+			return nil, "", newAPIError(http.StatusBadRequest, "missing_source", "The request body is empty")
+		}
+		return data, "", nil
+	}
+
+	if maxBytes > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, maxBytes+multipartOverhead)
+	}
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, "", newAPIError(http.StatusBadRequest, "invalid_request", "Failed to parse multipart body")
+	}
+	var data []byte
+	var key string
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, "", h.uploadReadError(err)
+		}
+		switch part.FormName() {
+		case "file":
+			limit := io.Reader(part)
+			if maxBytes > 0 {
+				limit = io.LimitReader(part, maxBytes+1)
+			}
+			if data, err = io.ReadAll(limit); err != nil {
+				return nil, "", h.uploadReadError(err)
+			}
+			if maxBytes > 0 && int64(len(data)) > maxBytes {
+				return nil, "", h.tooLargeError()
+			}
+		case "key":
+			value, err := io.ReadAll(io.LimitReader(part, maxKeyLength+1))
+			if err != nil {
+				return nil, "", h.uploadReadError(err)
+			}
+			key = string(value)
+		}
+		part.Close()
+	}
+	if len(data) == 0 {
+		return nil, "", newAPIError(http.StatusBadRequest, "missing_source", "The 'file' form field is required")
+	}
+	return data, key, nil
+}
+
+func (h *Handler) uploadReadError(err error) *apiError {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return h.tooLargeError()
+	}
+	return newAPIError(http.StatusBadRequest, "invalid_request", "Failed to parse multipart body")
+}
+
+// isValidKey reports whether key can name an R2 object: relative, at most 1024
+// bytes, and without empty, '.' or '..' path segments
+func isValidKey(key string) bool {
+	if len(key) > maxKeyLength {
+		return false
+	}
+	for _, segment := range strings.Split(key, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,175 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"image"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"image-converting-server/config"
+	"image-converting-server/processor"
+)
+
+func TestHandleConvert_Upload(t *testing.T) {
+	var buf bytes.Buffer
+	png.Encode(&buf, image.NewNRGBA(image.Rect(0, 0, 200, 100)))
+	imgData := buf.Bytes()
+
+	cfg := &config.Config{
+		R2: config.R2Config{Bucket: "test-bucket"},
+		Conversion: config.ConversionConfig{
+			Formats:   []string{"png"},
+			Quality:   80,
+			MaxSizeMB: 1,
+		},
+		Resize: config.ResizeConfig{
+			Presets: map[string]config.PresetConfig{"thumb": {Width: 50, Height: 25}},
+		},
+	}
+	uploads := make(map[string][]byte)
+	types := make(map[string]string)
+	mockStorage := &mockStorageClient{
+		uploadFunc: func(ctx context.Context, key string, data []byte, contentType string) error {
+			uploads[key] = data
+			types[key] = contentType
+			return nil
+		},
+	}
+	h := NewHandler(mockStorage, processor.NewProcessor(*cfg), cfg)
+
+	multipartBody := func(fields map[string]string, file []byte) (*bytes.Buffer, string) {
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		for name, value := range fields {
+			mw.WriteField(name, value)
+		}
+		if file != nil {
+			fw, _ := mw.CreateFormFile("file", "photo.png")
+			fw.Write(file)
+		}
+		mw.Close()
+		return &body, mw.FormDataContentType()
+	}
+	convert := func(query string, body *bytes.Buffer, contentType string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/convert"+query, body)
+		req.Header.Set("Content-Type", contentType)
+		w := httptest.NewRecorder()
+		h.HandleConvert(w, req)
+		return w
+	}
+
+	t.Run("multipart to key", func(t *testing.T) {
+		body, contentType := multipartBody(map[string]string{"key": "uploads/avatar.webp"}, imgData)
+		w := convert("?preset=thumb", body, contentType)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+		var res ConvertResponse
+		json.NewDecoder(w.Body).Decode(&res)
+		if res.Source != "upload" || res.Destination != "r2://test-bucket/uploads/avatar.webp" {
+			t.Errorf("unexpected source or destination: %+v", res)
+		}
+		if res.Width != 50 || res.Height != 25 || res.OriginalSize != len(imgData) {
+			t.Errorf("expected the preset size and the upload size, got %+v", res)
+		}
+		if len(uploads["uploads/avatar.webp"]) != res.ConvertedSize || types["uploads/avatar.webp"] != "image/webp" {
+			t.Errorf("expected the WebP to be stored under the given key, got %v", types)
+		}
+	})
+
+	t.Run("raw body streamed back", func(t *testing.T) {
+		w := convert("?width=40", bytes.NewBuffer(imgData), "image/png")
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+		if ct := w.Header().Get("Content-Type"); ct != "image/webp" {
+			t.Errorf("expected image/webp, got %s", ct)
+		}
+		img, _, err := image.Decode(w.Body)
+		if err != nil {
+			t.Fatalf("response is not an image: %v", err)
+		}
+		if img.Bounds().Dx() != 40 || img.Bounds().Dy() != 20 {
+			t.Errorf("expected 40x20, got %v", img.Bounds().Size())
+		}
+	})
+
+	t.Run("raw body to query key", func(t *testing.T) {
+		w := convert("?key=raw/photo.webp", bytes.NewBuffer(imgData), "image/png")
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+		if _, ok := uploads["raw/photo.webp"]; !ok {
+			t.Error("expected raw/photo.webp to be uploaded")
+		}
+	})
+
+	t.Run("variant set", func(t *testing.T) {
+		w := convert("?widths=20,40&key=gallery/a.png", bytes.NewBuffer(imgData), "image/png")
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+		for _, key := range []string{"gallery/a.w20.webp", "gallery/a.w40.webp", "gallery/a.variants.json"} {
+			if _, ok := uploads[key]; !ok {
+				t.Errorf("expected %s to be uploaded", key)
+			}
+		}
+	})
+
+	tooLarge := bytes.Repeat([]byte{0}, 1<<20+1)
+	noFile, noFileType := multipartBody(map[string]string{"key": "a.webp"}, nil)
+	largeFile, largeFileType := multipartBody(nil, tooLarge)
+	tests := []struct {
+		name        string
+		query       string
+		body        *bytes.Buffer
+		contentType string
+		status      int
+		code        string
+	}{
+		{"empty body", "", &bytes.Buffer{}, "image/png", http.StatusBadRequest, "missing_source"},
+		{"missing file", "", noFile, noFileType, http.StatusBadRequest, "missing_source"},
+		{"raw too large", "", bytes.NewBuffer(tooLarge), "image/png", http.StatusRequestEntityTooLarge, "image_too_large"},
+		{"multipart too large", "", largeFile, largeFileType, http.StatusRequestEntityTooLarge, "image_too_large"},
+		{"invalid key", "?key=../secret.webp", bytes.NewBuffer(imgData), "image/png", http.StatusBadRequest, "invalid_key"},
+		{"variants without key", "?widths=20", bytes.NewBuffer(imgData), "image/png", http.StatusBadRequest, "invalid_key"},
+		{"invalid preset", "?preset=missing", bytes.NewBuffer(imgData), "image/png", http.StatusBadRequest, "invalid_preset"},
+		{"broken multipart", "", bytes.NewBufferString("garbage"), "multipart/form-data", http.StatusBadRequest, "invalid_request"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := convert(tt.query, tt.body, tt.contentType)
+			if w.Code != tt.status {
+				t.Fatalf("expected %d, got %d: %s", tt.status, w.Code, w.Body.String())
+			}
+			var res ErrorResponse
+			json.NewDecoder(w.Body).Decode(&res)
+			if res.Error != tt.code {
+				t.Errorf("expected %s, got %s", tt.code, res.Error)
+			}
+		})
+	}
+}
+
+func TestIsValidKey(t *testing.T) {
+	for key, want := range map[string]bool{
+		"photo.webp":              true,
+		"uploads/a/b.webp":        true,
+		"":                        false,
+		"/abs.webp":               false,
+		"a//b.webp":               false,
+		"a/../b.webp":             false,
+		"./a.webp":                false,
+		strings.Repeat("a", 1025): false,
+	} {
+		if got := isValidKey(key); got != want {
+			t.Errorf("isValidKey(%q) = %v, want %v", key, got, want)
+		}
+	}
+}
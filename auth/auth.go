@@ -0,0 +1,156 @@
+// Package auth checks the API keys of the HTTP API. Keys are configured by
+// their SHA-256 only; operators use HashKey or GenerateKey to produce the
+// hash for auth.keys.
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"image-converting-server/config"
+)
+
+// Scope grants access to a group of endpoints
+type Scope string
+
+// Scopes. convert includes read, and admin includes every scope.
+const (
+	ScopeRead    Scope = "read"
+	ScopeConvert Scope = "convert"
+	ScopeAdmin   Scope = "admin"
+)
+
+// KeyHeader carries the key when the Authorization header does not
+const KeyHeader = "X-API-Key"
+
+// hashPrefix starts every configured hash
+const hashPrefix = "sha256:"
+
+// Authentication errors
+var (
+	ErrMissingKey = errors.New("API key is missing")
+	ErrInvalidKey = errors.New("API key is invalid")
+)
+
+// Key is an authenticated API key
+type Key struct {
+	Name     string
+	Scopes   []Scope
+	Prefixes []string // R2 key prefixes the key may read and write; empty allows all
+}
+
+// Allows reports whether the key has scope, directly or through a wider scope
+func (k *Key) Allows(scope Scope) bool {
+	for _, s := range k.Scopes {
+		if s == scope || s == ScopeAdmin || (s == ScopeConvert && scope == ScopeRead) {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsObject reports whether the key may read or write the R2 object key
+func (k *Key) AllowsObject(objectKey string) bool {
+	if len(k.Prefixes) == 0 {
+		return true
+	}
+	for _, prefix := range k.Prefixes {
+		if strings.HasPrefix(objectKey, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Authenticator looks up the keys of requests
+type Authenticator struct {
+	keys     map[[sha256.Size]byte]*Key
+	disabled bool
+}
+
+// New creates an Authenticator from the auth config. Without keys it fails
+// unless auth.disabled explicitly opens the API.
+func New(cfg config.AuthConfig) (*Authenticator, error) {
+	if cfg.Disabled {
+		return &Authenticator{disabled: true}, nil
+	}
+	if len(cfg.Keys) == 0 {
+		return nil, fmt.Errorf("no API keys configured; set auth.keys, or auth.disabled to allow unauthenticated access")
+	}
+	a := &Authenticator{keys: make(map[[sha256.Size]byte]*Key, len(cfg.Keys))}
+	for _, keyCfg := range cfg.Keys {
+		decoded, err := hex.DecodeString(strings.TrimPrefix(keyCfg.Hash, hashPrefix))
+		if err != nil || !strings.HasPrefix(keyCfg.Hash, hashPrefix) || len(decoded) != sha256.Size {
+			return nil, fmt.Errorf("key %s: hash must be %q and 64 hex digits", keyCfg.Name, hashPrefix)
+		}
+		var sum [sha256.Size]byte
+		copy(sum[:], decoded)
+		if _, ok := a.keys[sum]; ok {
+			return nil, fmt.Errorf("key %s: hash is the same as another key's", keyCfg.Name)
+		}
+		key := &Key{Name: keyCfg.Name, Prefixes: keyCfg.Prefixes}
+		for _, scope := range keyCfg.Scopes {
+			key.Scopes = append(key.Scopes, Scope(scope))
+		}
+		a.keys[sum] = key
+	}
+	return a, nil
+}
+
+// Disabled reports whether auth.disabled opened the API to every request
+func (a *Authenticator) Disabled() bool {
+	return a.disabled
+}
+
+// Authenticate returns the key sent with r as "Authorization: Bearer {key}" or
+// in KeyHeader
+func (a *Authenticator) Authenticate(r *http.Request) (*Key, error) {
+	token := r.Header.Get(KeyHeader)
+	if scheme, credentials, ok := strings.Cut(r.Header.Get("Authorization"), " "); ok && strings.EqualFold(scheme, "Bearer") {
+		token = strings.TrimSpace(credentials)
+	}
+	if token == "" {
+		return nil, ErrMissingKey
+	}
+	// Only hashes are compared, so the lookup time reveals nothing about the keys
+	key, ok := a.keys[sha256.Sum256([]byte(token))]
+	if !ok {
+		return nil, ErrInvalidKey
+	}
+	return key, nil
+}
+
+// HashKey returns the auth.keys hash of key
+func HashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hashPrefix + hex.EncodeToString(sum[:])
+}
+
+// GenerateKey returns a new random key
+func GenerateKey() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate key: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying key
+func NewContext(ctx context.Context, key *Key) context.Context {
+	return context.WithValue(ctx, contextKey{}, key)
+}
+
+// FromContext returns the key carried by ctx, if any
+func FromContext(ctx context.Context) (*Key, bool) {
+	key, ok := ctx.Value(contextKey{}).(*Key)
+	return key, ok
+}
@@ -0,0 +1,114 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"image-converting-server/config"
+)
+
+func TestAuthenticate(t *testing.T) {
+	if _, err := New(config.AuthConfig{Keys: []config.APIKeyConfig{{Name: "bare", Hash: HashKey("k")[len("sha256:"):]}}}); err == nil {
+		t.Error("expected an error for a hash without the sha256: prefix")
+	}
+	a, err := New(config.AuthConfig{Keys: []config.APIKeyConfig{
+		{Name: "app", Hash: HashKey("app-key"), Scopes: []string{"convert"}, Prefixes: []string{"uploads/"}},
+		{Name: "ops", Hash: "sha256:" + strings.ToUpper(HashKey("ops-key")[len("sha256:"):]), Scopes: []string{"admin"}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Disabled() {
+		t.Error("expected an authenticator with keys to be enabled")
+	}
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    string
+		err     error
+	}{
+		{"bearer", map[string]string{"Authorization": "Bearer app-key"}, "app", nil},
+		{"bearer scheme is case-insensitive", map[string]string{"Authorization": "bearer ops-key"}, "ops", nil},
+		{"header", map[string]string{KeyHeader: "ops-key"}, "ops", nil},
+		{"authorization wins", map[string]string{"Authorization": "Bearer app-key", KeyHeader: "ops-key"}, "app", nil},
+		{"basic is ignored", map[string]string{"Authorization": "Basic YXBwLWtleQ=="}, "", ErrMissingKey},
+		{"missing", nil, "", ErrMissingKey},
+		{"unknown", map[string]string{KeyHeader: "guess"}, "", ErrInvalidKey},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/api/info", nil)
+			for name, value := range tt.headers {
+				r.Header.Set(name, value)
+			}
+			key, err := a.Authenticate(r)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("expected error %v, got %v", tt.err, err)
+			}
+			if err == nil && key.Name != tt.want {
+				t.Errorf("expected key %s, got %s", tt.want, key.Name)
+			}
+		})
+	}
+
+	// The API is only open when auth.disabled says so
+	if _, err := New(config.AuthConfig{}); err == nil {
+		t.Error("expected an error without keys")
+	}
+	if open, err := New(config.AuthConfig{Disabled: true}); err != nil || !open.Disabled() {
+		t.Errorf("expected auth.disabled to disable the authenticator, got %v", err)
+	}
+}
+
+func TestKey(t *testing.T) {
+	convert := &Key{Scopes: []Scope{ScopeConvert}, Prefixes: []string{"a/", "b/"}}
+	read := &Key{Scopes: []Scope{ScopeRead}}
+	admin := &Key{Scopes: []Scope{ScopeAdmin}}
+
+	if !convert.Allows(ScopeRead) || !convert.Allows(ScopeConvert) || convert.Allows(ScopeAdmin) {
+		t.Error("expected convert to include read only")
+	}
+	if !read.Allows(ScopeRead) || read.Allows(ScopeConvert) {
+		t.Error("expected read to allow read only")
+	}
+	if !admin.Allows(ScopeRead) || !admin.Allows(ScopeConvert) || !admin.Allows(ScopeAdmin) {
+		t.Error("expected admin to allow every scope")
+	}
+
+	for key, want := range map[string]bool{"a/x.png": true, "b/c/x.png": true, "c/x.png": false, "a": false} {
+		if got := convert.AllowsObject(key); got != want {
+			t.Errorf("AllowsObject(%q) = %v, want %v", key, got, want)
+		}
+	}
+	if !read.AllowsObject("anything") {
+		t.Error("expected a key without prefixes to allow every object")
+	}
+}
+
+func TestContext(t *testing.T) {
+	if _, ok := FromContext(context.Background()); ok {
+		t.Error("expected no key in an empty context")
+	}
+	key := &Key{Name: "app"}
+	if got, ok := FromContext(NewContext(context.Background(), key)); !ok || got != key {
+		t.Errorf("expected the stored key, got %v", got)
+	}
+}
+
+func TestGenerateKey(t *testing.T) {
+	a, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := GenerateKey()
+	if len(a) < 40 || a == b {
+		t.Errorf("expected long, distinct keys, got %q and %q", a, b)
+	}
+	if hash := HashKey(a); !strings.HasPrefix(hash, "sha256:") || len(hash) != len("sha256:")+64 {
+		t.Errorf("unexpected hash %q", hash)
+	}
+}
@@ -1,9 +1,14 @@
 package config
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"net"
+	"net/url"
 	"os"
 	"strconv"
+	"strings"
 
 	"github.com/joho/godotenv"
 	"gopkg.in/yaml.v3"
@@ -11,11 +16,19 @@ import (
 
 // Config represents the entire configuration structure
 type Config struct {
-	R2         R2Config         `yaml:"r2"`
-	Conversion ConversionConfig `yaml:"conversion"`
-	Resize     ResizeConfig     `yaml:"resize"`
-	Cron       CronConfig       `yaml:"cron"`
-	Server     ServerConfig     `yaml:"server"`
+	R2         R2Config                   `yaml:"r2"`
+	Conversion ConversionConfig           `yaml:"conversion"`
+	Resize     ResizeConfig               `yaml:"resize"`
+	Cron       CronConfig                 `yaml:"cron"`
+	Server     ServerConfig               `yaml:"server"`
+	Transform  TransformConfig            `yaml:"transform"`
+	Fetch      FetchConfig                `yaml:"fetch"`
+	Watermarks map[string]WatermarkConfig `yaml:"watermarks"`
+	Responsive ResponsiveConfig           `yaml:"responsive"`
+	Batch      BatchConfig                `yaml:"batch"`
+	Jobs       JobsConfig                 `yaml:"jobs"`
+	Webhook    WebhookConfig              `yaml:"webhook"`
+	Auth       AuthConfig                 `yaml:"auth"`
 }
 
 // R2Config contains Cloudflare R2 connection settings
@@ -28,9 +41,59 @@ type R2Config struct {
 
 // ConversionConfig contains image conversion settings
 type ConversionConfig struct {
-	Formats   []string `yaml:"formats"`
-	Quality   int      `yaml:"quality"`
-	MaxSizeMB int      `yaml:"max_size_mb"`
+	Formats      []string        `yaml:"formats"`
+	Quality      int             `yaml:"quality"`
+	MaxSizeMB    int             `yaml:"max_size_mb"`
+	MaxPixels    int             `yaml:"max_pixels"`
+	OutputFormat string          `yaml:"output_format"`
+	Metadata     string          `yaml:"metadata"`
+	ColorProfile string          `yaml:"color_profile"`
+	Animation    AnimationConfig `yaml:"animation"`
+	AVIF         AVIFConfig      `yaml:"avif"`
+
+	QualitySearch QualitySearchConfig `yaml:"quality_search"`
+	Placeholders  PlaceholderConfig   `yaml:"placeholders"`
+}
+
+// MaxSizeBytes returns max_size_mb in bytes
+func (c ConversionConfig) MaxSizeBytes() int64 {
+	return int64(c.MaxSizeMB) << 20
+}
+
+// AnimationConfig limits animated inputs, which are decoded frame by frame
+type AnimationConfig struct {
+	MaxFrames      int `yaml:"max_frames"`
+	MaxTotalPixels int `yaml:"max_total_pixels"` // Frame count times canvas size
+}
+
+// QualitySearchConfig chooses the encoder quality per image instead of using a fixed
+// quality. The search is off unless a target or a byte budget is set.
+type QualitySearchConfig struct {
+	TargetSSIM  float64 `yaml:"target_ssim"`  // Lowest quality whose output reaches this SSIM
+	TargetDSSIM float64 `yaml:"target_dssim"` // The same target as DSSIM; excludes target_ssim
+	MaxBytes    int     `yaml:"max_bytes"`    // Highest quality whose output fits this size
+	MinQuality  int     `yaml:"min_quality"`
+	MaxQuality  int     `yaml:"max_quality"`
+}
+
+// Enabled reports whether a target or a byte budget is set
+func (c QualitySearchConfig) Enabled() bool {
+	return c.TargetSSIM > 0 || c.TargetDSSIM > 0 || c.MaxBytes > 0
+}
+
+// PlaceholderConfig selects the low-quality image placeholders computed for each output
+type PlaceholderConfig struct {
+	BlurHash    bool `yaml:"blurhash"`
+	ThumbHash   bool `yaml:"thumbhash"`
+	Preview     bool `yaml:"preview"`      // Tiny WebP as a base64 data URI
+	PreviewSize int  `yaml:"preview_size"` // Longest side of the preview in pixels
+}
+
+// AVIFConfig contains AVIF encoder settings
+type AVIFConfig struct {
+	Quality     int    `yaml:"quality"`
+	Speed       int    `yaml:"speed"`
+	EncoderPath string `yaml:"encoder_path"`
 }
 
 // ResizeConfig contains image resizing preset settings
@@ -38,16 +101,57 @@ type ResizeConfig struct {
 	Presets map[string]PresetConfig `yaml:"presets"`
 }
 
-// PresetConfig defines a resize preset with width and height
+// PresetConfig defines a resize preset with width and height, and optionally a
+// pipeline of operations
 type PresetConfig struct {
-	Width  int `yaml:"width"`
-	Height int `yaml:"height"`
+	Width   int    `yaml:"width"`
+	Height  int    `yaml:"height"`
+	Fit     string `yaml:"fit"`     // Fit mode used unless the request sets one; empty means fill
+	Gravity string `yaml:"gravity"` // Crop anchor used unless the request sets one; empty means center
+
+	// Operations run before the request's own, e.g. ["grayscale", "sharpen:1"].
+	// A preset with operations may leave width and height at 0 to skip resizing.
+	Operations []string `yaml:"operations"`
+	Watermark  string   `yaml:"watermark"` // Watermark profile used unless the request sets one
+}
+
+// WatermarkConfig defines an overlay image stamped onto converted images.
+// Exactly one of File and R2Key must be set.
+type WatermarkConfig struct {
+	File     string  `yaml:"file"`     // Local image path
+	R2Key    string  `yaml:"r2_key"`   // Object key in the configured bucket
+	Position string  `yaml:"position"` // Gravity anchor; empty means southeast
+	Margin   int     `yaml:"margin"`   // Distance from the edges, or between tiles, in pixels
+	Scale    float64 `yaml:"scale"`    // Overlay width as a fraction of the image width; 0 keeps its size
+	Opacity  float64 `yaml:"opacity"`  // 0-1 (default 1)
+	Tile     bool    `yaml:"tile"`     // Repeat the overlay across the whole image
+}
+
+// ResponsiveConfig contains named sets of responsive variants
+type ResponsiveConfig struct {
+	BaseURL string                      `yaml:"base_url"` // Prefix for keys in srcset strings, e.g. a CDN origin
+	Sets    map[string]VariantSetConfig `yaml:"sets"`
+}
+
+// VariantSetConfig lists the widths and output formats rendered for each source
+type VariantSetConfig struct {
+	Widths  []int    `yaml:"widths"`
+	Formats []string `yaml:"formats"` // Output formats; empty uses conversion.output_format
 }
 
 // CronConfig contains cron job scheduling settings
 type CronConfig struct {
-	Schedule string `yaml:"schedule"`
-	Enabled  bool   `yaml:"enabled"`
+	Schedule string     `yaml:"schedule"`
+	Enabled  bool       `yaml:"enabled"`
+	Rules    []CronRule `yaml:"rules"`
+}
+
+// CronRule applies conversion options to keys under a prefix.
+// The rule with the longest matching prefix wins.
+type CronRule struct {
+	Prefix     string `yaml:"prefix"`
+	Watermark  string `yaml:"watermark"`
+	VariantSet string `yaml:"variant_set"` // Render this responsive set instead of a single output
 }
 
 // ServerConfig contains HTTP server settings
@@ -56,6 +160,68 @@ type ServerConfig struct {
 	TimeoutSeconds int `yaml:"timeout_seconds"`
 }
 
+// TransformConfig contains settings for the on-the-fly transform endpoint
+type TransformConfig struct {
+	CachePrefix  string `yaml:"cache_prefix"`
+	MaxDimension int    `yaml:"max_dimension"`
+	// SigningSecret enables HMAC-signed URLs; unsigned requests are rejected when set
+	SigningSecret string `yaml:"signing_secret"`
+}
+
+// BatchConfig limits POST /api/convert/batch
+type BatchConfig struct {
+	MaxItems    int `yaml:"max_items"`
+	Concurrency int `yaml:"concurrency"` // Items converted at the same time
+	// TimeoutSeconds replaces server.timeout_seconds as the write timeout of batch requests
+	TimeoutSeconds int `yaml:"timeout_seconds"`
+}
+
+// JobsConfig configures the asynchronous job queue behind /api/jobs
+type JobsConfig struct {
+	Workers   int `yaml:"workers"`    // Jobs run at the same time
+	MaxQueued int `yaml:"max_queued"` // Waiting jobs beyond which submissions are rejected
+	MaxItems  int `yaml:"max_items"`
+	// RetentionHours is how long finished jobs stay queryable
+	RetentionHours int `yaml:"retention_hours"`
+}
+
+// WebhookConfig configures the completion events of jobs and cron conversions
+type WebhookConfig struct {
+	URL string `yaml:"url"` // Receives every event unless a job names its own callback_url
+	// Secret signs the payloads; required for any delivery
+	Secret         string `yaml:"secret"`
+	MaxAttempts    int    `yaml:"max_attempts"`
+	TimeoutSeconds int    `yaml:"timeout_seconds"`
+}
+
+// AuthConfig configures the API keys of the HTTP API. Keys are required unless
+// Disabled explicitly opens the API to anyone who can reach it.
+type AuthConfig struct {
+	Disabled bool           `yaml:"disabled"`
+	Keys     []APIKeyConfig `yaml:"keys"`
+	// KeysFile is a YAML list of more keys in the same format, loaded at startup
+	KeysFile string `yaml:"keys_file"`
+}
+
+// APIKeyConfig defines one API key. Only the key's hash is stored.
+type APIKeyConfig struct {
+	Name   string   `yaml:"name"`
+	Hash   string   `yaml:"hash"`   // "sha256:" and the hex SHA-256 of the key
+	Scopes []string `yaml:"scopes"` // convert, read or admin
+	// Prefixes limits the R2 keys the key may read and write; empty allows all
+	Prefixes []string `yaml:"prefixes"`
+}
+
+// FetchConfig controls which external http(s) sources may be downloaded
+type FetchConfig struct {
+	AllowedHosts   []string `yaml:"allowed_hosts"`
+	DeniedHosts    []string `yaml:"denied_hosts"`
+	AllowedCIDRs   []string `yaml:"allowed_cidrs"`
+	DeniedCIDRs    []string `yaml:"denied_cidrs"`
+	TimeoutSeconds int      `yaml:"timeout_seconds"`
+	MaxRedirects   int      `yaml:"max_redirects"`
+}
+
 // Load loads configuration from a YAML file
 // It also automatically loads .env file if it exists (non-fatal if missing)
 func Load(configPath string) (*Config, error) {
@@ -77,6 +243,11 @@ func Load(configPath string) (*Config, error) {
 	// Apply environment variables (override YAML values)
 	applyEnvironmentVariables(&config)
 
+	// Append the keys of auth.keys_file
+	if err := loadKeysFile(&config); err != nil {
+		return nil, err
+	}
+
 	// Set default values
 	setDefaults(&config)
 
@@ -103,6 +274,15 @@ func applyEnvironmentVariables(config *Config) {
 	if bucket := os.Getenv("R2_BUCKET"); bucket != "" {
 		config.R2.Bucket = bucket
 	}
+	if secret := os.Getenv("TRANSFORM_SIGNING_SECRET"); secret != "" {
+		config.Transform.SigningSecret = secret
+	}
+	if secret := os.Getenv("WEBHOOK_SECRET"); secret != "" {
+		config.Webhook.Secret = secret
+	}
+	if keysFile := os.Getenv("AUTH_KEYS_FILE"); keysFile != "" {
+		config.Auth.KeysFile = keysFile
+	}
 	if portStr := os.Getenv("SERVER_PORT"); portStr != "" {
 		if port, err := strconv.Atoi(portStr); err == nil {
 			config.Server.Port = port
@@ -110,6 +290,23 @@ func applyEnvironmentVariables(config *Config) {
 	}
 }
 
+// loadKeysFile appends the API keys listed in auth.keys_file
+func loadKeysFile(config *Config) error {
+	if config.Auth.KeysFile == "" {
+		return nil
+	}
+	data, err := os.ReadFile(config.Auth.KeysFile)
+	if err != nil {
+		return fmt.Errorf("failed to read auth.keys_file: %w", err)
+	}
+	var keys []APIKeyConfig
+	if err := yaml.Unmarshal(data, &keys); err != nil {
+		return fmt.Errorf("failed to parse auth.keys_file: %w", err)
+	}
+	config.Auth.Keys = append(config.Auth.Keys, keys...)
+	return nil
+}
+
 // setDefaults sets default values for optional configuration fields
 func setDefaults(config *Config) {
 	// Conversion defaults
@@ -122,6 +319,42 @@ func setDefaults(config *Config) {
 	if config.Conversion.MaxSizeMB == 0 {
 		config.Conversion.MaxSizeMB = 50
 	}
+	if config.Conversion.MaxPixels == 0 {
+		config.Conversion.MaxPixels = 50000000
+	}
+	if config.Conversion.OutputFormat == "" {
+		config.Conversion.OutputFormat = "webp"
+	}
+	if config.Conversion.Metadata == "" {
+		config.Conversion.Metadata = "strip"
+	}
+	if config.Conversion.ColorProfile == "" {
+		config.Conversion.ColorProfile = "srgb"
+	}
+	if config.Conversion.Animation.MaxFrames == 0 {
+		config.Conversion.Animation.MaxFrames = 1000
+	}
+	if config.Conversion.Animation.MaxTotalPixels == 0 {
+		config.Conversion.Animation.MaxTotalPixels = 50000000
+	}
+	if config.Conversion.AVIF.Quality == 0 {
+		config.Conversion.AVIF.Quality = 60
+	}
+	if config.Conversion.AVIF.Speed == 0 {
+		config.Conversion.AVIF.Speed = 6
+	}
+	if config.Conversion.QualitySearch.MinQuality == 0 {
+		config.Conversion.QualitySearch.MinQuality = 30
+	}
+	if config.Conversion.QualitySearch.MaxQuality == 0 {
+		config.Conversion.QualitySearch.MaxQuality = 95
+	}
+	if config.Conversion.Placeholders.PreviewSize == 0 {
+		config.Conversion.Placeholders.PreviewSize = 20
+	}
+	if config.Conversion.AVIF.EncoderPath == "" {
+		config.Conversion.AVIF.EncoderPath = "avifenc"
+	}
 
 	// Cron defaults
 	if config.Cron.Schedule == "" {
@@ -138,6 +371,66 @@ func setDefaults(config *Config) {
 	if config.Server.TimeoutSeconds == 0 {
 		config.Server.TimeoutSeconds = 30
 	}
+
+	// Transform defaults
+	if config.Transform.CachePrefix == "" {
+		config.Transform.CachePrefix = "_variants"
+	}
+	if config.Transform.MaxDimension == 0 {
+		config.Transform.MaxDimension = 4096
+	}
+
+	// Batch defaults
+	if config.Batch.MaxItems == 0 {
+		config.Batch.MaxItems = 50
+	}
+	if config.Batch.Concurrency == 0 {
+		config.Batch.Concurrency = 4
+	}
+	if config.Batch.TimeoutSeconds == 0 {
+		config.Batch.TimeoutSeconds = 300
+	}
+
+	// Jobs defaults
+	if config.Jobs.Workers == 0 {
+		config.Jobs.Workers = 2
+	}
+	if config.Jobs.MaxQueued == 0 {
+		config.Jobs.MaxQueued = 100
+	}
+	if config.Jobs.MaxItems == 0 {
+		config.Jobs.MaxItems = 500
+	}
+	if config.Jobs.RetentionHours == 0 {
+		config.Jobs.RetentionHours = 24
+	}
+
+	// Webhook defaults
+	if config.Webhook.MaxAttempts == 0 {
+		config.Webhook.MaxAttempts = 8
+	}
+	if config.Webhook.TimeoutSeconds == 0 {
+		config.Webhook.TimeoutSeconds = 10
+	}
+
+	// Fetch defaults
+	if config.Fetch.TimeoutSeconds == 0 {
+		config.Fetch.TimeoutSeconds = 10
+	}
+	if config.Fetch.MaxRedirects == 0 {
+		config.Fetch.MaxRedirects = 3
+	}
+
+	// Watermark defaults
+	for name, watermark := range config.Watermarks {
+		if watermark.Position == "" {
+			watermark.Position = "southeast"
+		}
+		if watermark.Opacity == 0 {
+			watermark.Opacity = 1
+		}
+		config.Watermarks[name] = watermark
+	}
 }
 
 // Validate validates the configuration
@@ -163,6 +456,53 @@ func Validate(config *Config) error {
 	if config.Conversion.MaxSizeMB <= 0 {
 		return fmt.Errorf("conversion.max_size_mb must be positive, got: %d", config.Conversion.MaxSizeMB)
 	}
+	if config.Conversion.MaxPixels < 0 {
+		return fmt.Errorf("conversion.max_pixels must not be negative, got: %d", config.Conversion.MaxPixels)
+	}
+	switch config.Conversion.Metadata {
+	case "", "strip", "preserve":
+	default:
+		return fmt.Errorf("conversion.metadata must be strip or preserve, got: %s", config.Conversion.Metadata)
+	}
+	switch config.Conversion.ColorProfile {
+	case "", "srgb", "embed":
+	default:
+		return fmt.Errorf("conversion.color_profile must be srgb or embed, got: %s", config.Conversion.ColorProfile)
+	}
+	if config.Conversion.Animation.MaxFrames < 0 {
+		return fmt.Errorf("conversion.animation.max_frames must not be negative, got: %d", config.Conversion.Animation.MaxFrames)
+	}
+	if config.Conversion.Animation.MaxTotalPixels < 0 {
+		return fmt.Errorf("conversion.animation.max_total_pixels must not be negative, got: %d", config.Conversion.Animation.MaxTotalPixels)
+	}
+	if config.Conversion.AVIF.Quality < 0 || config.Conversion.AVIF.Quality > 100 {
+		return fmt.Errorf("conversion.avif.quality must be between 0 and 100, got: %d", config.Conversion.AVIF.Quality)
+	}
+	if config.Conversion.AVIF.Speed < 0 || config.Conversion.AVIF.Speed > 10 {
+		return fmt.Errorf("conversion.avif.speed must be between 0 and 10, got: %d", config.Conversion.AVIF.Speed)
+	}
+	if qs := config.Conversion.QualitySearch; qs.Enabled() || qs.MinQuality != 0 || qs.MaxQuality != 0 {
+		if qs.TargetSSIM < 0 || qs.TargetSSIM >= 1 {
+			return fmt.Errorf("conversion.quality_search.target_ssim must be between 0 and 1, got: %g", qs.TargetSSIM)
+		}
+		if qs.TargetDSSIM < 0 || qs.TargetDSSIM >= 0.5 {
+			return fmt.Errorf("conversion.quality_search.target_dssim must be between 0 and 0.5, got: %g", qs.TargetDSSIM)
+		}
+		if qs.TargetSSIM > 0 && qs.TargetDSSIM > 0 {
+			return fmt.Errorf("conversion.quality_search.target_ssim and target_dssim are mutually exclusive")
+		}
+		if qs.MaxBytes < 0 {
+			return fmt.Errorf("conversion.quality_search.max_bytes must not be negative, got: %d", qs.MaxBytes)
+		}
+		if qs.MinQuality < 1 || qs.MaxQuality > 100 || qs.MinQuality > qs.MaxQuality {
+			return fmt.Errorf("conversion.quality_search.min_quality and max_quality must satisfy 1 <= min <= max <= 100, got: %d and %d", qs.MinQuality, qs.MaxQuality)
+		}
+	}
+
+	if size := config.Conversion.Placeholders.PreviewSize; size < 0 || size > 32 {
+		// Previews are stored in object metadata, which R2 limits to 2 KB
+		return fmt.Errorf("conversion.placeholders.preview_size must be between 0 and 32, got: %d", size)
+	}
 
 	// Validate server settings
 	if config.Server.Port < 1 || config.Server.Port > 65535 {
@@ -172,14 +512,173 @@ func Validate(config *Config) error {
 		return fmt.Errorf("server.timeout_seconds must be positive, got: %d", config.Server.TimeoutSeconds)
 	}
 
+	// Validate transform settings
+	if config.Transform.MaxDimension < 0 {
+		return fmt.Errorf("transform.max_dimension must not be negative, got: %d", config.Transform.MaxDimension)
+	}
+
+	// Validate batch settings
+	if config.Batch.MaxItems < 0 {
+		return fmt.Errorf("batch.max_items must not be negative, got: %d", config.Batch.MaxItems)
+	}
+	if config.Batch.Concurrency < 0 {
+		return fmt.Errorf("batch.concurrency must not be negative, got: %d", config.Batch.Concurrency)
+	}
+	if config.Batch.TimeoutSeconds < 0 {
+		return fmt.Errorf("batch.timeout_seconds must not be negative, got: %d", config.Batch.TimeoutSeconds)
+	}
+
+	// Validate jobs settings
+	if config.Jobs.Workers < 0 {
+		return fmt.Errorf("jobs.workers must not be negative, got: %d", config.Jobs.Workers)
+	}
+	if config.Jobs.MaxQueued < 0 {
+		return fmt.Errorf("jobs.max_queued must not be negative, got: %d", config.Jobs.MaxQueued)
+	}
+	if config.Jobs.MaxItems < 0 {
+		return fmt.Errorf("jobs.max_items must not be negative, got: %d", config.Jobs.MaxItems)
+	}
+	if config.Jobs.RetentionHours < 0 {
+		return fmt.Errorf("jobs.retention_hours must not be negative, got: %d", config.Jobs.RetentionHours)
+	}
+
+	// Validate webhook settings
+	if config.Webhook.URL != "" {
+		u, err := url.Parse(config.Webhook.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("webhook.url must be an absolute http(s) URL, got: %q", config.Webhook.URL)
+		}
+		if config.Webhook.Secret == "" {
+			return fmt.Errorf("webhook.secret is required when webhook.url is set")
+		}
+	}
+	if config.Webhook.MaxAttempts < 0 {
+		return fmt.Errorf("webhook.max_attempts must not be negative, got: %d", config.Webhook.MaxAttempts)
+	}
+	if config.Webhook.TimeoutSeconds < 0 {
+		return fmt.Errorf("webhook.timeout_seconds must not be negative, got: %d", config.Webhook.TimeoutSeconds)
+	}
+
+	// Validate API keys
+	if config.Auth.Disabled && len(config.Auth.Keys) > 0 {
+		return fmt.Errorf("auth.disabled and auth.keys are mutually exclusive")
+	}
+	if !config.Auth.Disabled && len(config.Auth.Keys) == 0 {
+		return fmt.Errorf("auth.keys must not be empty unless auth.disabled is true")
+	}
+	names := make(map[string]bool)
+	hashes := make(map[string]bool)
+	for i, key := range config.Auth.Keys {
+		if key.Name == "" {
+			return fmt.Errorf("auth.keys[%d].name is required", i)
+		}
+		if names[key.Name] {
+			return fmt.Errorf("auth.keys[%d].name is a duplicate: %s", i, key.Name)
+		}
+		names[key.Name] = true
+		hash, ok := strings.CutPrefix(key.Hash, "sha256:")
+		if decoded, err := hex.DecodeString(hash); !ok || err != nil || len(decoded) != sha256.Size {
+			return fmt.Errorf("auth.keys[%d].hash must be \"sha256:\" and 64 hex digits", i)
+		}
+		if hashes[strings.ToLower(hash)] {
+			return fmt.Errorf("auth.keys[%d].hash is the same as another key's", i)
+		}
+		hashes[strings.ToLower(hash)] = true
+		if len(key.Scopes) == 0 {
+			return fmt.Errorf("auth.keys[%d].scopes must not be empty", i)
+		}
+		for _, scope := range key.Scopes {
+			switch scope {
+			case "convert", "read", "admin":
+			default:
+				return fmt.Errorf("auth.keys[%d].scopes must be convert, read or admin, got: %s", i, scope)
+			}
+		}
+		for _, prefix := range key.Prefixes {
+			if prefix == "" {
+				return fmt.Errorf("auth.keys[%d].prefixes must not contain an empty prefix", i)
+			}
+		}
+	}
+
+	// Validate fetch settings
+	if config.Fetch.TimeoutSeconds < 0 {
+		return fmt.Errorf("fetch.timeout_seconds must not be negative, got: %d", config.Fetch.TimeoutSeconds)
+	}
+	if config.Fetch.MaxRedirects < 0 {
+		return fmt.Errorf("fetch.max_redirects must not be negative, got: %d", config.Fetch.MaxRedirects)
+	}
+	for _, cidr := range append(append([]string{}, config.Fetch.AllowedCIDRs...), config.Fetch.DeniedCIDRs...) {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return fmt.Errorf("fetch: invalid CIDR %q", cidr)
+		}
+	}
+
 	// Validate resize presets
 	for name, preset := range config.Resize.Presets {
-		if preset.Width <= 0 {
+		pipelineOnly := len(preset.Operations) > 0 && preset.Width == 0 && preset.Height == 0
+		if preset.Width <= 0 && !pipelineOnly {
 			return fmt.Errorf("resize.presets.%s.width must be positive, got: %d", name, preset.Width)
 		}
-		if preset.Height <= 0 {
+		if preset.Height <= 0 && !pipelineOnly {
 			return fmt.Errorf("resize.presets.%s.height must be positive, got: %d", name, preset.Height)
 		}
+		switch preset.Fit {
+		case "", "fill", "cover", "contain", "inside", "outside":
+		default:
+			return fmt.Errorf("resize.presets.%s.fit must be fill, cover, contain, inside or outside, got: %s", name, preset.Fit)
+		}
+		switch preset.Gravity {
+		case "", "center", "north", "south", "east", "west", "northeast", "northwest", "southeast", "southwest", "smart":
+		default:
+			return fmt.Errorf("resize.presets.%s.gravity must be a compass direction, center or smart, got: %s", name, preset.Gravity)
+		}
+		if _, ok := config.Watermarks[preset.Watermark]; preset.Watermark != "" && !ok {
+			return fmt.Errorf("resize.presets.%s.watermark refers to an unknown watermark: %s", name, preset.Watermark)
+		}
+	}
+
+	// Validate watermarks
+	for name, watermark := range config.Watermarks {
+		if (watermark.File == "") == (watermark.R2Key == "") {
+			return fmt.Errorf("watermarks.%s must set exactly one of file or r2_key", name)
+		}
+		switch watermark.Position {
+		case "", "center", "north", "south", "east", "west", "northeast", "northwest", "southeast", "southwest":
+		default:
+			return fmt.Errorf("watermarks.%s.position must be a compass direction or center, got: %s", name, watermark.Position)
+		}
+		if watermark.Margin < 0 {
+			return fmt.Errorf("watermarks.%s.margin must not be negative, got: %d", name, watermark.Margin)
+		}
+		if watermark.Scale < 0 || watermark.Scale > 1 {
+			return fmt.Errorf("watermarks.%s.scale must be between 0 and 1, got: %g", name, watermark.Scale)
+		}
+		if watermark.Opacity < 0 || watermark.Opacity > 1 {
+			return fmt.Errorf("watermarks.%s.opacity must be between 0 and 1, got: %g", name, watermark.Opacity)
+		}
+	}
+
+	// Validate responsive variant sets; formats are checked against the encoders at startup
+	for name, set := range config.Responsive.Sets {
+		if len(set.Widths) == 0 {
+			return fmt.Errorf("responsive.sets.%s.widths must not be empty", name)
+		}
+		for _, width := range set.Widths {
+			if width <= 0 {
+				return fmt.Errorf("responsive.sets.%s.widths must be positive, got: %d", name, width)
+			}
+		}
+	}
+
+	// Validate cron rules
+	for i, rule := range config.Cron.Rules {
+		if _, ok := config.Watermarks[rule.Watermark]; rule.Watermark != "" && !ok {
+			return fmt.Errorf("cron.rules[%d].watermark refers to an unknown watermark: %s", i, rule.Watermark)
+		}
+		if _, ok := config.Responsive.Sets[rule.VariantSet]; rule.VariantSet != "" && !ok {
+			return fmt.Errorf("cron.rules[%d].variant_set refers to an unknown set: %s", i, rule.VariantSet)
+		}
 	}
 
 	return nil
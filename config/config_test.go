@@ -2,6 +2,8 @@ package config
 
 import (
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -28,12 +30,25 @@ resize:
     thumbnail:
       width: 150
       height: 150
+      fit: cover
+      gravity: smart
+      operations: ["sharpen:0.5"]
+      watermark: logo
+watermarks:
+  logo:
+    file: "assets/logo.png"
+    scale: 0.2
 cron:
   schedule: "0 2 * * *"
   enabled: true
+  rules:
+    - prefix: "products/"
+      watermark: logo
 server:
   port: 8080
   timeout_seconds: 30
+auth:
+  disabled: true
 `
 
 	if _, err := tmpFile.WriteString(testConfig); err != nil {
@@ -83,9 +98,32 @@ server:
 		if thumbnail.Width != 150 || thumbnail.Height != 150 {
 			t.Errorf("Expected thumbnail 150x150, got %dx%d", thumbnail.Width, thumbnail.Height)
 		}
+		if thumbnail.Fit != "cover" || thumbnail.Gravity != "smart" {
+			t.Errorf("Expected thumbnail fit cover and gravity smart, got %s and %s", thumbnail.Fit, thumbnail.Gravity)
+		}
+		if len(thumbnail.Operations) != 1 || thumbnail.Operations[0] != "sharpen:0.5" {
+			t.Errorf("Expected thumbnail operations [sharpen:0.5], got %v", thumbnail.Operations)
+		}
+	}
+
+	// Validate watermarks, with defaults applied
+	logo, ok := config.Watermarks["logo"]
+	if !ok {
+		t.Error("Expected 'logo' watermark not found")
+	} else if logo.File != "assets/logo.png" || logo.Scale != 0.2 || logo.Position != "southeast" || logo.Opacity != 1 {
+		t.Errorf("Unexpected logo watermark: %+v", logo)
 	}
 
 	// Validate cron config
+	if len(config.Cron.Rules) != 1 || config.Cron.Rules[0].Prefix != "products/" || config.Cron.Rules[0].Watermark != "logo" {
+		t.Errorf("Expected one cron rule for products/ with watermark logo, got %+v", config.Cron.Rules)
+	}
+	if config.Conversion.QualitySearch.Enabled() || config.Conversion.QualitySearch.MinQuality != 30 || config.Conversion.QualitySearch.MaxQuality != 95 {
+		t.Errorf("Expected quality search off with bounds 30..95, got %+v", config.Conversion.QualitySearch)
+	}
+	if p := config.Conversion.Placeholders; p.BlurHash || p.ThumbHash || p.Preview || p.PreviewSize != 20 {
+		t.Errorf("Expected placeholders off with a 20px preview size, got %+v", p)
+	}
 	if config.Cron.Schedule != "0 2 * * *" {
 		t.Errorf("Expected schedule '0 2 * * *', got '%s'", config.Cron.Schedule)
 	}
@@ -116,6 +154,8 @@ func TestLoadConfigWithDefaults(t *testing.T) {
   secret_key: "test-secret-key"
   endpoint: "https://test.r2.cloudflarestorage.com"
   bucket: "test-bucket"
+auth:
+  disabled: true
 `
 
 	if _, err := tmpFile.WriteString(testConfig); err != nil {
@@ -139,6 +179,38 @@ func TestLoadConfigWithDefaults(t *testing.T) {
 	if config.Conversion.MaxSizeMB != 50 {
 		t.Errorf("Expected default max_size_mb 50, got %d", config.Conversion.MaxSizeMB)
 	}
+	if config.Conversion.Metadata != "strip" {
+		t.Errorf("Expected default metadata strip, got %s", config.Conversion.Metadata)
+	}
+	if config.Conversion.ColorProfile != "srgb" {
+		t.Errorf("Expected default color_profile srgb, got %s", config.Conversion.ColorProfile)
+	}
+	if config.Conversion.MaxPixels != 50000000 {
+		t.Errorf("Expected default max_pixels 50000000, got %d", config.Conversion.MaxPixels)
+	}
+	if config.Conversion.Animation.MaxFrames != 1000 || config.Conversion.Animation.MaxTotalPixels != 50000000 {
+		t.Errorf("Expected default animation max_frames 1000 and max_total_pixels 50000000, got %d and %d",
+			config.Conversion.Animation.MaxFrames, config.Conversion.Animation.MaxTotalPixels)
+	}
+	if config.Fetch.TimeoutSeconds != 10 || config.Fetch.MaxRedirects != 3 {
+		t.Errorf("Expected default fetch timeout 10 and max_redirects 3, got %d and %d",
+			config.Fetch.TimeoutSeconds, config.Fetch.MaxRedirects)
+	}
+	if config.Batch.MaxItems != 50 || config.Batch.Concurrency != 4 || config.Batch.TimeoutSeconds != 300 {
+		t.Errorf("Expected default batch max_items 50, concurrency 4 and timeout 300, got %+v", config.Batch)
+	}
+	if config.Jobs.Workers != 2 || config.Jobs.MaxQueued != 100 || config.Jobs.MaxItems != 500 || config.Jobs.RetentionHours != 24 {
+		t.Errorf("Expected default jobs workers 2, max_queued 100, max_items 500 and retention 24, got %+v", config.Jobs)
+	}
+	if config.Webhook.MaxAttempts != 8 || config.Webhook.TimeoutSeconds != 10 {
+		t.Errorf("Expected default webhook max_attempts 8 and timeout 10, got %+v", config.Webhook)
+	}
+	if config.Conversion.OutputFormat != "webp" {
+		t.Errorf("Expected default output_format webp, got %s", config.Conversion.OutputFormat)
+	}
+	if config.Conversion.AVIF.Quality != 60 || config.Conversion.AVIF.Speed != 6 {
+		t.Errorf("Expected default avif quality 60 and speed 6, got %d and %d", config.Conversion.AVIF.Quality, config.Conversion.AVIF.Speed)
+	}
 	if config.Cron.Schedule != "0 2 * * *" {
 		t.Errorf("Expected default schedule '0 2 * * *', got '%s'", config.Cron.Schedule)
 	}
@@ -179,6 +251,8 @@ func TestLoadConfigWithEnvironmentVariables(t *testing.T) {
   bucket: "file-bucket"
 server:
   port: 8080
+auth:
+  disabled: true
 `
 
 	if _, err := tmpFile.WriteString(testConfig); err != nil {
@@ -234,6 +308,8 @@ func TestLoadConfigWithoutR2InYaml(t *testing.T) {
   quality: 90
 server:
   port: 8080
+auth:
+  disabled: true
 `
 
 	if _, err := tmpFile.WriteString(testConfig); err != nil {
@@ -323,6 +399,8 @@ SERVER_PORT=9000
   bucket: "file-bucket"
 server:
   port: 8080
+auth:
+  disabled: true
 `
 
 	if _, err := tmpFile.WriteString(testConfig); err != nil {
@@ -354,6 +432,54 @@ server:
 	}
 }
 
+func TestLoadConfigWithKeysFile(t *testing.T) {
+	dir := t.TempDir()
+	hash := "sha256:" + strings.Repeat("ab", 32)
+	keysFile := filepath.Join(dir, "keys.yaml")
+	keys := `- name: "gallery"
+  hash: "sha256:` + strings.Repeat("cd", 32) + `"
+  scopes: ["convert"]
+  prefixes: ["gallery/"]
+`
+	if err := os.WriteFile(keysFile, []byte(keys), 0600); err != nil {
+		t.Fatalf("Failed to write keys file: %v", err)
+	}
+
+	configFile := filepath.Join(dir, "config.yaml")
+	testConfig := `r2:
+  access_key: "key"
+  secret_key: "secret"
+  endpoint: "https://test.r2.cloudflarestorage.com"
+  bucket: "bucket"
+auth:
+  keys_file: "` + keysFile + `"
+  keys:
+    - name: "admin"
+      hash: "` + hash + `"
+      scopes: ["admin"]
+`
+	if err := os.WriteFile(configFile, []byte(testConfig), 0600); err != nil {
+		t.Fatalf("Failed to write test config: %v", err)
+	}
+
+	config, err := Load(configFile)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if len(config.Auth.Keys) != 2 || config.Auth.Keys[0].Name != "admin" || config.Auth.Keys[1].Name != "gallery" {
+		t.Fatalf("Expected the inline key followed by the file's key, got %+v", config.Auth.Keys)
+	}
+	if prefixes := config.Auth.Keys[1].Prefixes; len(prefixes) != 1 || prefixes[0] != "gallery/" {
+		t.Errorf("Expected prefixes [gallery/], got %v", prefixes)
+	}
+
+	// A missing keys file fails the load rather than leaving the API open
+	os.Remove(keysFile)
+	if _, err := Load(configFile); err == nil || !strings.Contains(err.Error(), "auth.keys_file") {
+		t.Errorf("Expected an auth.keys_file error, got %v", err)
+	}
+}
+
 func TestValidateConfig(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -378,6 +504,7 @@ func TestValidateConfig(t *testing.T) {
 					Port:           8080,
 					TimeoutSeconds: 30,
 				},
+				Auth: AuthConfig{Disabled: true},
 			},
 			wantErr: false,
 		},
@@ -409,6 +536,540 @@ func TestValidateConfig(t *testing.T) {
 			wantErr: true,
 			errMsg:  "quality",
 		},
+		{
+			name: "negative max_pixels",
+			config: &Config{
+				R2: R2Config{
+					AccessKey: "key",
+					SecretKey: "secret",
+					Endpoint:  "https://test.r2.cloudflarestorage.com",
+					Bucket:    "bucket",
+				},
+				Conversion: ConversionConfig{
+					Quality:   85,
+					MaxSizeMB: 50,
+					MaxPixels: -1,
+				},
+				Server: ServerConfig{
+					Port:           8080,
+					TimeoutSeconds: 30,
+				},
+			},
+			wantErr: true,
+			errMsg:  "max_pixels",
+		},
+		{
+			name: "negative animation max_frames",
+			config: &Config{
+				R2: R2Config{
+					AccessKey: "key",
+					SecretKey: "secret",
+					Endpoint:  "https://test.r2.cloudflarestorage.com",
+					Bucket:    "bucket",
+				},
+				Conversion: ConversionConfig{
+					Quality:   85,
+					MaxSizeMB: 50,
+					Animation: AnimationConfig{MaxFrames: -1},
+				},
+				Server: ServerConfig{
+					Port:           8080,
+					TimeoutSeconds: 30,
+				},
+			},
+			wantErr: true,
+			errMsg:  "max_frames",
+		},
+		{
+			name: "invalid preset fit",
+			config: &Config{
+				R2: R2Config{
+					AccessKey: "key",
+					SecretKey: "secret",
+					Endpoint:  "https://test.r2.cloudflarestorage.com",
+					Bucket:    "bucket",
+				},
+				Conversion: ConversionConfig{
+					Quality:   85,
+					MaxSizeMB: 50,
+				},
+				Resize: ResizeConfig{
+					Presets: map[string]PresetConfig{
+						"thumbnail": {Width: 150, Height: 150, Fit: "squash"},
+					},
+				},
+				Server: ServerConfig{
+					Port:           8080,
+					TimeoutSeconds: 30,
+				},
+			},
+			wantErr: true,
+			errMsg:  "fit",
+		},
+		{
+			name: "pipeline-only preset",
+			config: &Config{
+				R2: R2Config{
+					AccessKey: "key",
+					SecretKey: "secret",
+					Endpoint:  "https://test.r2.cloudflarestorage.com",
+					Bucket:    "bucket",
+				},
+				Conversion: ConversionConfig{
+					Quality:   85,
+					MaxSizeMB: 50,
+				},
+				Resize: ResizeConfig{
+					Presets: map[string]PresetConfig{
+						"mono": {Operations: []string{"grayscale"}},
+					},
+				},
+				Server: ServerConfig{
+					Port:           8080,
+					TimeoutSeconds: 30,
+				},
+				Auth: AuthConfig{Disabled: true},
+			},
+			wantErr: false,
+		},
+		{
+			name: "preset without size or operations",
+			config: &Config{
+				R2: R2Config{
+					AccessKey: "key",
+					SecretKey: "secret",
+					Endpoint:  "https://test.r2.cloudflarestorage.com",
+					Bucket:    "bucket",
+				},
+				Conversion: ConversionConfig{
+					Quality:   85,
+					MaxSizeMB: 50,
+				},
+				Resize: ResizeConfig{
+					Presets: map[string]PresetConfig{
+						"empty": {},
+					},
+				},
+				Server: ServerConfig{
+					Port:           8080,
+					TimeoutSeconds: 30,
+				},
+			},
+			wantErr: true,
+			errMsg:  "width",
+		},
+		{
+			name: "watermark with file and r2_key",
+			config: &Config{
+				R2: R2Config{
+					AccessKey: "key",
+					SecretKey: "secret",
+					Endpoint:  "https://test.r2.cloudflarestorage.com",
+					Bucket:    "bucket",
+				},
+				Conversion: ConversionConfig{
+					Quality:   85,
+					MaxSizeMB: 50,
+				},
+				Watermarks: map[string]WatermarkConfig{
+					"logo": {File: "logo.png", R2Key: "logo.png"},
+				},
+				Server: ServerConfig{
+					Port:           8080,
+					TimeoutSeconds: 30,
+				},
+			},
+			wantErr: true,
+			errMsg:  "file or r2_key",
+		},
+		{
+			name: "watermark opacity out of range",
+			config: &Config{
+				R2: R2Config{
+					AccessKey: "key",
+					SecretKey: "secret",
+					Endpoint:  "https://test.r2.cloudflarestorage.com",
+					Bucket:    "bucket",
+				},
+				Conversion: ConversionConfig{
+					Quality:   85,
+					MaxSizeMB: 50,
+				},
+				Watermarks: map[string]WatermarkConfig{
+					"logo": {File: "logo.png", Opacity: 1.5},
+				},
+				Server: ServerConfig{
+					Port:           8080,
+					TimeoutSeconds: 30,
+				},
+			},
+			wantErr: true,
+			errMsg:  "opacity",
+		},
+		{
+			name: "preset with unknown watermark",
+			config: &Config{
+				R2: R2Config{
+					AccessKey: "key",
+					SecretKey: "secret",
+					Endpoint:  "https://test.r2.cloudflarestorage.com",
+					Bucket:    "bucket",
+				},
+				Conversion: ConversionConfig{
+					Quality:   85,
+					MaxSizeMB: 50,
+				},
+				Resize: ResizeConfig{
+					Presets: map[string]PresetConfig{
+						"thumbnail": {Width: 150, Height: 150, Watermark: "logo"},
+					},
+				},
+				Server: ServerConfig{
+					Port:           8080,
+					TimeoutSeconds: 30,
+				},
+			},
+			wantErr: true,
+			errMsg:  "unknown watermark",
+		},
+		{
+			name: "cron rule with unknown watermark",
+			config: &Config{
+				R2: R2Config{
+					AccessKey: "key",
+					SecretKey: "secret",
+					Endpoint:  "https://test.r2.cloudflarestorage.com",
+					Bucket:    "bucket",
+				},
+				Conversion: ConversionConfig{
+					Quality:   85,
+					MaxSizeMB: 50,
+				},
+				Cron: CronConfig{
+					Rules: []CronRule{{Prefix: "products/", Watermark: "logo"}},
+				},
+				Server: ServerConfig{
+					Port:           8080,
+					TimeoutSeconds: 30,
+				},
+			},
+			wantErr: true,
+			errMsg:  "cron.rules[0]",
+		},
+		{
+			name: "variant set without widths",
+			config: &Config{
+				R2: R2Config{
+					AccessKey: "key",
+					SecretKey: "secret",
+					Endpoint:  "https://test.r2.cloudflarestorage.com",
+					Bucket:    "bucket",
+				},
+				Conversion: ConversionConfig{
+					Quality:   85,
+					MaxSizeMB: 50,
+				},
+				Responsive: ResponsiveConfig{
+					Sets: map[string]VariantSetConfig{"web": {Formats: []string{"webp"}}},
+				},
+				Server: ServerConfig{
+					Port:           8080,
+					TimeoutSeconds: 30,
+				},
+			},
+			wantErr: true,
+			errMsg:  "widths",
+		},
+		{
+			name: "cron rule with unknown variant set",
+			config: &Config{
+				R2: R2Config{
+					AccessKey: "key",
+					SecretKey: "secret",
+					Endpoint:  "https://test.r2.cloudflarestorage.com",
+					Bucket:    "bucket",
+				},
+				Conversion: ConversionConfig{
+					Quality:   85,
+					MaxSizeMB: 50,
+				},
+				Cron: CronConfig{
+					Rules: []CronRule{{Prefix: "gallery/", VariantSet: "web"}},
+				},
+				Server: ServerConfig{
+					Port:           8080,
+					TimeoutSeconds: 30,
+				},
+			},
+			wantErr: true,
+			errMsg:  "variant_set",
+		},
+		{
+			name: "quality search with both ssim and dssim targets",
+			config: &Config{
+				R2: R2Config{
+					AccessKey: "key",
+					SecretKey: "secret",
+					Endpoint:  "https://test.r2.cloudflarestorage.com",
+					Bucket:    "bucket",
+				},
+				Conversion: ConversionConfig{
+					Quality:       85,
+					MaxSizeMB:     50,
+					QualitySearch: QualitySearchConfig{TargetSSIM: 0.98, TargetDSSIM: 0.01, MinQuality: 30, MaxQuality: 95},
+				},
+				Server: ServerConfig{
+					Port:           8080,
+					TimeoutSeconds: 30,
+				},
+			},
+			wantErr: true,
+			errMsg:  "target_dssim",
+		},
+		{
+			name: "quality search with inverted bounds",
+			config: &Config{
+				R2: R2Config{
+					AccessKey: "key",
+					SecretKey: "secret",
+					Endpoint:  "https://test.r2.cloudflarestorage.com",
+					Bucket:    "bucket",
+				},
+				Conversion: ConversionConfig{
+					Quality:       85,
+					MaxSizeMB:     50,
+					QualitySearch: QualitySearchConfig{MaxBytes: 100000, MinQuality: 90, MaxQuality: 40},
+				},
+				Server: ServerConfig{
+					Port:           8080,
+					TimeoutSeconds: 30,
+				},
+			},
+			wantErr: true,
+			errMsg:  "min_quality",
+		},
+		{
+			name: "placeholder preview too large",
+			config: &Config{
+				R2: R2Config{
+					AccessKey: "key",
+					SecretKey: "secret",
+					Endpoint:  "https://test.r2.cloudflarestorage.com",
+					Bucket:    "bucket",
+				},
+				Conversion: ConversionConfig{
+					Quality:      85,
+					MaxSizeMB:    50,
+					Placeholders: PlaceholderConfig{Preview: true, PreviewSize: 64},
+				},
+				Server: ServerConfig{
+					Port:           8080,
+					TimeoutSeconds: 30,
+				},
+			},
+			wantErr: true,
+			errMsg:  "preview_size",
+		},
+		{
+			name: "negative batch concurrency",
+			config: &Config{
+				R2: R2Config{
+					AccessKey: "key",
+					SecretKey: "secret",
+					Endpoint:  "https://test.r2.cloudflarestorage.com",
+					Bucket:    "bucket",
+				},
+				Conversion: ConversionConfig{
+					Quality:   85,
+					MaxSizeMB: 50,
+				},
+				Server: ServerConfig{
+					Port:           8080,
+					TimeoutSeconds: 30,
+				},
+				Batch: BatchConfig{Concurrency: -1},
+			},
+			wantErr: true,
+			errMsg:  "batch.concurrency",
+		},
+		{
+			name: "negative jobs workers",
+			config: &Config{
+				R2: R2Config{
+					AccessKey: "key",
+					SecretKey: "secret",
+					Endpoint:  "https://test.r2.cloudflarestorage.com",
+					Bucket:    "bucket",
+				},
+				Conversion: ConversionConfig{
+					Quality:   85,
+					MaxSizeMB: 50,
+				},
+				Server: ServerConfig{
+					Port:           8080,
+					TimeoutSeconds: 30,
+				},
+				Jobs: JobsConfig{Workers: -1},
+			},
+			wantErr: true,
+			errMsg:  "jobs.workers",
+		},
+		{
+			name: "webhook URL without secret",
+			config: &Config{
+				R2: R2Config{
+					AccessKey: "key",
+					SecretKey: "secret",
+					Endpoint:  "https://test.r2.cloudflarestorage.com",
+					Bucket:    "bucket",
+				},
+				Conversion: ConversionConfig{
+					Quality:   85,
+					MaxSizeMB: 50,
+				},
+				Server: ServerConfig{
+					Port:           8080,
+					TimeoutSeconds: 30,
+				},
+				Webhook: WebhookConfig{URL: "https://hooks.example.com/images"},
+			},
+			wantErr: true,
+			errMsg:  "webhook.secret",
+		},
+		{
+			name: "relative webhook URL",
+			config: &Config{
+				R2: R2Config{
+					AccessKey: "key",
+					SecretKey: "secret",
+					Endpoint:  "https://test.r2.cloudflarestorage.com",
+					Bucket:    "bucket",
+				},
+				Conversion: ConversionConfig{
+					Quality:   85,
+					MaxSizeMB: 50,
+				},
+				Server: ServerConfig{
+					Port:           8080,
+					TimeoutSeconds: 30,
+				},
+				Webhook: WebhookConfig{URL: "/hooks", Secret: "s"},
+			},
+			wantErr: true,
+			errMsg:  "webhook.url",
+		},
+		{
+			name: "no API keys without auth.disabled",
+			config: &Config{
+				R2: R2Config{
+					AccessKey: "key",
+					SecretKey: "secret",
+					Endpoint:  "https://test.r2.cloudflarestorage.com",
+					Bucket:    "bucket",
+				},
+				Conversion: ConversionConfig{
+					Quality:   85,
+					MaxSizeMB: 50,
+				},
+				Server: ServerConfig{
+					Port:           8080,
+					TimeoutSeconds: 30,
+				},
+				Auth: AuthConfig{},
+			},
+			wantErr: true,
+			errMsg:  "auth.keys must not be empty",
+		},
+		{
+			name: "auth.disabled with API keys",
+			config: &Config{
+				R2: R2Config{
+					AccessKey: "key",
+					SecretKey: "secret",
+					Endpoint:  "https://test.r2.cloudflarestorage.com",
+					Bucket:    "bucket",
+				},
+				Conversion: ConversionConfig{
+					Quality:   85,
+					MaxSizeMB: 50,
+				},
+				Server: ServerConfig{
+					Port:           8080,
+					TimeoutSeconds: 30,
+				},
+				Auth: AuthConfig{Disabled: true, Keys: []APIKeyConfig{{Name: "app", Hash: "sha256:" + strings.Repeat("ab", 32), Scopes: []string{"read"}}}},
+			},
+			wantErr: true,
+			errMsg:  "mutually exclusive",
+		},
+		{
+			name: "malformed API key hash",
+			config: &Config{
+				R2: R2Config{
+					AccessKey: "key",
+					SecretKey: "secret",
+					Endpoint:  "https://test.r2.cloudflarestorage.com",
+					Bucket:    "bucket",
+				},
+				Conversion: ConversionConfig{
+					Quality:   85,
+					MaxSizeMB: 50,
+				},
+				Server: ServerConfig{
+					Port:           8080,
+					TimeoutSeconds: 30,
+				},
+				Auth: AuthConfig{Keys: []APIKeyConfig{{Name: "app", Hash: "0123", Scopes: []string{"convert"}}}},
+			},
+			wantErr: true,
+			errMsg:  "auth.keys[0].hash",
+		},
+		{
+			name: "unknown API key scope",
+			config: &Config{
+				R2: R2Config{
+					AccessKey: "key",
+					SecretKey: "secret",
+					Endpoint:  "https://test.r2.cloudflarestorage.com",
+					Bucket:    "bucket",
+				},
+				Conversion: ConversionConfig{
+					Quality:   85,
+					MaxSizeMB: 50,
+				},
+				Server: ServerConfig{
+					Port:           8080,
+					TimeoutSeconds: 30,
+				},
+				Auth: AuthConfig{Keys: []APIKeyConfig{{Name: "app", Hash: "sha256:" + strings.Repeat("ab", 32), Scopes: []string{"write"}}}},
+			},
+			wantErr: true,
+			errMsg:  "auth.keys[0].scopes",
+		},
+		{
+			name: "invalid fetch CIDR",
+			config: &Config{
+				R2: R2Config{
+					AccessKey: "key",
+					SecretKey: "secret",
+					Endpoint:  "https://test.r2.cloudflarestorage.com",
+					Bucket:    "bucket",
+				},
+				Conversion: ConversionConfig{
+					Quality:   85,
+					MaxSizeMB: 50,
+				},
+				Server: ServerConfig{
+					Port:           8080,
+					TimeoutSeconds: 30,
+				},
+				Fetch: FetchConfig{
+					DeniedCIDRs: []string{"10.0.0.0"},
+				},
+			},
+			wantErr: true,
+			errMsg:  "CIDR",
+		},
 		{
 			name: "invalid port",
 			config: &Config{
@@ -2,17 +2,21 @@ package cron
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"os"
 	"path/filepath"
+	"runtime/debug"
 	"strings"
 	"time"
 
 	"image-converting-server/config"
 	"image-converting-server/processor"
 	"image-converting-server/r2"
+	"image-converting-server/responsive"
 	"image-converting-server/state"
+	"image-converting-server/webhook"
 
 	"github.com/robfig/cron/v3"
 )
@@ -25,12 +29,13 @@ type Job struct {
 	processor *processor.Processor
 	statePath string
 	lockPath  string
+	webhook   *webhook.Dispatcher
 }
 
 // NewJob creates a new Job instance
 func NewJob(cfg *config.Config, r2Client r2.StorageClient, proc *processor.Processor, statePath string) *Job {
 	return &Job{
-		cron:      cron.New(),
+		cron:      cron.New(cron.WithChain(cron.Recover(cron.PrintfLogger(log.Default())))),
 		cfg:       cfg,
 		r2Client:  r2Client,
 		processor: proc,
@@ -39,6 +44,11 @@ func NewJob(cfg *config.Config, r2Client r2.StorageClient, proc *processor.Proce
 	}
 }
 
+// SetWebhook sends a cron.converted event for every image converted or failed
+func (j *Job) SetWebhook(dispatcher *webhook.Dispatcher) {
+	j.webhook = dispatcher
+}
+
 // Start registers and starts the cron job
 func (j *Job) Start() error {
 	if !j.cfg.Cron.Enabled {
@@ -97,62 +107,46 @@ func (j *Job) ProcessImages() {
 
 	log.Printf("[INFO] Found %d objects to check", len(keys))
 
+	encoder, err := j.processor.Encoder("")
+	if err != nil {
+		log.Printf("[ERROR] Failed to resolve output encoder: %v", err)
+		return
+	}
+
 	processedCount := 0
 	failedCount := 0
+	skippedCount := 0
 
 	// 4. Process each image
 	for _, key := range keys {
-		// Skip if already webp
-		if strings.HasSuffix(strings.ToLower(key), ".webp") {
-			continue
-		}
-
-		// Check if extension is supported
-		if !j.isSupportedExtension(key) {
+		// Skip if already in the output format
+		if strings.EqualFold(filepath.Ext(key), encoder.Extension()) {
 			continue
 		}
 
-		log.Printf("[INFO] Processing image: %s", key)
-
-		// Download
-		data, err := j.r2Client.DownloadImage(ctx, key)
-		if err != nil {
-			log.Printf("[ERROR] Failed to download image %s: %v", key, err)
-			failedCount++
+		// Skip variants cached by the transform endpoint or rendered for a responsive set
+		if j.isTransformVariant(key) || responsive.IsVariantKey(key) {
 			continue
 		}
 
-		// Convert
-		webpData, _, err := j.processor.Process(data, processor.ProcessOptions{})
-		if err != nil {
-			log.Printf("[ERROR] Failed to convert image %s: %v", key, err)
-			failedCount++
+		// Check if extension is supported
+		if !j.isSupportedExtension(key) {
 			continue
 		}
 
-		// Upload with .webp extension
-		destKey := j.changeExtensionToWebp(key)
-		err = j.r2Client.UploadImage(ctx, destKey, webpData, "image/webp")
-		if err != nil {
-			log.Printf("[ERROR] Failed to upload converted image %s: %v", destKey, err)
+		log.Printf("[INFO] Processing image: %s", key)
+		item, err := j.convertImageRecover(ctx, key, encoder)
+		switch {
+		case errors.Is(err, r2.ErrObjectTooLarge), errors.Is(err, processor.ErrImageTooLarge):
+			log.Printf("[WARN] Skipped %s: too large: %v", key, err)
+			skippedCount++
+		case err != nil:
+			log.Printf("[ERROR] Failed to process image %s: %v", key, err)
 			failedCount++
-			continue
+		default:
+			processedCount++
 		}
-
-		log.Printf("[INFO] Successfully converted %s to %s", key, destKey)
-		processedCount++
-
-		// Delete original image
-		/*
-			if key != destKey {
-				err = j.r2Client.DeleteObject(ctx, key)
-				if err != nil {
-					log.Printf("[WARN] Failed to delete original image %s: %v", key, err)
-				} else {
-					log.Printf("[INFO] Deleted original image: %s", key)
-				}
-			}
-		*/
+		j.notify(item, err)
 
 		// Note: We might want to keep track of the latest LastModified time from the objects
 		// but since we don't have it here (ListObjects only returns keys),
@@ -163,6 +157,7 @@ func (j *Job) ProcessImages() {
 	// 5. Update state
 	currentState.ProcessedCount = processedCount
 	currentState.FailedCount = failedCount
+	currentState.SkippedCount = skippedCount
 	currentState.LastRunTime = startTime
 	// Update last processed time to the start of this run
 	// so next time we only look at images modified after this run started.
@@ -172,8 +167,112 @@ func (j *Job) ProcessImages() {
 		log.Printf("[ERROR] Failed to save state: %v", err)
 	}
 
-	log.Printf("[INFO] Cron job execution completed. Processed: %d, Failed: %d, Duration: %v",
-		processedCount, failedCount, time.Since(startTime))
+	log.Printf("[INFO] Cron job execution completed. Processed: %d, Failed: %d, Skipped: %d, Duration: %v",
+		processedCount, failedCount, skippedCount, time.Since(startTime))
+}
+
+// convertImageRecover runs convertImage and reports a panic as a failed object, so
+// one bad object cannot stop the run or crash the server on every scheduled run
+func (j *Job) convertImageRecover(ctx context.Context, key string, encoder processor.Encoder) (item webhook.Item, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("[ERROR] Processing %s panicked: %v\n%s", key, r, debug.Stack())
+			err = fmt.Errorf("panicked: %v", r)
+		}
+	}()
+	return j.convertImage(ctx, key, encoder)
+}
+
+// convertImage converts one object, or renders its variant set when the matching
+// rule names one, and describes the outcome for the webhook
+func (j *Job) convertImage(ctx context.Context, key string, encoder processor.Encoder) (webhook.Item, error) {
+	item := webhook.Item{Source: "r2://" + j.cfg.R2.Bucket + "/" + key}
+
+	// Download
+	data, err := j.r2Client.DownloadImage(ctx, key)
+	if err != nil {
+		return item, fmt.Errorf("failed to download: %w", err)
+	}
+	item.OriginalSize = len(data)
+
+	rule := j.matchRule(key)
+	options := processor.ProcessOptions{Watermark: rule.Watermark, Context: ctx}
+
+	// Render a responsive set instead of a single output when the rule names one
+	if set, ok := j.cfg.Responsive.Sets[rule.VariantSet]; ok && rule.VariantSet != "" {
+		variants, err := responsive.Generate(j.processor, key, data, options, set)
+		if err == nil {
+			_, err = responsive.Store(ctx, j.r2Client, item.Source, key, variants, j.cfg.Responsive.BaseURL)
+		}
+		if err != nil {
+			return item, fmt.Errorf("failed to render variants: %w", err)
+		}
+		item.Destination = "r2://" + j.cfg.R2.Bucket + "/" + responsive.ManifestKey(key)
+		for _, v := range variants {
+			item.Variants = append(item.Variants, v.Key)
+			item.ConvertedSize += v.Size
+		}
+		log.Printf("[INFO] Successfully rendered %d variants of %s", len(variants), key)
+		return item, nil
+	}
+
+	// Convert
+	result, err := j.processor.ProcessResult(data, options)
+	if err != nil {
+		return item, fmt.Errorf("failed to convert: %w", err)
+	}
+
+	// Upload with the output format extension
+	destKey := j.changeExtension(key, encoder.Extension())
+	err = j.r2Client.UploadImageWithMetadata(ctx, destKey, result.Data, encoder.ContentType(), result.Placeholders.Metadata())
+	if err != nil {
+		return item, fmt.Errorf("failed to upload converted image %s: %w", destKey, err)
+	}
+	item.Destination = "r2://" + j.cfg.R2.Bucket + "/" + destKey
+	item.ConvertedSize = len(result.Data)
+
+	log.Printf("[INFO] Successfully converted %s to %s", key, destKey)
+
+	// Delete original image
+	/*
+		if key != destKey {
+			err = j.r2Client.DeleteObject(ctx, key)
+			if err != nil {
+				log.Printf("[WARN] Failed to delete original image %s: %v", key, err)
+			} else {
+				log.Printf("[INFO] Deleted original image: %s", key)
+			}
+		}
+	*/
+
+	return item, nil
+}
+
+// notify sends the cron.converted event of one image to webhook.url
+func (j *Job) notify(item webhook.Item, err error) {
+	if j.webhook == nil {
+		return
+	}
+	event := webhook.Event{Type: webhook.EventCronConverted, Status: "succeeded", Items: []webhook.Item{item}}
+	if err != nil {
+		event.Status = "failed"
+		event.Items[0].Error = err.Error()
+	}
+	if err := j.webhook.Send("", event); err != nil {
+		log.Printf("[ERROR] Failed to queue webhook for %s: %v", item.Source, err)
+	}
+}
+
+// matchRule returns the rule with the longest prefix matching key, or an empty rule
+func (j *Job) matchRule(key string) config.CronRule {
+	var matched config.CronRule
+	length := -1
+	for _, rule := range j.cfg.Cron.Rules {
+		if strings.HasPrefix(key, rule.Prefix) && len(rule.Prefix) > length {
+			matched, length = rule, len(rule.Prefix)
+		}
+	}
+	return matched
 }
 
 func (j *Job) isSupportedExtension(key string) bool {
@@ -185,19 +284,25 @@ func (j *Job) isSupportedExtension(key string) bool {
 	ext = ext[1:]
 
 	for _, format := range j.cfg.Conversion.Formats {
-		if strings.ToLower(format) == ext || (ext == "jpeg" && format == "jpg") || (ext == "jpg" && format == "jpeg") {
+		if strings.ToLower(format) == ext || (ext == "jpeg" && format == "jpg") || (ext == "jpg" && format == "jpeg") ||
+			(ext == "tif" && format == "tiff") || (ext == "tiff" && format == "tif") {
 			return true
 		}
 	}
 	return false
 }
 
-func (j *Job) changeExtensionToWebp(key string) string {
+func (j *Job) isTransformVariant(key string) bool {
+	prefix := j.cfg.Transform.CachePrefix
+	return prefix != "" && strings.HasPrefix(key, strings.TrimSuffix(prefix, "/")+"/")
+}
+
+func (j *Job) changeExtension(key, newExt string) string {
 	ext := filepath.Ext(key)
 	if ext == "" {
-		return key + ".webp"
+		return key + newExt
 	}
-	return key[:len(key)-len(ext)] + ".webp"
+	return key[:len(key)-len(ext)] + newExt
 }
 
 func (j *Job) acquireLock() error {
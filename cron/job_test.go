@@ -1,11 +1,22 @@
 package cron
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"image"
 	"image-converting-server/config"
 	"image-converting-server/processor"
+	"image-converting-server/r2"
+	"image-converting-server/state"
+	"image-converting-server/webhook"
+	"image/png"
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"sync"
 	"testing"
 	"time"
 )
@@ -15,6 +26,7 @@ type mockStorageClient struct {
 	downloadFunc func(ctx context.Context, key string) ([]byte, error)
 	uploadFunc   func(ctx context.Context, key string, data []byte, contentType string) error
 	listFunc     func(ctx context.Context, since time.Time) ([]string, error)
+	metadata     map[string]map[string]string // Metadata of uploads, by key; recorded when non-nil
 }
 
 func (m *mockStorageClient) DownloadImage(ctx context.Context, key string) ([]byte, error) {
@@ -23,6 +35,12 @@ func (m *mockStorageClient) DownloadImage(ctx context.Context, key string) ([]by
 func (m *mockStorageClient) UploadImage(ctx context.Context, key string, data []byte, contentType string) error {
 	return m.uploadFunc(ctx, key, data, contentType)
 }
+func (m *mockStorageClient) UploadImageWithMetadata(ctx context.Context, key string, data []byte, contentType string, metadata map[string]string) error {
+	if m.metadata != nil {
+		m.metadata[key] = metadata
+	}
+	return m.uploadFunc(ctx, key, data, contentType)
+}
 func (m *mockStorageClient) ListObjects(ctx context.Context, since time.Time) ([]string, error) {
 	return m.listFunc(ctx, since)
 }
@@ -100,6 +118,98 @@ func TestProcessImages(t *testing.T) {
 	}
 }
 
+func TestProcessImages_SkipsTooLarge(t *testing.T) {
+	tempDir := t.TempDir()
+	statePath := filepath.Join(tempDir, "state.json")
+
+	cfg := &config.Config{
+		Conversion: config.ConversionConfig{
+			Formats:   []string{"png"},
+			Quality:   85,
+			MaxPixels: 100,
+		},
+	}
+	proc := processor.NewProcessor(*cfg)
+
+	var buf bytes.Buffer
+	png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 20, 20)))
+
+	r2Mock := &mockStorageClient{
+		listFunc: func(ctx context.Context, since time.Time) ([]string, error) {
+			return []string{"big-object.png", "big-pixels.png"}, nil
+		},
+		downloadFunc: func(ctx context.Context, key string) ([]byte, error) {
+			if key == "big-object.png" {
+				return nil, r2.ErrObjectTooLarge
+			}
+			return buf.Bytes(), nil
+		},
+		uploadFunc: func(ctx context.Context, key string, data []byte, contentType string) error {
+			t.Errorf("unexpected upload of %s", key)
+			return nil
+		},
+	}
+
+	job := NewJob(cfg, r2Mock, proc, statePath)
+	job.ProcessImages()
+
+	s, err := state.LoadState(statePath)
+	if err != nil {
+		t.Fatalf("LoadState failed: %v", err)
+	}
+	if s.SkippedCount != 2 || s.FailedCount != 0 {
+		t.Errorf("expected 2 skipped and 0 failed, got %d skipped and %d failed", s.SkippedCount, s.FailedCount)
+	}
+}
+
+func TestProcessImages_RecoversPanic(t *testing.T) {
+	statePath := filepath.Join(t.TempDir(), "state.json")
+	cfg := &config.Config{
+		Conversion: config.ConversionConfig{
+			Formats: []string{"png"},
+			Quality: 85,
+		},
+	}
+	proc := processor.NewProcessor(*cfg)
+
+	var buf bytes.Buffer
+	png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 4, 4)))
+
+	uploadedKeys := make(map[string]bool)
+	r2Mock := &mockStorageClient{
+		listFunc: func(ctx context.Context, since time.Time) ([]string, error) {
+			return []string{"crash.png", "good.png"}, nil
+		},
+		downloadFunc: func(ctx context.Context, key string) ([]byte, error) {
+			if key == "crash.png" {
+				panic("crafted upload")
+			}
+			return buf.Bytes(), nil
+		},
+		uploadFunc: func(ctx context.Context, key string, data []byte, contentType string) error {
+			uploadedKeys[key] = true
+			return nil
+		},
+	}
+
+	job := NewJob(cfg, r2Mock, proc, statePath)
+	job.ProcessImages()
+
+	if !uploadedKeys["good.webp"] {
+		t.Error("expected the run to continue after the panic")
+	}
+	s, err := state.LoadState(statePath)
+	if err != nil {
+		t.Fatalf("LoadState failed: %v", err)
+	}
+	if s.ProcessedCount != 1 || s.FailedCount != 1 {
+		t.Errorf("expected 1 processed and 1 failed, got %d processed and %d failed", s.ProcessedCount, s.FailedCount)
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(statePath), ".lock")); !os.IsNotExist(err) {
+		t.Errorf("expected the lock to be released, got %v", err)
+	}
+}
+
 func TestLocking(t *testing.T) {
 	tempDir, err := os.MkdirTemp("", "cron_lock_test")
 	if err != nil {
@@ -132,3 +242,248 @@ func TestLocking(t *testing.T) {
 	}
 	job.releaseLock()
 }
+
+func TestMatchRule(t *testing.T) {
+	cfg := &config.Config{
+		Cron: config.CronConfig{Rules: []config.CronRule{
+			{Prefix: "products/", Watermark: "logo"},
+			{Prefix: "products/raw/", Watermark: ""},
+			{Prefix: "blog/", Watermark: "small"},
+		}},
+	}
+	job := NewJob(cfg, nil, nil, "state.json")
+
+	tests := []struct {
+		key       string
+		watermark string
+	}{
+		{"products/shoe.png", "logo"},
+		{"products/raw/shoe.png", ""},
+		{"blog/post.jpg", "small"},
+		{"other/image.png", ""},
+	}
+	for _, tt := range tests {
+		if got := job.matchRule(tt.key).Watermark; got != tt.watermark {
+			t.Errorf("%s: expected watermark %q, got %q", tt.key, tt.watermark, got)
+		}
+	}
+}
+
+func TestProcessImages_Watermark(t *testing.T) {
+	tempDir := t.TempDir()
+	logoPath := filepath.Join(tempDir, "logo.png")
+	var logo bytes.Buffer
+	black := image.NewNRGBA(image.Rect(0, 0, 8, 8))
+	for i := 3; i < len(black.Pix); i += 4 {
+		black.Pix[i] = 255
+	}
+	png.Encode(&logo, black)
+	if err := os.WriteFile(logoPath, logo.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	white := image.NewNRGBA(image.Rect(0, 0, 32, 32))
+	for i := range white.Pix {
+		white.Pix[i] = 255
+	}
+	var src bytes.Buffer
+	png.Encode(&src, white)
+
+	cfg := &config.Config{
+		Conversion: config.ConversionConfig{Formats: []string{"png"}, Quality: 90},
+		Cron: config.CronConfig{
+			Enabled: true,
+			Rules:   []config.CronRule{{Prefix: "products/", Watermark: "logo"}},
+		},
+		Watermarks: map[string]config.WatermarkConfig{
+			"logo": {File: logoPath, Position: "southeast", Opacity: 1},
+		},
+	}
+	uploads := make(map[string][]byte)
+	r2Mock := &mockStorageClient{
+		listFunc: func(ctx context.Context, since time.Time) ([]string, error) {
+			return []string{"products/a.png", "blog/b.png"}, nil
+		},
+		downloadFunc: func(ctx context.Context, key string) ([]byte, error) {
+			return src.Bytes(), nil
+		},
+		uploadFunc: func(ctx context.Context, key string, data []byte, contentType string) error {
+			uploads[key] = data
+			return nil
+		},
+	}
+
+	job := NewJob(cfg, r2Mock, processor.NewProcessor(*cfg), filepath.Join(tempDir, "state.json"))
+	job.ProcessImages()
+
+	corner := func(key string) uint32 {
+		img, _, err := image.Decode(bytes.NewReader(uploads[key]))
+		if err != nil {
+			t.Fatalf("%s: failed to decode upload: %v", key, err)
+		}
+		r, _, _, _ := img.At(28, 28).RGBA()
+		return r >> 8
+	}
+	if r := corner("products/a.webp"); r > 64 {
+		t.Errorf("expected the watermark on products/a.webp, got red %d", r)
+	}
+	if r := corner("blog/b.webp"); r < 192 {
+		t.Errorf("expected no watermark on blog/b.webp, got red %d", r)
+	}
+}
+
+func TestProcessImages_Placeholders(t *testing.T) {
+	var src bytes.Buffer
+	png.Encode(&src, image.NewNRGBA(image.Rect(0, 0, 32, 32)))
+
+	cfg := &config.Config{
+		Conversion: config.ConversionConfig{
+			Formats:      []string{"png"},
+			Quality:      80,
+			Placeholders: config.PlaceholderConfig{BlurHash: true, ThumbHash: true},
+		},
+	}
+	r2Mock := &mockStorageClient{
+		listFunc: func(ctx context.Context, since time.Time) ([]string, error) {
+			return []string{"a.png"}, nil
+		},
+		downloadFunc: func(ctx context.Context, key string) ([]byte, error) {
+			return src.Bytes(), nil
+		},
+		uploadFunc: func(ctx context.Context, key string, data []byte, contentType string) error {
+			return nil
+		},
+		metadata: map[string]map[string]string{},
+	}
+
+	job := NewJob(cfg, r2Mock, processor.NewProcessor(*cfg), filepath.Join(t.TempDir(), "state.json"))
+	job.ProcessImages()
+
+	metadata := r2Mock.metadata["a.webp"]
+	if metadata["blurhash"] == "" || metadata["thumbhash"] == "" {
+		t.Errorf("expected placeholder metadata on a.webp, got %v", metadata)
+	}
+	if _, ok := metadata["preview"]; ok {
+		t.Errorf("did not expect a preview, got %v", metadata)
+	}
+}
+
+func TestProcessImages_VariantSet(t *testing.T) {
+	tempDir := t.TempDir()
+	var src bytes.Buffer
+	png.Encode(&src, image.NewNRGBA(image.Rect(0, 0, 64, 32)))
+
+	cfg := &config.Config{
+		R2:         config.R2Config{Bucket: "bucket"},
+		Conversion: config.ConversionConfig{Formats: []string{"png"}, Quality: 80},
+		Cron: config.CronConfig{
+			Enabled: true,
+			Rules:   []config.CronRule{{Prefix: "gallery/", VariantSet: "web"}},
+		},
+		Responsive: config.ResponsiveConfig{
+			Sets: map[string]config.VariantSetConfig{"web": {Widths: []int{16, 32}}},
+		},
+	}
+	uploads := make(map[string]bool)
+	r2Mock := &mockStorageClient{
+		listFunc: func(ctx context.Context, since time.Time) ([]string, error) {
+			// Variants from an earlier run are not converted again
+			return []string{"gallery/a.png", "gallery/a.w16.png", "other/b.png"}, nil
+		},
+		downloadFunc: func(ctx context.Context, key string) ([]byte, error) {
+			return src.Bytes(), nil
+		},
+		uploadFunc: func(ctx context.Context, key string, data []byte, contentType string) error {
+			uploads[key] = true
+			return nil
+		},
+	}
+
+	job := NewJob(cfg, r2Mock, processor.NewProcessor(*cfg), filepath.Join(tempDir, "state.json"))
+	job.ProcessImages()
+
+	for _, key := range []string{"gallery/a.w16.webp", "gallery/a.w32.webp", "gallery/a.variants.json", "other/b.webp"} {
+		if !uploads[key] {
+			t.Errorf("expected %s to be uploaded", key)
+		}
+	}
+	if uploads["gallery/a.webp"] || uploads["gallery/a.w16.w16.webp"] {
+		t.Errorf("unexpected uploads: %v", uploads)
+	}
+}
+
+func TestProcessImages_Webhook(t *testing.T) {
+	var src bytes.Buffer
+	png.Encode(&src, image.NewNRGBA(image.Rect(0, 0, 32, 32)))
+
+	var mu sync.Mutex
+	var events []webhook.Event
+	received := make(chan struct{}, 2)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if err := webhook.Verify("secret", r.Header, body, time.Now()); err != nil {
+			t.Errorf("delivery failed verification: %v", err)
+		}
+		var event webhook.Event
+		json.Unmarshal(body, &event)
+		mu.Lock()
+		events = append(events, event)
+		mu.Unlock()
+		received <- struct{}{}
+	}))
+	defer receiver.Close()
+
+	cfg := &config.Config{
+		R2:         config.R2Config{Bucket: "bucket"},
+		Conversion: config.ConversionConfig{Formats: []string{"png"}, Quality: 80},
+		Webhook:    config.WebhookConfig{URL: receiver.URL, Secret: "secret", MaxAttempts: 1, TimeoutSeconds: 5},
+	}
+	r2Mock := &mockStorageClient{
+		listFunc: func(ctx context.Context, since time.Time) ([]string, error) {
+			return []string{"a.png", "broken.png"}, nil
+		},
+		downloadFunc: func(ctx context.Context, key string) ([]byte, error) {
+			if key == "broken.png" {
+				return []byte("not an image"), nil
+			}
+			return src.Bytes(), nil
+		},
+		uploadFunc: func(ctx context.Context, key string, data []byte, contentType string) error {
+			return nil
+		},
+	}
+	dispatcher, err := webhook.NewDispatcher(t.TempDir(), cfg.Webhook, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dispatcher.Start()
+	defer dispatcher.Stop()
+
+	job := NewJob(cfg, r2Mock, processor.NewProcessor(*cfg), filepath.Join(t.TempDir(), "state.json"))
+	job.SetWebhook(dispatcher)
+	job.ProcessImages()
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-received:
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for webhooks")
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	byStatus := map[string]webhook.Item{}
+	for _, event := range events {
+		if event.Type != webhook.EventCronConverted || len(event.Items) != 1 {
+			t.Fatalf("unexpected event: %+v", event)
+		}
+		byStatus[event.Status] = event.Items[0]
+	}
+	ok := byStatus["succeeded"]
+	if ok.Source != "r2://bucket/a.png" || ok.Destination != "r2://bucket/a.webp" || ok.OriginalSize != src.Len() || ok.ConvertedSize == 0 {
+		t.Errorf("unexpected success item: %+v", ok)
+	}
+	if failed := byStatus["failed"]; failed.Source != "r2://bucket/broken.png" || failed.Error == "" {
+		t.Errorf("unexpected failure item: %+v", failed)
+	}
+}
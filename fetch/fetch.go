@@ -0,0 +1,283 @@
+// Package fetch downloads source images from external http(s) URLs while
+// guarding against server-side request forgery. Hosts are checked against the
+// configured allow/deny lists before each request and redirect, and resolved
+// addresses are checked at dial time so DNS rebinding cannot reach internal
+// networks.
+package fetch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"image-converting-server/config"
+)
+
+// Defaults used when the config leaves a value unset
+const (
+	defaultTimeout      = 10 * time.Second
+	defaultMaxRedirects = 3
+)
+
+// Fetch errors
+var (
+	ErrBlocked           = errors.New("destination is not allowed")
+	ErrNotImage          = errors.New("response is not an image")
+	ErrTooLarge          = errors.New("response body too large")
+	ErrTooManyRedirects  = errors.New("too many redirects")
+	ErrUnsupportedScheme = errors.New("only http and https URLs are supported")
+)
+
+// reservedNetworks are blocked in addition to loopback, private, link-local,
+// multicast and unspecified addresses
+var reservedNetworks = mustParseCIDRs(
+	"0.0.0.0/8",     // "this" network
+	"100.64.0.0/10", // carrier-grade NAT
+	"192.0.0.0/24",  // IETF protocol assignments
+	"198.18.0.0/15", // benchmarking
+	"64:ff9b::/96",  // NAT64, can embed private IPv4 addresses
+)
+
+// Fetcher downloads images from external URLs
+type Fetcher struct {
+	client       *http.Client
+	allowedHosts []string
+	deniedHosts  []string
+	allowedNets  []*net.IPNet
+	deniedNets   []*net.IPNet
+	maxBytes     int64
+}
+
+// New creates a Fetcher from the fetch config.
+// Responses larger than maxBytes fail with ErrTooLarge; 0 disables the limit.
+func New(cfg config.FetchConfig, maxBytes int64) (*Fetcher, error) {
+	allowedNets, err := parseCIDRs(cfg.AllowedCIDRs)
+	if err != nil {
+		return nil, err
+	}
+	deniedNets, err := parseCIDRs(cfg.DeniedCIDRs)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &Fetcher{
+		allowedHosts: normalizeHosts(cfg.AllowedHosts),
+		deniedHosts:  normalizeHosts(cfg.DeniedHosts),
+		allowedNets:  allowedNets,
+		deniedNets:   deniedNets,
+		maxBytes:     maxBytes,
+	}
+
+	timeout := time.Duration(cfg.TimeoutSeconds) * time.Second
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	maxRedirects := cfg.MaxRedirects
+	if maxRedirects <= 0 {
+		maxRedirects = defaultMaxRedirects
+	}
+
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: f.checkDial,
+	}
+	f.client = &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			// Never use an environment proxy: the dial check must see the real destination
+			Proxy:                 nil,
+			DialContext:           dialer.DialContext,
+			TLSHandshakeTimeout:   timeout,
+			ResponseHeaderTimeout: timeout,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > maxRedirects {
+				return ErrTooManyRedirects
+			}
+			return f.checkURL(req.URL)
+		},
+	}
+	return f, nil
+}
+
+// NewForCallbacks creates a Fetcher for callback URLs given by API clients. The
+// CIDR rules and denied hosts of cfg apply, but not its allowed hosts, which list
+// image sources; timeoutSeconds replaces the fetch timeout.
+func NewForCallbacks(cfg config.FetchConfig, timeoutSeconds int) (*Fetcher, error) {
+	cfg.AllowedHosts = nil
+	cfg.TimeoutSeconds = timeoutSeconds
+	return New(cfg, 0)
+}
+
+// Fetch downloads rawURL and returns the body.
+// The response must have status 200 and an image/* Content-Type.
+func (f *Fetcher) Fetch(ctx context.Context, rawURL string) ([]byte, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL: %w", err)
+	}
+	if err := f.checkURL(u); err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL: %w", err)
+	}
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("bad status: %s", resp.Status)
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if !strings.HasPrefix(mediaType, "image/") {
+		return nil, fmt.Errorf("%w: Content-Type %q", ErrNotImage, resp.Header.Get("Content-Type"))
+	}
+
+	if f.maxBytes > 0 && resp.ContentLength > f.maxBytes {
+		return nil, fmt.Errorf("%w: %d bytes", ErrTooLarge, resp.ContentLength)
+	}
+	body := io.Reader(resp.Body)
+	if f.maxBytes > 0 {
+		// Read one extra byte to detect bodies longer than the limit
+		body = io.LimitReader(resp.Body, f.maxBytes+1)
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+	if f.maxBytes > 0 && int64(len(data)) > f.maxBytes {
+		return nil, fmt.Errorf("%w: more than %d bytes", ErrTooLarge, f.maxBytes)
+	}
+	return data, nil
+}
+
+// Do sends req with the same destination checks as Fetch, for requests other
+// than image downloads such as webhook callbacks. The caller closes the body.
+func (f *Fetcher) Do(req *http.Request) (*http.Response, error) {
+	if err := f.checkURL(req.URL); err != nil {
+		return nil, err
+	}
+	return f.client.Do(req)
+}
+
+// checkURL checks the scheme and host name of a request or redirect target
+func (f *Fetcher) checkURL(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return ErrUnsupportedScheme
+	}
+	host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+	if host == "" {
+		return fmt.Errorf("%w: missing host", ErrBlocked)
+	}
+	if matchHost(f.deniedHosts, host) {
+		return fmt.Errorf("%w: host %s is denied", ErrBlocked, host)
+	}
+	if len(f.allowedHosts) > 0 && !matchHost(f.allowedHosts, host) {
+		return fmt.Errorf("%w: host %s is not in the allowlist", ErrBlocked, host)
+	}
+	return nil
+}
+
+// checkDial runs after DNS resolution for every connection, including redirects
+func (f *Fetcher) checkDial(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrBlocked, err)
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return fmt.Errorf("%w: unresolved address %s", ErrBlocked, host)
+	}
+	if !f.allowIP(ip) {
+		return fmt.Errorf("%w: address %s", ErrBlocked, ip)
+	}
+	return nil
+}
+
+// allowIP applies the CIDR rules. The denylist always wins; an allowlist, if set,
+// is the only way to reach private or reserved addresses.
+func (f *Fetcher) allowIP(ip net.IP) bool {
+	if containsIP(f.deniedNets, ip) {
+		return false
+	}
+	if len(f.allowedNets) > 0 {
+		return containsIP(f.allowedNets, ip)
+	}
+	return !isInternal(ip)
+}
+
+// isInternal reports whether ip is not a public unicast address
+func isInternal(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() ||
+		containsIP(reservedNetworks, ip)
+}
+
+// matchHost reports whether host matches a pattern: an exact name, or
+// "*.example.com" for any subdomain of example.com
+func matchHost(patterns []string, host string) bool {
+	for _, pattern := range patterns {
+		if suffix, ok := strings.CutPrefix(pattern, "*."); ok {
+			if strings.HasSuffix(host, "."+suffix) {
+				return true
+			}
+		} else if host == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+func containsIP(networks []*net.IPNet, ip net.IP) bool {
+	for _, n := range networks {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func normalizeHosts(hosts []string) []string {
+	normalized := make([]string, 0, len(hosts))
+	for _, host := range hosts {
+		if host = strings.ToLower(strings.TrimSpace(host)); host != "" {
+			normalized = append(normalized, strings.TrimSuffix(host, "."))
+		}
+	}
+	return normalized
+}
+
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(strings.TrimSpace(cidr))
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q: %w", cidr, err)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	networks, err := parseCIDRs(cidrs)
+	if err != nil {
+		panic(err)
+	}
+	return networks
+}
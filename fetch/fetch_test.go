@@ -0,0 +1,201 @@
+package fetch
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"image-converting-server/config"
+)
+
+// loopback allows the httptest server, which listens on 127.0.0.1
+var loopback = []string{"127.0.0.0/8", "::1/128"}
+
+func newTestServer(contentType string, body string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		w.Write([]byte(body))
+	}))
+}
+
+func TestFetch(t *testing.T) {
+	server := newTestServer("image/png", "png-bytes")
+	defer server.Close()
+
+	f, err := New(config.FetchConfig{AllowedCIDRs: loopback}, 0)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	data, err := f.Fetch(context.Background(), server.URL+"/a.png")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(data) != "png-bytes" {
+		t.Errorf("unexpected body: %q", data)
+	}
+}
+
+func TestFetch_Blocked(t *testing.T) {
+	server := newTestServer("image/png", "png-bytes")
+	defer server.Close()
+	port := server.URL[strings.LastIndex(server.URL, ":")+1:]
+
+	tests := []struct {
+		name string
+		cfg  config.FetchConfig
+		url  string
+	}{
+		{"Loopback by default", config.FetchConfig{}, server.URL},
+		// localhost is an allowed name, but it resolves to a blocked address at dial time
+		{"Loopback via DNS", config.FetchConfig{}, "http://localhost:" + port},
+		{"Link-local metadata", config.FetchConfig{}, "http://169.254.169.254/latest/meta-data"},
+		{"Private range", config.FetchConfig{}, "http://10.0.0.1/"},
+		{"Denied CIDR wins over allowed", config.FetchConfig{AllowedCIDRs: loopback, DeniedCIDRs: []string{"127.0.0.1/32"}}, server.URL},
+		{"Denied host", config.FetchConfig{AllowedCIDRs: loopback, DeniedHosts: []string{"127.0.0.1"}}, server.URL},
+		{"Host not in allowlist", config.FetchConfig{AllowedCIDRs: loopback, AllowedHosts: []string{"*.example.com"}}, server.URL},
+		{"Unsupported scheme", config.FetchConfig{}, "file:///etc/passwd"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := New(tt.cfg, 0)
+			if err != nil {
+				t.Fatalf("New failed: %v", err)
+			}
+			_, err = f.Fetch(context.Background(), tt.url)
+			if !errors.Is(err, ErrBlocked) && !errors.Is(err, ErrUnsupportedScheme) {
+				t.Errorf("expected the request to be blocked, got %v", err)
+			}
+		})
+	}
+}
+
+func TestFetch_RedirectToBlockedAddress(t *testing.T) {
+	redirector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "http://169.254.169.254/latest/meta-data", http.StatusFound)
+	}))
+	defer redirector.Close()
+
+	f, _ := New(config.FetchConfig{AllowedCIDRs: loopback}, 0)
+	if _, err := f.Fetch(context.Background(), redirector.URL); !errors.Is(err, ErrBlocked) {
+		t.Errorf("expected redirect to be blocked, got %v", err)
+	}
+}
+
+func TestFetch_TooManyRedirects(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, server.URL+"/loop", http.StatusFound)
+	}))
+	defer server.Close()
+
+	f, _ := New(config.FetchConfig{AllowedCIDRs: loopback, MaxRedirects: 2}, 0)
+	if _, err := f.Fetch(context.Background(), server.URL); !errors.Is(err, ErrTooManyRedirects) {
+		t.Errorf("expected ErrTooManyRedirects, got %v", err)
+	}
+}
+
+func TestFetch_NotImage(t *testing.T) {
+	server := newTestServer("text/html; charset=utf-8", "<html></html>")
+	defer server.Close()
+
+	f, _ := New(config.FetchConfig{AllowedCIDRs: loopback}, 0)
+	if _, err := f.Fetch(context.Background(), server.URL); !errors.Is(err, ErrNotImage) {
+		t.Errorf("expected ErrNotImage, got %v", err)
+	}
+}
+
+func TestFetch_TooLarge(t *testing.T) {
+	server := newTestServer("image/png", strings.Repeat("x", 100))
+	defer server.Close()
+
+	f, _ := New(config.FetchConfig{AllowedCIDRs: loopback}, 99)
+	if _, err := f.Fetch(context.Background(), server.URL); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("expected ErrTooLarge, got %v", err)
+	}
+}
+
+func TestFetch_Timeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer server.Close()
+
+	f, _ := New(config.FetchConfig{AllowedCIDRs: loopback}, 0)
+	f.client.Timeout = 20 * time.Millisecond
+	_, err := f.Fetch(context.Background(), server.URL)
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Errorf("expected a timeout error, got %v", err)
+	}
+}
+
+func TestDo(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	req, _ := http.NewRequest(http.MethodPost, server.URL+"/hook", strings.NewReader("{}"))
+	f, _ := New(config.FetchConfig{AllowedCIDRs: loopback}, 0)
+	resp, err := f.Do(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("expected 204, got %d", resp.StatusCode)
+	}
+
+	req, _ = http.NewRequest(http.MethodPost, server.URL+"/hook", strings.NewReader("{}"))
+	f, _ = New(config.FetchConfig{}, 0)
+	if _, err := f.Do(req); !errors.Is(err, ErrBlocked) {
+		t.Errorf("expected ErrBlocked for a loopback callback, got %v", err)
+	}
+}
+
+func TestNewForCallbacks(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	// The image source allowlist does not apply to callbacks, and the timeout is the caller's
+	cfg := config.FetchConfig{AllowedCIDRs: loopback, AllowedHosts: []string{"cdn.example.com"}, TimeoutSeconds: 30}
+	f, err := NewForCallbacks(cfg, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.client.Timeout != 2*time.Second {
+		t.Errorf("expected a 2s timeout, got %v", f.client.Timeout)
+	}
+	req, _ := http.NewRequest(http.MethodPost, server.URL+"/hook", strings.NewReader("{}"))
+	resp, err := f.Do(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp.Body.Close()
+
+	// The deny rules and the internal address check still do
+	for _, cfg := range []config.FetchConfig{
+		{AllowedCIDRs: loopback, DeniedHosts: []string{"127.0.0.1"}},
+		{AllowedCIDRs: loopback, DeniedCIDRs: []string{"127.0.0.1/32"}},
+		{AllowedHosts: []string{"127.0.0.1"}},
+	} {
+		f, _ := NewForCallbacks(cfg, 2)
+		req, _ := http.NewRequest(http.MethodPost, server.URL+"/hook", strings.NewReader("{}"))
+		if _, err := f.Do(req); !errors.Is(err, ErrBlocked) {
+			t.Errorf("%+v: expected ErrBlocked, got %v", cfg, err)
+		}
+	}
+}
+
+func TestNew_InvalidCIDR(t *testing.T) {
+	if _, err := New(config.FetchConfig{AllowedCIDRs: []string{"not-a-cidr"}}, 0); err == nil {
+		t.Error("expected an error for an invalid CIDR")
+	}
+}
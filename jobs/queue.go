@@ -0,0 +1,374 @@
+package jobs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Status is the lifecycle state of a job
+type Status string
+
+const (
+	StatusQueued    Status = "queued"
+	StatusRunning   Status = "running"
+	StatusSucceeded Status = "succeeded"
+	StatusFailed    Status = "failed"
+	StatusCanceled  Status = "canceled"
+)
+
+// Finished reports whether the job will not run again
+func (s Status) Finished() bool {
+	return s == StatusSucceeded || s == StatusFailed || s == StatusCanceled
+}
+
+var (
+	ErrNotFound  = errors.New("job not found")
+	ErrQueueFull = errors.New("job queue is full")
+	ErrFinished  = errors.New("job already finished")
+)
+
+// Progress counts the finished units of work of a job
+type Progress struct {
+	Done  int `json:"done"`
+	Total int `json:"total"`
+}
+
+// Job is the persisted record of one job. Request and Result are opaque to the
+// queue; the Runner defines them.
+type Job struct {
+	ID         string          `json:"id"`
+	Status     Status          `json:"status"`
+	Progress   Progress        `json:"progress"`
+	Request    json.RawMessage `json:"request"`
+	Result     json.RawMessage `json:"result,omitempty"`
+	Error      string          `json:"error,omitempty"`
+	CreatedAt  time.Time       `json:"created_at"`
+	StartedAt  *time.Time      `json:"started_at,omitempty"`
+	FinishedAt *time.Time      `json:"finished_at,omitempty"`
+}
+
+// Runner executes a job request. It reports progress as units of work finish
+// and should stop early when ctx is done; a result returned alongside an error
+// is kept as the partial result.
+type Runner func(ctx context.Context, request json.RawMessage, progress func(done int)) (json.RawMessage, error)
+
+// Queue runs jobs on a pool of workers and keeps one JSON record per job in a
+// directory, so that queued jobs survive a restart. Jobs that were running when
+// the process stopped are queued again.
+type Queue struct {
+	dir       string
+	runner    Runner
+	workers   int
+	maxQueued int
+	retention time.Duration
+
+	mu       sync.Mutex
+	cond     *sync.Cond
+	jobs     map[string]*Job
+	pending  []string
+	cancels  map[string]context.CancelFunc
+	canceled map[string]bool // Running jobs canceled by Cancel rather than Stop
+	stopped  bool
+	wg       sync.WaitGroup
+	onFinish func(Job)
+}
+
+// NewQueue creates a queue persisting to dir and loads the jobs recorded there.
+// Finished jobs are dropped retention after they finish.
+func NewQueue(dir string, workers, maxQueued int, retention time.Duration, runner Runner) (*Queue, error) {
+	q := &Queue{
+		dir:       dir,
+		runner:    runner,
+		workers:   max(workers, 1),
+		maxQueued: maxQueued,
+		retention: retention,
+		jobs:      make(map[string]*Job),
+		cancels:   make(map[string]context.CancelFunc),
+		canceled:  make(map[string]bool),
+	}
+	q.cond = sync.NewCond(&q.mu)
+	if err := q.load(); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+// load reads the job records of the directory and queues the unfinished ones
+// in submission order
+func (q *Queue) load() error {
+	if err := os.MkdirAll(q.dir, 0755); err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(q.dir)
+	if err != nil {
+		return err
+	}
+	var unfinished []*Job
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(q.dir, entry.Name()))
+		if err != nil {
+			return err
+		}
+		var job Job
+		if err := json.Unmarshal(data, &job); err != nil || !IsValidID(job.ID) {
+			log.Printf("[WARN] Skipped unreadable job record %s: %v", entry.Name(), err)
+			continue
+		}
+		q.jobs[job.ID] = &job
+		if !job.Status.Finished() {
+			unfinished = append(unfinished, &job)
+		}
+	}
+
+	sort.SliceStable(unfinished, func(i, j int) bool { return unfinished[i].CreatedAt.Before(unfinished[j].CreatedAt) })
+	for _, job := range unfinished {
+		if job.Status == StatusRunning {
+			job.Status, job.StartedAt, job.Progress.Done = StatusQueued, nil, 0
+			if err := q.save(job); err != nil {
+				return err
+			}
+		}
+		q.pending = append(q.pending, job.ID)
+	}
+	if len(q.pending) > 0 {
+		log.Printf("[INFO] Restored %d queued jobs", len(q.pending))
+	}
+	q.prune()
+	return nil
+}
+
+// OnFinish sets a function called with every job that finishes. It runs with
+// the queue locked, so it must not call the queue. Set it before Start.
+func (q *Queue) OnFinish(fn func(Job)) {
+	q.onFinish = fn
+}
+
+// Start starts the workers
+func (q *Queue) Start() {
+	for i := 0; i < q.workers; i++ {
+		q.wg.Add(1)
+		go q.work()
+	}
+	log.Printf("[INFO] Job queue started with %d workers", q.workers)
+}
+
+// Stop interrupts running jobs, which are queued again on the next start, and
+// waits for the workers to exit
+func (q *Queue) Stop() {
+	q.mu.Lock()
+	q.stopped = true
+	for _, cancel := range q.cancels {
+		cancel()
+	}
+	q.cond.Broadcast()
+	q.mu.Unlock()
+	q.wg.Wait()
+}
+
+// Submit queues a request with total units of work
+func (q *Queue) Submit(request json.RawMessage, total int) (Job, error) {
+	id, err := newID()
+	if err != nil {
+		return Job{}, err
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.prune()
+	if q.maxQueued > 0 && len(q.pending) >= q.maxQueued {
+		return Job{}, ErrQueueFull
+	}
+
+	job := &Job{
+		ID:        id,
+		Status:    StatusQueued,
+		Progress:  Progress{Total: total},
+		Request:   request,
+		CreatedAt: time.Now().UTC(),
+	}
+	if err := q.save(job); err != nil {
+		return Job{}, err
+	}
+	q.jobs[id] = job
+	q.pending = append(q.pending, id)
+	q.cond.Signal()
+	return *job, nil
+}
+
+// Get returns a copy of the job with the given ID
+func (q *Queue) Get(id string) (Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	job, ok := q.jobs[id]
+	if !ok {
+		return Job{}, ErrNotFound
+	}
+	return *job, nil
+}
+
+// Cancel cancels a queued or running job. A running job keeps the partial
+// result its runner returns.
+func (q *Queue) Cancel(id string) (Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	job, ok := q.jobs[id]
+	if !ok {
+		return Job{}, ErrNotFound
+	}
+	switch job.Status {
+	case StatusQueued:
+		for i, pending := range q.pending {
+			if pending == id {
+				q.pending = append(q.pending[:i], q.pending[i+1:]...)
+				break
+			}
+		}
+		q.finish(job, StatusCanceled, nil, "")
+	case StatusRunning:
+		// The worker records the outcome once the runner returns
+		q.canceled[id] = true
+		q.cancels[id]()
+	default:
+		return *job, ErrFinished
+	}
+	return *job, nil
+}
+
+func (q *Queue) work() {
+	defer q.wg.Done()
+	for {
+		q.mu.Lock()
+		for len(q.pending) == 0 && !q.stopped {
+			q.cond.Wait()
+		}
+		if q.stopped {
+			q.mu.Unlock()
+			return
+		}
+		id := q.pending[0]
+		q.pending = q.pending[1:]
+		job := q.jobs[id]
+		now := time.Now().UTC()
+		job.Status, job.StartedAt = StatusRunning, &now
+		ctx, cancel := context.WithCancel(context.Background())
+		q.cancels[id] = cancel
+		if err := q.save(job); err != nil {
+			log.Printf("[ERROR] Failed to save job %s: %v", id, err)
+		}
+		request := job.Request
+		q.mu.Unlock()
+
+		result, err := q.run(ctx, id, request)
+		interrupted := ctx.Err() != nil
+		cancel()
+
+		q.mu.Lock()
+		delete(q.cancels, id)
+		switch {
+		case q.canceled[id]:
+			delete(q.canceled, id)
+			q.finish(job, StatusCanceled, result, "")
+		case q.stopped && interrupted:
+			// Interrupted by Stop; run it again after the restart
+			job.Status, job.StartedAt, job.Progress.Done = StatusQueued, nil, 0
+			if err := q.save(job); err != nil {
+				log.Printf("[ERROR] Failed to save job %s: %v", id, err)
+			}
+		case err != nil:
+			log.Printf("[ERROR] Job %s failed: %v", id, err)
+			q.finish(job, StatusFailed, result, err.Error())
+		default:
+			q.finish(job, StatusSucceeded, result, "")
+		}
+		q.mu.Unlock()
+	}
+}
+
+// run calls the runner, turning a panic into a job failure
+func (q *Queue) run(ctx context.Context, id string, request json.RawMessage) (result json.RawMessage, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("job panicked: %v", r)
+		}
+	}()
+	return q.runner(ctx, request, func(done int) {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		q.jobs[id].Progress.Done = done
+	})
+}
+
+// finish records the outcome of a job; the caller holds q.mu
+func (q *Queue) finish(job *Job, status Status, result json.RawMessage, errMsg string) {
+	now := time.Now().UTC()
+	job.Status, job.Result, job.Error, job.FinishedAt = status, result, errMsg, &now
+	if err := q.save(job); err != nil {
+		log.Printf("[ERROR] Failed to save job %s: %v", job.ID, err)
+	}
+	if q.onFinish != nil {
+		q.onFinish(*job)
+	}
+}
+
+// prune drops finished jobs past the retention; the caller holds q.mu or owns q
+func (q *Queue) prune() {
+	if q.retention <= 0 {
+		return
+	}
+	cutoff := time.Now().Add(-q.retention)
+	for id, job := range q.jobs {
+		if job.Status.Finished() && job.FinishedAt != nil && job.FinishedAt.Before(cutoff) {
+			delete(q.jobs, id)
+			if err := os.Remove(q.path(id)); err != nil && !os.IsNotExist(err) {
+				log.Printf("[WARN] Failed to remove job record %s: %v", id, err)
+			}
+		}
+	}
+}
+
+// save writes the job record atomically, like state.SaveState
+func (q *Queue) save(job *Job) error {
+	data, err := json.MarshalIndent(job, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmpFile := q.path(job.ID) + ".tmp"
+	if err := os.WriteFile(tmpFile, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpFile, q.path(job.ID))
+}
+
+func (q *Queue) path(id string) string {
+	return filepath.Join(q.dir, id+".json")
+}
+
+func newID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate job id: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// IsValidID reports whether id has the form of generated job IDs
+func IsValidID(id string) bool {
+	if len(id) != 32 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil && strings.ToLower(id) == id
+}
@@ -0,0 +1,220 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// waitFor polls the job until cond holds or the test times out
+func waitFor(t *testing.T, q *Queue, id string, cond func(Job) bool) Job {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		job, err := q.Get(id)
+		if err != nil {
+			t.Fatalf("Get(%s) failed: %v", id, err)
+		}
+		if cond(job) {
+			return job
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for job %s, last state %+v", id, job)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func finished(job Job) bool { return job.Status.Finished() }
+
+func TestQueue_RunsJobs(t *testing.T) {
+	runner := func(ctx context.Context, request json.RawMessage, progress func(int)) (json.RawMessage, error) {
+		var n int
+		json.Unmarshal(request, &n)
+		if n < 0 {
+			return nil, errors.New("negative")
+		}
+		for i := 1; i <= n; i++ {
+			progress(i)
+		}
+		return json.Marshal(n * 2)
+	}
+	q, err := NewQueue(t.TempDir(), 2, 10, time.Hour, runner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.Start()
+	defer q.Stop()
+
+	ok, err := q.Submit(json.RawMessage("3"), 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok.Status != StatusQueued || ok.Progress.Total != 3 || !IsValidID(ok.ID) {
+		t.Errorf("unexpected submitted job: %+v", ok)
+	}
+	bad, _ := q.Submit(json.RawMessage("-1"), 1)
+
+	job := waitFor(t, q, ok.ID, finished)
+	if job.Status != StatusSucceeded || string(job.Result) != "6" || job.Progress.Done != 3 {
+		t.Errorf("expected a succeeded job with result 6, got %+v", job)
+	}
+	if job.StartedAt == nil || job.FinishedAt == nil {
+		t.Errorf("expected start and finish times, got %+v", job)
+	}
+	job = waitFor(t, q, bad.ID, finished)
+	if job.Status != StatusFailed || job.Error != "negative" {
+		t.Errorf("expected a failed job, got %+v", job)
+	}
+
+	if _, err := q.Get("0123456789abcdef0123456789abcdef"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+	if _, err := q.Cancel(ok.ID); !errors.Is(err, ErrFinished) {
+		t.Errorf("expected ErrFinished for a finished job, got %v", err)
+	}
+}
+
+func TestQueue_Cancel(t *testing.T) {
+	started := make(chan struct{})
+	runner := func(ctx context.Context, request json.RawMessage, progress func(int)) (json.RawMessage, error) {
+		started <- struct{}{}
+		<-ctx.Done()
+		return json.RawMessage(`"partial"`), nil
+	}
+	q, err := NewQueue(t.TempDir(), 1, 10, time.Hour, runner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	finishedJobs := make(chan Job, 2)
+	q.OnFinish(func(job Job) { finishedJobs <- job })
+	q.Start()
+	defer q.Stop()
+
+	running, _ := q.Submit(json.RawMessage("1"), 1)
+	queued, _ := q.Submit(json.RawMessage("2"), 1)
+	<-started
+
+	job, err := q.Cancel(queued.ID)
+	if err != nil || job.Status != StatusCanceled {
+		t.Errorf("expected the queued job to be canceled at once, got %+v, %v", job, err)
+	}
+	if _, err := q.Cancel(running.ID); err != nil {
+		t.Fatal(err)
+	}
+	job = waitFor(t, q, running.ID, finished)
+	if job.Status != StatusCanceled || string(job.Result) != `"partial"` {
+		t.Errorf("expected a canceled job with the partial result, got %+v", job)
+	}
+	for _, id := range []string{queued.ID, running.ID} {
+		if job := <-finishedJobs; job.ID != id || job.Status != StatusCanceled {
+			t.Errorf("expected OnFinish with canceled job %s, got %+v", id, job)
+		}
+	}
+}
+
+func TestQueue_MaxQueued(t *testing.T) {
+	q, err := NewQueue(t.TempDir(), 1, 2, time.Hour, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Not started, so every job stays queued
+	for i := 0; i < 2; i++ {
+		if _, err := q.Submit(json.RawMessage("1"), 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := q.Submit(json.RawMessage("1"), 1); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("expected ErrQueueFull, got %v", err)
+	}
+}
+
+func TestQueue_Persistence(t *testing.T) {
+	dir := t.TempDir()
+	started := make(chan struct{}, 1)
+	blocking := func(ctx context.Context, request json.RawMessage, progress func(int)) (json.RawMessage, error) {
+		started <- struct{}{}
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	q, err := NewQueue(dir, 1, 10, time.Hour, blocking)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.Start()
+	first, _ := q.Submit(json.RawMessage(`"first"`), 1)
+	second, _ := q.Submit(json.RawMessage(`"second"`), 1)
+	<-started
+	q.Stop()
+
+	// A restart queues the interrupted job again, ahead of the waiting one
+	var order []string
+	done := make(chan struct{}, 2)
+	recording := func(ctx context.Context, request json.RawMessage, progress func(int)) (json.RawMessage, error) {
+		order = append(order, string(request))
+		done <- struct{}{}
+		return request, nil
+	}
+	q, err = NewQueue(dir, 1, 10, time.Hour, recording)
+	if err != nil {
+		t.Fatal(err)
+	}
+	job, _ := q.Get(first.ID)
+	if job.Status != StatusQueued || job.StartedAt != nil {
+		t.Errorf("expected the interrupted job to be queued again, got %+v", job)
+	}
+	q.Start()
+	defer q.Stop()
+	<-done
+	<-done
+	waitFor(t, q, second.ID, finished)
+	if len(order) != 2 || order[0] != `"first"` || order[1] != `"second"` {
+		t.Errorf("expected the jobs in submission order, got %v", order)
+	}
+}
+
+func TestQueue_Retention(t *testing.T) {
+	dir := t.TempDir()
+	old := time.Now().Add(-2 * time.Hour)
+	for id, status := range map[string]Status{
+		"00000000000000000000000000000001": StatusSucceeded,
+		"00000000000000000000000000000002": StatusQueued,
+	} {
+		data, _ := json.Marshal(Job{ID: id, Status: status, CreatedAt: old, FinishedAt: &old})
+		os.WriteFile(filepath.Join(dir, id+".json"), data, 0644)
+	}
+	os.WriteFile(filepath.Join(dir, "garbage.json"), []byte("{"), 0644)
+
+	q, err := NewQueue(dir, 1, 10, time.Hour, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := q.Get("00000000000000000000000000000001"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected the expired job to be dropped, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "00000000000000000000000000000001.json")); !os.IsNotExist(err) {
+		t.Errorf("expected the expired record to be removed, got %v", err)
+	}
+	if _, err := q.Get("00000000000000000000000000000002"); err != nil {
+		t.Errorf("expected the queued job to be kept, got %v", err)
+	}
+}
+
+func TestIsValidID(t *testing.T) {
+	tests := map[string]bool{
+		"0123456789abcdef0123456789abcdef": true,
+		"0123456789ABCDEF0123456789ABCDEF": false,
+		"0123456789abcdef":                 false,
+		"../../../../etc/passwd0000000000": false,
+		"":                                 false,
+	}
+	for id, want := range tests {
+		if got := IsValidID(id); got != want {
+			t.Errorf("IsValidID(%q) = %v, want %v", id, got, want)
+		}
+	}
+}
@@ -7,14 +7,20 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"syscall"
 	"time"
 
 	"image-converting-server/api"
+	"image-converting-server/auth"
 	"image-converting-server/config"
 	"image-converting-server/cron"
+	"image-converting-server/fetch"
+	"image-converting-server/jobs"
 	"image-converting-server/processor"
 	"image-converting-server/r2"
+	"image-converting-server/responsive"
+	"image-converting-server/webhook"
 )
 
 func main() {
@@ -26,7 +32,7 @@ func main() {
 
 	// 2. Initialize R2 client
 	ctx := context.Background()
-	storageClient, err := r2.NewClient(ctx, &cfg.R2)
+	storageClient, err := r2.NewClient(ctx, &cfg.R2, cfg.Conversion.MaxSizeBytes())
 	if err != nil {
 		log.Fatalf("[FATAL] Failed to initialize R2 client: %v", err)
 	}
@@ -39,11 +45,40 @@ func main() {
 	}
 
 	// 3. Initialize Image Processor
+	if err := processor.CheckDecoders(cfg.Conversion.Formats); err != nil {
+		log.Fatalf("[FATAL] Invalid conversion.formats: %v", err)
+	}
+	if err := processor.CheckPresets(cfg.Resize.Presets); err != nil {
+		log.Fatalf("[FATAL] Invalid resize.presets: %v", err)
+	}
 	proc := processor.NewProcessor(*cfg)
+	proc.SetOverlaySource(storageClient)
+	if _, err := proc.Encoder(cfg.Conversion.OutputFormat); err != nil {
+		log.Fatalf("[FATAL] Invalid conversion.output_format: %v", err)
+	}
+	if err := responsive.CheckSets(proc, cfg.Responsive.Sets); err != nil {
+		log.Fatalf("[FATAL] Invalid responsive.sets: %v", err)
+	}
 
 	// 4. Initialize Cron Job
 	statePath := "data/state.json"
+
+	// Webhook deliveries wait in an outbox next to the cron state. Callback URLs
+	// given by API clients go through the address and deny checks of URL sources,
+	// but not the image host allowlist.
+	callbacks, err := fetch.NewForCallbacks(cfg.Fetch, cfg.Webhook.TimeoutSeconds)
+	if err != nil {
+		log.Fatalf("[FATAL] Invalid fetch config: %v", err)
+	}
+	dispatcher, err := webhook.NewDispatcher(filepath.Join(filepath.Dir(statePath), "webhooks"), cfg.Webhook, callbacks)
+	if err != nil {
+		log.Fatalf("[FATAL] Failed to load webhook outbox: %v", err)
+	}
+	dispatcher.Start()
+	defer dispatcher.Stop()
+
 	cronJob := cron.NewJob(cfg, storageClient, proc, statePath)
+	cronJob.SetWebhook(dispatcher)
 	if err := cronJob.Start(); err != nil {
 		log.Fatalf("[FATAL] Failed to start cron job: %v", err)
 	}
@@ -52,16 +87,46 @@ func main() {
 	// 5. Setup HTTP Router
 	handler := api.NewHandler(storageClient, proc, cfg)
 
+	// Job records live next to the cron state
+	jobQueue, err := jobs.NewQueue(filepath.Join(filepath.Dir(statePath), "jobs"), cfg.Jobs.Workers, cfg.Jobs.MaxQueued,
+		time.Duration(cfg.Jobs.RetentionHours)*time.Hour, handler.RunJob)
+	if err != nil {
+		log.Fatalf("[FATAL] Failed to load jobs: %v", err)
+	}
+	jobQueue.OnFinish(handler.NotifyJob)
+	handler.SetJobQueue(jobQueue)
+	handler.SetWebhook(dispatcher)
+	jobQueue.Start()
+	defer jobQueue.Stop()
+
 	mux := http.NewServeMux()
 	mux.HandleFunc("/", handler.HandleIndex)
 	mux.HandleFunc("/health", handler.HandleHealth)
 	mux.HandleFunc("/api/convert", handler.HandleConvert)
+	mux.HandleFunc("/api/convert/batch", handler.HandleBatch)
+	mux.HandleFunc("/api/image", handler.HandleImage)
+	mux.HandleFunc("/api/compare", handler.HandleCompare)
+	mux.HandleFunc("/api/info", handler.HandleInfo)
+	mux.HandleFunc("/api/jobs", handler.HandleJobs)
+	mux.HandleFunc("/api/jobs/", handler.HandleJob)
+	mux.HandleFunc("/img/", handler.HandleTransform)
+
+	// Every API request needs a key with the endpoint's scope unless auth.disabled is set
+	authn, err := auth.New(cfg.Auth)
+	if err != nil {
+		log.Fatalf("[FATAL] Invalid auth config: %v", err)
+	}
+	if authn.Disabled() {
+		log.Println("[WARN] API key authentication is disabled (auth.disabled); the API is open to anyone who can reach it")
+	} else {
+		log.Printf("[INFO] API key authentication enabled with %d keys", len(cfg.Auth.Keys))
+	}
 
 	// 6. Start HTTP Server
 	port := fmt.Sprintf(":%d", cfg.Server.Port)
 	server := &http.Server{
 		Addr:         port,
-		Handler:      mux,
+		Handler:      handler.RequireAuth(authn, mux),
 		ReadTimeout:  time.Duration(cfg.Server.TimeoutSeconds) * time.Second,
 		WriteTimeout: time.Duration(cfg.Server.TimeoutSeconds) * time.Second,
 	}
@@ -0,0 +1,88 @@
+package metrics
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+)
+
+// MaxPSNR is reported for identical images, whose PSNR is infinite
+const MaxPSNR = 100
+
+// heatmapSaturation is the per-channel difference shown at full heatmap intensity
+const heatmapSaturation = 64
+
+// PSNR returns the peak signal-to-noise ratio of b against a in decibels, over the
+// RGB channels composited over white. Higher is better; around 40 dB and above is
+// usually hard to tell apart.
+func PSNR(a, b image.Image) (float64, error) {
+	pa, pb, err := overWhitePair(a, b)
+	if err != nil {
+		return 0, err
+	}
+	var sum float64
+	var n int
+	for i := 0; i < len(pa.Pix); i += 4 {
+		for c := 0; c < 3; c++ {
+			d := float64(pa.Pix[i+c]) - float64(pb.Pix[i+c])
+			sum += d * d
+			n++
+		}
+	}
+	if n == 0 || sum == 0 {
+		return MaxPSNR, nil
+	}
+	return math.Min(MaxPSNR, 10*math.Log10(255*255/(sum/float64(n)))), nil
+}
+
+// Heatmap returns an image of where b differs from a: black where the pixels match,
+// through red to yellow as the largest channel difference grows. Differences of
+// 64 levels or more are shown at full intensity.
+func Heatmap(a, b image.Image) (*image.NRGBA, error) {
+	pa, pb, err := overWhitePair(a, b)
+	if err != nil {
+		return nil, err
+	}
+	out := image.NewNRGBA(pa.Rect)
+	for i := 0; i < len(pa.Pix); i += 4 {
+		var d int
+		for c := 0; c < 3; c++ {
+			d = max(d, absDiff(pa.Pix[i+c], pb.Pix[i+c]))
+		}
+		t := math.Min(1, float64(d)/heatmapSaturation)
+		c := color.NRGBA{A: 255}
+		if t < 0.5 {
+			c.R = uint8(math.Round(510 * t))
+		} else {
+			c.R, c.G = 255, uint8(math.Round(510*(t-0.5)))
+		}
+		out.Pix[i], out.Pix[i+1], out.Pix[i+2], out.Pix[i+3] = c.R, c.G, c.B, c.A
+	}
+	return out, nil
+}
+
+func absDiff(a, b uint8) int {
+	if a > b {
+		return int(a - b)
+	}
+	return int(b - a)
+}
+
+// overWhitePair composites both images over white, checking that their sizes match
+func overWhitePair(a, b image.Image) (*image.RGBA, *image.RGBA, error) {
+	if a.Bounds().Size() != b.Bounds().Size() {
+		return nil, nil, fmt.Errorf("%w: %v and %v", ErrSizeMismatch, a.Bounds().Size(), b.Bounds().Size())
+	}
+	return overWhite(a), overWhite(b), nil
+}
+
+// overWhite returns img composited over white, with its origin at (0, 0)
+func overWhite(img image.Image) *image.RGBA {
+	b := img.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Rect, image.White, image.Point{}, draw.Src)
+	draw.Draw(dst, dst.Rect, img, b.Min, draw.Over)
+	return dst
+}
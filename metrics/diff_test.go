@@ -0,0 +1,62 @@
+package metrics
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+func TestPSNR(t *testing.T) {
+	img := gradient(64, 48)
+	if p, err := PSNR(img, img); err != nil || p != MaxPSNR {
+		t.Errorf("expected MaxPSNR for identical images, got %f (%v)", p, err)
+	}
+
+	high, _ := PSNR(img, jpegRoundTrip(t, img, 95))
+	low, _ := PSNR(img, jpegRoundTrip(t, img, 10))
+	if !(high < MaxPSNR && low < high) {
+		t.Errorf("expected MaxPSNR > q95 (%f) > q10 (%f)", high, low)
+	}
+
+	// A uniform error of 1 level gives 20*log10(255)
+	a := image.NewGray(image.Rect(0, 0, 4, 4))
+	b := image.NewGray(image.Rect(0, 0, 4, 4))
+	for i := range b.Pix {
+		b.Pix[i] = 1
+	}
+	if p, _ := PSNR(a, b); math.Abs(p-20*math.Log10(255)) > 1e-9 {
+		t.Errorf("expected %f, got %f", 20*math.Log10(255), p)
+	}
+
+	if _, err := PSNR(img, gradient(64, 47)); !errors.Is(err, ErrSizeMismatch) {
+		t.Errorf("expected ErrSizeMismatch, got %v", err)
+	}
+}
+
+func TestHeatmap(t *testing.T) {
+	a := image.NewNRGBA(image.Rect(10, 10, 13, 11))
+	b := image.NewNRGBA(image.Rect(0, 0, 3, 1))
+	for x := 0; x < 3; x++ {
+		a.SetNRGBA(10+x, 10, color.NRGBA{100, 100, 100, 255})
+	}
+	b.SetNRGBA(0, 0, color.NRGBA{100, 100, 100, 255})
+	b.SetNRGBA(1, 0, color.NRGBA{116, 100, 100, 255})
+	b.SetNRGBA(2, 0, color.NRGBA{100, 100, 250, 255})
+
+	heat, err := Heatmap(a, b)
+	if err != nil {
+		t.Fatalf("Heatmap failed: %v", err)
+	}
+	want := []color.NRGBA{{0, 0, 0, 255}, {128, 0, 0, 255}, {255, 255, 0, 255}}
+	for x, c := range want {
+		if got := heat.NRGBAAt(x, 0); got != c {
+			t.Errorf("pixel %d: expected %v, got %v", x, c, got)
+		}
+	}
+
+	if _, err := Heatmap(a, image.NewNRGBA(image.Rect(0, 0, 2, 1))); !errors.Is(err, ErrSizeMismatch) {
+		t.Errorf("expected ErrSizeMismatch, got %v", err)
+	}
+}
@@ -0,0 +1,142 @@
+// Package metrics scores how closely an image matches a reference, for choosing
+// encoder settings and comparing outputs.
+package metrics
+
+import (
+	"errors"
+	"fmt"
+	"image"
+)
+
+// ErrSizeMismatch is returned when the compared images differ in size
+var ErrSizeMismatch = errors.New("images differ in size")
+
+// SSIM window size and stride. Windows overlap by half, which is close to the
+// per-pixel score at a fraction of the cost.
+const (
+	ssimWindow = 8
+	ssimStride = 4
+)
+
+// SSIM stabilizing constants for 8-bit samples
+const (
+	ssimC1 = (0.01 * 255) * (0.01 * 255)
+	ssimC2 = (0.03 * 255) * (0.03 * 255)
+)
+
+// Luma is the 8-bit luma plane of an image composited over white, the form the
+// scores are computed on. Building it once lets a reference be scored against
+// many candidates.
+type Luma struct {
+	Pix    []uint8
+	Width  int
+	Height int
+}
+
+// NewLuma extracts the luma plane of img. Transparent pixels are composited over
+// white, as the JPEG encoder does, so that flattening alone does not lower a score.
+func NewLuma(img image.Image) *Luma {
+	b := img.Bounds()
+	l := &Luma{Pix: make([]uint8, b.Dx()*b.Dy()), Width: b.Dx(), Height: b.Dy()}
+
+	switch src := img.(type) {
+	case *image.YCbCr:
+		for y := 0; y < l.Height; y++ {
+			copy(l.Pix[y*l.Width:(y+1)*l.Width], src.Y[src.YOffset(b.Min.X, b.Min.Y+y):])
+		}
+	case *image.NRGBA:
+		for y := 0; y < l.Height; y++ {
+			row := src.Pix[src.PixOffset(b.Min.X, b.Min.Y+y):]
+			for x := 0; x < l.Width; x++ {
+				p := row[x*4 : x*4+4]
+				a := uint32(p[3])
+				r := (uint32(p[0])*a + 255*(255-a)) / 255
+				g := (uint32(p[1])*a + 255*(255-a)) / 255
+				bl := (uint32(p[2])*a + 255*(255-a)) / 255
+				l.Pix[y*l.Width+x] = luma(r, g, bl)
+			}
+		}
+	default:
+		for y := 0; y < l.Height; y++ {
+			for x := 0; x < l.Width; x++ {
+				// RGBA returns premultiplied 16-bit values
+				r, g, bl, a := img.At(b.Min.X+x, b.Min.Y+y).RGBA()
+				r, g, bl = (r+0xffff-a)>>8, (g+0xffff-a)>>8, (bl+0xffff-a)>>8
+				l.Pix[y*l.Width+x] = luma(r, g, bl)
+			}
+		}
+	}
+	return l
+}
+
+// luma returns the BT.601 luma of 8-bit RGB, as used by JPEG and lossy WebP
+func luma(r, g, b uint32) uint8 {
+	return uint8((19595*r + 38470*g + 7471*b + 1<<15) >> 16)
+}
+
+// SSIM returns the mean structural similarity of a and b: 1 for identical images,
+// lower as they diverge
+func SSIM(a, b image.Image) (float64, error) {
+	return NewLuma(a).SSIM(NewLuma(b))
+}
+
+// SSIM returns the mean structural similarity of l and other over overlapping
+// 8x8 windows. Images smaller than a window are scored as a single window.
+func (l *Luma) SSIM(other *Luma) (float64, error) {
+	ssim, _, err := l.ssimTerms(other)
+	return ssim, err
+}
+
+// ssimTerms returns the mean SSIM and the mean of its contrast-structure term alone
+func (l *Luma) ssimTerms(other *Luma) (ssim, cs float64, err error) {
+	if l.Width != other.Width || l.Height != other.Height {
+		return 0, 0, fmt.Errorf("%w: %dx%d and %dx%d", ErrSizeMismatch, l.Width, l.Height, other.Width, other.Height)
+	}
+	if l.Width == 0 || l.Height == 0 {
+		return 1, 1, nil
+	}
+
+	ww, wh := min(ssimWindow, l.Width), min(ssimWindow, l.Height)
+	var windows int
+	for y := 0; y+wh <= l.Height; y += ssimStride {
+		for x := 0; x+ww <= l.Width; x += ssimStride {
+			lum, c := l.windowTerms(other, x, y, ww, wh)
+			ssim += lum * c
+			cs += c
+			windows++
+		}
+	}
+	return ssim / float64(windows), cs / float64(windows), nil
+}
+
+// windowTerms returns the luminance and contrast-structure terms of one window,
+// whose product is the window's SSIM
+func (l *Luma) windowTerms(other *Luma, x0, y0, w, h int) (lum, cs float64) {
+	var sa, sb, saa, sbb, sab float64
+	for y := y0; y < y0+h; y++ {
+		row := y * l.Width
+		for x := x0; x < x0+w; x++ {
+			a, b := float64(l.Pix[row+x]), float64(other.Pix[row+x])
+			sa += a
+			sb += b
+			saa += a * a
+			sbb += b * b
+			sab += a * b
+		}
+	}
+	n := float64(w * h)
+	ma, mb := sa/n, sb/n
+	va, vb := saa/n-ma*ma, sbb/n-mb*mb
+	cov := sab/n - ma*mb
+	return (2*ma*mb + ssimC1) / (ma*ma + mb*mb + ssimC1), (2*cov + ssimC2) / (va + vb + ssimC2)
+}
+
+// DSSIM converts an SSIM score to structural dissimilarity: 0 for identical images
+func DSSIM(ssim float64) float64 {
+	return (1 - ssim) / 2
+}
+
+// SSIMFromDSSIM converts a DSSIM score back to SSIM
+func SSIMFromDSSIM(dssim float64) float64 {
+	return 1 - 2*dssim
+}
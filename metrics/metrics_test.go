@@ -0,0 +1,97 @@
+package metrics
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"math"
+	"testing"
+)
+
+// gradient returns a w x h image with a diagonal gradient and some texture
+func gradient(w, h int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			v := uint8((x*255/w + y*255/h) / 2)
+			if (x/4+y/4)%2 == 0 {
+				v /= 2
+			}
+			img.SetNRGBA(x, y, color.NRGBA{v, 255 - v, v / 2, 255})
+		}
+	}
+	return img
+}
+
+func jpegRoundTrip(t *testing.T, img image.Image, quality int) image.Image {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+		t.Fatal(err)
+	}
+	out, err := jpeg.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func TestSSIM(t *testing.T) {
+	img := gradient(64, 48)
+
+	same, err := SSIM(img, img)
+	if err != nil {
+		t.Fatalf("SSIM failed: %v", err)
+	}
+	if math.Abs(same-1) > 1e-9 {
+		t.Errorf("expected 1 for identical images, got %f", same)
+	}
+
+	// Lower JPEG quality scores lower
+	high, _ := SSIM(img, jpegRoundTrip(t, img, 95))
+	low, _ := SSIM(img, jpegRoundTrip(t, img, 10))
+	if !(high < 1 && low < high) {
+		t.Errorf("expected 1 > q95 (%f) > q10 (%f)", high, low)
+	}
+
+	if _, err := SSIM(img, gradient(32, 48)); !errors.Is(err, ErrSizeMismatch) {
+		t.Errorf("expected ErrSizeMismatch, got %v", err)
+	}
+}
+
+func TestSSIM_SmallImage(t *testing.T) {
+	img := gradient(3, 2)
+	if s, err := SSIM(img, img); err != nil || math.Abs(s-1) > 1e-9 {
+		t.Errorf("expected 1 for a single-window image, got %f (%v)", s, err)
+	}
+}
+
+func TestNewLuma_Alpha(t *testing.T) {
+	// A transparent pixel counts as white, whatever its color
+	img := image.NewNRGBA(image.Rect(0, 0, 2, 1))
+	img.SetNRGBA(0, 0, color.NRGBA{0, 0, 0, 0})
+	img.SetNRGBA(1, 0, color.NRGBA{0, 0, 0, 255})
+	if l := NewLuma(img); l.Pix[0] != 255 || l.Pix[1] != 0 {
+		t.Errorf("expected [255 0], got %v", l.Pix)
+	}
+
+	// The generic path agrees with the NRGBA fast path
+	rgba := image.NewRGBA(img.Bounds())
+	for x := 0; x < 2; x++ {
+		rgba.Set(x, 0, img.At(x, 0))
+	}
+	if l := NewLuma(rgba); l.Pix[0] != 255 || l.Pix[1] != 0 {
+		t.Errorf("RGBA: expected [255 0], got %v", l.Pix)
+	}
+}
+
+func TestDSSIM(t *testing.T) {
+	if DSSIM(1) != 0 || DSSIM(0.9) < 0.0499 || DSSIM(0.9) > 0.0501 {
+		t.Errorf("unexpected DSSIM values: %f %f", DSSIM(1), DSSIM(0.9))
+	}
+	if got := SSIMFromDSSIM(DSSIM(0.97)); math.Abs(got-0.97) > 1e-12 {
+		t.Errorf("round trip: got %f", got)
+	}
+}
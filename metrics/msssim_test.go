@@ -0,0 +1,51 @@
+package metrics
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestMSSSIM(t *testing.T) {
+	img := gradient(256, 192)
+
+	same, err := MSSSIM(img, img)
+	if err != nil {
+		t.Fatalf("MSSSIM failed: %v", err)
+	}
+	if math.Abs(same-1) > 1e-9 {
+		t.Errorf("expected 1 for identical images, got %f", same)
+	}
+
+	high, _ := MSSSIM(img, jpegRoundTrip(t, img, 95))
+	low, _ := MSSSIM(img, jpegRoundTrip(t, img, 10))
+	if !(high < 1 && low < high && low > 0) {
+		t.Errorf("expected 1 > q95 (%f) > q10 (%f) > 0", high, low)
+	}
+
+	if _, err := MSSSIM(img, gradient(128, 192)); !errors.Is(err, ErrSizeMismatch) {
+		t.Errorf("expected ErrSizeMismatch, got %v", err)
+	}
+}
+
+func TestMSSSIM_SmallImage(t *testing.T) {
+	// Too small for a second scale, so only the SSIM of the full image counts
+	img := gradient(12, 12)
+	distorted := jpegRoundTrip(t, img, 20)
+	ms, err := MSSSIM(img, distorted)
+	if err != nil {
+		t.Fatalf("MSSSIM failed: %v", err)
+	}
+	ssim, _ := SSIM(img, distorted)
+	if math.Abs(ms-ssim) > 1e-9 {
+		t.Errorf("expected MS-SSIM %f to equal SSIM %f", ms, ssim)
+	}
+}
+
+func TestLuma_Half(t *testing.T) {
+	l := &Luma{Pix: []uint8{0, 4, 8, 9, 10, 20, 30, 40, 1, 1, 1, 1}, Width: 4, Height: 3}
+	h := l.half()
+	if h.Width != 2 || h.Height != 1 || h.Pix[0] != 9 || h.Pix[1] != 22 {
+		t.Errorf("unexpected half: %+v", h)
+	}
+}
@@ -0,0 +1,285 @@
+package processor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/gif"
+	"time"
+)
+
+// AnimationFrame is one full-canvas frame of an animation
+type AnimationFrame struct {
+	Image    image.Image
+	Duration time.Duration
+}
+
+// animationEncoder is implemented by encoders that can write animated images.
+// loopCount uses WebP semantics: 0 loops forever, n plays the animation n times.
+type animationEncoder interface {
+	EncodeAnimation(frames []AnimationFrame, loopCount int, opts EncodeOptions) ([]byte, error)
+}
+
+// minGIFDelay matches browsers, which play GIF frame delays of 0 or 10ms at 100ms
+const minGIFDelay = 100 * time.Millisecond
+
+// processAnimation converts a multi-frame GIF into an animated image.
+// Frames are composited onto the full canvas with the GIF disposal methods applied,
+// so every output frame is complete and can be transformed independently.
+// A smart crop is chosen on the first frame and reused for the others.
+func (p *Processor) processAnimation(data []byte, options ProcessOptions, encoder animationEncoder) (*Result, error) {
+	anim, err := gif.DecodeAll(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode gif: %w", err)
+	}
+
+	bounds := image.Rect(0, 0, anim.Config.Width, anim.Config.Height)
+	canvas := image.NewNRGBA(bounds)
+	frames := make([]AnimationFrame, 0, len(anim.Image))
+	for i, frame := range anim.Image {
+		disposal := byte(0)
+		if i < len(anim.Disposal) {
+			disposal = anim.Disposal[i]
+		}
+		var previous *image.NRGBA
+		if disposal == gif.DisposalPrevious {
+			previous = image.NewNRGBA(bounds)
+			copy(previous.Pix, canvas.Pix)
+		}
+
+		draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+
+		snapshot := image.NewNRGBA(bounds)
+		copy(snapshot.Pix, canvas.Pix)
+		delay := time.Duration(0)
+		if i < len(anim.Delay) {
+			delay = time.Duration(anim.Delay[i]) * 10 * time.Millisecond
+		}
+		if delay <= 10*time.Millisecond {
+			delay = minGIFDelay
+		}
+		img, rest, err := p.transformToResize(snapshot, options)
+		if err != nil {
+			return nil, err
+		}
+		if i == 0 {
+			options = p.pinSmartCrop(img, options)
+		}
+		transformed, err := p.transformFromResize(img, rest, options)
+		if err != nil {
+			return nil, err
+		}
+		frames = append(frames, AnimationFrame{Image: transformed, Duration: delay})
+
+		switch disposal {
+		case gif.DisposalBackground:
+			// Browsers clear to transparent rather than the background color
+			draw.Draw(canvas, frame.Bounds(), image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			canvas = previous
+		}
+	}
+
+	output, err := encoder.EncodeAnimation(frames, webpLoopCount(anim.LoopCount), EncodeOptions{Quality: options.Quality, Context: options.Context})
+	if err != nil {
+		return nil, err
+	}
+	placeholders, err := p.placeholders(frames[0].Image, options)
+	if err != nil {
+		return nil, err
+	}
+	size := frames[0].Image.Bounds().Size()
+	return &Result{Data: output, InputFormat: "gif", Width: size.X, Height: size.Y, Placeholders: placeholders}, nil
+}
+
+// webpLoopCount maps a GIF loop count (0 forever, -1 once, n repeats) to the
+// number of plays used by WebP (0 forever)
+func webpLoopCount(gifLoopCount int) int {
+	switch {
+	case gifLoopCount == 0:
+		return 0
+	case gifLoopCount < 0:
+		return 1
+	}
+	return min(gifLoopCount+1, 0xFFFF)
+}
+
+// checkAnimationLimits scans the GIF block structure without decoding pixels and
+// returns the frame count, or ErrImageTooLarge if the animation exceeds the limits
+func (p *Processor) checkAnimationLimits(data []byte) (int, error) {
+	frames, err := countGIFFrames(data)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read gif: %w", err)
+	}
+	cfg, err := gif.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return 0, fmt.Errorf("failed to read gif: %w", err)
+	}
+
+	limits := p.cfg.Conversion.Animation
+	if limits.MaxFrames > 0 && frames > limits.MaxFrames {
+		return 0, fmt.Errorf("%w: %d frames exceeds the %d frame limit", ErrImageTooLarge, frames, limits.MaxFrames)
+	}
+	total := int64(frames) * int64(cfg.Width) * int64(cfg.Height)
+	if limits.MaxTotalPixels > 0 && total > int64(limits.MaxTotalPixels) {
+		return 0, fmt.Errorf("%w: %d total pixels exceeds the %d pixel animation limit", ErrImageTooLarge, total, limits.MaxTotalPixels)
+	}
+	return frames, nil
+}
+
+// countGIFFrames counts the image descriptors in a GIF
+func countGIFFrames(data []byte) (int, error) {
+	errTruncated := fmt.Errorf("truncated gif")
+	if len(data) < 13 {
+		return 0, errTruncated
+	}
+	pos := 13
+	if flags := data[10]; flags&0x80 != 0 {
+		pos += 3 << ((flags & 0x07) + 1) // Global color table
+	}
+
+	// skipSubBlocks skips a sequence of data sub-blocks ending with a zero-length block
+	skipSubBlocks := func() bool {
+		for pos < len(data) {
+			size := int(data[pos])
+			pos += 1 + size
+			if size == 0 {
+				return true
+			}
+		}
+		return false
+	}
+
+	frames := 0
+	for pos < len(data) {
+		switch data[pos] {
+		case 0x21: // Extension: introducer, label, sub-blocks
+			pos += 2
+			if !skipSubBlocks() {
+				return 0, errTruncated
+			}
+		case 0x2C: // Image descriptor
+			if pos+10 > len(data) {
+				return 0, errTruncated
+			}
+			flags := data[pos+9]
+			pos += 10
+			if flags&0x80 != 0 {
+				pos += 3 << ((flags & 0x07) + 1) // Local color table
+			}
+			pos++ // LZW minimum code size
+			if !skipSubBlocks() {
+				return 0, errTruncated
+			}
+			frames++
+		case 0x3B: // Trailer
+			return frames, nil
+		default:
+			return 0, fmt.Errorf("unexpected gif block 0x%02x", data[pos])
+		}
+	}
+	return frames, nil
+}
+
+// EncodeAnimation writes an animated WebP. Each frame is encoded as a still WebP
+// and its bitstream chunks are wrapped in ANMF chunks of an extended-format file.
+func (e *webpEncoder) EncodeAnimation(frames []AnimationFrame, loopCount int, opts EncodeOptions) ([]byte, error) {
+	if len(frames) == 0 {
+		return nil, fmt.Errorf("animation has no frames")
+	}
+	canvas := frames[0].Image.Bounds()
+	hasAlpha := false
+
+	var body bytes.Buffer
+	for i, frame := range frames {
+		still, err := e.Encode(frame.Image, EncodeOptions{Quality: opts.Quality, Context: opts.Context})
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode frame %d: %w", i, err)
+		}
+		chunks, err := readWebPChunks(still)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode frame %d: %w", i, err)
+		}
+		if !isOpaque(frame.Image) {
+			hasAlpha = true
+		}
+
+		// Frame header: offset (0, 0), size, duration, and "do not blend" since
+		// every frame covers the whole canvas
+		var anmf bytes.Buffer
+		anmf.Write(uint24(0))
+		anmf.Write(uint24(0))
+		anmf.Write(uint24(frame.Image.Bounds().Dx() - 1))
+		anmf.Write(uint24(frame.Image.Bounds().Dy() - 1))
+		anmf.Write(uint24(int(min(frame.Duration.Milliseconds(), 0xFFFFFF))))
+		anmf.WriteByte(0x02)
+		for _, chunk := range chunks {
+			switch chunk.fourCC {
+			case "ALPH", "VP8 ", "VP8L":
+				writeWebPChunk(&anmf, chunk.fourCC, chunk.data)
+			}
+		}
+		writeWebPChunk(&body, "ANMF", anmf.Bytes())
+	}
+
+	var vp8x bytes.Buffer
+	flags := byte(0x02) // Animation
+	if hasAlpha {
+		flags |= 0x10
+	}
+	vp8x.Write([]byte{flags, 0, 0, 0})
+	vp8x.Write(uint24(canvas.Dx() - 1))
+	vp8x.Write(uint24(canvas.Dy() - 1))
+
+	// Background color (BGRA, transparent) and loop count
+	anim := binary.LittleEndian.AppendUint16([]byte{0, 0, 0, 0}, uint16(loopCount))
+
+	var chunks bytes.Buffer
+	writeWebPChunk(&chunks, "VP8X", vp8x.Bytes())
+	writeWebPChunk(&chunks, "ANIM", anim)
+	chunks.Write(body.Bytes())
+
+	out := make([]byte, 0, 12+chunks.Len())
+	out = append(out, "RIFF"...)
+	out = binary.LittleEndian.AppendUint32(out, uint32(4+chunks.Len()))
+	out = append(out, "WEBP"...)
+	return append(out, chunks.Bytes()...), nil
+}
+
+// webpChunk is a chunk of a WebP RIFF container
+type webpChunk struct {
+	fourCC string
+	data   []byte
+}
+
+// readWebPChunks returns the top-level chunks of a WebP file
+func readWebPChunks(data []byte) ([]webpChunk, error) {
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil, fmt.Errorf("not a webp file")
+	}
+	var chunks []webpChunk
+	for pos := 12; pos+8 <= len(data); {
+		size := int(binary.LittleEndian.Uint32(data[pos+4:]))
+		if pos+8+size > len(data) {
+			return nil, fmt.Errorf("truncated webp chunk")
+		}
+		chunks = append(chunks, webpChunk{fourCC: string(data[pos : pos+4]), data: data[pos+8 : pos+8+size]})
+		pos += 8 + size + size%2
+	}
+	return chunks, nil
+}
+
+func writeWebPChunk(w *bytes.Buffer, fourCC string, data []byte) {
+	w.WriteString(fourCC)
+	binary.Write(w, binary.LittleEndian, uint32(len(data)))
+	w.Write(data)
+	if len(data)%2 == 1 {
+		w.WriteByte(0)
+	}
+}
+
+func uint24(v int) []byte {
+	return []byte{byte(v), byte(v >> 8), byte(v >> 16)}
+}
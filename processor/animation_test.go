@@ -0,0 +1,267 @@
+package processor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"testing"
+
+	"image-converting-server/config"
+
+	"github.com/chai2010/webp"
+)
+
+var (
+	animRed   = color.RGBA{255, 0, 0, 255}
+	animBlue  = color.RGBA{0, 0, 255, 255}
+	animGreen = color.RGBA{0, 255, 0, 255}
+)
+
+// makeAnimatedGIF builds an 8x8 animation: a red background, a blue square in the
+// top-left that is disposed to background, then a green square in the bottom-right
+func makeAnimatedGIF(t *testing.T) []byte {
+	t.Helper()
+	palette := color.Palette{color.Transparent, animRed, animBlue, animGreen}
+	frame := func(rect image.Rectangle, index uint8) *image.Paletted {
+		img := image.NewPaletted(rect, palette)
+		for i := range img.Pix {
+			img.Pix[i] = index
+		}
+		return img
+	}
+
+	anim := &gif.GIF{
+		Image: []*image.Paletted{
+			frame(image.Rect(0, 0, 8, 8), 1),
+			frame(image.Rect(0, 0, 4, 4), 2),
+			frame(image.Rect(4, 4, 8, 8), 3),
+		},
+		Delay:     []int{10, 20, 0},
+		Disposal:  []byte{gif.DisposalNone, gif.DisposalBackground, gif.DisposalNone},
+		LoopCount: 2,
+	}
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, anim); err != nil {
+		t.Fatalf("failed to encode gif: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// decodedAnimation is an animated WebP read back chunk by chunk
+type decodedAnimation struct {
+	width, height int
+	loopCount     int
+	durations     []int
+	frames        []image.Image
+}
+
+func decodeAnimatedWebP(t *testing.T, data []byte) decodedAnimation {
+	t.Helper()
+	chunks, err := readWebPChunks(data)
+	if err != nil {
+		t.Fatalf("failed to read webp: %v", err)
+	}
+
+	var anim decodedAnimation
+	for _, chunk := range chunks {
+		switch chunk.fourCC {
+		case "VP8X":
+			if chunk.data[0]&0x02 == 0 {
+				t.Error("expected the animation flag to be set")
+			}
+			anim.width = int(readUint24(chunk.data[4:])) + 1
+			anim.height = int(readUint24(chunk.data[7:])) + 1
+		case "ANIM":
+			anim.loopCount = int(binary.LittleEndian.Uint16(chunk.data[4:]))
+		case "ANMF":
+			anim.durations = append(anim.durations, int(readUint24(chunk.data[12:])))
+			// Wrap the frame bitstream in an extended-format still to decode it;
+			// VP8X is needed for lossy frames with an ALPH chunk
+			var body bytes.Buffer
+			writeWebPChunk(&body, "VP8X", append([]byte{0x10, 0, 0, 0}, chunk.data[6:12]...))
+			body.Write(chunk.data[16:])
+			var still bytes.Buffer
+			still.WriteString("RIFF")
+			binary.Write(&still, binary.LittleEndian, uint32(4+body.Len()))
+			still.WriteString("WEBP")
+			still.Write(body.Bytes())
+			img, err := webp.Decode(&still)
+			if err != nil {
+				t.Fatalf("failed to decode frame %d: %v", len(anim.frames), err)
+			}
+			anim.frames = append(anim.frames, img)
+		}
+	}
+	return anim
+}
+
+func readUint24(b []byte) uint32 {
+	return uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16
+}
+
+func assertRGBA(t *testing.T, img image.Image, x, y int, want color.RGBA) {
+	t.Helper()
+	if got := color.RGBAModel.Convert(img.At(x, y)).(color.RGBA); got != want {
+		t.Errorf("pixel (%d,%d): expected %v, got %v", x, y, want, got)
+	}
+}
+
+func TestProcessor_Process_AnimatedGIF(t *testing.T) {
+	p := NewProcessor(config.Config{Conversion: config.ConversionConfig{Formats: []string{"gif"}}})
+
+	output, format, err := p.Process(makeAnimatedGIF(t), ProcessOptions{Format: FormatWebPLossless})
+	if err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+	if format != "gif" {
+		t.Errorf("expected input format gif, got %s", format)
+	}
+
+	anim := decodeAnimatedWebP(t, output)
+	if anim.width != 8 || anim.height != 8 {
+		t.Errorf("expected an 8x8 canvas, got %dx%d", anim.width, anim.height)
+	}
+	if anim.loopCount != 3 {
+		t.Errorf("expected the animation to play 3 times, got loop count %d", anim.loopCount)
+	}
+	if len(anim.frames) != 3 {
+		t.Fatalf("expected 3 frames, got %d", len(anim.frames))
+	}
+	wantDurations := []int{100, 200, 100}
+	for i, want := range wantDurations {
+		if anim.durations[i] != want {
+			t.Errorf("frame %d: expected duration %dms, got %d", i, want, anim.durations[i])
+		}
+	}
+
+	assertRGBA(t, anim.frames[0], 1, 1, animRed)
+	assertRGBA(t, anim.frames[1], 1, 1, animBlue)
+	assertRGBA(t, anim.frames[1], 6, 6, animRed)
+	// The blue square was disposed to background before the last frame
+	assertRGBA(t, anim.frames[2], 1, 1, color.RGBA{})
+	assertRGBA(t, anim.frames[2], 6, 1, animRed)
+	assertRGBA(t, anim.frames[2], 6, 6, animGreen)
+}
+
+func TestProcessor_Process_AnimatedGIF_Resize(t *testing.T) {
+	p := NewProcessor(config.Config{Conversion: config.ConversionConfig{Formats: []string{"gif"}}})
+
+	output, _, err := p.Process(makeAnimatedGIF(t), ProcessOptions{Width: 4})
+	if err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+	anim := decodeAnimatedWebP(t, output)
+	if anim.width != 4 || anim.height != 4 {
+		t.Errorf("expected a 4x4 canvas, got %dx%d", anim.width, anim.height)
+	}
+	for i, frame := range anim.frames {
+		if size := frame.Bounds().Size(); size != image.Pt(4, 4) {
+			t.Errorf("frame %d: expected 4x4, got %v", i, size)
+		}
+	}
+}
+
+func TestProcessor_Process_AnimatedGIF_SmartCrop(t *testing.T) {
+	p := NewProcessor(config.Config{Conversion: config.ConversionConfig{Formats: []string{"gif"}}})
+
+	// A 60x20 animation whose detail moves from the left third to the right third
+	darkGray, lightGray := color.RGBA{64, 64, 64, 255}, color.RGBA{192, 192, 192, 255}
+	palette := color.Palette{darkGray, lightGray, color.Black, color.White}
+	frame := func(flat uint8, detailX int) *image.Paletted {
+		img := image.NewPaletted(image.Rect(0, 0, 60, 20), palette)
+		for y := 0; y < 20; y++ {
+			for x := 0; x < 60; x++ {
+				index := flat
+				if x >= detailX && x < detailX+20 {
+					index = 2 + uint8((x+y)%2)
+				}
+				img.SetColorIndex(x, y, index)
+			}
+		}
+		return img
+	}
+	var buf bytes.Buffer
+	anim := &gif.GIF{Image: []*image.Paletted{frame(0, 0), frame(1, 40)}, Delay: []int{10, 10}}
+	if err := gif.EncodeAll(&buf, anim); err != nil {
+		t.Fatalf("failed to encode gif: %v", err)
+	}
+
+	output, _, err := p.Process(buf.Bytes(), ProcessOptions{
+		Width: 20, Height: 20, Fit: FitCover, Gravity: GravitySmart, Format: FormatWebPLossless,
+	})
+	if err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+	frames := decodeAnimatedWebP(t, output).frames
+	if len(frames) != 2 {
+		t.Fatalf("expected 2 frames, got %d", len(frames))
+	}
+	// The window is picked on the first frame, so the second one shows its flat left third
+	assertRGBA(t, frames[0], 10, 10, color.RGBA{0, 0, 0, 255})
+	assertRGBA(t, frames[0], 11, 10, color.RGBA{255, 255, 255, 255})
+	assertRGBA(t, frames[1], 10, 10, lightGray)
+	assertRGBA(t, frames[1], 11, 10, lightGray)
+}
+
+func TestProcessor_Process_AnimatedGIF_StillFormat(t *testing.T) {
+	p := NewProcessor(config.Config{Conversion: config.ConversionConfig{Formats: []string{"gif"}}})
+
+	output, _, err := p.Process(makeAnimatedGIF(t), ProcessOptions{Format: FormatPNG})
+	if err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+	img, _, err := image.Decode(bytes.NewReader(output))
+	if err != nil {
+		t.Fatalf("failed to decode output: %v", err)
+	}
+	assertRGBA(t, img, 1, 1, animRed)
+}
+
+func TestProcessor_Process_AnimatedGIF_Limits(t *testing.T) {
+	tests := []struct {
+		name   string
+		limits config.AnimationConfig
+	}{
+		{"too many frames", config.AnimationConfig{MaxFrames: 2}},
+		{"too many total pixels", config.AnimationConfig{MaxTotalPixels: 3*64 - 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewProcessor(config.Config{
+				Conversion: config.ConversionConfig{Formats: []string{"gif"}, Animation: tt.limits},
+			})
+			_, _, err := p.Process(makeAnimatedGIF(t), ProcessOptions{})
+			if !errors.Is(err, ErrImageTooLarge) {
+				t.Errorf("expected ErrImageTooLarge, got %v", err)
+			}
+		})
+	}
+}
+
+func TestCountGIFFrames(t *testing.T) {
+	data := makeAnimatedGIF(t)
+	frames, err := countGIFFrames(data)
+	if err != nil || frames != 3 {
+		t.Errorf("expected 3 frames, got %d (%v)", frames, err)
+	}
+	if _, err := countGIFFrames(data[:len(data)/2]); err == nil {
+		t.Error("expected an error for truncated data")
+	}
+}
+
+func TestWebPLoopCount(t *testing.T) {
+	tests := []struct{ gif, webp int }{
+		{0, 0},
+		{-1, 1},
+		{1, 2},
+		{70000, 0xFFFF},
+	}
+	for _, tt := range tests {
+		if got := webpLoopCount(tt.gif); got != tt.webp {
+			t.Errorf("webpLoopCount(%d) = %d, want %d", tt.gif, got, tt.webp)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package processor
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/png"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"image-converting-server/config"
+)
+
+// avifTimeout bounds a single avifenc run, so a hung encoder cannot block the
+// request, job or cron run that started it
+const avifTimeout = 2 * time.Minute
+
+// ConvertToAVIF encodes an image to AVIF format
+func (p *Processor) ConvertToAVIF(img image.Image) ([]byte, error) {
+	enc := &avifEncoder{cfg: p.cfg.Conversion.AVIF}
+	return enc.Encode(img, EncodeOptions{})
+}
+
+// avifEncoder encodes AVIF by delegating to the avifenc command line tool
+// (libavif), since there is no AVIF encoder available in pure Go
+type avifEncoder struct {
+	cfg config.AVIFConfig
+}
+
+func (e *avifEncoder) Encode(img image.Image, opts EncodeOptions) ([]byte, error) {
+	quality := opts.Quality
+	if quality == 0 {
+		quality = e.cfg.Quality
+	}
+	encoderPath := e.encoderPath()
+
+	dir, err := os.MkdirTemp("", "avif-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp dir: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// avifenc reads PNG input, so write the image losslessly first
+	inputPath := filepath.Join(dir, "input.png")
+	outputPath := filepath.Join(dir, "output.avif")
+
+	var buf bytes.Buffer
+	encoder := png.Encoder{CompressionLevel: png.BestSpeed}
+	if err := encoder.Encode(&buf, img); err != nil {
+		return nil, fmt.Errorf("failed to prepare avif input: %w", err)
+	}
+	if err := os.WriteFile(inputPath, buf.Bytes(), 0600); err != nil {
+		return nil, fmt.Errorf("failed to write avif input: %w", err)
+	}
+
+	args := []string{
+		"-q", strconv.Itoa(quality),
+		"-s", strconv.Itoa(e.cfg.Speed),
+	}
+	if md := opts.Metadata; !md.IsEmpty() {
+		for _, item := range []struct {
+			flag string
+			data []byte
+		}{{"--exif", md.EXIF}, {"--xmp", md.XMP}, {"--icc", md.ICC}} {
+			if len(item.data) == 0 {
+				continue
+			}
+			path := filepath.Join(dir, strings.TrimPrefix(item.flag, "--"))
+			if err := os.WriteFile(path, item.data, 0600); err != nil {
+				return nil, fmt.Errorf("failed to write avif metadata: %w", err)
+			}
+			args = append(args, item.flag, path)
+		}
+	}
+	args = append(args, inputPath, outputPath)
+	ctx := opts.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ctx, cancel := context.WithTimeout(ctx, avifTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, encoderPath, args...)
+	// Stop waiting for stderr too, which children of a killed encoder may hold open
+	cmd.WaitDelay = time.Second
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("avifenc failed: %w", ctx.Err())
+		}
+		return nil, fmt.Errorf("avifenc failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	return os.ReadFile(outputPath)
+}
+
+// Available reports whether the avifenc binary can be found
+func (e *avifEncoder) Available() bool {
+	_, err := exec.LookPath(e.encoderPath())
+	return err == nil
+}
+
+func (e *avifEncoder) encoderPath() string {
+	if e.cfg.EncoderPath == "" {
+		return "avifenc"
+	}
+	return e.cfg.EncoderPath
+}
+
+func (e *avifEncoder) Extension() string   { return ".avif" }
+func (e *avifEncoder) ContentType() string { return "image/avif" }
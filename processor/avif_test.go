@@ -0,0 +1,114 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"image-converting-server/config"
+)
+
+// writeFakeAVIFEncoder creates a script that records its arguments and
+// writes a placeholder AVIF file to the output path (last argument)
+func writeFakeAVIFEncoder(t *testing.T) (scriptPath, argsPath string) {
+	t.Helper()
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not available")
+	}
+	dir := t.TempDir()
+	scriptPath = filepath.Join(dir, "avifenc")
+	argsPath = filepath.Join(dir, "args")
+	script := "#!/bin/sh\n" +
+		"echo \"$@\" > " + argsPath + "\n" +
+		"for last; do :; done\n" +
+		"printf 'fake-avif' > \"$last\"\n"
+	if err := os.WriteFile(scriptPath, []byte(script), 0755); err != nil {
+		t.Fatalf("failed to write fake encoder: %v", err)
+	}
+	return scriptPath, argsPath
+}
+
+func TestProcessor_ConvertToAVIF(t *testing.T) {
+	scriptPath, argsPath := writeFakeAVIFEncoder(t)
+
+	cfg := config.Config{
+		Conversion: config.ConversionConfig{
+			Formats: []string{"png"},
+			Quality: 80,
+			AVIF: config.AVIFConfig{
+				Quality:     55,
+				Speed:       8,
+				EncoderPath: scriptPath,
+			},
+		},
+	}
+	p := NewProcessor(cfg)
+	data := imageToBytes(t, createTestImage(20, 20), "png")
+
+	t.Run("Per request format", func(t *testing.T) {
+		out, format, err := p.Process(data, ProcessOptions{Format: "avif"})
+		if err != nil {
+			t.Fatalf("Process failed: %v", err)
+		}
+		if format != "png" {
+			t.Errorf("expected input format png, got %s", format)
+		}
+		if string(out) != "fake-avif" {
+			t.Errorf("expected encoder output, got %q", out)
+		}
+
+		args, err := os.ReadFile(argsPath)
+		if err != nil {
+			t.Fatalf("encoder was not invoked: %v", err)
+		}
+		if !strings.Contains(string(args), "-q 55 -s 8") {
+			t.Errorf("expected quality and speed arguments, got %q", args)
+		}
+	})
+
+	t.Run("Deployment default format", func(t *testing.T) {
+		avifCfg := cfg
+		avifCfg.Conversion.OutputFormat = "avif"
+		p := NewProcessor(avifCfg)
+		if got := p.OutputFormat(ProcessOptions{}); got != FormatAVIF {
+			t.Errorf("expected default format avif, got %s", got)
+		}
+		if got := p.OutputFormat(ProcessOptions{Format: "webp"}); got != FormatWebP {
+			t.Errorf("expected request format to override default, got %s", got)
+		}
+	})
+
+	t.Run("Encoder failure", func(t *testing.T) {
+		badCfg := cfg
+		badCfg.Conversion.AVIF.EncoderPath = filepath.Join(t.TempDir(), "missing-avifenc")
+		p := NewProcessor(badCfg)
+		if _, _, err := p.Process(data, ProcessOptions{Format: "avif"}); err == nil {
+			t.Error("expected error when encoder is missing")
+		}
+	})
+	t.Run("Cancelled", func(t *testing.T) {
+		hangPath := filepath.Join(t.TempDir(), "avifenc")
+		if err := os.WriteFile(hangPath, []byte("#!/bin/sh\nsleep 30\n"), 0755); err != nil {
+			t.Fatalf("failed to write fake encoder: %v", err)
+		}
+		hangCfg := cfg
+		hangCfg.Conversion.AVIF.EncoderPath = hangPath
+		p := NewProcessor(hangCfg)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+		_, _, err := p.Process(data, ProcessOptions{Format: "avif", Context: ctx})
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("expected a deadline error, got %v", err)
+		}
+		if elapsed := time.Since(start); elapsed > 10*time.Second {
+			t.Errorf("expected the encoder to be stopped, took %v", elapsed)
+		}
+	})
+}
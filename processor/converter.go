@@ -2,24 +2,38 @@ package processor
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"image"
+	"image/color"
 	_ "image/gif"
 	_ "image/jpeg"
 	_ "image/png"
 	"io"
 	"net/http"
-	"strings"
+	"sync"
 
 	"image-converting-server/config"
+)
 
-	"github.com/chai2010/webp"
-	"github.com/disintegration/imaging"
+// Supported output formats
+const (
+	FormatWebP = "webp"
+	FormatAVIF = "avif"
 )
 
+// ErrImageTooLarge is returned when an input exceeds conversion.max_size_mb
+// or conversion.max_pixels
+var ErrImageTooLarge = errors.New("image too large")
+
 // Processor handles image conversion and resizing
 type Processor struct {
 	cfg config.Config
+
+	overlaySource OverlaySource
+	overlayMu     sync.Mutex
+	overlays      map[string]*overlayEntry // Watermark overlay loads by profile name
 }
 
 // NewProcessor creates a new Processor instance
@@ -29,81 +43,291 @@ func NewProcessor(cfg config.Config) *Processor {
 	}
 }
 
-// Process handles the full image processing flow: decode, resize (if needed), and convert to WebP
+// Result is the output of ProcessResult
+type Result struct {
+	Data        []byte
+	InputFormat string // Decoder format name of the input
+	Width       int    // Output dimensions after all operations
+	Height      int
+
+	// Set when the quality search ran; SSIM is 0 if the output could not be scored
+	Quality int
+	SSIM    float64
+
+	Placeholders *Placeholders // nil unless placeholders were selected
+}
+
+// Process handles the full image processing flow: decode, run the operation pipeline
+// (including the resize, if needed), and encode
+// to the requested output format (WebP unless configured otherwise)
 func (p *Processor) Process(data []byte, options ProcessOptions) ([]byte, string, error) {
-	// 1. Detect format
-	contentType := http.DetectContentType(data)
-	if !p.isSupported(contentType) {
-		return nil, "", fmt.Errorf("unsupported image format: %s", contentType)
+	// The placeholders would be discarded, so skip computing them
+	options.Placeholders = &config.PlaceholderConfig{}
+	result, err := p.ProcessResult(data, options)
+	if err != nil {
+		return nil, "", err
 	}
+	return result.Data, result.InputFormat, nil
+}
 
-	// 2. Decode image
-	img, format, err := image.Decode(bytes.NewReader(data))
+// ProcessResult is Process, also returning the output dimensions and the quality
+// chosen by the quality search
+func (p *Processor) ProcessResult(data []byte, options ProcessOptions) (*Result, error) {
+	prepared, err := p.Prepare(data, options)
 	if err != nil {
-		return nil, "", fmt.Errorf("failed to decode image: %w", err)
+		return nil, err
 	}
+	return p.Render(prepared, options)
+}
 
-	// 3. Resize if options provided
-	if options.Width > 0 || options.Height > 0 {
-		img = p.ResizeImage(img, options.Width, options.Height)
-	} else if options.Preset != "" {
-		if preset, ok := p.cfg.Resize.Presets[options.Preset]; ok {
-			img = p.ResizeImage(img, preset.Width, preset.Height)
-		}
+// Prepared is an input decoded and transformed up to its resize. Render produces
+// outputs of several sizes and formats from it without decoding the input again.
+// It is not safe for concurrent use.
+type Prepared struct {
+	data     []byte // Selected page of the input, for the animation path
+	format   string
+	animated bool // Multi-frame GIF
+	metadata *Metadata
+	keepICC  bool
+	img      image.Image
+	rest     []Operation // Operations from the resize on
+}
+
+// Width returns the width of the image the resize starts from
+func (s *Prepared) Width() int {
+	return s.img.Bounds().Dx()
+}
+
+// Prepare runs the steps of ProcessResult that do not depend on the output size
+// or format: it checks the limits, decodes the input, applies the color profile
+// and EXIF orientation, and runs the operations before the resize
+func (p *Processor) Prepare(data []byte, options ProcessOptions) (*Prepared, error) {
+	// 1. Detect format and select the requested page
+	inputFormat, err := p.detectFormat(data)
+	if err != nil {
+		return nil, err
+	}
+	if data, err = selectPage(data, inputFormat, options.Page); err != nil {
+		return nil, err
 	}
 
-	// 4. Convert to WebP
-	webpData, err := p.ConvertToWebP(img)
+	// 2. Check size limits before allocating the decoded image
+	if err := p.CheckLimits(data); err != nil {
+		return nil, err
+	}
+	prepared := &Prepared{data: data, format: inputFormat}
+	if inputFormat == "gif" {
+		frames, err := countGIFFrames(data)
+		prepared.animated = err == nil && frames > 1
+	}
+
+	// 3. Decode image and apply the EXIF orientation, which image.Decode ignores.
+	// Animations decode their first frame here for outputs without animation.
+	img, _, err := image.Decode(bytes.NewReader(data))
 	if err != nil {
-		return nil, "", fmt.Errorf("failed to convert to webp: %w", err)
+		return nil, fmt.Errorf("failed to decode image: %w", err)
 	}
+	prepared.metadata = ReadMetadata(data)
+	img, prepared.keepICC = p.applyColorProfile(img, prepared.metadata.ICC)
+	img = applyOrientation(img, prepared.metadata.Orientation())
 
-	return webpData, format, nil
+	// 4. Run the preset and requested operations up to the resize
+	if prepared.img, prepared.rest, err = p.transformToResize(img, options); err != nil {
+		return nil, err
+	}
+	return prepared, nil
 }
 
-// ConvertToWebP encodes an image to WebP format
-func (p *Processor) ConvertToWebP(img image.Image) ([]byte, error) {
-	var buf bytes.Buffer
-	err := webp.Encode(&buf, img, &webp.Options{
-		Lossless: false,
-		Quality:  float32(p.cfg.Conversion.Quality),
-	})
+// Render resizes and encodes a prepared input. options must match those given to
+// Prepare apart from the size, format, quality and placeholders.
+func (p *Processor) Render(prepared *Prepared, options ProcessOptions) (*Result, error) {
+	// Animated GIFs take a separate path when the output format supports animation;
+	// otherwise only the first frame is converted
+	if prepared.animated {
+		if encoder, err := p.Encoder(options.Format); err == nil {
+			if animEncoder, ok := encoder.(animationEncoder); ok {
+				frames, err := p.checkAnimationLimits(prepared.data)
+				if err != nil {
+					return nil, err
+				}
+				if frames > 1 {
+					result, err := p.processAnimation(prepared.data, options, animEncoder)
+					if err != nil {
+						return nil, fmt.Errorf("failed to convert to %s: %w", p.OutputFormat(options), err)
+					}
+					return result, nil
+				}
+			}
+		}
+	}
+
+	// 5. Resize and run the remaining operations
+	img, err := p.transformFromResize(prepared.img, prepared.rest, options)
+	if err != nil {
+		return nil, err
+	}
+
+	// 6. Encode to the output format
+	encoder, err := p.Encoder(options.Format)
 	if err != nil {
 		return nil, err
 	}
-	return buf.Bytes(), nil
+	metadata := prepared.metadata
+	encodeOptions := EncodeOptions{Quality: options.Quality, Context: options.Context}
+	outputMetadata := &Metadata{}
+	if p.metadataMode(options) == MetadataPreserve {
+		// The pixels are already upright, so the orientation tag must not be applied again
+		outputMetadata = metadata.withNormalOrientation()
+	}
+	if prepared.keepICC {
+		outputMetadata.ICC = metadata.ICC
+	} else if p.colorProfileMode() == ColorProfileSRGB {
+		// The pixels are sRGB now, so the source profile no longer describes them
+		outputMetadata.ICC = nil
+	}
+	if !outputMetadata.IsEmpty() {
+		encodeOptions.Metadata = outputMetadata
+	}
+	size := img.Bounds().Size()
+	result := &Result{InputFormat: prepared.format, Width: size.X, Height: size.Y}
+	if result.Placeholders, err = p.placeholders(img, options); err != nil {
+		return nil, err
+	}
+	if search := p.qualitySearch(options); search.Enabled() && isLossy(encoder) {
+		found, err := searchQuality(img, encoder, encodeOptions, search)
+		if err != nil {
+			return nil, fmt.Errorf("failed to convert to %s: %w", p.OutputFormat(options), err)
+		}
+		result.Data, result.Quality, result.SSIM = found.data, found.quality, found.ssim
+		return result, nil
+	}
+	if result.Data, err = encoder.Encode(img, encodeOptions); err != nil {
+		return nil, fmt.Errorf("failed to convert to %s: %w", p.OutputFormat(options), err)
+	}
+	return result, nil
 }
 
-// ResizeImage resizes the image while maintaining aspect ratio if one dimension is 0
-func (p *Processor) ResizeImage(img image.Image, width, height int) image.Image {
-	// imaging.Resize maintains aspect ratio if one of the dimensions is 0
-	return imaging.Resize(img, width, height, imaging.Lanczos)
+// Decode decodes data the way ProcessResult does before running any operations:
+// after checking the size limits, the color profile and EXIF orientation are
+// applied. Unlike ProcessResult it accepts any format with a registered decoder,
+// including those not enabled in conversion.formats.
+func (p *Processor) Decode(data []byte) (image.Image, error) {
+	if err := p.CheckLimits(data); err != nil {
+		return nil, err
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %w", err)
+	}
+	metadata := ReadMetadata(data)
+	img, _ = p.applyColorProfile(img, metadata.ICC)
+	return applyOrientation(img, metadata.Orientation()), nil
 }
 
-// isSupported checks if the content type is in the supported formats list
-func (p *Processor) isSupported(contentType string) bool {
-	for _, format := range p.cfg.Conversion.Formats {
-		if strings.Contains(strings.ToLower(contentType), strings.ToLower(format)) {
-			return true
-		}
+// applyColorProfile handles an embedded ICC profile according to conversion.color_profile.
+// It returns the image to process and whether the profile must be embedded in the output
+// for the colors to display correctly.
+func (p *Processor) applyColorProfile(img image.Image, icc []byte) (image.Image, bool) {
+	if len(icc) == 0 {
+		return img, false
 	}
-	// Also allow common mappings if not explicitly in config
-	if strings.HasPrefix(contentType, "image/") {
-		ext := strings.TrimPrefix(contentType, "image/")
-		for _, f := range p.cfg.Conversion.Formats {
-			if f == ext || (f == "jpg" && ext == "jpeg") || (f == "jpeg" && ext == "jpg") {
-				return true
-			}
-		}
+	if p.colorProfileMode() == ColorProfileEmbed {
+		return img, true
+	}
+
+	profile, err := parseICCProfile(icc)
+	if err != nil {
+		// Profiles we cannot convert (LUT-based, CMYK, ...) are passed through instead
+		return img, true
+	}
+	if profile.isSRGB() {
+		return img, false
+	}
+	return profile.toSRGB(img), false
+}
+
+func (p *Processor) colorProfileMode() string {
+	if p.cfg.Conversion.ColorProfile != "" {
+		return p.cfg.Conversion.ColorProfile
+	}
+	return ColorProfileSRGB
+}
+
+// metadataMode returns the metadata mode for the given options,
+// falling back to the configured default
+func (p *Processor) metadataMode(options ProcessOptions) string {
+	if options.Metadata != "" {
+		return options.Metadata
 	}
-	return false
+	if p.cfg.Conversion.Metadata != "" {
+		return p.cfg.Conversion.Metadata
+	}
+	return MetadataStrip
+}
+
+// CheckLimits returns ErrImageTooLarge if the encoded data exceeds max_size_mb
+// or its dimensions, read from the header only, exceed max_pixels
+func (p *Processor) CheckLimits(data []byte) error {
+	if maxBytes := p.cfg.Conversion.MaxSizeBytes(); maxBytes > 0 && int64(len(data)) > maxBytes {
+		return fmt.Errorf("%w: %d bytes exceeds the %d MB limit", ErrImageTooLarge, len(data), p.cfg.Conversion.MaxSizeMB)
+	}
+
+	maxPixels := int64(p.cfg.Conversion.MaxPixels)
+	if maxPixels <= 0 {
+		return nil
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		// Let the full decode report the error
+		return nil
+	}
+	if pixels := int64(cfg.Width) * int64(cfg.Height); pixels > maxPixels {
+		return fmt.Errorf("%w: %dx%d exceeds the %d pixel limit", ErrImageTooLarge, cfg.Width, cfg.Height, maxPixels)
+	}
+	return nil
+}
+
+// OutputFormat returns the output format used for the given options,
+// falling back to the configured default
+func (p *Processor) OutputFormat(options ProcessOptions) string {
+	format := options.Format
+	if format == "" {
+		format = p.cfg.Conversion.OutputFormat
+	}
+	if format == "" {
+		format = FormatWebP
+	}
+	return normalizeFormat(format)
+}
+
+// ConvertToWebP encodes an image to lossy WebP format
+func (p *Processor) ConvertToWebP(img image.Image) ([]byte, error) {
+	enc := &webpEncoder{quality: p.cfg.Conversion.Quality}
+	return enc.Encode(img, EncodeOptions{})
 }
 
-// ProcessOptions defines resizing parameters for Process method
+// ProcessOptions defines resizing and output parameters for Process method
 type ProcessOptions struct {
-	Width  int
-	Height int
-	Preset string
+	Width    int
+	Height   int
+	Preset   string
+	Fit      string // Fit mode when both dimensions are set; empty uses the preset fit, then fill
+	Format   string // Output format name from the encoder registry; empty uses conversion.output_format
+	Quality  int    // Encoder quality override (1-100); 0 uses the configured quality
+	Metadata string // MetadataStrip or MetadataPreserve; empty uses conversion.metadata
+	Page     int    // 1-based page of a multi-page TIFF; 0 uses the first page
+
+	Gravity    string      // Anchor for cover crops and contain placement, or GravitySmart; empty uses the preset gravity, then center
+	Focal      *FocalPoint // Focal point for cover crops; overrides Gravity when set
+	Background color.Color // Letterbox color for contain and rotation; nil means transparent
+
+	Operations []Operation // Applied in order after the preset's operations; see transform
+	Watermark  string      // Watermark profile name; empty uses the preset's watermark, if any
+
+	QualitySearch *QualitySearch            // Overrides conversion.quality_search; ignored when Quality is set
+	Placeholders  *config.PlaceholderConfig // Overrides conversion.placeholders; preview_size always comes from the config
+
+	Context context.Context // Request or job context passed to the encoder; nil means no cancellation
 }
 
 // GetImageFormat returns the format of the image data
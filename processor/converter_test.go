@@ -2,6 +2,7 @@ package processor
 
 import (
 	"bytes"
+	"errors"
 	"image"
 	"image/color"
 	"image/jpeg"
@@ -129,6 +130,123 @@ func TestProcessor_Process(t *testing.T) {
 	})
 }
 
+func TestProcessor_ResizeImageFit(t *testing.T) {
+	p := NewProcessor(config.Config{})
+	img := createTestImage(400, 200)
+
+	tests := []struct {
+		fit        string
+		wantWidth  int
+		wantHeight int
+	}{
+		{FitFill, 100, 100},
+		{FitCover, 100, 100},
+		{FitContain, 100, 100},
+		{FitInside, 100, 50},
+		{FitOutside, 200, 100},
+		{"", 100, 100},
+	}
+	for _, tt := range tests {
+		bounds := p.ResizeImageFit(img, 100, 100, tt.fit).Bounds()
+		if bounds.Dx() != tt.wantWidth || bounds.Dy() != tt.wantHeight {
+			t.Errorf("fit %q: expected %dx%d, got %dx%d", tt.fit, tt.wantWidth, tt.wantHeight, bounds.Dx(), bounds.Dy())
+		}
+	}
+}
+
+func TestProcessor_CheckLimits(t *testing.T) {
+	p := NewProcessor(config.Config{
+		Conversion: config.ConversionConfig{
+			Formats:   []string{"png"},
+			MaxSizeMB: 1,
+			MaxPixels: 10000,
+		},
+	})
+
+	t.Run("Within limits", func(t *testing.T) {
+		data := imageToBytes(t, createTestImage(100, 100), "png")
+		if _, _, err := p.Process(data, ProcessOptions{}); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("Too many pixels", func(t *testing.T) {
+		data := imageToBytes(t, createTestImage(101, 100), "png")
+		_, _, err := p.Process(data, ProcessOptions{})
+		if !errors.Is(err, ErrImageTooLarge) {
+			t.Errorf("expected ErrImageTooLarge, got %v", err)
+		}
+	})
+
+	t.Run("Too many bytes", func(t *testing.T) {
+		data := imageToBytes(t, createTestImage(10, 10), "png")
+		data = append(data, make([]byte, 1<<20)...)
+		_, _, err := p.Process(data, ProcessOptions{})
+		if !errors.Is(err, ErrImageTooLarge) {
+			t.Errorf("expected ErrImageTooLarge, got %v", err)
+		}
+	})
+}
+
+func TestProcessor_Decode(t *testing.T) {
+	// WebP is not in conversion.formats, but Decode accepts any decodable input
+	p := NewProcessor(config.Config{Conversion: config.ConversionConfig{Formats: []string{"png"}, MaxPixels: 10000}})
+	webpData, err := p.ConvertToWebP(createTestImage(40, 20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := p.Decode(webpData)
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if size := img.Bounds().Size(); size != image.Pt(40, 20) {
+		t.Errorf("expected 40x20, got %v", size)
+	}
+
+	// The EXIF orientation is applied
+	rotated := insertJPEGMetadata(imageToBytes(t, createTestImage(40, 20), "jpeg"), &Metadata{EXIF: makeEXIF(6)})
+	if img, err = p.Decode(rotated); err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if size := img.Bounds().Size(); size != image.Pt(20, 40) {
+		t.Errorf("expected a 20x40 upright image, got %v", size)
+	}
+
+	if _, err := p.Decode(imageToBytes(t, createTestImage(101, 100), "png")); !errors.Is(err, ErrImageTooLarge) {
+		t.Errorf("expected ErrImageTooLarge, got %v", err)
+	}
+}
+
+func TestProcessor_PrepareRender(t *testing.T) {
+	p := NewProcessor(config.Config{Conversion: config.ConversionConfig{Formats: []string{"png"}}})
+	data := imageToBytes(t, createTestImage(200, 100), "png")
+	ops, _ := ParseOperations("crop:0,0,160,100|resize|blur:1")
+	options := ProcessOptions{Format: FormatPNG, Operations: ops}
+
+	prepared, err := p.Prepare(data, options)
+	if err != nil {
+		t.Fatalf("Prepare failed: %v", err)
+	}
+	if prepared.Width() != 160 {
+		t.Errorf("expected the crop before the resize to run, got width %d", prepared.Width())
+	}
+	// Every render matches a full ProcessResult at that size
+	for _, width := range []int{80, 40} {
+		options.Width = width
+		rendered, err := p.Render(prepared, options)
+		if err != nil {
+			t.Fatalf("Render failed: %v", err)
+		}
+		full, err := p.ProcessResult(data, options)
+		if err != nil {
+			t.Fatalf("ProcessResult failed: %v", err)
+		}
+		if rendered.Width != width || !bytes.Equal(rendered.Data, full.Data) {
+			t.Errorf("width %d: expected the render to match ProcessResult, got %dx%d", width, rendered.Width, rendered.Height)
+		}
+	}
+}
+
 func TestGetMimeType(t *testing.T) {
 	img := createTestImage(10, 10)
 	jpegData := imageToBytes(t, img, "jpeg")
@@ -0,0 +1,145 @@
+package processor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"strings"
+
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/tiff"
+)
+
+// ErrPageOutOfRange is returned when ProcessOptions.Page is beyond the last page of the input
+var ErrPageOutOfRange = errors.New("page out of range")
+
+// MaxPage is the highest page the API accepts
+const MaxPage = 10000
+
+// decoderFormats maps the names accepted in conversion.formats to the format names
+// registered with the image package by the decoders imported in this package
+var decoderFormats = map[string]string{
+	"jpeg": "jpeg",
+	"jpg":  "jpeg",
+	"png":  "png",
+	"gif":  "gif",
+	"bmp":  "bmp",
+	"tiff": "tiff",
+	"tif":  "tiff",
+	"webp": "webp",
+}
+
+// decoderSamples holds, per registered format name, the smallest 1x1 header
+// that image.DecodeConfig recognizes
+var decoderSamples = map[string][]byte{
+	"jpeg": []byte("\xff\xd8\xff\xe0\x00\x10JFIF\x00\x01\x01\x00\x00\x01\x00\x01\x00\x00" +
+		"\xff\xc0\x00\x0b\x08\x00\x01\x00\x01\x01\x01\x11\x00"),
+	"png": []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR\x00\x00\x00\x01\x00\x00\x00\x01" +
+		"\x08\x00\x00\x00\x00:~\x9bU"),
+	"gif": []byte("GIF89a\x01\x00\x01\x00\x00\x00\x00"),
+	"bmp": []byte("BM:\x00\x00\x00\x00\x00\x00\x006\x00\x00\x00(\x00\x00\x00\x01\x00\x00\x00" +
+		"\x01\x00\x00\x00\x01\x00\x18\x00\x00\x00\x00\x00\x04\x00\x00\x00" +
+		"\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00"),
+	// ImageWidth, ImageLength and PhotometricInterpretation
+	"tiff": []byte("II*\x00\x08\x00\x00\x00\x03\x00" +
+		"\x00\x01\x03\x00\x01\x00\x00\x00\x01\x00\x00\x00" +
+		"\x01\x01\x03\x00\x01\x00\x00\x00\x01\x00\x00\x00" +
+		"\x06\x01\x03\x00\x01\x00\x00\x00\x01\x00\x00\x00" +
+		"\x00\x00\x00\x00"),
+	"webp": []byte("RIFF\x1a\x00\x00\x00WEBPVP8L\x0e\x00\x00\x00/\x00\x00\x00\x00"),
+}
+
+// CheckDecoders returns an error if any of the formats has no working decoder.
+// Each decoder is probed with its sample, so a dropped import fails at startup.
+func CheckDecoders(formats []string) error {
+	var missing []string
+	for _, format := range formats {
+		name, ok := decoderFormats[strings.ToLower(format)]
+		if !ok || !probeDecoder(name) {
+			missing = append(missing, format)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("no decoder registered for: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// probeDecoder reports whether a registered decoder reads the sample of format
+// and names it format
+func probeDecoder(format string) bool {
+	sample, ok := decoderSamples[format]
+	if !ok {
+		return false
+	}
+	_, got, err := image.DecodeConfig(bytes.NewReader(sample))
+	return err == nil && got == format
+}
+
+// detectFormat reads the image header and returns the decoder format name,
+// or an error if the format is unknown or not enabled in conversion.formats
+func (p *Processor) detectFormat(data []byte) (string, error) {
+	_, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if errors.Is(err, image.ErrFormat) {
+		return "", fmt.Errorf("unsupported image format: %s", GetMimeType(data))
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read image header: %w", err)
+	}
+	for _, f := range p.cfg.Conversion.Formats {
+		if decoderFormats[strings.ToLower(f)] == format {
+			return format, nil
+		}
+	}
+	return "", fmt.Errorf("unsupported image format: %s", format)
+}
+
+// selectPage returns data that decodes to the given 1-based page of the input.
+// Only TIFF files can have more than one page; 0 selects the first page.
+func selectPage(data []byte, format string, page int) ([]byte, error) {
+	if page <= 1 {
+		return data, nil
+	}
+	if format != "tiff" {
+		return nil, fmt.Errorf("%w: %s images have a single page", ErrPageOutOfRange, format)
+	}
+	return selectTIFFPage(data, page)
+}
+
+// selectTIFFPage points the TIFF header at the IFD of the given page, so that the
+// single-image decoder reads that page. IFD entries use absolute offsets, so the
+// rest of the file stays valid.
+func selectTIFFPage(data []byte, page int) ([]byte, error) {
+	if len(data) < 8 {
+		return nil, fmt.Errorf("truncated tiff header")
+	}
+	var order binary.ByteOrder = binary.LittleEndian
+	if string(data[:2]) == "MM" {
+		order = binary.BigEndian
+	}
+
+	offset := int64(order.Uint32(data[4:]))
+	// A next-IFD offset pointing back into the chain would loop forever
+	visited := make(map[int64]bool)
+	for i := 1; i < page; i++ {
+		if offset == 0 || offset+2 > int64(len(data)) || visited[offset] {
+			return nil, fmt.Errorf("%w: page %d of %d", ErrPageOutOfRange, page, i)
+		}
+		visited[offset] = true
+		count := int64(order.Uint16(data[offset:]))
+		next := offset + 2 + count*12
+		if next+4 > int64(len(data)) {
+			return nil, fmt.Errorf("truncated tiff IFD")
+		}
+		offset = int64(order.Uint32(data[next:]))
+	}
+	if offset == 0 {
+		return nil, fmt.Errorf("%w: page %d of %d", ErrPageOutOfRange, page, page-1)
+	}
+
+	out := bytes.Clone(data)
+	order.PutUint32(out[4:], uint32(offset))
+	return out, nil
+}
@@ -0,0 +1,142 @@
+package processor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+
+	"image-converting-server/config"
+
+	"golang.org/x/image/bmp"
+	"golang.org/x/image/tiff"
+)
+
+// makeMultiPageTIFF builds an uncompressed 8-bit grayscale TIFF with one 4x4 page per value
+func makeMultiPageTIFF(values ...uint8) []byte {
+	buf := []byte("II*\x00\x00\x00\x00\x00")
+	nextOffset := 4 // Position of the pointer to the next IFD
+	for _, value := range values {
+		pixels := len(buf)
+		buf = append(buf, bytes.Repeat([]byte{value}, 16)...)
+
+		ifd := len(buf)
+		binary.LittleEndian.PutUint32(buf[nextOffset:], uint32(ifd))
+		entries := [][2]uint32{
+			{256, 4},              // ImageWidth
+			{257, 4},              // ImageLength
+			{258, 8},              // BitsPerSample
+			{259, 1},              // Compression: none
+			{262, 1},              // PhotometricInterpretation: BlackIsZero
+			{273, uint32(pixels)}, // StripOffsets
+			{277, 1},              // SamplesPerPixel
+			{278, 4},              // RowsPerStrip
+			{279, 16},             // StripByteCounts
+		}
+		buf = binary.LittleEndian.AppendUint16(buf, uint16(len(entries)))
+		for _, entry := range entries {
+			buf = binary.LittleEndian.AppendUint16(buf, uint16(entry[0]))
+			buf = binary.LittleEndian.AppendUint16(buf, 4) // LONG
+			buf = binary.LittleEndian.AppendUint32(buf, 1)
+			buf = binary.LittleEndian.AppendUint32(buf, entry[1])
+		}
+		nextOffset = len(buf)
+		buf = append(buf, 0, 0, 0, 0)
+	}
+	return buf
+}
+
+func TestProcessor_Process_BMPAndTIFF(t *testing.T) {
+	src := createTestImage(40, 30)
+	var bmpData, tiffData bytes.Buffer
+	if err := bmp.Encode(&bmpData, src); err != nil {
+		t.Fatalf("failed to encode bmp: %v", err)
+	}
+	if err := tiff.Encode(&tiffData, src, &tiff.Options{Compression: tiff.Deflate}); err != nil {
+		t.Fatalf("failed to encode tiff: %v", err)
+	}
+
+	p := NewProcessor(config.Config{Conversion: config.ConversionConfig{Formats: []string{"bmp", "tiff"}}})
+	for name, data := range map[string][]byte{"bmp": bmpData.Bytes(), "tiff": tiffData.Bytes()} {
+		output, format, err := p.Process(data, ProcessOptions{Format: FormatPNG})
+		if err != nil {
+			t.Fatalf("%s: Process failed: %v", name, err)
+		}
+		if format != name {
+			t.Errorf("%s: expected input format %s, got %s", name, name, format)
+		}
+		img, _, err := image.Decode(bytes.NewReader(output))
+		if err != nil {
+			t.Fatalf("%s: failed to decode output: %v", name, err)
+		}
+		if img.Bounds().Dx() != 40 || img.Bounds().Dy() != 30 {
+			t.Errorf("%s: expected 40x30, got %v", name, img.Bounds())
+		}
+	}
+
+	// Formats that are not enabled are still rejected
+	p = NewProcessor(config.Config{Conversion: config.ConversionConfig{Formats: []string{"png"}}})
+	if _, _, err := p.Process(bmpData.Bytes(), ProcessOptions{}); err == nil {
+		t.Error("expected an error for a format that is not in conversion.formats")
+	}
+}
+
+func TestProcessor_Process_TIFFPage(t *testing.T) {
+	data := makeMultiPageTIFF(10, 120, 240)
+	p := NewProcessor(config.Config{Conversion: config.ConversionConfig{Formats: []string{"tif"}}})
+
+	for page, want := range map[int]uint8{0: 10, 1: 10, 2: 120, 3: 240} {
+		output, _, err := p.Process(data, ProcessOptions{Format: FormatPNG, Page: page})
+		if err != nil {
+			t.Fatalf("page %d: Process failed: %v", page, err)
+		}
+		img, _, err := image.Decode(bytes.NewReader(output))
+		if err != nil {
+			t.Fatalf("page %d: failed to decode output: %v", page, err)
+		}
+		if got := color.GrayModel.Convert(img.At(1, 1)).(color.Gray).Y; got != want {
+			t.Errorf("page %d: expected gray %d, got %d", page, want, got)
+		}
+	}
+
+	if _, _, err := p.Process(data, ProcessOptions{Page: 4}); !errors.Is(err, ErrPageOutOfRange) {
+		t.Errorf("expected ErrPageOutOfRange for page 4, got %v", err)
+	}
+
+	// An IFD whose next offset points back at itself ends the chain
+	cyclic := makeMultiPageTIFF(10)
+	binary.LittleEndian.PutUint32(cyclic[len(cyclic)-4:], binary.LittleEndian.Uint32(cyclic[4:]))
+	if _, _, err := p.Process(cyclic, ProcessOptions{Page: 1 << 40}); !errors.Is(err, ErrPageOutOfRange) {
+		t.Errorf("expected ErrPageOutOfRange for a cyclic IFD chain, got %v", err)
+	}
+
+	pngProcessor := NewProcessor(config.Config{Conversion: config.ConversionConfig{Formats: []string{"png"}}})
+	pngData := imageToBytes(t, createTestImage(4, 4), "png")
+	if _, _, err := pngProcessor.Process(pngData, ProcessOptions{Page: 2}); !errors.Is(err, ErrPageOutOfRange) {
+		t.Errorf("expected ErrPageOutOfRange for page 2 of a PNG, got %v", err)
+	}
+}
+
+func TestCheckDecoders(t *testing.T) {
+	if err := CheckDecoders([]string{"jpeg", "jpg", "png", "gif", "bmp", "tiff", "TIF", "webp"}); err != nil {
+		t.Errorf("expected all formats to have decoders, got %v", err)
+	}
+	if err := CheckDecoders([]string{"png", "heic"}); err == nil {
+		t.Error("expected an error for heic")
+	}
+
+	// A name without a working decoder fails even though it is a known format
+	webpSample := decoderSamples["webp"]
+	defer func() { decoderSamples["webp"] = webpSample }()
+	decoderSamples["webp"] = decoderSamples["gif"]
+	if err := CheckDecoders([]string{"png", "webp"}); err == nil || !strings.Contains(err.Error(), "webp") {
+		t.Errorf("expected an error for webp when its sample decodes as another format, got %v", err)
+	}
+	decoderSamples["webp"] = []byte("RIFF")
+	if err := CheckDecoders([]string{"webp"}); err == nil {
+		t.Error("expected an error for webp when its sample does not decode")
+	}
+}
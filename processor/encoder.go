@@ -0,0 +1,332 @@
+package processor
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"sort"
+	"strings"
+	"sync"
+
+	"image-converting-server/config"
+
+	"github.com/chai2010/webp"
+)
+
+// Additional output format names registered by default
+const (
+	FormatWebPLossless    = "webp-lossless"
+	FormatJPEG            = "jpeg"
+	FormatJPEGProgressive = "jpeg-progressive"
+	FormatPNG             = "png"
+)
+
+// defaultQuality is used when neither the request nor the config sets a quality
+const defaultQuality = 85
+
+// Encoder encodes an image into a specific output format
+type Encoder interface {
+	// Encode encodes the image using the given options
+	Encode(img image.Image, opts EncodeOptions) ([]byte, error)
+	// Extension returns the file extension for the format, including the dot
+	Extension() string
+	// ContentType returns the MIME type for the format
+	ContentType() string
+}
+
+// availabilityChecker is implemented by encoders that depend on external tools
+type availabilityChecker interface {
+	Available() bool
+}
+
+// EncodeOptions contains per-call encoding parameters
+type EncodeOptions struct {
+	Quality  int       // 0 uses the encoder's configured quality
+	Metadata *Metadata // Metadata to embed in the output; nil strips all metadata
+
+	Context context.Context // Cancels external encoders such as avifenc; nil means no cancellation
+}
+
+// EncoderFactory creates an Encoder from the application configuration
+type EncoderFactory func(cfg config.Config) Encoder
+
+var (
+	encoderMu        sync.RWMutex
+	encoderFactories = map[string]EncoderFactory{}
+)
+
+// RegisterEncoder registers an encoder factory under the given format name.
+// Registering an existing name replaces the previous factory.
+func RegisterEncoder(name string, factory EncoderFactory) {
+	encoderMu.Lock()
+	defer encoderMu.Unlock()
+	encoderFactories[strings.ToLower(name)] = factory
+}
+
+// RegisteredFormats returns the sorted names of all registered output formats
+func RegisteredFormats() []string {
+	encoderMu.RLock()
+	defer encoderMu.RUnlock()
+	names := make([]string, 0, len(encoderFactories))
+	for name := range encoderFactories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// IsOutputFormat reports whether an encoder is registered for the format
+func IsOutputFormat(format string) bool {
+	encoderMu.RLock()
+	defer encoderMu.RUnlock()
+	_, ok := encoderFactories[normalizeFormat(format)]
+	return ok
+}
+
+// normalizeFormat maps format aliases to their registered names
+func normalizeFormat(format string) string {
+	format = strings.ToLower(strings.TrimSpace(format))
+	switch format {
+	case "jpg":
+		return FormatJPEG
+	case "jpg-progressive":
+		return FormatJPEGProgressive
+	}
+	return format
+}
+
+// Encoder returns the encoder for the given format.
+// An empty format resolves to conversion.output_format.
+func (p *Processor) Encoder(format string) (Encoder, error) {
+	name := p.OutputFormat(ProcessOptions{Format: format})
+
+	encoderMu.RLock()
+	factory, ok := encoderFactories[name]
+	encoderMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unsupported output format: %s", name)
+	}
+	return factory(p.cfg), nil
+}
+
+// CanEncode reports whether the format is registered and its encoder is usable
+// on this host (for example, whether avifenc is installed)
+func (p *Processor) CanEncode(format string) bool {
+	encoder, err := p.Encoder(format)
+	if err != nil {
+		return false
+	}
+	if checker, ok := encoder.(availabilityChecker); ok {
+		return checker.Available()
+	}
+	return true
+}
+
+func init() {
+	RegisterEncoder(FormatWebP, func(cfg config.Config) Encoder {
+		return &webpEncoder{quality: cfg.Conversion.Quality}
+	})
+	RegisterEncoder(FormatWebPLossless, func(cfg config.Config) Encoder {
+		return &webpEncoder{lossless: true}
+	})
+	RegisterEncoder(FormatAVIF, func(cfg config.Config) Encoder {
+		return &avifEncoder{cfg: cfg.Conversion.AVIF}
+	})
+	RegisterEncoder(FormatJPEG, func(cfg config.Config) Encoder {
+		return &jpegEncoder{quality: cfg.Conversion.Quality}
+	})
+	RegisterEncoder(FormatJPEGProgressive, func(cfg config.Config) Encoder {
+		return &jpegEncoder{quality: cfg.Conversion.Quality, progressive: true}
+	})
+	RegisterEncoder(FormatPNG, func(cfg config.Config) Encoder {
+		return &pngEncoder{}
+	})
+}
+
+// webpEncoder encodes lossy or lossless WebP
+type webpEncoder struct {
+	quality  int
+	lossless bool
+}
+
+func (e *webpEncoder) Encode(img image.Image, opts EncodeOptions) ([]byte, error) {
+	quality := opts.Quality
+	if quality == 0 {
+		quality = e.quality
+	}
+	if quality == 0 && !e.lossless {
+		quality = defaultQuality
+	}
+
+	var buf bytes.Buffer
+	err := webp.Encode(&buf, img, &webp.Options{
+		Lossless: e.lossless,
+		Quality:  float32(quality),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return insertWebPMetadata(buf.Bytes(), opts.Metadata)
+}
+
+// insertWebPMetadata adds EXIF, XMP and ICCP chunks to an encoded WebP
+func insertWebPMetadata(data []byte, md *Metadata) ([]byte, error) {
+	if md.IsEmpty() {
+		return data, nil
+	}
+	chunks := []struct {
+		format string
+		data   []byte
+	}{
+		{"ICCP", md.ICC},
+		{"EXIF", md.EXIF},
+		{"XMP", md.XMP},
+	}
+	for _, chunk := range chunks {
+		if len(chunk.data) == 0 {
+			continue
+		}
+		var err error
+		if data, err = webp.SetMetadata(data, chunk.data, chunk.format); err != nil {
+			return nil, fmt.Errorf("failed to write %s metadata: %w", chunk.format, err)
+		}
+	}
+	return data, nil
+}
+
+func (e *webpEncoder) Extension() string   { return ".webp" }
+func (e *webpEncoder) ContentType() string { return "image/webp" }
+
+// jpegEncoder encodes baseline or progressive JPEG.
+// JPEG has no alpha channel, so transparent pixels are flattened onto white.
+type jpegEncoder struct {
+	quality     int
+	progressive bool
+}
+
+func (e *jpegEncoder) Encode(img image.Image, opts EncodeOptions) ([]byte, error) {
+	quality := opts.Quality
+	if quality == 0 {
+		quality = e.quality
+	}
+	if quality == 0 {
+		quality = defaultQuality
+	}
+
+	img = flattenAlpha(img, color.White)
+
+	var buf bytes.Buffer
+	var err error
+	if e.progressive {
+		err = encodeProgressiveJPEG(&buf, img, quality)
+	} else {
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality})
+	}
+	if err != nil {
+		return nil, err
+	}
+	return insertJPEGMetadata(buf.Bytes(), opts.Metadata), nil
+}
+
+func (e *jpegEncoder) Extension() string   { return ".jpg" }
+func (e *jpegEncoder) ContentType() string { return "image/jpeg" }
+
+// pngEncoder encodes size-optimized PNG.
+// Images with few colors are written as paletted PNG and grayscale images
+// as single-channel PNG, both of which are lossless.
+type pngEncoder struct{}
+
+func (e *pngEncoder) Encode(img image.Image, opts EncodeOptions) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := png.Encoder{CompressionLevel: png.BestCompression}
+	if err := encoder.Encode(&buf, optimizeForPNG(img)); err != nil {
+		return nil, err
+	}
+	return insertPNGMetadata(buf.Bytes(), opts.Metadata), nil
+}
+
+func (e *pngEncoder) Extension() string   { return ".png" }
+func (e *pngEncoder) ContentType() string { return "image/png" }
+
+// flattenAlpha composites the image onto a solid background if it has transparency
+func flattenAlpha(img image.Image, background color.Color) image.Image {
+	if isOpaque(img) {
+		return img
+	}
+	bounds := img.Bounds()
+	dst := image.NewRGBA(bounds)
+	draw.Draw(dst, bounds, image.NewUniform(background), image.Point{}, draw.Src)
+	draw.Draw(dst, bounds, img, bounds.Min, draw.Over)
+	return dst
+}
+
+// isOpaque reports whether every pixel of the image is fully opaque
+func isOpaque(img image.Image) bool {
+	if o, ok := img.(interface{ Opaque() bool }); ok {
+		return o.Opaque()
+	}
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if _, _, _, a := img.At(x, y).RGBA(); a != 0xffff {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// optimizeForPNG converts the image to the smallest lossless PNG color type.
+// 16-bit images are kept as they are, since every reduced type has 8-bit samples.
+func optimizeForPNG(img image.Image) image.Image {
+	switch img.(type) {
+	case *image.NRGBA64, *image.RGBA64, *image.Gray16:
+		return img
+	}
+	bounds := img.Bounds()
+	opaque := isOpaque(img)
+
+	gray := opaque
+	seen := make(map[color.NRGBA]uint8)
+	palette := make(color.Palette, 0, 256)
+	for y := bounds.Min.Y; y < bounds.Max.Y && (gray || palette != nil); y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			if gray && (c.R != c.G || c.G != c.B) {
+				gray = false
+			}
+			if palette != nil {
+				if _, ok := seen[c]; !ok {
+					if len(palette) == 256 {
+						palette = nil
+						continue
+					}
+					seen[c] = uint8(len(palette))
+					palette = append(palette, c)
+				}
+			}
+		}
+	}
+
+	switch {
+	case gray:
+		dst := image.NewGray(bounds)
+		draw.Draw(dst, bounds, img, bounds.Min, draw.Src)
+		return dst
+	case palette != nil:
+		dst := image.NewPaletted(bounds, palette)
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+				dst.SetColorIndex(x, y, seen[c])
+			}
+		}
+		return dst
+	}
+	return img
+}
@@ -0,0 +1,212 @@
+package processor
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+
+	"image-converting-server/config"
+
+	"github.com/chai2010/webp"
+)
+
+func TestProcessor_Encoder(t *testing.T) {
+	p := NewProcessor(config.Config{
+		Conversion: config.ConversionConfig{
+			Formats: []string{"png", "jpeg"},
+			Quality: 80,
+		},
+	})
+
+	tests := []struct {
+		format      string
+		extension   string
+		contentType string
+	}{
+		{"", ".webp", "image/webp"},
+		{"webp", ".webp", "image/webp"},
+		{"webp-lossless", ".webp", "image/webp"},
+		{"jpeg", ".jpg", "image/jpeg"},
+		{"jpg", ".jpg", "image/jpeg"},
+		{"jpeg-progressive", ".jpg", "image/jpeg"},
+		{"png", ".png", "image/png"},
+		{"avif", ".avif", "image/avif"},
+	}
+	for _, tt := range tests {
+		enc, err := p.Encoder(tt.format)
+		if err != nil {
+			t.Errorf("Encoder(%q) failed: %v", tt.format, err)
+			continue
+		}
+		if enc.Extension() != tt.extension || enc.ContentType() != tt.contentType {
+			t.Errorf("Encoder(%q) = %s %s, want %s %s", tt.format, enc.Extension(), enc.ContentType(), tt.extension, tt.contentType)
+		}
+	}
+
+	if _, err := p.Encoder("heic"); err == nil {
+		t.Error("expected error for unregistered format")
+	}
+	if IsOutputFormat("heic") {
+		t.Error("heic should not be a registered output format")
+	}
+}
+
+func TestRegisterEncoder(t *testing.T) {
+	RegisterEncoder("test-raw", func(cfg config.Config) Encoder { return &pngEncoder{} })
+	defer func() {
+		encoderMu.Lock()
+		delete(encoderFactories, "test-raw")
+		encoderMu.Unlock()
+	}()
+
+	p := NewProcessor(config.Config{Conversion: config.ConversionConfig{Formats: []string{"png"}}})
+	data := imageToBytes(t, createTestImage(8, 8), "png")
+	out, _, err := p.Process(data, ProcessOptions{Format: "test-raw"})
+	if err != nil {
+		t.Fatalf("Process with custom encoder failed: %v", err)
+	}
+	if GetMimeType(out) != "image/png" {
+		t.Errorf("expected custom encoder output, got %s", GetMimeType(out))
+	}
+}
+
+func TestEncoders(t *testing.T) {
+	p := NewProcessor(config.Config{
+		Conversion: config.ConversionConfig{
+			Formats: []string{"png", "jpeg"},
+			Quality: 80,
+		},
+	})
+	img := createTestImage(40, 24)
+	data := imageToBytes(t, img, "png")
+
+	t.Run("Lossless WebP", func(t *testing.T) {
+		out, _, err := p.Process(data, ProcessOptions{Format: "webp-lossless"})
+		if err != nil {
+			t.Fatalf("Process failed: %v", err)
+		}
+		decoded, err := webp.Decode(bytes.NewReader(out))
+		if err != nil {
+			t.Fatalf("failed to decode result: %v", err)
+		}
+		for y := 0; y < 24; y++ {
+			for x := 0; x < 40; x++ {
+				r1, g1, b1, _ := img.At(x, y).RGBA()
+				r2, g2, b2, _ := decoded.At(x, y).RGBA()
+				if r1 != r2 || g1 != g2 || b1 != b2 {
+					t.Fatalf("pixel (%d,%d) changed in lossless output", x, y)
+				}
+			}
+		}
+	})
+
+	t.Run("Baseline JPEG", func(t *testing.T) {
+		out, _, err := p.Process(data, ProcessOptions{Format: "jpeg"})
+		if err != nil {
+			t.Fatalf("Process failed: %v", err)
+		}
+		if bytes.Contains(out, []byte{0xff, 0xc2}) {
+			t.Error("baseline JPEG should not contain a progressive SOF2 marker")
+		}
+	})
+
+	t.Run("Progressive JPEG", func(t *testing.T) {
+		out, _, err := p.Process(data, ProcessOptions{Format: "jpeg-progressive"})
+		if err != nil {
+			t.Fatalf("Process failed: %v", err)
+		}
+		if !bytes.Contains(out, []byte{0xff, 0xc2}) {
+			t.Error("expected a progressive SOF2 marker")
+		}
+		decoded, format, err := image.Decode(bytes.NewReader(out))
+		if err != nil {
+			t.Fatalf("failed to decode progressive JPEG: %v", err)
+		}
+		if format != "jpeg" {
+			t.Errorf("expected jpeg, got %s", format)
+		}
+		if decoded.Bounds().Dx() != 40 || decoded.Bounds().Dy() != 24 {
+			t.Errorf("expected 40x24, got %v", decoded.Bounds())
+		}
+		// Lossy, but colors should stay close to the source
+		r1, g1, _, _ := img.At(30, 20).RGBA()
+		r2, g2, _, _ := decoded.At(30, 20).RGBA()
+		if diff(r1>>8, r2>>8) > 16 || diff(g1>>8, g2>>8) > 16 {
+			t.Errorf("decoded color drifted too far: got %d,%d want %d,%d", r2>>8, g2>>8, r1>>8, g1>>8)
+		}
+	})
+
+	t.Run("Optimized PNG", func(t *testing.T) {
+		// Two-color image should be written as a paletted PNG
+		twoColor := image.NewRGBA(image.Rect(0, 0, 32, 32))
+		for y := 0; y < 32; y++ {
+			for x := 0; x < 32; x++ {
+				c := color.RGBA{255, 0, 0, 255}
+				if x < 16 {
+					c = color.RGBA{0, 0, 255, 255}
+				}
+				twoColor.Set(x, y, c)
+			}
+		}
+		enc, _ := p.Encoder("png")
+		out, err := enc.Encode(twoColor, EncodeOptions{})
+		if err != nil {
+			t.Fatalf("Encode failed: %v", err)
+		}
+		decoded, err := png.Decode(bytes.NewReader(out))
+		if err != nil {
+			t.Fatalf("failed to decode result: %v", err)
+		}
+		if _, ok := decoded.(*image.Paletted); !ok {
+			t.Errorf("expected paletted PNG, got %T", decoded)
+		}
+		if r, _, b, _ := decoded.At(0, 0).RGBA(); r != 0 || b != 0xffff {
+			t.Error("palette conversion changed pixel colors")
+		}
+	})
+
+	t.Run("16-bit PNG", func(t *testing.T) {
+		// Few colors, but with values that need 16 bits
+		rgba := image.NewNRGBA64(image.Rect(0, 0, 8, 8))
+		gray := image.NewGray16(image.Rect(0, 0, 8, 8))
+		for y := 0; y < 8; y++ {
+			for x := 0; x < 8; x++ {
+				v := uint16(0x1234 + 0x0101*x)
+				rgba.SetNRGBA64(x, y, color.NRGBA64{v, 0x8001, 0xfffe, 0x7fff})
+				gray.SetGray16(x, y, color.Gray16{v})
+			}
+		}
+		for _, src := range []image.Image{rgba, gray} {
+			out, _, err := p.Process(imageToBytes(t, src, "png"), ProcessOptions{Format: "png"})
+			if err != nil {
+				t.Fatalf("Process failed: %v", err)
+			}
+			decoded, err := png.Decode(bytes.NewReader(out))
+			if err != nil {
+				t.Fatalf("failed to decode result: %v", err)
+			}
+			for y := 0; y < 8; y++ {
+				for x := 0; x < 8; x++ {
+					if got, want := decoded.At(x, y), src.At(x, y); !sameColor(got, want) {
+						t.Fatalf("%T: pixel (%d,%d) changed from %v to %v", src, x, y, want, got)
+					}
+				}
+			}
+		}
+	})
+}
+
+func sameColor(a, b color.Color) bool {
+	r1, g1, b1, a1 := a.RGBA()
+	r2, g2, b2, a2 := b.RGBA()
+	return r1 == r2 && g1 == g2 && b1 == b2 && a1 == a2
+}
+
+func diff(a, b uint32) uint32 {
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
@@ -0,0 +1,218 @@
+package processor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// EXIF holds the commonly used fields of an EXIF block
+type EXIF struct {
+	Make         string   `json:"make,omitempty"`
+	Model        string   `json:"model,omitempty"`
+	LensModel    string   `json:"lens_model,omitempty"`
+	Software     string   `json:"software,omitempty"`
+	DateTime     string   `json:"date_time,omitempty"` // DateTimeOriginal, else DateTime, as "2006:01:02 15:04:05"
+	Orientation  int      `json:"orientation,omitempty"`
+	ExposureTime string   `json:"exposure_time,omitempty"` // Seconds, e.g. "1/125"
+	FNumber      float64  `json:"f_number,omitempty"`
+	ISO          int      `json:"iso,omitempty"`
+	FocalLength  float64  `json:"focal_length,omitempty"` // Millimeters
+	Latitude     *float64 `json:"latitude,omitempty"`     // Degrees, negative south
+	Longitude    *float64 `json:"longitude,omitempty"`    // Degrees, negative west
+}
+
+// EXIF tags read by ParseEXIF
+const (
+	tagMake             = 0x010F
+	tagModel            = 0x0110
+	tagOrientation      = 0x0112
+	tagSoftware         = 0x0131
+	tagDateTime         = 0x0132
+	tagExifIFD          = 0x8769
+	tagGPSIFD           = 0x8825
+	tagExposureTime     = 0x829A
+	tagFNumber          = 0x829D
+	tagISO              = 0x8827
+	tagDateTimeOriginal = 0x9003
+	tagFocalLength      = 0x920A
+	tagLensModel        = 0xA434
+	tagGPSLatitudeRef   = 0x0001
+	tagGPSLatitude      = 0x0002
+	tagGPSLongitudeRef  = 0x0003
+	tagGPSLongitude     = 0x0004
+)
+
+// tiffTypeSizes are the byte sizes of the TIFF field types, by type number
+var tiffTypeSizes = map[uint16]int{1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8}
+
+// ifdEntry is one field of an IFD, with its value bytes resolved
+type ifdEntry struct {
+	typ   uint16
+	count int
+	value []byte
+}
+
+// exifReader reads IFDs from a TIFF-structured EXIF block
+type exifReader struct {
+	data  []byte
+	order binary.ByteOrder
+}
+
+// ParseEXIF reads the fields of EXIF from a TIFF-structured EXIF block, as found
+// in Metadata.EXIF. Missing or malformed fields are left empty.
+func ParseEXIF(data []byte) (*EXIF, error) {
+	if len(data) < 8 {
+		return nil, fmt.Errorf("truncated exif")
+	}
+	r := &exifReader{data: data}
+	switch string(data[:4]) {
+	case "II*\x00":
+		r.order = binary.LittleEndian
+	case "MM\x00*":
+		r.order = binary.BigEndian
+	default:
+		return nil, fmt.Errorf("invalid exif header")
+	}
+
+	ifd0, err := r.readIFD(int(r.order.Uint32(data[4:])))
+	if err != nil {
+		return nil, err
+	}
+	exif := &EXIF{
+		Make:     r.ascii(ifd0[tagMake]),
+		Model:    r.ascii(ifd0[tagModel]),
+		Software: r.ascii(ifd0[tagSoftware]),
+		DateTime: r.ascii(ifd0[tagDateTime]),
+	}
+	if v, ok := r.uint(ifd0[tagOrientation]); ok && v >= 1 && v <= 8 {
+		exif.Orientation = v
+	}
+
+	// Sub-IFDs that fail to parse are skipped, keeping the fields read so far
+	if offset, ok := r.uint(ifd0[tagExifIFD]); ok {
+		if sub, err := r.readIFD(offset); err == nil {
+			if s := r.ascii(sub[tagDateTimeOriginal]); s != "" {
+				exif.DateTime = s
+			}
+			exif.LensModel = r.ascii(sub[tagLensModel])
+			if num, den, ok := r.rational(sub[tagExposureTime], 0); ok && den != 0 {
+				exif.ExposureTime = formatExposure(num, den)
+			}
+			exif.FNumber = r.float(sub[tagFNumber])
+			exif.FocalLength = r.float(sub[tagFocalLength])
+			if v, ok := r.uint(sub[tagISO]); ok {
+				exif.ISO = v
+			}
+		}
+	}
+	if offset, ok := r.uint(ifd0[tagGPSIFD]); ok {
+		if gps, err := r.readIFD(offset); err == nil {
+			exif.Latitude = r.coordinate(gps[tagGPSLatitude], gps[tagGPSLatitudeRef], "S")
+			exif.Longitude = r.coordinate(gps[tagGPSLongitude], gps[tagGPSLongitudeRef], "W")
+		}
+	}
+	return exif, nil
+}
+
+// readIFD reads the entries of the IFD at offset, keyed by tag
+func (r *exifReader) readIFD(offset int) (map[uint16]*ifdEntry, error) {
+	if offset < 8 || offset+2 > len(r.data) {
+		return nil, fmt.Errorf("ifd offset %d out of range", offset)
+	}
+	count := int(r.order.Uint16(r.data[offset:]))
+	entries := make(map[uint16]*ifdEntry, count)
+	for i := 0; i < count; i++ {
+		pos := offset + 2 + i*12
+		if pos+12 > len(r.data) {
+			break
+		}
+		typ := r.order.Uint16(r.data[pos+2:])
+		size, ok := tiffTypeSizes[typ]
+		if !ok {
+			continue
+		}
+		n := int(r.order.Uint32(r.data[pos+4:]))
+		length := n * size
+		if length > len(r.data) {
+			continue
+		}
+		// Values of up to 4 bytes are stored inline, larger ones at an offset
+		start := pos + 8
+		if length > 4 {
+			start = int(r.order.Uint32(r.data[pos+8:]))
+			if start < 0 || start+length > len(r.data) {
+				continue
+			}
+		}
+		entries[r.order.Uint16(r.data[pos:])] = &ifdEntry{typ: typ, count: n, value: r.data[start : start+length]}
+	}
+	return entries, nil
+}
+
+func (r *exifReader) ascii(e *ifdEntry) string {
+	if e == nil || e.typ != 2 {
+		return ""
+	}
+	value, _, _ := bytes.Cut(e.value, []byte{0})
+	return string(bytes.TrimSpace(value))
+}
+
+// uint returns the first value of a BYTE, SHORT or LONG field
+func (r *exifReader) uint(e *ifdEntry) (int, bool) {
+	if e == nil || e.count == 0 {
+		return 0, false
+	}
+	switch e.typ {
+	case 1:
+		return int(e.value[0]), true
+	case 3:
+		return int(r.order.Uint16(e.value)), true
+	case 4:
+		return int(r.order.Uint32(e.value)), true
+	}
+	return 0, false
+}
+
+// rational returns the i-th numerator and denominator of a RATIONAL field
+func (r *exifReader) rational(e *ifdEntry, i int) (uint32, uint32, bool) {
+	if e == nil || e.typ != 5 || i >= e.count {
+		return 0, 0, false
+	}
+	return r.order.Uint32(e.value[i*8:]), r.order.Uint32(e.value[i*8+4:]), true
+}
+
+func (r *exifReader) float(e *ifdEntry) float64 {
+	num, den, ok := r.rational(e, 0)
+	if !ok || den == 0 {
+		return 0
+	}
+	return float64(num) / float64(den)
+}
+
+// coordinate converts a GPS degrees, minutes, seconds field to signed degrees
+func (r *exifReader) coordinate(e, ref *ifdEntry, negativeRef string) *float64 {
+	var degrees float64
+	for i, scale := range []float64{1, 60, 3600} {
+		num, den, ok := r.rational(e, i)
+		if !ok || den == 0 {
+			return nil
+		}
+		degrees += float64(num) / float64(den) / scale
+	}
+	if r.ascii(ref) == negativeRef {
+		degrees = -degrees
+	}
+	degrees = math.Round(degrees*1e6) / 1e6
+	return &degrees
+}
+
+// formatExposure formats an exposure time as a fraction below one second, e.g. "1/125"
+func formatExposure(num, den uint32) string {
+	if num > 0 && num < den {
+		return "1/" + strconv.FormatFloat(math.Round(float64(den)/float64(num)), 'f', -1, 64)
+	}
+	return strconv.FormatFloat(float64(num)/float64(den), 'f', -1, 64)
+}
@@ -0,0 +1,123 @@
+package processor
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// exifField is one IFD entry for buildEXIF; values over 4 bytes are stored after the IFDs
+type exifField struct {
+	tag, typ uint16
+	count    uint32
+	value    []byte
+}
+
+// buildEXIF builds a big-endian EXIF block with IFD0, an Exif sub-IFD and a GPS IFD
+func buildEXIF(ifd0, sub, gps []exifField) []byte {
+	be := binary.BigEndian
+	ifdSize := func(fields []exifField) int { return 2 + 12*len(fields) + 4 }
+	// IFD0 gains the two sub-IFD pointers
+	ifd0Offset := 8
+	subOffset := ifd0Offset + ifdSize(ifd0) + 24
+	gpsOffset := subOffset + ifdSize(sub)
+	dataOffset := gpsOffset + ifdSize(gps)
+
+	ifd0 = append(ifd0,
+		exifField{tagExifIFD, 4, 1, be.AppendUint32(nil, uint32(subOffset))},
+		exifField{tagGPSIFD, 4, 1, be.AppendUint32(nil, uint32(gpsOffset))})
+	out := []byte("MM\x00*")
+	out = be.AppendUint32(out, uint32(ifd0Offset))
+	var extra []byte
+	for _, fields := range [][]exifField{ifd0, sub, gps} {
+		out = be.AppendUint16(out, uint16(len(fields)))
+		for _, f := range fields {
+			out = be.AppendUint16(out, f.tag)
+			out = be.AppendUint16(out, f.typ)
+			out = be.AppendUint32(out, f.count)
+			if len(f.value) > 4 {
+				out = be.AppendUint32(out, uint32(dataOffset+len(extra)))
+				extra = append(extra, f.value...)
+			} else {
+				out = append(out, f.value...)
+				out = append(out, make([]byte, 4-len(f.value))...)
+			}
+		}
+		out = be.AppendUint32(out, 0)
+	}
+	return append(out, extra...)
+}
+
+func asciiField(tag uint16, s string) exifField {
+	return exifField{tag, 2, uint32(len(s) + 1), append([]byte(s), 0)}
+}
+
+func rationalField(tag uint16, values ...uint32) exifField {
+	var b []byte
+	for _, v := range values {
+		b = binary.BigEndian.AppendUint32(b, v)
+	}
+	return exifField{tag, 5, uint32(len(values) / 2), b}
+}
+
+func TestParseEXIF(t *testing.T) {
+	data := buildEXIF(
+		[]exifField{
+			asciiField(tagMake, "Canon"),
+			asciiField(tagModel, "EOS R5"),
+			exifField{tagOrientation, 3, 1, []byte{0, 6}},
+			asciiField(tagDateTime, "2024:01:02 10:00:00"),
+		},
+		[]exifField{
+			rationalField(tagExposureTime, 1, 250),
+			rationalField(tagFNumber, 28, 10),
+			exifField{tagISO, 3, 1, []byte{0x01, 0x90}},
+			asciiField(tagDateTimeOriginal, "2024:01:01 09:30:00"),
+			rationalField(tagFocalLength, 50, 1),
+			asciiField(tagLensModel, "RF50mm F1.8 STM"),
+		},
+		[]exifField{
+			asciiField(tagGPSLatitudeRef, "N"),
+			rationalField(tagGPSLatitude, 37, 1, 33, 1, 36, 1),
+			asciiField(tagGPSLongitudeRef, "W"),
+			rationalField(tagGPSLongitude, 122, 1, 30, 1, 0, 1),
+		},
+	)
+
+	exif, err := ParseEXIF(data)
+	if err != nil {
+		t.Fatalf("ParseEXIF failed: %v", err)
+	}
+	if exif.Make != "Canon" || exif.Model != "EOS R5" || exif.LensModel != "RF50mm F1.8 STM" {
+		t.Errorf("unexpected camera fields: %+v", exif)
+	}
+	if exif.Orientation != 6 || exif.DateTime != "2024:01:01 09:30:00" {
+		t.Errorf("expected orientation 6 and the original date, got %d and %q", exif.Orientation, exif.DateTime)
+	}
+	if exif.ExposureTime != "1/250" || exif.FNumber != 2.8 || exif.ISO != 400 || exif.FocalLength != 50 {
+		t.Errorf("unexpected exposure fields: %+v", exif)
+	}
+	if exif.Latitude == nil || *exif.Latitude != 37.56 || exif.Longitude == nil || *exif.Longitude != -122.5 {
+		t.Errorf("unexpected GPS position: %v, %v", exif.Latitude, exif.Longitude)
+	}
+}
+
+func TestParseEXIF_Malformed(t *testing.T) {
+	if _, err := ParseEXIF([]byte("not exif")); err == nil {
+		t.Error("expected an error for an invalid header")
+	}
+
+	// The orientation-only block has no sub-IFDs; truncating it loses the field, not the parse
+	exif, err := ParseEXIF(makeEXIF(3))
+	if err != nil || exif.Orientation != 3 || exif.Latitude != nil {
+		t.Errorf("expected orientation 3 only, got %+v, %v", exif, err)
+	}
+	data := makeEXIF(3)
+	exif, err = ParseEXIF(data[:len(data)-8])
+	if err != nil || exif.Orientation != 0 {
+		t.Errorf("expected a truncated entry to be skipped, got %+v, %v", exif, err)
+	}
+
+	if got := formatExposure(2, 1); got != "2" {
+		t.Errorf("expected a 2 second exposure, got %s", got)
+	}
+}
@@ -0,0 +1,297 @@
+package processor
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"math"
+
+	"github.com/disintegration/imaging"
+)
+
+// Color profile modes
+const (
+	ColorProfileSRGB  = "srgb"  // Convert pixels to sRGB and drop the profile (default)
+	ColorProfileEmbed = "embed" // Keep the pixels and embed the source profile in the output
+)
+
+// errUnsupportedProfile is returned for ICC profiles that are not RGB matrix/TRC profiles
+var errUnsupportedProfile = errors.New("unsupported ICC profile")
+
+// srgbToXYZ is the sRGB to D50 XYZ matrix used by the standard sRGB ICC profile
+var srgbToXYZ = [3][3]float64{
+	{0.4360747, 0.3850649, 0.1430804},
+	{0.2225045, 0.7168786, 0.0606169},
+	{0.0139322, 0.0971045, 0.7141733},
+}
+
+var xyzToSRGB = invert3x3(srgbToXYZ)
+
+// iccProfile is a parsed RGB matrix/TRC ICC profile, the kind used by
+// Display P3, Adobe RGB, ProPhoto RGB and sRGB
+type iccProfile struct {
+	toXYZ  [3][3]float64 // Linear RGB to D50 XYZ; columns are the colorants
+	curves [3]iccCurve   // Tone reproduction curves for R, G and B
+}
+
+// parseICCProfile parses the colorant and TRC tags of an RGB display profile
+func parseICCProfile(data []byte) (*iccProfile, error) {
+	if len(data) < 132 {
+		return nil, fmt.Errorf("%w: too short", errUnsupportedProfile)
+	}
+	if string(data[16:20]) != "RGB " || string(data[20:24]) != "XYZ " {
+		return nil, fmt.Errorf("%w: color space %q, PCS %q", errUnsupportedProfile, data[16:20], data[20:24])
+	}
+
+	tags := map[string][]byte{}
+	count := int(binary.BigEndian.Uint32(data[128:]))
+	for i := 0; i < count; i++ {
+		entry := 132 + i*12
+		if entry+12 > len(data) {
+			return nil, fmt.Errorf("%w: truncated tag table", errUnsupportedProfile)
+		}
+		offset := int(binary.BigEndian.Uint32(data[entry+4:]))
+		size := int(binary.BigEndian.Uint32(data[entry+8:]))
+		if offset < 0 || size < 0 || offset+size > len(data) {
+			return nil, fmt.Errorf("%w: tag outside profile", errUnsupportedProfile)
+		}
+		tags[string(data[entry:entry+4])] = data[offset : offset+size]
+	}
+
+	profile := &iccProfile{}
+	for i, name := range []string{"r", "g", "b"} {
+		xyz, err := parseXYZTag(tags[name+"XYZ"])
+		if err != nil {
+			return nil, err
+		}
+		for row := 0; row < 3; row++ {
+			profile.toXYZ[row][i] = xyz[row]
+		}
+		if profile.curves[i], err = parseCurveTag(tags[name+"TRC"]); err != nil {
+			return nil, err
+		}
+	}
+	return profile, nil
+}
+
+// isSRGB reports whether the profile describes sRGB, in which case no conversion is needed
+func (p *iccProfile) isSRGB() bool {
+	for row := 0; row < 3; row++ {
+		for col := 0; col < 3; col++ {
+			if math.Abs(p.toXYZ[row][col]-srgbToXYZ[row][col]) > 0.002 {
+				return false
+			}
+		}
+	}
+	for _, curve := range p.curves {
+		for _, x := range []float64{0.02, 0.2, 0.5, 0.8} {
+			if math.Abs(curve.eval(x)-srgbToLinear(x)) > 0.002 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// toSRGB converts an image whose pixels are in this profile's color space to sRGB.
+// Out-of-gamut colors are clipped.
+func (p *iccProfile) toSRGB(img image.Image) image.Image {
+	// Combined matrix: source linear RGB -> XYZ -> sRGB linear
+	m := multiply3x3(xyzToSRGB, p.toXYZ)
+
+	var linear [3][256]float64
+	for c := 0; c < 3; c++ {
+		for v := 0; v < 256; v++ {
+			linear[c][v] = p.curves[c].eval(float64(v) / 255)
+		}
+	}
+	const encodeSize = 4096
+	var encode [encodeSize + 1]uint8
+	for i := range encode {
+		encode[i] = uint8(math.Round(linearToSRGB(float64(i)/encodeSize) * 255))
+	}
+	toByte := func(v float64) uint8 {
+		if math.IsNaN(v) {
+			return encode[0]
+		}
+		return encode[int(math.Round(math.Max(0, math.Min(1, v))*encodeSize))]
+	}
+
+	dst := imaging.Clone(img)
+	for i := 0; i+3 < len(dst.Pix); i += 4 {
+		r := linear[0][dst.Pix[i]]
+		g := linear[1][dst.Pix[i+1]]
+		b := linear[2][dst.Pix[i+2]]
+		dst.Pix[i] = toByte(m[0][0]*r + m[0][1]*g + m[0][2]*b)
+		dst.Pix[i+1] = toByte(m[1][0]*r + m[1][1]*g + m[1][2]*b)
+		dst.Pix[i+2] = toByte(m[2][0]*r + m[2][1]*g + m[2][2]*b)
+	}
+	return dst
+}
+
+// iccCurve is a parsed curveType or parametricCurveType tag
+type iccCurve struct {
+	table  []float64 // Sampled curve; nil for gamma and parametric curves
+	gamma  float64
+	kind   int // Parametric function type, or -1 for curveType
+	params [7]float64
+}
+
+// eval maps an encoded value in [0, 1] to linear light
+func (c iccCurve) eval(x float64) float64 {
+	if c.table != nil {
+		pos := x * float64(len(c.table)-1)
+		i := int(pos)
+		if i >= len(c.table)-1 {
+			return c.table[len(c.table)-1]
+		}
+		frac := pos - float64(i)
+		return c.table[i]*(1-frac) + c.table[i+1]*frac
+	}
+
+	g, a, b, cc, d, e, f := c.params[0], c.params[1], c.params[2], c.params[3], c.params[4], c.params[5], c.params[6]
+	switch c.kind {
+	case -1:
+		return math.Pow(x, c.gamma)
+	case 0:
+		return math.Pow(x, g)
+	case 1:
+		if x >= -b/a {
+			return math.Pow(a*x+b, g)
+		}
+		return 0
+	case 2:
+		if x >= -b/a {
+			return math.Pow(a*x+b, g) + cc
+		}
+		return cc
+	case 3:
+		if x >= d {
+			return math.Pow(a*x+b, g)
+		}
+		return cc * x
+	case 4:
+		if x >= d {
+			return math.Pow(a*x+b, g) + e
+		}
+		return cc*x + f
+	}
+	return x
+}
+
+// valid reports whether the curve gives finite values on [0, 1] without raising
+// a negative base to its gamma
+func (c iccCurve) valid() bool {
+	a, b, d := c.params[1], c.params[2], c.params[4]
+	for i := 0; i <= 255; i++ {
+		x := float64(i) / 255
+		var powered bool
+		switch c.kind {
+		case 1, 2:
+			powered = x >= -b/a
+		case 3, 4:
+			powered = x >= d
+		}
+		if powered && (a*x+b < 0 || math.IsNaN(a*x+b)) {
+			return false
+		}
+		if v := c.eval(x); math.IsNaN(v) || math.IsInf(v, 0) {
+			return false
+		}
+	}
+	return true
+}
+
+func parseXYZTag(data []byte) ([3]float64, error) {
+	var xyz [3]float64
+	if len(data) < 20 || string(data[:4]) != "XYZ " {
+		return xyz, fmt.Errorf("%w: missing colorant tag", errUnsupportedProfile)
+	}
+	for i := range xyz {
+		xyz[i] = s15Fixed16(data[8+i*4:])
+	}
+	return xyz, nil
+}
+
+func parseCurveTag(data []byte) (iccCurve, error) {
+	if len(data) < 12 {
+		return iccCurve{}, fmt.Errorf("%w: missing TRC tag", errUnsupportedProfile)
+	}
+
+	switch string(data[:4]) {
+	case "curv":
+		n := int(binary.BigEndian.Uint32(data[8:]))
+		if len(data) < 12+n*2 {
+			return iccCurve{}, fmt.Errorf("%w: truncated curve", errUnsupportedProfile)
+		}
+		switch n {
+		case 0:
+			return iccCurve{kind: -1, gamma: 1}, nil
+		case 1:
+			return iccCurve{kind: -1, gamma: float64(binary.BigEndian.Uint16(data[12:])) / 256}, nil
+		}
+		table := make([]float64, n)
+		for i := range table {
+			table[i] = float64(binary.BigEndian.Uint16(data[12+i*2:])) / 65535
+		}
+		return iccCurve{table: table}, nil
+
+	case "para":
+		kind := int(binary.BigEndian.Uint16(data[8:]))
+		paramCounts := []int{1, 3, 4, 5, 7}
+		if kind >= len(paramCounts) || len(data) < 12+paramCounts[kind]*4 {
+			return iccCurve{}, fmt.Errorf("%w: parametric curve type %d", errUnsupportedProfile, kind)
+		}
+		curve := iccCurve{kind: kind}
+		for i := 0; i < paramCounts[kind]; i++ {
+			curve.params[i] = s15Fixed16(data[12+i*4:])
+		}
+		if !curve.valid() {
+			return iccCurve{}, fmt.Errorf("%w: parametric curve type %d is not finite on [0, 1]", errUnsupportedProfile, kind)
+		}
+		return curve, nil
+	}
+	return iccCurve{}, fmt.Errorf("%w: TRC type %q", errUnsupportedProfile, data[:4])
+}
+
+func s15Fixed16(b []byte) float64 {
+	return float64(int32(binary.BigEndian.Uint32(b))) / 65536
+}
+
+func srgbToLinear(v float64) float64 {
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+func linearToSRGB(v float64) float64 {
+	if v <= 0.0031308 {
+		return v * 12.92
+	}
+	return 1.055*math.Pow(v, 1/2.4) - 0.055
+}
+
+func multiply3x3(a, b [3][3]float64) [3][3]float64 {
+	var out [3][3]float64
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			for k := 0; k < 3; k++ {
+				out[i][j] += a[i][k] * b[k][j]
+			}
+		}
+	}
+	return out
+}
+
+func invert3x3(m [3][3]float64) [3][3]float64 {
+	det := m[0][0]*(m[1][1]*m[2][2]-m[1][2]*m[2][1]) -
+		m[0][1]*(m[1][0]*m[2][2]-m[1][2]*m[2][0]) +
+		m[0][2]*(m[1][0]*m[2][1]-m[1][1]*m[2][0])
+	return [3][3]float64{
+		{(m[1][1]*m[2][2] - m[1][2]*m[2][1]) / det, (m[0][2]*m[2][1] - m[0][1]*m[2][2]) / det, (m[0][1]*m[1][2] - m[0][2]*m[1][1]) / det},
+		{(m[1][2]*m[2][0] - m[1][0]*m[2][2]) / det, (m[0][0]*m[2][2] - m[0][2]*m[2][0]) / det, (m[0][2]*m[1][0] - m[0][0]*m[1][2]) / det},
+		{(m[1][0]*m[2][1] - m[1][1]*m[2][0]) / det, (m[0][1]*m[2][0] - m[0][0]*m[2][1]) / det, (m[0][0]*m[1][1] - m[0][1]*m[1][0]) / det},
+	}
+}
@@ -0,0 +1,204 @@
+package processor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"math"
+	"os"
+	"testing"
+
+	"image-converting-server/config"
+)
+
+// The fixtures in testdata are generated by testdata/gen_icc_fixtures.go.
+// Expected sRGB values were computed independently from the profile colorants.
+
+func readFixture(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile("testdata/" + name)
+	if err != nil {
+		t.Fatalf("failed to read fixture: %v", err)
+	}
+	return data
+}
+
+// assertColor checks the pixel at (x, y) against want, allowing for rounding and
+// lossy compression
+func assertColor(t *testing.T, img image.Image, x, y int, want [3]uint8, tolerance int) {
+	t.Helper()
+	r, g, b, _ := img.At(x, y).RGBA()
+	got := [3]uint8{uint8(r >> 8), uint8(g >> 8), uint8(b >> 8)}
+	for i := range want {
+		if int(diff(uint32(got[i]), uint32(want[i]))) > tolerance {
+			t.Errorf("pixel (%d,%d): expected %v, got %v", x, y, want, got)
+			return
+		}
+	}
+}
+
+func TestParseICCProfile(t *testing.T) {
+	tests := []struct {
+		fixture string
+		srgb    bool
+	}{
+		{"display-p3.jpg", false},
+		{"adobe-rgb.png", false},
+		{"srgb.png", true},
+	}
+	for _, tt := range tests {
+		md := ReadMetadata(readFixture(t, tt.fixture))
+		profile, err := parseICCProfile(md.ICC)
+		if err != nil {
+			t.Fatalf("%s: failed to parse profile: %v", tt.fixture, err)
+		}
+		if profile.isSRGB() != tt.srgb {
+			t.Errorf("%s: expected isSRGB %v", tt.fixture, tt.srgb)
+		}
+	}
+
+	if _, err := parseICCProfile([]byte("not a profile")); err == nil {
+		t.Error("expected an error for invalid profile data")
+	}
+}
+
+func TestProcessor_ColorProfile(t *testing.T) {
+	convert := func(t *testing.T, mode, fixture string) (image.Image, *Metadata) {
+		t.Helper()
+		p := NewProcessor(config.Config{
+			Conversion: config.ConversionConfig{Formats: []string{"jpeg", "png"}, ColorProfile: mode},
+		})
+		output, _, err := p.Process(readFixture(t, fixture), ProcessOptions{Format: FormatPNG})
+		if err != nil {
+			t.Fatalf("Process failed: %v", err)
+		}
+		img, _, err := image.Decode(bytes.NewReader(output))
+		if err != nil {
+			t.Fatalf("failed to decode output: %v", err)
+		}
+		return img, ReadMetadata(output)
+	}
+
+	t.Run("Display P3 to sRGB", func(t *testing.T) {
+		img, md := convert(t, ColorProfileSRGB, "display-p3.jpg")
+		assertColor(t, img, 4, 8, [3]uint8{217, 42, 52}, 3)
+		assertColor(t, img, 28, 8, [3]uint8{128, 128, 128}, 2)
+		if len(md.ICC) != 0 {
+			t.Error("converted output should not carry the source profile")
+		}
+	})
+
+	t.Run("Adobe RGB to sRGB", func(t *testing.T) {
+		img, _ := convert(t, ColorProfileSRGB, "adobe-rgb.png")
+		assertColor(t, img, 8, 8, [3]uint8{162, 121, 88}, 1)
+	})
+
+	t.Run("sRGB is unchanged", func(t *testing.T) {
+		img, _ := convert(t, ColorProfileSRGB, "srgb.png")
+		assertColor(t, img, 8, 8, [3]uint8{150, 120, 90}, 0)
+	})
+
+	t.Run("Embed keeps pixels and profile", func(t *testing.T) {
+		source := ReadMetadata(readFixture(t, "adobe-rgb.png"))
+		img, md := convert(t, ColorProfileEmbed, "adobe-rgb.png")
+		assertColor(t, img, 8, 8, [3]uint8{150, 120, 90}, 0)
+		if !bytes.Equal(md.ICC, source.ICC) {
+			t.Error("expected the source profile to be embedded")
+		}
+	})
+}
+
+// paraTag returns a parametricCurveType tag of the given function type
+func paraTag(kind uint16, params ...float64) []byte {
+	tag := []byte("para\x00\x00\x00\x00")
+	tag = binary.BigEndian.AppendUint16(tag, kind)
+	tag = append(tag, 0, 0)
+	for _, p := range params {
+		tag = binary.BigEndian.AppendUint32(tag, uint32(int32(math.Round(p*65536))))
+	}
+	return tag
+}
+
+// rgbProfile returns an RGB matrix/TRC profile with sRGB colorants and trc for all channels
+func rgbProfile(trc []byte) []byte {
+	type tag struct {
+		sig  string
+		data []byte
+	}
+	var tags []tag
+	for i, name := range []string{"r", "g", "b"} {
+		xyz := []byte("XYZ \x00\x00\x00\x00")
+		for row := 0; row < 3; row++ {
+			xyz = binary.BigEndian.AppendUint32(xyz, uint32(int32(math.Round(srgbToXYZ[row][i]*65536))))
+		}
+		tags = append(tags, tag{name + "XYZ", xyz})
+	}
+	for _, name := range []string{"r", "g", "b"} {
+		tags = append(tags, tag{name + "TRC", trc})
+	}
+
+	header := make([]byte, 128)
+	copy(header[16:], "RGB XYZ ")
+	table := binary.BigEndian.AppendUint32(nil, uint32(len(tags)))
+	offset := 128 + 4 + 12*len(tags)
+	var body []byte
+	for _, t := range tags {
+		table = append(table, t.sig...)
+		table = binary.BigEndian.AppendUint32(table, uint32(offset+len(body)))
+		table = binary.BigEndian.AppendUint32(table, uint32(len(t.data)))
+		body = append(body, t.data...)
+	}
+	return append(append(header, table...), body...)
+}
+
+func TestParseICCProfile_MalformedParametricCurve(t *testing.T) {
+	if _, err := parseICCProfile(rgbProfile(paraTag(0, 2.2))); err != nil {
+		t.Fatalf("expected a valid gamma profile, got %v", err)
+	}
+	tests := []struct {
+		name string
+		trc  []byte
+	}{
+		// a*x+b is negative wherever the power applies
+		{"negative base", paraTag(1, 2.2, -1, 0.5)},
+		{"negative base below d", paraTag(3, 2.4, -1, 0.5, 1, 0)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := parseICCProfile(rgbProfile(tt.trc)); !errors.Is(err, errUnsupportedProfile) {
+				t.Errorf("expected errUnsupportedProfile, got %v", err)
+			}
+		})
+	}
+
+	// The image is passed through with its profile instead of crashing the conversion
+	var buf bytes.Buffer
+	png.Encode(&buf, createTestImage(8, 8))
+	icc := rgbProfile(paraTag(1, 2.2, -1, 0.5))
+	p := NewProcessor(config.Config{Conversion: config.ConversionConfig{Formats: []string{"png"}}})
+	output, _, err := p.Process(insertPNGMetadata(buf.Bytes(), &Metadata{ICC: icc}), ProcessOptions{Format: FormatPNG})
+	if err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+	if md := ReadMetadata(output); !bytes.Equal(md.ICC, icc) {
+		t.Error("expected the unsupported profile to be embedded in the output")
+	}
+}
+
+func TestICCProfile_ToSRGBNaN(t *testing.T) {
+	// A curve that yields NaN must not index outside the encode table
+	profile := &iccProfile{toXYZ: srgbToXYZ}
+	for i := range profile.curves {
+		profile.curves[i] = iccCurve{kind: 1, params: [7]float64{2.2, -1, 0.5}}
+	}
+	img := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+	draw.Draw(img, img.Bounds(), image.NewUniform(color.NRGBA{200, 200, 200, 255}), image.Point{}, draw.Src)
+	out := profile.toSRGB(img)
+	if r, g, b, _ := out.At(1, 1).RGBA(); r|g|b != 0 {
+		t.Errorf("expected NaN to map to black, got %d %d %d", r, g, b)
+	}
+}
@@ -0,0 +1,138 @@
+package processor
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+)
+
+// Info describes an image without converting it
+type Info struct {
+	Format     string `json:"format"` // Decoder format name, e.g. "jpeg"
+	MimeType   string `json:"mime_type"`
+	Width      int    `json:"width"` // As displayed, after the EXIF orientation
+	Height     int    `json:"height"`
+	ColorModel string `json:"color_model"` // e.g. "ycbcr", "nrgba", "paletted"
+	HasAlpha   bool   `json:"has_alpha"`
+	Frames     int    `json:"frames"`      // Animation frames; 1 for still images
+	ICCProfile bool   `json:"icc_profile"` // Whether a color profile is embedded
+
+	EXIF           *EXIF          `json:"exif,omitempty"`
+	DominantColors []PaletteColor `json:"dominant_colors,omitempty"`
+}
+
+// ReadInfo describes data, accepting any format with a registered decoder. The
+// format, size and color model come from the header alone, and HasAlpha reports
+// whether the color model can hold transparency. With colors > 0 the pixels are
+// decoded too, after checking the size limits, to pick up to colors dominant
+// colors and to check whether any pixel is actually transparent.
+func (p *Processor) ReadInfo(data []byte, colors int) (*Info, error) {
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image header: %w", err)
+	}
+	metadata := ReadMetadata(data)
+	info := &Info{
+		Format:     format,
+		MimeType:   GetMimeType(data),
+		Width:      cfg.Width,
+		Height:     cfg.Height,
+		ColorModel: colorModelName(cfg.ColorModel),
+		HasAlpha:   modelHasAlpha(cfg.ColorModel),
+		Frames:     1,
+		ICCProfile: len(metadata.ICC) > 0,
+	}
+	if orientation := metadata.Orientation(); orientation >= 5 {
+		// Orientations 5-8 rotate by a quarter turn
+		info.Width, info.Height = info.Height, info.Width
+	}
+	if len(metadata.EXIF) > 0 {
+		// Malformed EXIF is reported as missing rather than failing the request
+		info.EXIF, _ = ParseEXIF(metadata.EXIF)
+	}
+
+	switch format {
+	case "gif":
+		if frames, err := countGIFFrames(data); err == nil && frames > 0 {
+			info.Frames = frames
+		}
+	case "webp":
+		if chunks, err := readWebPChunks(data); err == nil {
+			frames := 0
+			for _, chunk := range chunks {
+				switch {
+				case chunk.fourCC == "ANMF":
+					frames++
+				case chunk.fourCC == "VP8X" && len(chunk.data) > 0:
+					info.HasAlpha = chunk.data[0]&0x10 != 0
+				case chunk.fourCC == "VP8L" && len(chunk.data) >= 5:
+					info.HasAlpha = chunk.data[4]&0x10 != 0 // alpha_is_used bit of the header
+				}
+			}
+			info.Frames = max(frames, 1)
+		}
+	}
+
+	if colors > 0 {
+		img, err := p.Decode(data)
+		if err != nil {
+			return nil, err
+		}
+		info.HasAlpha = !isOpaque(img)
+		info.DominantColors = DominantColors(img, colors)
+	}
+	return info, nil
+}
+
+// colorModelName returns a short name for the color models of the standard decoders
+func colorModelName(m color.Model) string {
+	if _, ok := m.(color.Palette); ok {
+		return "paletted"
+	}
+	switch m {
+	case color.RGBAModel:
+		return "rgba"
+	case color.RGBA64Model:
+		return "rgba64"
+	case color.NRGBAModel:
+		return "nrgba"
+	case color.NRGBA64Model:
+		return "nrgba64"
+	case color.GrayModel:
+		return "gray"
+	case color.Gray16Model:
+		return "gray16"
+	case color.AlphaModel:
+		return "alpha"
+	case color.Alpha16Model:
+		return "alpha16"
+	case color.YCbCrModel:
+		return "ycbcr"
+	case color.NYCbCrAModel:
+		return "nycbcra"
+	case color.CMYKModel:
+		return "cmyk"
+	}
+	return "unknown"
+}
+
+// modelHasAlpha reports whether images of the color model can hold transparency.
+// The PNG and BMP decoders use the premultiplied RGBA models for opaque
+// truecolor images, so those count as opaque. The WebP decoder always reports
+// RGBA, so ReadInfo reads the WebP alpha flags from the container instead.
+func modelHasAlpha(m color.Model) bool {
+	if palette, ok := m.(color.Palette); ok {
+		for _, c := range palette {
+			if _, _, _, a := c.RGBA(); a != 0xffff {
+				return true
+			}
+		}
+		return false
+	}
+	switch m {
+	case color.NRGBAModel, color.NRGBA64Model, color.AlphaModel, color.Alpha16Model, color.NYCbCrAModel:
+		return true
+	}
+	return false
+}
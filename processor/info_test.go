@@ -0,0 +1,98 @@
+package processor
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"testing"
+
+	"image-converting-server/config"
+
+	"github.com/chai2010/webp"
+)
+
+func TestProcessor_ReadInfo(t *testing.T) {
+	p := NewProcessor(config.Config{Conversion: config.ConversionConfig{Formats: []string{"gif"}}})
+
+	// An upright-rotated JPEG reports its displayed size
+	rotated := insertJPEGMetadata(imageToBytes(t, createTestImage(40, 30), "jpeg"), &Metadata{EXIF: makeEXIF(6)})
+	info, err := p.ReadInfo(rotated, 0)
+	if err != nil {
+		t.Fatalf("ReadInfo failed: %v", err)
+	}
+	if info.Format != "jpeg" || info.MimeType != "image/jpeg" || info.ColorModel != "ycbcr" {
+		t.Errorf("unexpected format fields: %+v", info)
+	}
+	if info.Width != 30 || info.Height != 40 || info.HasAlpha || info.Frames != 1 {
+		t.Errorf("expected an opaque still 30x40 image, got %+v", info)
+	}
+	if info.EXIF == nil || info.EXIF.Orientation != 6 || info.DominantColors != nil {
+		t.Errorf("expected EXIF and no colors, got %+v", info)
+	}
+
+	// Animated GIFs report their frame count
+	info, err = p.ReadInfo(makeAnimatedGIF(t), 3)
+	if err != nil {
+		t.Fatalf("ReadInfo failed: %v", err)
+	}
+	if info.Format != "gif" || info.Frames != 3 || info.ColorModel != "paletted" {
+		t.Errorf("expected a 3-frame paletted gif, got %+v", info)
+	}
+	if len(info.DominantColors) != 1 || info.DominantColors[0].Hex != "#ff0000" {
+		t.Errorf("expected the red first frame, got %v", info.DominantColors)
+	}
+
+	// A PNG with an alpha channel is transparent by its header alone
+	transparent := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+	transparent.Set(1, 1, color.NRGBA{0, 0, 255, 255})
+	info, err = p.ReadInfo(imageToBytes(t, transparent, "png"), 1)
+	if err != nil {
+		t.Fatalf("ReadInfo failed: %v", err)
+	}
+	if info.ColorModel != "nrgba" || !info.HasAlpha || len(info.DominantColors) != 1 {
+		t.Errorf("expected a transparent nrgba png with one opaque color, got %+v", info)
+	}
+
+	if _, err := p.ReadInfo([]byte("not an image"), 0); err == nil {
+		t.Error("expected an error for non-image data")
+	}
+}
+
+func TestProcessor_ReadInfo_WebP(t *testing.T) {
+	p := NewProcessor(config.Config{Conversion: config.ConversionConfig{Formats: []string{"gif"}}})
+
+	img := image.NewNRGBA(image.Rect(0, 0, 8, 8))
+	img.Set(0, 0, color.NRGBA{255, 0, 0, 255})
+	var buf bytes.Buffer
+	if err := webp.Encode(&buf, img, &webp.Options{Lossless: true}); err != nil {
+		t.Fatal(err)
+	}
+	info, err := p.ReadInfo(buf.Bytes(), 0)
+	if err != nil {
+		t.Fatalf("ReadInfo failed: %v", err)
+	}
+	if info.Format != "webp" || !info.HasAlpha || info.Frames != 1 {
+		t.Errorf("expected a transparent still webp, got %+v", info)
+	}
+
+	anim, _, err := p.Process(makeAnimatedGIF(t), ProcessOptions{Format: FormatWebPLossless})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info, err := p.ReadInfo(anim, 0); err != nil || info.Frames != 3 || info.Width != 8 {
+		t.Errorf("expected an 8px wide 3-frame webp, got %+v, %v", info, err)
+	}
+}
+
+func TestProcessor_ReadInfo_Limits(t *testing.T) {
+	p := NewProcessor(config.Config{Conversion: config.ConversionConfig{MaxPixels: 100}})
+	data := imageToBytes(t, createTestImage(20, 20), "png")
+
+	if _, err := p.ReadInfo(data, 0); err != nil {
+		t.Errorf("expected the header to be read regardless of the limits, got %v", err)
+	}
+	if _, err := p.ReadInfo(data, 5); !errors.Is(err, ErrImageTooLarge) {
+		t.Errorf("expected ErrImageTooLarge when decoding, got %v", err)
+	}
+}
@@ -0,0 +1,400 @@
+package processor
+
+import (
+	"bufio"
+	"image"
+	"image/color"
+	"io"
+	"math"
+)
+
+// Progressive JPEG encoding.
+//
+// The standard library only writes baseline JPEG, so this file implements a
+// small progressive (SOF2) writer using spectral selection: one interleaved
+// DC scan followed by two AC bands per component. Chroma is not subsampled,
+// which keeps the block layout identical for every component.
+
+// zigzag maps zig-zag scan order to natural (row-major) coefficient order
+var zigzag = [64]int{
+	0, 1, 8, 16, 9, 2, 3, 10,
+	17, 24, 32, 25, 18, 11, 4, 5,
+	12, 19, 26, 33, 40, 48, 41, 34,
+	27, 20, 13, 6, 7, 14, 21, 28,
+	35, 42, 49, 56, 57, 50, 43, 36,
+	29, 22, 15, 23, 30, 37, 44, 51,
+	58, 59, 52, 45, 38, 31, 39, 46,
+	53, 60, 61, 54, 47, 55, 62, 63,
+}
+
+// Quantization tables from section K.1 of the JPEG specification, in natural order
+var baseQuant = [2][64]int{
+	{
+		16, 11, 10, 16, 24, 40, 51, 61,
+		12, 12, 14, 19, 26, 58, 60, 55,
+		14, 13, 16, 24, 40, 57, 69, 56,
+		14, 17, 22, 29, 51, 87, 80, 62,
+		18, 22, 37, 56, 68, 109, 103, 77,
+		24, 35, 55, 64, 81, 104, 113, 92,
+		49, 64, 78, 87, 103, 121, 120, 101,
+		72, 92, 95, 98, 112, 100, 103, 99,
+	},
+	{
+		17, 18, 24, 47, 99, 99, 99, 99,
+		18, 21, 26, 66, 99, 99, 99, 99,
+		24, 26, 56, 99, 99, 99, 99, 99,
+		47, 66, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+	},
+}
+
+// huffmanSpec holds the code length counts and symbol values of a Huffman table
+type huffmanSpec struct {
+	counts [16]byte
+	values []byte
+}
+
+// Standard Huffman tables from section K.3 of the JPEG specification:
+// luminance DC, luminance AC, chrominance DC, chrominance AC
+var huffmanSpecs = [4]huffmanSpec{
+	{
+		counts: [16]byte{0, 1, 5, 1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0, 0, 0},
+		values: []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11},
+	},
+	{
+		counts: [16]byte{0, 2, 1, 3, 3, 2, 4, 3, 5, 5, 4, 4, 0, 0, 1, 125},
+		values: []byte{
+			0x01, 0x02, 0x03, 0x00, 0x04, 0x11, 0x05, 0x12,
+			0x21, 0x31, 0x41, 0x06, 0x13, 0x51, 0x61, 0x07,
+			0x22, 0x71, 0x14, 0x32, 0x81, 0x91, 0xa1, 0x08,
+			0x23, 0x42, 0xb1, 0xc1, 0x15, 0x52, 0xd1, 0xf0,
+			0x24, 0x33, 0x62, 0x72, 0x82, 0x09, 0x0a, 0x16,
+			0x17, 0x18, 0x19, 0x1a, 0x25, 0x26, 0x27, 0x28,
+			0x29, 0x2a, 0x34, 0x35, 0x36, 0x37, 0x38, 0x39,
+			0x3a, 0x43, 0x44, 0x45, 0x46, 0x47, 0x48, 0x49,
+			0x4a, 0x53, 0x54, 0x55, 0x56, 0x57, 0x58, 0x59,
+			0x5a, 0x63, 0x64, 0x65, 0x66, 0x67, 0x68, 0x69,
+			0x6a, 0x73, 0x74, 0x75, 0x76, 0x77, 0x78, 0x79,
+			0x7a, 0x83, 0x84, 0x85, 0x86, 0x87, 0x88, 0x89,
+			0x8a, 0x92, 0x93, 0x94, 0x95, 0x96, 0x97, 0x98,
+			0x99, 0x9a, 0xa2, 0xa3, 0xa4, 0xa5, 0xa6, 0xa7,
+			0xa8, 0xa9, 0xaa, 0xb2, 0xb3, 0xb4, 0xb5, 0xb6,
+			0xb7, 0xb8, 0xb9, 0xba, 0xc2, 0xc3, 0xc4, 0xc5,
+			0xc6, 0xc7, 0xc8, 0xc9, 0xca, 0xd2, 0xd3, 0xd4,
+			0xd5, 0xd6, 0xd7, 0xd8, 0xd9, 0xda, 0xe1, 0xe2,
+			0xe3, 0xe4, 0xe5, 0xe6, 0xe7, 0xe8, 0xe9, 0xea,
+			0xf1, 0xf2, 0xf3, 0xf4, 0xf5, 0xf6, 0xf7, 0xf8,
+			0xf9, 0xfa,
+		},
+	},
+	{
+		counts: [16]byte{0, 3, 1, 1, 1, 1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0},
+		values: []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11},
+	},
+	{
+		counts: [16]byte{0, 2, 1, 2, 4, 4, 3, 4, 7, 5, 4, 4, 0, 1, 2, 119},
+		values: []byte{
+			0x00, 0x01, 0x02, 0x03, 0x11, 0x04, 0x05, 0x21,
+			0x31, 0x06, 0x12, 0x41, 0x51, 0x07, 0x61, 0x71,
+			0x13, 0x22, 0x32, 0x81, 0x08, 0x14, 0x42, 0x91,
+			0xa1, 0xb1, 0xc1, 0x09, 0x23, 0x33, 0x52, 0xf0,
+			0x15, 0x62, 0x72, 0xd1, 0x0a, 0x16, 0x24, 0x34,
+			0xe1, 0x25, 0xf1, 0x17, 0x18, 0x19, 0x1a, 0x26,
+			0x27, 0x28, 0x29, 0x2a, 0x35, 0x36, 0x37, 0x38,
+			0x39, 0x3a, 0x43, 0x44, 0x45, 0x46, 0x47, 0x48,
+			0x49, 0x4a, 0x53, 0x54, 0x55, 0x56, 0x57, 0x58,
+			0x59, 0x5a, 0x63, 0x64, 0x65, 0x66, 0x67, 0x68,
+			0x69, 0x6a, 0x73, 0x74, 0x75, 0x76, 0x77, 0x78,
+			0x79, 0x7a, 0x82, 0x83, 0x84, 0x85, 0x86, 0x87,
+			0x88, 0x89, 0x8a, 0x92, 0x93, 0x94, 0x95, 0x96,
+			0x97, 0x98, 0x99, 0x9a, 0xa2, 0xa3, 0xa4, 0xa5,
+			0xa6, 0xa7, 0xa8, 0xa9, 0xaa, 0xb2, 0xb3, 0xb4,
+			0xb5, 0xb6, 0xb7, 0xb8, 0xb9, 0xba, 0xc2, 0xc3,
+			0xc4, 0xc5, 0xc6, 0xc7, 0xc8, 0xc9, 0xca, 0xd2,
+			0xd3, 0xd4, 0xd5, 0xd6, 0xd7, 0xd8, 0xd9, 0xda,
+			0xe2, 0xe3, 0xe4, 0xe5, 0xe6, 0xe7, 0xe8, 0xe9,
+			0xea, 0xf2, 0xf3, 0xf4, 0xf5, 0xf6, 0xf7, 0xf8,
+			0xf9, 0xfa,
+		},
+	},
+}
+
+// huffmanCode is a code word and its bit length
+type huffmanCode struct {
+	code   uint32
+	length uint8
+}
+
+// buildHuffmanCodes generates the code table for a Huffman spec (section C.2)
+func buildHuffmanCodes(spec huffmanSpec) map[byte]huffmanCode {
+	codes := make(map[byte]huffmanCode, len(spec.values))
+	code, k := uint32(0), 0
+	for length := 1; length <= 16; length++ {
+		for i := 0; i < int(spec.counts[length-1]); i++ {
+			codes[spec.values[k]] = huffmanCode{code: code, length: uint8(length)}
+			code++
+			k++
+		}
+		code <<= 1
+	}
+	return codes
+}
+
+// bitWriter writes an entropy-coded segment with 0xFF byte stuffing
+type bitWriter struct {
+	w     *bufio.Writer
+	bits  uint32
+	nBits uint8
+}
+
+func (b *bitWriter) writeBits(bits uint32, n uint8) {
+	for i := int(n) - 1; i >= 0; i-- {
+		b.bits = b.bits<<1 | (bits>>uint(i))&1
+		b.nBits++
+		if b.nBits == 8 {
+			c := byte(b.bits)
+			b.w.WriteByte(c)
+			if c == 0xff {
+				b.w.WriteByte(0x00)
+			}
+			b.bits, b.nBits = 0, 0
+		}
+	}
+}
+
+// flush pads the final byte with 1 bits as required at the end of a scan
+func (b *bitWriter) flush() {
+	if b.nBits > 0 {
+		b.writeBits(0xff, 8-b.nBits)
+	}
+}
+
+func (b *bitWriter) writeCode(c huffmanCode) {
+	b.writeBits(c.code, c.length)
+}
+
+// writeValue writes the size category symbol followed by the value's extra bits
+func (b *bitWriter) writeValue(codes map[byte]huffmanCode, run int, value int32) {
+	size, bits := valueBits(value)
+	b.writeCode(codes[byte(run<<4|size)])
+	b.writeBits(bits, uint8(size))
+}
+
+// valueBits returns the magnitude category of v and its encoded extra bits
+func valueBits(v int32) (int, uint32) {
+	a := v
+	if a < 0 {
+		a = -a
+		v--
+	}
+	size := 0
+	for a > 0 {
+		size++
+		a >>= 1
+	}
+	return size, uint32(v) & (1<<uint(size) - 1)
+}
+
+// encodeProgressiveJPEG writes img as a progressive JPEG at the given quality
+func encodeProgressiveJPEG(w io.Writer, img image.Image, quality int) error {
+	if quality < 1 {
+		quality = 1
+	} else if quality > 100 {
+		quality = 100
+	}
+	scale := 5000 / quality
+	if quality >= 50 {
+		scale = 200 - quality*2
+	}
+	var quant [2][64]int
+	for t := range baseQuant {
+		for i, q := range baseQuant[t] {
+			v := (q*scale + 50) / 100
+			if v < 1 {
+				v = 1
+			} else if v > 255 {
+				v = 255
+			}
+			quant[t][i] = v
+		}
+	}
+
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	_, gray := img.(*image.Gray)
+	nComp := 3
+	if gray {
+		nComp = 1
+	}
+
+	// Compute quantized coefficients for every block of every component
+	bw, bh := (width+7)/8, (height+7)/8
+	coeffs := make([][][64]int32, nComp)
+	for c := range coeffs {
+		coeffs[c] = make([][64]int32, bw*bh)
+	}
+	var samples [3][64]float64
+	for by := 0; by < bh; by++ {
+		for bx := 0; bx < bw; bx++ {
+			for j := 0; j < 8; j++ {
+				for i := 0; i < 8; i++ {
+					x := bounds.Min.X + min(bx*8+i, width-1)
+					y := bounds.Min.Y + min(by*8+j, height-1)
+					if gray {
+						samples[0][j*8+i] = float64(img.(*image.Gray).GrayAt(x, y).Y) - 128
+						continue
+					}
+					r, g, b, _ := img.At(x, y).RGBA()
+					yy, cb, cr := color.RGBToYCbCr(uint8(r>>8), uint8(g>>8), uint8(b>>8))
+					samples[0][j*8+i] = float64(yy) - 128
+					samples[1][j*8+i] = float64(cb) - 128
+					samples[2][j*8+i] = float64(cr) - 128
+				}
+			}
+			for c := 0; c < nComp; c++ {
+				table := quant[min(c, 1)]
+				block := forwardDCT(&samples[c])
+				for k := 0; k < 64; k++ {
+					n := zigzag[k]
+					coeffs[c][by*bw+bx][k] = int32(math.Round(block[n] / float64(table[n])))
+				}
+			}
+		}
+	}
+
+	out := bufio.NewWriter(w)
+	out.Write([]byte{0xff, 0xd8}) // SOI
+
+	// DQT
+	for t := 0; t < min(nComp, 2); t++ {
+		out.Write([]byte{0xff, 0xdb, 0x00, 67, byte(t)})
+		for k := 0; k < 64; k++ {
+			out.WriteByte(byte(quant[t][zigzag[k]]))
+		}
+	}
+
+	// SOF2
+	sofLen := 8 + 3*nComp
+	out.Write([]byte{0xff, 0xc2, byte(sofLen >> 8), byte(sofLen), 8,
+		byte(height >> 8), byte(height), byte(width >> 8), byte(width), byte(nComp)})
+	for c := 0; c < nComp; c++ {
+		out.Write([]byte{byte(c + 1), 0x11, byte(min(c, 1))})
+	}
+
+	// DHT
+	for i, spec := range huffmanSpecs {
+		if nComp == 1 && i >= 2 {
+			break
+		}
+		class := byte(i % 2)
+		id := byte(i / 2)
+		segLen := 2 + 1 + 16 + len(spec.values)
+		out.Write([]byte{0xff, 0xc4, byte(segLen >> 8), byte(segLen), class<<4 | id})
+		out.Write(spec.counts[:])
+		out.Write(spec.values)
+	}
+
+	var codes [4]map[byte]huffmanCode
+	for i, spec := range huffmanSpecs {
+		codes[i] = buildHuffmanCodes(spec)
+	}
+	bits := &bitWriter{w: out}
+
+	// Interleaved DC scan
+	writeSOS(out, nComp, 0, 0, 0)
+	var pred [3]int32
+	for b := 0; b < bw*bh; b++ {
+		for c := 0; c < nComp; c++ {
+			dc := coeffs[c][b][0]
+			bits.writeValue(codes[min(c, 1)*2], 0, dc-pred[c])
+			pred[c] = dc
+		}
+	}
+	bits.flush()
+
+	// AC scans: low frequencies first so a coarse preview appears early
+	for _, band := range [][2]int{{1, 5}, {6, 63}} {
+		for c := 0; c < nComp; c++ {
+			writeSOS(out, 1, c, band[0], band[1])
+			acCodes := codes[min(c, 1)*2+1]
+			for b := 0; b < bw*bh; b++ {
+				run := 0
+				for k := band[0]; k <= band[1]; k++ {
+					v := coeffs[c][b][k]
+					if v == 0 {
+						run++
+						continue
+					}
+					for run > 15 {
+						bits.writeCode(acCodes[0xf0])
+						run -= 16
+					}
+					bits.writeValue(acCodes, run, v)
+					run = 0
+				}
+				if run > 0 {
+					bits.writeCode(acCodes[0x00]) // EOB
+				}
+			}
+			bits.flush()
+		}
+	}
+
+	out.Write([]byte{0xff, 0xd9}) // EOI
+	return out.Flush()
+}
+
+// writeSOS writes a start-of-scan header. With a single component, first
+// selects which component the scan covers.
+func writeSOS(out *bufio.Writer, n, first, ss, se int) {
+	segLen := 6 + 2*n
+	out.Write([]byte{0xff, 0xda, byte(segLen >> 8), byte(segLen), byte(n)})
+	for i := 0; i < n; i++ {
+		c := first + i
+		table := byte(min(c, 1))
+		out.Write([]byte{byte(c + 1), table<<4 | table})
+	}
+	out.Write([]byte{byte(ss), byte(se), 0})
+}
+
+// dctCos holds the DCT basis: dctCos[u][x] = C(u)/2 * cos((2x+1)uπ/16)
+var dctCos = func() [8][8]float64 {
+	var t [8][8]float64
+	for u := 0; u < 8; u++ {
+		cu := 1.0
+		if u == 0 {
+			cu = 1 / math.Sqrt2
+		}
+		for x := 0; x < 8; x++ {
+			t[u][x] = cu / 2 * math.Cos(float64(2*x+1)*float64(u)*math.Pi/16)
+		}
+	}
+	return t
+}()
+
+// forwardDCT computes the 2D DCT-II of a level-shifted 8x8 block
+func forwardDCT(in *[64]float64) [64]float64 {
+	var tmp, out [64]float64
+	for y := 0; y < 8; y++ {
+		for u := 0; u < 8; u++ {
+			var sum float64
+			for x := 0; x < 8; x++ {
+				sum += dctCos[u][x] * in[y*8+x]
+			}
+			tmp[y*8+u] = sum
+		}
+	}
+	for u := 0; u < 8; u++ {
+		for v := 0; v < 8; v++ {
+			var sum float64
+			for y := 0; y < 8; y++ {
+				sum += dctCos[v][y] * tmp[y*8+u]
+			}
+			out[v*8+u] = sum
+		}
+	}
+	return out
+}
@@ -0,0 +1,356 @@
+package processor
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"io"
+	"sort"
+
+	"github.com/disintegration/imaging"
+)
+
+// Metadata modes
+const (
+	MetadataStrip    = "strip"    // Drop all metadata (default)
+	MetadataPreserve = "preserve" // Keep EXIF, XMP and the ICC profile
+)
+
+// IsMetadataMode reports whether mode is a known metadata mode
+func IsMetadataMode(mode string) bool {
+	return mode == MetadataStrip || mode == MetadataPreserve
+}
+
+// Metadata holds the raw metadata blocks of an image
+type Metadata struct {
+	EXIF []byte // TIFF-structured EXIF data, without the JPEG "Exif\0\0" header
+	XMP  []byte // XMP packet (XML)
+	ICC  []byte // ICC color profile
+}
+
+// IsEmpty reports whether no metadata is present
+func (m *Metadata) IsEmpty() bool {
+	return m == nil || (len(m.EXIF) == 0 && len(m.XMP) == 0 && len(m.ICC) == 0)
+}
+
+// Orientation returns the EXIF orientation (1-8), or 1 if it is missing or invalid
+func (m *Metadata) Orientation() int {
+	if m == nil {
+		return 1
+	}
+	offset, order, ok := findOrientation(m.EXIF)
+	if !ok {
+		return 1
+	}
+	value := int(order.Uint16(m.EXIF[offset:]))
+	if value < 1 || value > 8 {
+		return 1
+	}
+	return value
+}
+
+// withNormalOrientation returns a copy whose EXIF orientation is reset to 1,
+// for use after the orientation has been applied to the pixels
+func (m *Metadata) withNormalOrientation() *Metadata {
+	out := *m
+	if offset, order, ok := findOrientation(m.EXIF); ok {
+		out.EXIF = bytes.Clone(m.EXIF)
+		order.PutUint16(out.EXIF[offset:], 1)
+	}
+	return &out
+}
+
+// ReadMetadata extracts EXIF, XMP and ICC data from JPEG or PNG data.
+// Other formats return empty metadata.
+func ReadMetadata(data []byte) *Metadata {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xFF, 0xD8}):
+		return readJPEGMetadata(data)
+	case bytes.HasPrefix(data, pngSignature):
+		return readPNGMetadata(data)
+	}
+	return &Metadata{}
+}
+
+// applyOrientation transforms the image so that it displays upright for the given
+// EXIF orientation value
+func applyOrientation(img image.Image, orientation int) image.Image {
+	switch orientation {
+	case 2:
+		return imaging.FlipH(img)
+	case 3:
+		return imaging.Rotate180(img)
+	case 4:
+		return imaging.FlipV(img)
+	case 5:
+		return imaging.Transpose(img)
+	case 6:
+		return imaging.Rotate270(img)
+	case 7:
+		return imaging.Transverse(img)
+	case 8:
+		return imaging.Rotate90(img)
+	}
+	return img
+}
+
+// findOrientation locates the value of the Orientation tag (0x0112) in IFD0
+func findOrientation(exif []byte) (int, binary.ByteOrder, bool) {
+	if len(exif) < 8 {
+		return 0, nil, false
+	}
+	var order binary.ByteOrder
+	switch string(exif[:4]) {
+	case "II*\x00":
+		order = binary.LittleEndian
+	case "MM\x00*":
+		order = binary.BigEndian
+	default:
+		return 0, nil, false
+	}
+
+	ifd := int(order.Uint32(exif[4:]))
+	if ifd < 8 || ifd+2 > len(exif) {
+		return 0, nil, false
+	}
+	count := int(order.Uint16(exif[ifd:]))
+	for i := 0; i < count; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(exif) {
+			break
+		}
+		// Tag 0x0112, type SHORT, count 1: the value is stored inline
+		if order.Uint16(exif[entry:]) == 0x0112 && order.Uint16(exif[entry+2:]) == 3 {
+			return entry + 8, order, true
+		}
+	}
+	return 0, nil, false
+}
+
+// JPEG APP segment identifiers
+var (
+	jpegEXIFHeader = []byte("Exif\x00\x00")
+	jpegXMPHeader  = []byte("http://ns.adobe.com/xap/1.0/\x00")
+	jpegICCHeader  = []byte("ICC_PROFILE\x00")
+)
+
+// maxJPEGSegment is the largest payload of a JPEG marker segment
+const maxJPEGSegment = 65533
+
+func readJPEGMetadata(data []byte) *Metadata {
+	md := &Metadata{}
+	iccChunks := map[int][]byte{}
+
+	for pos := 2; pos+4 <= len(data); {
+		if data[pos] != 0xFF {
+			break
+		}
+		marker := data[pos+1]
+		if marker == 0xDA || marker == 0xD9 { // Start of scan or end of image
+			break
+		}
+		length := int(binary.BigEndian.Uint16(data[pos+2:]))
+		if length < 2 || pos+2+length > len(data) {
+			break
+		}
+		payload := data[pos+4 : pos+2+length]
+
+		switch {
+		case marker == 0xE1 && bytes.HasPrefix(payload, jpegEXIFHeader):
+			md.EXIF = bytes.Clone(payload[len(jpegEXIFHeader):])
+		case marker == 0xE1 && bytes.HasPrefix(payload, jpegXMPHeader):
+			md.XMP = bytes.Clone(payload[len(jpegXMPHeader):])
+		case marker == 0xE2 && bytes.HasPrefix(payload, jpegICCHeader) && len(payload) > len(jpegICCHeader)+2:
+			// Large profiles are split across segments, each with a 1-based sequence number
+			seq := int(payload[len(jpegICCHeader)])
+			iccChunks[seq] = payload[len(jpegICCHeader)+2:]
+		}
+		pos += 2 + length
+	}
+
+	if len(iccChunks) > 0 {
+		seqs := make([]int, 0, len(iccChunks))
+		for seq := range iccChunks {
+			seqs = append(seqs, seq)
+		}
+		sort.Ints(seqs)
+		for _, seq := range seqs {
+			md.ICC = append(md.ICC, iccChunks[seq]...)
+		}
+	}
+	return md
+}
+
+// insertJPEGMetadata writes metadata segments right after the SOI marker
+func insertJPEGMetadata(data []byte, md *Metadata) []byte {
+	if md.IsEmpty() || len(data) < 2 {
+		return data
+	}
+
+	var segments bytes.Buffer
+	writeSegment := func(marker byte, parts ...[]byte) {
+		length := 2
+		for _, part := range parts {
+			length += len(part)
+		}
+		segments.Write([]byte{0xFF, marker, byte(length >> 8), byte(length)})
+		for _, part := range parts {
+			segments.Write(part)
+		}
+	}
+
+	if len(md.EXIF) > 0 && len(jpegEXIFHeader)+len(md.EXIF) <= maxJPEGSegment {
+		writeSegment(0xE1, jpegEXIFHeader, md.EXIF)
+	}
+	if len(md.XMP) > 0 && len(jpegXMPHeader)+len(md.XMP) <= maxJPEGSegment {
+		writeSegment(0xE1, jpegXMPHeader, md.XMP)
+	}
+	if len(md.ICC) > 0 {
+		chunkSize := maxJPEGSegment - len(jpegICCHeader) - 2
+		count := (len(md.ICC) + chunkSize - 1) / chunkSize
+		if count <= 255 {
+			for i := 0; i < count; i++ {
+				chunk := md.ICC[i*chunkSize : min((i+1)*chunkSize, len(md.ICC))]
+				writeSegment(0xE2, jpegICCHeader, []byte{byte(i + 1), byte(count)}, chunk)
+			}
+		}
+	}
+
+	out := make([]byte, 0, len(data)+segments.Len())
+	out = append(out, data[:2]...)
+	out = append(out, segments.Bytes()...)
+	return append(out, data[2:]...)
+}
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// pngXMPKeyword is the iTXt keyword used for XMP packets
+const pngXMPKeyword = "XML:com.adobe.xmp"
+
+func readPNGMetadata(data []byte) *Metadata {
+	md := &Metadata{}
+	for pos := len(pngSignature); pos+12 <= len(data); {
+		length := int(binary.BigEndian.Uint32(data[pos:]))
+		if pos+12+length > len(data) {
+			break
+		}
+		chunkType := string(data[pos+4 : pos+8])
+		chunk := data[pos+8 : pos+8+length]
+
+		switch chunkType {
+		case "eXIf":
+			md.EXIF = bytes.Clone(chunk)
+		case "iCCP":
+			// Profile name, null separator, compression method, zlib data
+			if i := bytes.IndexByte(chunk, 0); i >= 0 && i+2 <= len(chunk) {
+				if profile, err := inflate(chunk[i+2:]); err == nil {
+					md.ICC = profile
+				}
+			}
+		case "iTXt":
+			if xmp, ok := parsePNGXMP(chunk); ok {
+				md.XMP = xmp
+			}
+		case "IEND":
+			return md
+		}
+		pos += 12 + length
+	}
+	return md
+}
+
+// parsePNGXMP returns the text of an iTXt chunk holding an XMP packet
+func parsePNGXMP(chunk []byte) ([]byte, bool) {
+	// Keyword, null, compression flag, compression method, language tag, null,
+	// translated keyword, null, text
+	keyword, rest, ok := bytes.Cut(chunk, []byte{0})
+	if !ok || string(keyword) != pngXMPKeyword || len(rest) < 2 {
+		return nil, false
+	}
+	compressed := rest[0] == 1
+	rest = rest[2:]
+	if _, rest, ok = bytes.Cut(rest, []byte{0}); !ok {
+		return nil, false
+	}
+	if _, rest, ok = bytes.Cut(rest, []byte{0}); !ok {
+		return nil, false
+	}
+	if compressed {
+		text, err := inflate(rest)
+		return text, err == nil
+	}
+	return bytes.Clone(rest), true
+}
+
+// insertPNGMetadata writes metadata chunks right after the IHDR chunk
+func insertPNGMetadata(data []byte, md *Metadata) []byte {
+	// Signature (8) + IHDR length, type, 13 bytes of data and CRC (25)
+	const ihdrEnd = 33
+	if md.IsEmpty() || len(data) < ihdrEnd || !bytes.HasPrefix(data, pngSignature) {
+		return data
+	}
+
+	var chunks bytes.Buffer
+	if len(md.ICC) > 0 {
+		var compressed bytes.Buffer
+		zw := zlib.NewWriter(&compressed)
+		zw.Write(md.ICC)
+		zw.Close()
+		writePNGChunk(&chunks, "iCCP", []byte("ICC Profile\x00\x00"), compressed.Bytes())
+	}
+	if len(md.EXIF) > 0 {
+		writePNGChunk(&chunks, "eXIf", md.EXIF)
+	}
+	if len(md.XMP) > 0 {
+		// Uncompressed, with empty language tag and translated keyword
+		writePNGChunk(&chunks, "iTXt", []byte(pngXMPKeyword+"\x00\x00\x00\x00\x00"), md.XMP)
+	}
+
+	out := make([]byte, 0, len(data)+chunks.Len())
+	out = append(out, data[:ihdrEnd]...)
+	out = append(out, chunks.Bytes()...)
+	return append(out, data[ihdrEnd:]...)
+}
+
+func writePNGChunk(w *bytes.Buffer, chunkType string, parts ...[]byte) {
+	length := 0
+	for _, part := range parts {
+		length += len(part)
+	}
+	binary.Write(w, binary.BigEndian, uint32(length))
+
+	crc := crc32.NewIEEE()
+	w.WriteString(chunkType)
+	crc.Write([]byte(chunkType))
+	for _, part := range parts {
+		w.Write(part)
+		crc.Write(part)
+	}
+	binary.Write(w, binary.BigEndian, crc.Sum32())
+}
+
+// maxInflatedMetadata caps a decompressed ICC profile or XMP packet. A few KB of
+// zlib data can expand to gigabytes, far beyond the input size limits.
+const maxInflatedMetadata = 4 << 20
+
+// errMetadataTooLarge drops a compressed chunk that inflates past maxInflatedMetadata
+var errMetadataTooLarge = errors.New("metadata chunk is too large")
+
+func inflate(data []byte) ([]byte, error) {
+	zr, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	out, err := io.ReadAll(io.LimitReader(zr, maxInflatedMetadata+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(out) > maxInflatedMetadata {
+		return nil, errMetadataTooLarge
+	}
+	return out, nil
+}
@@ -0,0 +1,160 @@
+package processor
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+	"testing"
+
+	"image-converting-server/config"
+
+	"github.com/chai2010/webp"
+)
+
+// makeEXIF builds a minimal little-endian EXIF block holding only the Orientation tag
+func makeEXIF(orientation uint16) []byte {
+	exif := []byte("II*\x00")
+	exif = binary.LittleEndian.AppendUint32(exif, 8)
+	exif = binary.LittleEndian.AppendUint16(exif, 1)
+	exif = binary.LittleEndian.AppendUint16(exif, 0x0112)
+	exif = binary.LittleEndian.AppendUint16(exif, 3)
+	exif = binary.LittleEndian.AppendUint32(exif, 1)
+	exif = binary.LittleEndian.AppendUint16(exif, orientation)
+	exif = binary.LittleEndian.AppendUint16(exif, 0)
+	return binary.LittleEndian.AppendUint32(exif, 0)
+}
+
+func testMetadata(orientation uint16) *Metadata {
+	return &Metadata{
+		EXIF: makeEXIF(orientation),
+		XMP:  []byte(`<x:xmpmeta xmlns:x="adobe:ns:meta/"></x:xmpmeta>`),
+		ICC:  bytes.Repeat([]byte{0x42}, 70000), // Spans two JPEG APP2 segments
+	}
+}
+
+func TestReadMetadata_RoundTrip(t *testing.T) {
+	img := createTestImage(8, 8)
+	md := testMetadata(6)
+
+	tests := map[string][]byte{
+		"jpeg": insertJPEGMetadata(imageToBytes(t, img, "jpeg"), md),
+		"png":  insertPNGMetadata(imageToBytes(t, img, "png"), md),
+	}
+	for name, data := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, _, err := image.Decode(bytes.NewReader(data)); err != nil {
+				t.Fatalf("output with metadata no longer decodes: %v", err)
+			}
+			got := ReadMetadata(data)
+			if !bytes.Equal(got.EXIF, md.EXIF) || !bytes.Equal(got.XMP, md.XMP) || !bytes.Equal(got.ICC, md.ICC) {
+				t.Errorf("metadata did not round-trip: exif %d, xmp %d, icc %d bytes", len(got.EXIF), len(got.XMP), len(got.ICC))
+			}
+			if got.Orientation() != 6 {
+				t.Errorf("expected orientation 6, got %d", got.Orientation())
+			}
+		})
+	}
+}
+
+func TestReadMetadata_InflateBomb(t *testing.T) {
+	// 64 MB of zeros compresses to about 64 KB
+	var bomb bytes.Buffer
+	zw := zlib.NewWriter(&bomb)
+	zw.Write(make([]byte, 64<<20))
+	zw.Close()
+
+	var chunks bytes.Buffer
+	writePNGChunk(&chunks, "iCCP", []byte("bomb\x00\x00"), bomb.Bytes())
+	writePNGChunk(&chunks, "iTXt", []byte(pngXMPKeyword+"\x00\x01\x00\x00\x00"), bomb.Bytes())
+	png := imageToBytes(t, createTestImage(8, 8), "png")
+	data := append(append(bytes.Clone(png[:33]), chunks.Bytes()...), png[33:]...)
+
+	md := ReadMetadata(data)
+	if md.ICC != nil || md.XMP != nil {
+		t.Errorf("expected oversized chunks to be dropped, got icc %d, xmp %d bytes", len(md.ICC), len(md.XMP))
+	}
+
+	// Chunks within the cap are still read
+	small := insertPNGMetadata(png, &Metadata{ICC: make([]byte, maxInflatedMetadata)})
+	if got := ReadMetadata(small); len(got.ICC) != maxInflatedMetadata {
+		t.Errorf("expected a %d byte profile, got %d", maxInflatedMetadata, len(got.ICC))
+	}
+}
+
+func TestProcessor_Process_Orientation(t *testing.T) {
+	p := NewProcessor(config.Config{
+		Conversion: config.ConversionConfig{Formats: []string{"jpeg"}},
+	})
+
+	tests := []struct {
+		orientation uint16
+		wantWidth   int
+		wantHeight  int
+	}{
+		{1, 40, 20},
+		{3, 40, 20},
+		{6, 20, 40},
+		{8, 20, 40},
+	}
+	for _, tt := range tests {
+		data := insertJPEGMetadata(imageToBytes(t, createTestImage(40, 20), "jpeg"), &Metadata{EXIF: makeEXIF(tt.orientation)})
+		output, _, err := p.Process(data, ProcessOptions{Format: FormatPNG})
+		if err != nil {
+			t.Fatalf("orientation %d: Process failed: %v", tt.orientation, err)
+		}
+		cfg, _, err := image.DecodeConfig(bytes.NewReader(output))
+		if err != nil {
+			t.Fatalf("orientation %d: failed to decode output: %v", tt.orientation, err)
+		}
+		if cfg.Width != tt.wantWidth || cfg.Height != tt.wantHeight {
+			t.Errorf("orientation %d: expected %dx%d, got %dx%d", tt.orientation, tt.wantWidth, tt.wantHeight, cfg.Width, cfg.Height)
+		}
+	}
+}
+
+func TestProcessor_Process_Metadata(t *testing.T) {
+	md := testMetadata(6)
+	var buf bytes.Buffer
+	jpeg.Encode(&buf, createTestImage(40, 20), nil)
+	data := insertJPEGMetadata(buf.Bytes(), md)
+
+	p := NewProcessor(config.Config{
+		Conversion: config.ConversionConfig{Formats: []string{"jpeg"}, Quality: 80},
+	})
+
+	t.Run("Strip by default", func(t *testing.T) {
+		output, _, err := p.Process(data, ProcessOptions{})
+		if err != nil {
+			t.Fatalf("Process failed: %v", err)
+		}
+		// The test ICC data is not a real profile, so it is passed through rather than
+		// converted (see TestProcessor_ColorProfile)
+		for _, format := range []string{"EXIF", "XMP"} {
+			if chunk, _ := webp.GetMetadata(output, format); len(chunk) > 0 {
+				t.Errorf("expected %s to be stripped", format)
+			}
+		}
+	})
+
+	t.Run("Preserve in WebP", func(t *testing.T) {
+		output, _, err := p.Process(data, ProcessOptions{Metadata: MetadataPreserve})
+		if err != nil {
+			t.Fatalf("Process failed: %v", err)
+		}
+		exif, _ := webp.GetMetadata(output, "EXIF")
+		if got := (&Metadata{EXIF: exif}).Orientation(); len(exif) == 0 || got != 1 {
+			t.Errorf("expected EXIF with orientation reset to 1, got %d bytes, orientation %d", len(exif), got)
+		}
+		if xmp, _ := webp.GetMetadata(output, "XMP"); !bytes.Equal(xmp, md.XMP) {
+			t.Errorf("XMP was not preserved")
+		}
+		if icc, _ := webp.GetMetadata(output, "ICCP"); !bytes.Equal(icc, md.ICC) {
+			t.Errorf("ICC profile was not preserved")
+		}
+		if bytes.Equal(md.EXIF, makeEXIF(1)) {
+			t.Error("source metadata must not be modified")
+		}
+	})
+}
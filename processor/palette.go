@@ -0,0 +1,133 @@
+package processor
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"sort"
+
+	"github.com/disintegration/imaging"
+)
+
+// MaxPaletteColors bounds the number of colors DominantColors returns
+const MaxPaletteColors = 16
+
+// paletteSourceSize bounds the image the palette is computed from
+const paletteSourceSize = 64
+
+// PaletteColor is one color of a dominant-color palette
+type PaletteColor struct {
+	Hex      string  `json:"hex"`      // e.g. "#1a2b3c"
+	Fraction float64 `json:"fraction"` // Share of the opaque pixels closest to this color
+}
+
+// DominantColors returns up to n of the most common colors of img, most common
+// first, by median cut over a downsampled copy. Mostly transparent pixels are ignored.
+func DominantColors(img image.Image, n int) []PaletteColor {
+	n = min(n, MaxPaletteColors)
+	if n <= 0 || img.Bounds().Empty() {
+		return nil
+	}
+	small := imaging.Fit(img, paletteSourceSize, paletteSourceSize, imaging.Box)
+	pixels := make([][3]uint8, 0, len(small.Pix)/4)
+	for i := 0; i < len(small.Pix); i += 4 {
+		if small.Pix[i+3] >= 128 {
+			pixels = append(pixels, [3]uint8{small.Pix[i], small.Pix[i+1], small.Pix[i+2]})
+		}
+	}
+	if len(pixels) == 0 {
+		return nil
+	}
+
+	boxes := []colorBox{newColorBox(pixels)}
+	for len(boxes) < n {
+		// Split the box with the largest pixel count times range, which favors
+		// large varied areas over small outliers
+		best, score := -1, 0
+		for i, b := range boxes {
+			if s := len(b.pixels) * b.spread(); s > score {
+				best, score = i, s
+			}
+		}
+		if best < 0 {
+			break
+		}
+		a, b := boxes[best].split()
+		boxes[best] = a
+		boxes = append(boxes, b)
+	}
+
+	sort.SliceStable(boxes, func(i, j int) bool { return len(boxes[i].pixels) > len(boxes[j].pixels) })
+	colors := make([]PaletteColor, len(boxes))
+	for i, b := range boxes {
+		colors[i] = PaletteColor{
+			Hex:      b.hex(),
+			Fraction: math.Round(float64(len(b.pixels))/float64(len(pixels))*1e4) / 1e4,
+		}
+	}
+	return colors
+}
+
+// colorBox is a set of pixels and the channel ranges they span
+type colorBox struct {
+	pixels   [][3]uint8
+	min, max [3]uint8
+}
+
+func newColorBox(pixels [][3]uint8) colorBox {
+	b := colorBox{pixels: pixels, min: [3]uint8{255, 255, 255}}
+	for _, p := range pixels {
+		for c := 0; c < 3; c++ {
+			b.min[c] = min(b.min[c], p[c])
+			b.max[c] = max(b.max[c], p[c])
+		}
+	}
+	return b
+}
+
+// widest returns the channel with the largest range
+func (b colorBox) widest() int {
+	channel := 0
+	for c := 1; c < 3; c++ {
+		if b.max[c]-b.min[c] > b.max[channel]-b.min[channel] {
+			channel = c
+		}
+	}
+	return channel
+}
+
+func (b colorBox) spread() int {
+	c := b.widest()
+	return int(b.max[c] - b.min[c])
+}
+
+// split divides the box at the median of its widest channel
+func (b colorBox) split() (colorBox, colorBox) {
+	c := b.widest()
+	sort.Slice(b.pixels, func(i, j int) bool { return b.pixels[i][c] < b.pixels[j][c] })
+	// Move the cut past equal values so that both halves stay non-empty and
+	// disjoint along the channel
+	mid := len(b.pixels) / 2
+	for mid < len(b.pixels) && b.pixels[mid][c] == b.pixels[mid-1][c] {
+		mid++
+	}
+	if mid == len(b.pixels) {
+		mid = len(b.pixels) / 2
+		for mid > 1 && b.pixels[mid-1][c] == b.pixels[mid][c] {
+			mid--
+		}
+	}
+	return newColorBox(b.pixels[:mid]), newColorBox(b.pixels[mid:])
+}
+
+// hex returns the average color of the box as #rrggbb
+func (b colorBox) hex() string {
+	var sum [3]int
+	for _, p := range b.pixels {
+		for c := 0; c < 3; c++ {
+			sum[c] += int(p[c])
+		}
+	}
+	n := len(b.pixels)
+	return fmt.Sprintf("#%02x%02x%02x", (sum[0]+n/2)/n, (sum[1]+n/2)/n, (sum[2]+n/2)/n)
+}
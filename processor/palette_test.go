@@ -0,0 +1,42 @@
+package processor
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestDominantColors(t *testing.T) {
+	// Three quarters red, one quarter blue, and a transparent strip that is ignored
+	img := image.NewNRGBA(image.Rect(0, 0, 100, 120))
+	for y := 0; y < 120; y++ {
+		for x := 0; x < 100; x++ {
+			switch {
+			case y >= 100:
+				img.Set(x, y, color.NRGBA{0, 255, 0, 0})
+			case x < 75:
+				img.Set(x, y, color.NRGBA{200, 20, 20, 255})
+			default:
+				img.Set(x, y, color.NRGBA{20, 20, 200, 255})
+			}
+		}
+	}
+
+	colors := DominantColors(img, 5)
+	if len(colors) != 2 {
+		t.Fatalf("expected 2 colors for a two-color image, got %v", colors)
+	}
+	if colors[0].Hex != "#c81414" || colors[1].Hex != "#1414c8" {
+		t.Errorf("expected red then blue, got %v", colors)
+	}
+	if colors[0].Fraction < 0.7 || colors[0].Fraction > 0.8 || colors[0].Fraction+colors[1].Fraction != 1 {
+		t.Errorf("unexpected fractions: %v", colors)
+	}
+
+	if got := DominantColors(texturedImage(64, 64), 100); len(got) != MaxPaletteColors {
+		t.Errorf("expected %d colors at most, got %d", MaxPaletteColors, len(got))
+	}
+	if got := DominantColors(image.NewNRGBA(image.Rect(0, 0, 8, 8)), 5); got != nil {
+		t.Errorf("expected no colors for a transparent image, got %v", got)
+	}
+}
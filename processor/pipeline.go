@@ -0,0 +1,258 @@
+package processor
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"strconv"
+	"strings"
+
+	"image-converting-server/config"
+
+	"github.com/disintegration/imaging"
+)
+
+// ErrInvalidOperation is returned for operations that cannot be parsed or applied
+var ErrInvalidOperation = errors.New("invalid operation")
+
+// Operation names
+const (
+	OpResize     = "resize"     // Resize with the width, height and fit options; marks where resizing happens
+	OpRotate     = "rotate"     // rotate:degrees, clockwise
+	OpFlip       = "flip"       // flip:h or flip:v
+	OpCrop       = "crop"       // crop:x,y,width,height in pixels
+	OpBlur       = "blur"       // blur:sigma (gaussian)
+	OpSharpen    = "sharpen"    // sharpen:sigma
+	OpGrayscale  = "grayscale"  // grayscale
+	OpBrightness = "brightness" // brightness:percent (-100 to 100)
+	OpContrast   = "contrast"   // contrast:percent (-100 to 100)
+	OpGamma      = "gamma"      // gamma:value (> 0, 1 keeps the image unchanged)
+	OpSaturation = "saturation" // saturation:percent (-100 to 100)
+)
+
+// OperationSeparator separates operations in a pipeline string
+const OperationSeparator = "|"
+
+// maxSigma bounds blur and sharpen, whose cost grows with the sigma
+const maxSigma = 100
+
+// maxOperations bounds the length of a pipeline, since every step runs on the full image
+const maxOperations = 16
+
+// Operation is one step of an image pipeline, written as "name" or "name:arg,arg"
+type Operation struct {
+	Name string
+	Args []float64
+	Axis string // "h" or "v" for flip
+}
+
+// String returns the operation in the syntax accepted by ParseOperation
+func (o Operation) String() string {
+	if o.Name == OpFlip {
+		return o.Name + ":" + o.Axis
+	}
+	if len(o.Args) == 0 {
+		return o.Name
+	}
+	args := make([]string, len(o.Args))
+	for i, arg := range o.Args {
+		args[i] = strconv.FormatFloat(arg, 'f', -1, 64)
+	}
+	return o.Name + ":" + strings.Join(args, ",")
+}
+
+// ParseOperations parses a pipeline string such as "rotate:90|crop:0,0,400,300|grayscale"
+func ParseOperations(s string) ([]Operation, error) {
+	if s == "" {
+		return nil, nil
+	}
+	return ParseOperationList(strings.Split(s, OperationSeparator))
+}
+
+// ParseOperationList parses a list of operations, one per element
+func ParseOperationList(list []string) ([]Operation, error) {
+	if len(list) > maxOperations {
+		return nil, fmt.Errorf("%w: at most %d operations are allowed, got %d", ErrInvalidOperation, maxOperations, len(list))
+	}
+	ops := make([]Operation, 0, len(list))
+	for _, s := range list {
+		op, err := ParseOperation(s)
+		if err != nil {
+			return nil, err
+		}
+		ops = append(ops, op)
+	}
+	return ops, nil
+}
+
+// FormatOperations returns the pipeline string for ops
+func FormatOperations(ops []Operation) string {
+	parts := make([]string, len(ops))
+	for i, op := range ops {
+		parts[i] = op.String()
+	}
+	return strings.Join(parts, OperationSeparator)
+}
+
+// ParseOperation parses and validates a single operation
+func ParseOperation(s string) (Operation, error) {
+	name, rawArgs, _ := strings.Cut(strings.TrimSpace(s), ":")
+	op := Operation{Name: name}
+
+	if name == OpFlip {
+		if rawArgs != "h" && rawArgs != "v" {
+			return op, fmt.Errorf("%w: flip needs h or v, got %q", ErrInvalidOperation, s)
+		}
+		op.Axis = rawArgs
+		return op, nil
+	}
+
+	if rawArgs != "" {
+		for _, raw := range strings.Split(rawArgs, ",") {
+			v, err := strconv.ParseFloat(strings.TrimSpace(raw), 64)
+			if err != nil {
+				return op, fmt.Errorf("%w: %q has a non-numeric argument", ErrInvalidOperation, s)
+			}
+			op.Args = append(op.Args, v)
+		}
+	}
+
+	argCount := map[string]int{
+		OpResize: 0, OpGrayscale: 0,
+		OpRotate: 1, OpBlur: 1, OpSharpen: 1, OpBrightness: 1, OpContrast: 1, OpGamma: 1, OpSaturation: 1,
+		OpCrop: 4,
+	}
+	want, ok := argCount[name]
+	if !ok {
+		return op, fmt.Errorf("%w: unknown operation %q", ErrInvalidOperation, name)
+	}
+	if len(op.Args) != want {
+		return op, fmt.Errorf("%w: %s takes %d argument(s), got %d", ErrInvalidOperation, name, want, len(op.Args))
+	}
+
+	switch name {
+	case OpCrop:
+		if op.Args[2] <= 0 || op.Args[3] <= 0 || op.Args[0] < 0 || op.Args[1] < 0 {
+			return op, fmt.Errorf("%w: crop needs a non-negative origin and a positive size", ErrInvalidOperation)
+		}
+	case OpBlur, OpSharpen:
+		if op.Args[0] <= 0 || op.Args[0] > maxSigma {
+			return op, fmt.Errorf("%w: %s sigma must be between 0 and %d", ErrInvalidOperation, name, maxSigma)
+		}
+	case OpBrightness, OpContrast, OpSaturation:
+		if op.Args[0] < -100 || op.Args[0] > 100 {
+			return op, fmt.Errorf("%w: %s must be between -100 and 100", ErrInvalidOperation, name)
+		}
+	case OpGamma:
+		if op.Args[0] <= 0 {
+			return op, fmt.Errorf("%w: gamma must be positive", ErrInvalidOperation)
+		}
+	}
+	return op, nil
+}
+
+// CheckPresets returns an error if any preset names an invalid operation
+func CheckPresets(presets map[string]config.PresetConfig) error {
+	for name, preset := range presets {
+		if _, err := ParseOperationList(preset.Operations); err != nil {
+			return fmt.Errorf("preset %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// transform runs the preset's operations followed by the requested operations.
+// Resizing runs where a resize operation appears, or last if there is none.
+// The watermark, if any, is stamped onto the final image.
+func (p *Processor) transform(img image.Image, options ProcessOptions) (image.Image, error) {
+	img, rest, err := p.transformToResize(img, options)
+	if err != nil {
+		return nil, err
+	}
+	return p.transformFromResize(img, rest, options)
+}
+
+// transformToResize runs the operations before the first resize operation and
+// returns the rest, which start with that resize
+func (p *Processor) transformToResize(img image.Image, options ProcessOptions) (image.Image, []Operation, error) {
+	var ops []Operation
+	if preset, ok := p.cfg.Resize.Presets[options.Preset]; ok && options.Preset != "" {
+		presetOps, err := ParseOperationList(preset.Operations)
+		if err != nil {
+			return nil, nil, fmt.Errorf("preset %s: %w", options.Preset, err)
+		}
+		ops = append(ops, presetOps...)
+	}
+	ops = append(ops, options.Operations...)
+
+	for i, op := range ops {
+		if op.Name == OpResize {
+			return img, ops[i:], nil
+		}
+		var err error
+		if img, err = applyOperation(img, op, options); err != nil {
+			return nil, nil, err
+		}
+	}
+	return img, nil, nil
+}
+
+// transformFromResize runs the operations returned by transformToResize, resizing
+// first if there are none, and stamps the watermark
+func (p *Processor) transformFromResize(img image.Image, ops []Operation, options ProcessOptions) (image.Image, error) {
+	if len(ops) == 0 {
+		img = p.resize(img, options)
+	}
+	for _, op := range ops {
+		var err error
+		if op.Name == OpResize {
+			img = p.resize(img, options)
+			continue
+		}
+		if img, err = applyOperation(img, op, options); err != nil {
+			return nil, err
+		}
+	}
+	return p.applyWatermark(img, options)
+}
+
+func applyOperation(img image.Image, op Operation, options ProcessOptions) (image.Image, error) {
+	switch op.Name {
+	case OpRotate:
+		background := options.Background
+		if background == nil {
+			background = color.Transparent
+		}
+		// imaging rotates counter-clockwise
+		return imaging.Rotate(img, -op.Args[0], background), nil
+	case OpFlip:
+		if op.Axis == "v" {
+			return imaging.FlipV(img), nil
+		}
+		return imaging.FlipH(img), nil
+	case OpCrop:
+		b := img.Bounds()
+		x, y := b.Min.X+int(op.Args[0]), b.Min.Y+int(op.Args[1])
+		rect := image.Rect(x, y, x+int(op.Args[2]), y+int(op.Args[3])).Intersect(b)
+		if rect.Empty() {
+			return nil, fmt.Errorf("%w: %s is outside the %dx%d image", ErrInvalidOperation, op, b.Dx(), b.Dy())
+		}
+		return imaging.Crop(img, rect), nil
+	case OpBlur:
+		return imaging.Blur(img, op.Args[0]), nil
+	case OpSharpen:
+		return imaging.Sharpen(img, op.Args[0]), nil
+	case OpGrayscale:
+		return imaging.Grayscale(img), nil
+	case OpBrightness:
+		return imaging.AdjustBrightness(img, op.Args[0]), nil
+	case OpContrast:
+		return imaging.AdjustContrast(img, op.Args[0]), nil
+	case OpGamma:
+		return imaging.AdjustGamma(img, op.Args[0]), nil
+	case OpSaturation:
+		return imaging.AdjustSaturation(img, op.Args[0]), nil
+	}
+	return nil, fmt.Errorf("%w: unknown operation %q", ErrInvalidOperation, op.Name)
+}
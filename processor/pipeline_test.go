@@ -0,0 +1,193 @@
+package processor
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+
+	"image-converting-server/config"
+)
+
+func TestParseOperations(t *testing.T) {
+	ops, err := ParseOperations("rotate:90|flip:h|crop:0,0,100,50|blur:1.5|grayscale|gamma:2.2")
+	if err != nil {
+		t.Fatalf("ParseOperations failed: %v", err)
+	}
+	if len(ops) != 6 {
+		t.Fatalf("expected 6 operations, got %d", len(ops))
+	}
+	if ops[1].Axis != "h" || len(ops[2].Args) != 4 || ops[3].Args[0] != 1.5 {
+		t.Errorf("unexpected operations: %+v", ops)
+	}
+	if got := FormatOperations(ops); got != "rotate:90|flip:h|crop:0,0,100,50|blur:1.5|grayscale|gamma:2.2" {
+		t.Errorf("FormatOperations round trip: got %s", got)
+	}
+
+	if ops, err := ParseOperations(""); err != nil || len(ops) != 0 {
+		t.Errorf("expected no operations for an empty string, got %v (%v)", ops, err)
+	}
+
+	long := strings.Repeat("blur:100|", maxOperations)
+	if ops, err := ParseOperations(strings.TrimSuffix(long, "|")); err != nil || len(ops) != maxOperations {
+		t.Errorf("expected %d operations to be allowed, got %d (%v)", maxOperations, len(ops), err)
+	}
+	if _, err := ParseOperations(long + "sharpen:100"); !errors.Is(err, ErrInvalidOperation) {
+		t.Errorf("expected ErrInvalidOperation for %d operations, got %v", maxOperations+1, err)
+	}
+}
+
+func TestParseOperation_Invalid(t *testing.T) {
+	invalid := []string{
+		"",
+		"spin:90",
+		"rotate",
+		"rotate:ninety",
+		"flip:x",
+		"crop:0,0,100",
+		"crop:0,0,0,100",
+		"crop:-1,0,10,10",
+		"blur:0",
+		"blur:500",
+		"brightness:150",
+		"gamma:0",
+		"grayscale:1",
+	}
+	for _, s := range invalid {
+		if _, err := ParseOperation(s); !errors.Is(err, ErrInvalidOperation) {
+			t.Errorf("ParseOperation(%q): expected ErrInvalidOperation, got %v", s, err)
+		}
+	}
+}
+
+func TestProcessor_Transform(t *testing.T) {
+	p := NewProcessor(config.Config{})
+	red := color.NRGBA{255, 0, 0, 255}
+	blue := color.NRGBA{0, 0, 255, 255}
+
+	tests := []struct {
+		name     string
+		ops      string
+		size     image.Point
+		pixel    image.Point
+		expected color.NRGBA
+	}{
+		// makeSplitImage is 200x100, red on the left and blue on the right
+		{"rotate clockwise", "rotate:90", image.Pt(100, 200), image.Pt(50, 10), red},
+		{"flip horizontally", "flip:h", image.Pt(200, 100), image.Pt(10, 50), blue},
+		{"flip vertically", "flip:v", image.Pt(200, 100), image.Pt(10, 50), red},
+		{"crop", "crop:150,0,20,20", image.Pt(20, 20), image.Pt(10, 10), blue},
+		{"crop clamped to the image", "crop:180,90,100,100", image.Pt(20, 10), image.Pt(5, 5), blue},
+		{"operations run in order", "crop:0,0,100,100|flip:h|crop:0,0,10,10", image.Pt(10, 10), image.Pt(5, 5), red},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ops, err := ParseOperations(tt.ops)
+			if err != nil {
+				t.Fatalf("ParseOperations failed: %v", err)
+			}
+			out, err := p.transform(makeSplitImage(), ProcessOptions{Operations: ops})
+			if err != nil {
+				t.Fatalf("transform failed: %v", err)
+			}
+			if size := out.Bounds().Size(); size != tt.size {
+				t.Errorf("expected size %v, got %v", tt.size, size)
+			}
+			if got := nrgbaAt(out, tt.pixel.X, tt.pixel.Y); got != tt.expected {
+				t.Errorf("pixel %v: expected %v, got %v", tt.pixel, tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestProcessor_Transform_Adjustments(t *testing.T) {
+	p := NewProcessor(config.Config{})
+	for _, s := range []string{"grayscale", "saturation:-100"} {
+		ops, _ := ParseOperations(s)
+		out, err := p.transform(makeSplitImage(), ProcessOptions{Operations: ops})
+		if err != nil {
+			t.Fatalf("%s: transform failed: %v", s, err)
+		}
+		if c := nrgbaAt(out, 10, 10); c.R != c.G || c.G != c.B {
+			t.Errorf("%s: expected a gray pixel, got %v", s, c)
+		}
+	}
+
+	ops, _ := ParseOperations("brightness:-100")
+	out, _ := p.transform(makeSplitImage(), ProcessOptions{Operations: ops})
+	if c := nrgbaAt(out, 10, 10); c != (color.NRGBA{0, 0, 0, 255}) {
+		t.Errorf("brightness:-100: expected black, got %v", c)
+	}
+}
+
+func TestProcessor_Transform_ResizePlacement(t *testing.T) {
+	p := NewProcessor(config.Config{})
+
+	// Without a resize operation the crop uses source coordinates and resizing runs last
+	ops, _ := ParseOperations("crop:0,0,100,100")
+	out, err := p.transform(makeSplitImage(), ProcessOptions{Width: 50, Operations: ops})
+	if err != nil {
+		t.Fatalf("transform failed: %v", err)
+	}
+	if size := out.Bounds().Size(); size != image.Pt(50, 50) {
+		t.Errorf("expected 50x50, got %v", size)
+	}
+
+	// With one, the crop applies to the resized image
+	ops, _ = ParseOperations("resize|crop:0,0,100,100")
+	out, err = p.transform(makeSplitImage(), ProcessOptions{Width: 50, Operations: ops})
+	if err != nil {
+		t.Fatalf("transform failed: %v", err)
+	}
+	if size := out.Bounds().Size(); size != image.Pt(50, 25) {
+		t.Errorf("expected 50x25, got %v", size)
+	}
+}
+
+func TestProcessor_Transform_CropOutside(t *testing.T) {
+	p := NewProcessor(config.Config{})
+	ops, _ := ParseOperations("crop:500,500,10,10")
+	if _, err := p.transform(makeSplitImage(), ProcessOptions{Operations: ops}); !errors.Is(err, ErrInvalidOperation) {
+		t.Errorf("expected ErrInvalidOperation, got %v", err)
+	}
+}
+
+func TestProcessor_Transform_PresetPipeline(t *testing.T) {
+	p := NewProcessor(config.Config{Resize: config.ResizeConfig{Presets: map[string]config.PresetConfig{
+		"mono":      {Operations: []string{"grayscale"}},
+		"mono_half": {Width: 100, Height: 50, Operations: []string{"crop:100,0,100,100", "grayscale"}},
+	}}})
+
+	out, err := p.transform(makeSplitImage(), ProcessOptions{Preset: "mono"})
+	if err != nil {
+		t.Fatalf("transform failed: %v", err)
+	}
+	if size := out.Bounds().Size(); size != image.Pt(200, 100) {
+		t.Errorf("pipeline-only preset: expected the size to be unchanged, got %v", size)
+	}
+	if c := nrgbaAt(out, 10, 10); c.R != c.G || c.G != c.B {
+		t.Errorf("expected a gray pixel, got %v", c)
+	}
+
+	// Preset operations run before the request's
+	ops, _ := ParseOperations("flip:v")
+	out, err = p.transform(makeSplitImage(), ProcessOptions{Preset: "mono_half", Operations: ops})
+	if err != nil {
+		t.Fatalf("transform failed: %v", err)
+	}
+	if size := out.Bounds().Size(); size != image.Pt(100, 50) {
+		t.Errorf("expected 100x50, got %v", size)
+	}
+}
+
+func TestCheckPresets(t *testing.T) {
+	valid := map[string]config.PresetConfig{"a": {Operations: []string{"rotate:180", "sharpen:1"}}}
+	if err := CheckPresets(valid); err != nil {
+		t.Errorf("expected valid presets, got %v", err)
+	}
+	invalid := map[string]config.PresetConfig{"b": {Operations: []string{"warp:3"}}}
+	if err := CheckPresets(invalid); !errors.Is(err, ErrInvalidOperation) {
+		t.Errorf("expected ErrInvalidOperation, got %v", err)
+	}
+}
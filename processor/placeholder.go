@@ -0,0 +1,312 @@
+package processor
+
+import (
+	"encoding/base64"
+	"fmt"
+	"image"
+	"math"
+	"strings"
+
+	"image-converting-server/config"
+
+	"github.com/disintegration/imaging"
+)
+
+// Placeholder kinds accepted in the placeholders request parameter
+const (
+	PlaceholderBlurHash  = "blurhash"
+	PlaceholderThumbHash = "thumbhash"
+	PlaceholderPreview   = "preview"
+)
+
+const (
+	// hashSourceSize bounds the image the hashes are computed from; they only
+	// keep a handful of frequencies, so more pixels add cost but no detail
+	hashSourceSize = 64
+	// defaultPreviewSize is the longest side of the preview when not configured
+	defaultPreviewSize = 20
+	previewQuality     = 50
+)
+
+// base83Chars is the BlurHash digit alphabet
+const base83Chars = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~"
+
+// Placeholders are low-quality previews of an output, shown while it loads
+type Placeholders struct {
+	BlurHash  string `json:"blurhash,omitempty"`
+	ThumbHash string `json:"thumbhash,omitempty"` // Base64
+	Preview   string `json:"preview,omitempty"`   // Tiny WebP as a data URI
+}
+
+// Metadata returns the placeholders as object metadata, keyed by kind
+func (p *Placeholders) Metadata() map[string]string {
+	if p == nil {
+		return nil
+	}
+	md := make(map[string]string)
+	for key, value := range map[string]string{
+		PlaceholderBlurHash:  p.BlurHash,
+		PlaceholderThumbHash: p.ThumbHash,
+		PlaceholderPreview:   p.Preview,
+	} {
+		if value != "" {
+			md[key] = value
+		}
+	}
+	return md
+}
+
+// ParsePlaceholders parses a comma-separated list of placeholder kinds, or "none"
+func ParsePlaceholders(s string) (config.PlaceholderConfig, error) {
+	var cfg config.PlaceholderConfig
+	if s == "none" {
+		return cfg, nil
+	}
+	for _, kind := range strings.Split(s, ",") {
+		switch strings.TrimSpace(kind) {
+		case PlaceholderBlurHash:
+			cfg.BlurHash = true
+		case PlaceholderThumbHash:
+			cfg.ThumbHash = true
+		case PlaceholderPreview:
+			cfg.Preview = true
+		default:
+			return cfg, fmt.Errorf("unknown placeholder: %s", kind)
+		}
+	}
+	return cfg, nil
+}
+
+// placeholders computes the placeholders selected by options for img, or returns
+// nil if none are selected
+func (p *Processor) placeholders(img image.Image, options ProcessOptions) (*Placeholders, error) {
+	cfg := p.cfg.Conversion.Placeholders
+	if options.Placeholders != nil {
+		cfg = *options.Placeholders
+	}
+	if !cfg.BlurHash && !cfg.ThumbHash && !cfg.Preview || img.Bounds().Empty() {
+		return nil, nil
+	}
+
+	result := &Placeholders{}
+	if cfg.BlurHash || cfg.ThumbHash {
+		small := imaging.Fit(img, hashSourceSize, hashSourceSize, imaging.Box)
+		if cfg.BlurHash {
+			result.BlurHash = blurHash(small)
+		}
+		if cfg.ThumbHash {
+			result.ThumbHash = base64.StdEncoding.EncodeToString(thumbHash(small))
+		}
+	}
+	if cfg.Preview {
+		size := p.cfg.Conversion.Placeholders.PreviewSize
+		if size <= 0 {
+			size = defaultPreviewSize
+		}
+		enc := &webpEncoder{quality: previewQuality}
+		data, err := enc.Encode(imaging.Fit(img, size, size, imaging.Lanczos), EncodeOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode preview: %w", err)
+		}
+		result.Preview = "data:image/webp;base64," + base64.StdEncoding.EncodeToString(data)
+	}
+	return result, nil
+}
+
+// blurHash encodes img as a BlurHash with 4x3 components, or 3x4 for portrait images.
+// Transparent pixels are composited over white, as the JPEG encoder does.
+func blurHash(img *image.NRGBA) string {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	xComp, yComp := 4, 3
+	if h > w {
+		xComp, yComp = 3, 4
+	}
+
+	linear := make([][3]float64, w*h)
+	for y := 0; y < h; y++ {
+		row := img.Pix[img.PixOffset(img.Rect.Min.X, img.Rect.Min.Y+y):]
+		for x := 0; x < w; x++ {
+			px := row[x*4 : x*4+4]
+			a := float64(px[3]) / 255
+			for c := 0; c < 3; c++ {
+				linear[y*w+x][c] = srgbToLinear((float64(px[c])*a + 255*(1-a)) / 255)
+			}
+		}
+	}
+
+	factors := make([][3]float64, 0, xComp*yComp)
+	for cy := 0; cy < yComp; cy++ {
+		for cx := 0; cx < xComp; cx++ {
+			norm := 2.0
+			if cx == 0 && cy == 0 {
+				norm = 1
+			}
+			var f [3]float64
+			for y := 0; y < h; y++ {
+				fy := math.Cos(math.Pi * float64(cy) * float64(y) / float64(h))
+				for x := 0; x < w; x++ {
+					basis := norm * fy * math.Cos(math.Pi*float64(cx)*float64(x)/float64(w))
+					for c := 0; c < 3; c++ {
+						f[c] += basis * linear[y*w+x][c]
+					}
+				}
+			}
+			for c := range f {
+				f[c] /= float64(w * h)
+			}
+			factors = append(factors, f)
+		}
+	}
+
+	var sb strings.Builder
+	writeBase83(&sb, (xComp-1)+(yComp-1)*9, 1)
+
+	ac := factors[1:]
+	var actualMax float64
+	for _, f := range ac {
+		for _, v := range f {
+			actualMax = math.Max(actualMax, math.Abs(v))
+		}
+	}
+	quantisedMax := int(math.Max(0, math.Min(82, math.Floor(actualMax*166-0.5))))
+	maxValue := float64(quantisedMax+1) / 166
+	writeBase83(&sb, quantisedMax, 1)
+
+	dc := factors[0]
+	writeBase83(&sb, quantizeSRGB(dc[0])<<16|quantizeSRGB(dc[1])<<8|quantizeSRGB(dc[2]), 4)
+	for _, f := range ac {
+		var value int
+		for _, v := range f {
+			q := int(math.Max(0, math.Min(18, math.Floor(signPow(v/maxValue, 0.5)*9+9.5))))
+			value = value*19 + q
+		}
+		writeBase83(&sb, value, 2)
+	}
+	return sb.String()
+}
+
+func writeBase83(sb *strings.Builder, value, length int) {
+	for i := length - 1; i >= 0; i-- {
+		sb.WriteByte(base83Chars[value/int(math.Pow(83, float64(i)))%83])
+	}
+}
+
+// quantizeSRGB converts a linear value to an 8-bit sRGB value
+func quantizeSRGB(v float64) int {
+	return int(linearToSRGB(math.Max(0, math.Min(1, v)))*255 + 0.5)
+}
+
+func signPow(v, exp float64) float64 {
+	return math.Copysign(math.Pow(math.Abs(v), exp), v)
+}
+
+// thumbHash encodes img, at most 100x100, as a ThumbHash. Unlike BlurHash it keeps
+// the aspect ratio and alpha channel.
+func thumbHash(img *image.NRGBA) []byte {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	n := w * h
+
+	// Average color, weighted by alpha
+	var avgR, avgG, avgB, avgA float64
+	pixel := func(i int) []uint8 {
+		off := img.PixOffset(img.Rect.Min.X+i%w, img.Rect.Min.Y+i/w)
+		return img.Pix[off : off+4]
+	}
+	for i := 0; i < n; i++ {
+		px := pixel(i)
+		alpha := float64(px[3]) / 255
+		avgR += alpha / 255 * float64(px[0])
+		avgG += alpha / 255 * float64(px[1])
+		avgB += alpha / 255 * float64(px[2])
+		avgA += alpha
+	}
+	if avgA > 0 {
+		avgR, avgG, avgB = avgR/avgA, avgG/avgA, avgB/avgA
+	}
+
+	hasAlpha := avgA < float64(n)
+	lLimit := 7.0 // Fewer luminance components when there is alpha
+	if hasAlpha {
+		lLimit = 5
+	}
+	longest := float64(max(w, h))
+	lx := max(1, int(math.Round(lLimit*float64(w)/longest)))
+	ly := max(1, int(math.Round(lLimit*float64(h)/longest)))
+
+	// Luminance, yellow-blue, red-green and alpha, composited over the average color
+	l, p, q, a := make([]float64, n), make([]float64, n), make([]float64, n), make([]float64, n)
+	for i := 0; i < n; i++ {
+		px := pixel(i)
+		alpha := float64(px[3]) / 255
+		r := avgR*(1-alpha) + alpha/255*float64(px[0])
+		g := avgG*(1-alpha) + alpha/255*float64(px[1])
+		b := avgB*(1-alpha) + alpha/255*float64(px[2])
+		l[i], p[i], q[i], a[i] = (r+g+b)/3, (r+g)/2-b, r-g, alpha
+	}
+
+	encode := func(channel []float64, nx, ny int) (dc float64, ac []float64, scale float64) {
+		fx := make([]float64, w)
+		for cy := 0; cy < ny; cy++ {
+			for cx := 0; cx*ny < nx*(ny-cy); cx++ {
+				for x := range fx {
+					fx[x] = math.Cos(math.Pi / float64(w) * float64(cx) * (float64(x) + 0.5))
+				}
+				var f float64
+				for y := 0; y < h; y++ {
+					fy := math.Cos(math.Pi / float64(h) * float64(cy) * (float64(y) + 0.5))
+					for x := 0; x < w; x++ {
+						f += channel[x+y*w] * fx[x] * fy
+					}
+				}
+				f /= float64(n)
+				if cx > 0 || cy > 0 {
+					ac = append(ac, f)
+					scale = math.Max(scale, math.Abs(f))
+				} else {
+					dc = f
+				}
+			}
+		}
+		if scale > 0 {
+			for i := range ac {
+				ac[i] = 0.5 + 0.5/scale*ac[i]
+			}
+		}
+		return dc, ac, scale
+	}
+	lDC, lAC, lScale := encode(l, max(3, lx), max(3, ly))
+	pDC, pAC, pScale := encode(p, 3, 3)
+	qDC, qAC, qScale := encode(q, 3, 3)
+	channels := [][]float64{lAC, pAC, qAC}
+
+	round := func(v float64) int { return int(math.Round(v)) }
+	isLandscape := w > h
+	header24 := round(63*lDC) | round(31.5+31.5*pDC)<<6 | round(31.5+31.5*qDC)<<12 | round(31*lScale)<<18
+	header16 := lx | round(63*pScale)<<3 | round(63*qScale)<<9
+	if hasAlpha {
+		header24 |= 1 << 23
+	}
+	if isLandscape {
+		header16 = ly | round(63*pScale)<<3 | round(63*qScale)<<9 | 1<<15
+	}
+	hash := []byte{byte(header24), byte(header24 >> 8), byte(header24 >> 16), byte(header16), byte(header16 >> 8)}
+	if hasAlpha {
+		aDC, aAC, aScale := encode(a, 5, 5)
+		hash = append(hash, byte(round(15*aDC)|round(15*aScale)<<4))
+		channels = append(channels, aAC)
+	}
+
+	acStart := len(hash)
+	acIndex := 0
+	for _, ac := range channels {
+		for _, f := range ac {
+			i := acStart + acIndex>>1
+			if i == len(hash) {
+				hash = append(hash, 0)
+			}
+			hash[i] |= byte(round(15*f) << ((acIndex & 1) << 2))
+			acIndex++
+		}
+	}
+	return hash
+}
@@ -0,0 +1,127 @@
+package processor
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/color"
+	"math"
+	"strings"
+	"testing"
+
+	"image-converting-server/config"
+
+	"github.com/chai2010/webp"
+)
+
+func TestBlurHash(t *testing.T) {
+	// The well-known hash of a black image: every AC digit pair is the zero value "fQ"
+	if got := blurHash(solidImage(16, 16, color.NRGBA{0, 0, 0, 255})); got != "L00000fQfQfQfQfQfQfQfQfQfQfQ" {
+		t.Errorf("black: got %s", got)
+	}
+	// The DC digits hold the average color, 0xff0000 in base 83
+	if got := blurHash(solidImage(16, 16, color.NRGBA{255, 0, 0, 255})); got[2:6] != "TI:j" {
+		t.Errorf("red: got %s", got)
+	}
+	// Portrait images use 3x4 components
+	if got := blurHash(solidImage(8, 16, color.NRGBA{0, 0, 0, 255})); !strings.HasPrefix(got, "T") || len(got) != 28 {
+		t.Errorf("portrait: got %s", got)
+	}
+	// Detail shows up in the AC components
+	if got := blurHash(texturedImage(32, 24)); strings.HasSuffix(got, "fQfQfQ") || len(got) != 28 {
+		t.Errorf("textured: got %s", got)
+	}
+}
+
+// thumbHashAverage decodes the average color stored in a ThumbHash header
+func thumbHashAverage(hash []byte) (r, g, b float64) {
+	header := int(hash[0]) | int(hash[1])<<8 | int(hash[2])<<16
+	l := float64(header&63) / 63
+	p := float64(header>>6&63)/31.5 - 1
+	q := float64(header>>12&63)/31.5 - 1
+	b = l - 2.0/3*p
+	r = (3*l - b + q) / 2
+	return r, r - q, b
+}
+
+func TestThumbHash(t *testing.T) {
+	hash := thumbHash(solidImage(40, 20, color.NRGBA{255, 0, 0, 255}))
+	r, g, b := thumbHashAverage(hash)
+	if math.Abs(r-1) > 0.03 || math.Abs(g) > 0.03 || math.Abs(b) > 0.03 {
+		t.Errorf("expected an average of red, got %.2f %.2f %.2f", r, g, b)
+	}
+	if hash[2]&0x80 != 0 {
+		t.Error("expected no alpha flag for an opaque image")
+	}
+	if hash[4]&0x80 == 0 {
+		t.Error("expected the landscape flag for a 40x20 image")
+	}
+
+	transparent := thumbHash(solidImage(20, 40, color.NRGBA{0, 0, 255, 0}))
+	if transparent[2]&0x80 == 0 || transparent[4]&0x80 != 0 {
+		t.Errorf("expected the alpha flag and no landscape flag, got %x", transparent)
+	}
+	if len(transparent) <= len(hash) {
+		t.Errorf("expected alpha to add components: %d vs %d bytes", len(transparent), len(hash))
+	}
+}
+
+func TestParsePlaceholders(t *testing.T) {
+	cfg, err := ParsePlaceholders("blurhash, preview")
+	if err != nil || !cfg.BlurHash || cfg.ThumbHash || !cfg.Preview {
+		t.Errorf("unexpected result: %+v (%v)", cfg, err)
+	}
+	if cfg, err := ParsePlaceholders("none"); err != nil || cfg.BlurHash || cfg.ThumbHash || cfg.Preview {
+		t.Errorf("none: unexpected result: %+v (%v)", cfg, err)
+	}
+	if _, err := ParsePlaceholders("blurhash,lqip"); err == nil {
+		t.Error("expected an error for an unknown kind")
+	}
+}
+
+func TestProcessor_Placeholders(t *testing.T) {
+	p := NewProcessor(config.Config{Conversion: config.ConversionConfig{
+		Formats:      []string{"png"},
+		Placeholders: config.PlaceholderConfig{BlurHash: true, ThumbHash: true, Preview: true, PreviewSize: 16},
+	}})
+	input := encodePNG(t, texturedImage(200, 100))
+
+	result, err := p.ProcessResult(input, ProcessOptions{Width: 100})
+	if err != nil {
+		t.Fatalf("ProcessResult failed: %v", err)
+	}
+	ph := result.Placeholders
+	if ph == nil || len(ph.BlurHash) != 28 || ph.ThumbHash == "" {
+		t.Fatalf("expected every placeholder, got %+v", ph)
+	}
+	if _, err := base64.StdEncoding.DecodeString(ph.ThumbHash); err != nil {
+		t.Errorf("ThumbHash is not base64: %v", err)
+	}
+	data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(ph.Preview, "data:image/webp;base64,"))
+	if err != nil {
+		t.Fatalf("preview is not a base64 data URI: %v", err)
+	}
+	preview, err := webp.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("failed to decode preview: %v", err)
+	}
+	if size := preview.Bounds().Size(); size != image.Pt(16, 8) {
+		t.Errorf("expected a 16x8 preview, got %v", size)
+	}
+	if md := ph.Metadata(); len(md) != 3 || md[PlaceholderBlurHash] != ph.BlurHash {
+		t.Errorf("unexpected metadata: %v", md)
+	}
+
+	// The request's selection replaces the configured one
+	result, err = p.ProcessResult(input, ProcessOptions{Placeholders: &config.PlaceholderConfig{ThumbHash: true}})
+	if err != nil {
+		t.Fatalf("ProcessResult failed: %v", err)
+	}
+	if ph := result.Placeholders; ph == nil || ph.BlurHash != "" || ph.ThumbHash == "" || ph.Preview != "" {
+		t.Errorf("expected only a ThumbHash, got %+v", ph)
+	}
+	result, _ = p.ProcessResult(input, ProcessOptions{Placeholders: &config.PlaceholderConfig{}})
+	if result.Placeholders != nil || result.Placeholders.Metadata() != nil {
+		t.Errorf("expected no placeholders, got %+v", result.Placeholders)
+	}
+}
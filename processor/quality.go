@@ -0,0 +1,161 @@
+package processor
+
+import (
+	"bytes"
+	"image"
+
+	"image-converting-server/metrics"
+)
+
+// QualitySearch chooses the encoder quality per image; see searchQuality
+type QualitySearch struct {
+	TargetSSIM float64 // Lowest quality whose output reaches this SSIM; 0 disables
+	MaxBytes   int     // Highest quality whose output fits this size; 0 disables
+	MinQuality int     // Search bounds; 0 uses conversion.quality_search
+	MaxQuality int
+}
+
+// Enabled reports whether a target or a byte budget is set
+func (s QualitySearch) Enabled() bool {
+	return s.TargetSSIM > 0 || s.MaxBytes > 0
+}
+
+// lossyEncoder is implemented by encoders whose output depends on EncodeOptions.Quality
+type lossyEncoder interface {
+	Lossy() bool
+}
+
+func (e *webpEncoder) Lossy() bool { return !e.lossless }
+func (e *jpegEncoder) Lossy() bool { return true }
+func (e *avifEncoder) Lossy() bool { return true }
+
+// isLossy reports whether the encoder's output depends on the quality
+func isLossy(encoder Encoder) bool {
+	lossy, ok := encoder.(lossyEncoder)
+	return ok && lossy.Lossy()
+}
+
+// qualitySearch returns the search to run for options: the request's, then
+// conversion.quality_search. An explicit quality disables the search.
+func (p *Processor) qualitySearch(options ProcessOptions) QualitySearch {
+	if options.Quality > 0 {
+		return QualitySearch{}
+	}
+	cfg := p.cfg.Conversion.QualitySearch
+	search := QualitySearch{TargetSSIM: cfg.TargetSSIM, MaxBytes: cfg.MaxBytes}
+	if cfg.TargetDSSIM > 0 {
+		search.TargetSSIM = metrics.SSIMFromDSSIM(cfg.TargetDSSIM)
+	}
+	if options.QualitySearch != nil {
+		search = *options.QualitySearch
+	}
+	if search.MinQuality == 0 {
+		search.MinQuality = cfg.MinQuality
+	}
+	if search.MaxQuality == 0 {
+		search.MaxQuality = cfg.MaxQuality
+	}
+	if search.MinQuality == 0 {
+		search.MinQuality = 1
+	}
+	if search.MaxQuality == 0 || search.MaxQuality > 100 {
+		search.MaxQuality = 100
+	}
+	// A request may set one bound past the configured other
+	search.MinQuality = min(search.MinQuality, search.MaxQuality)
+	return search
+}
+
+// searchResult is the output chosen by searchQuality
+type searchResult struct {
+	data    []byte
+	quality int
+	ssim    float64 // 0 if the output could not be decoded for scoring
+}
+
+// searchQuality binary-searches the encoder quality within the search bounds.
+// With a byte budget, the highest quality whose output fits is the ceiling (the
+// minimum quality if none fits); with an SSIM target, the lowest quality up to
+// that ceiling whose decoded output reaches the target is chosen. Outputs that
+// cannot be decoded here, such as AVIF, are only searched by size.
+func searchQuality(img image.Image, encoder Encoder, opts EncodeOptions, search QualitySearch) (*searchResult, error) {
+	outputs := make(map[int][]byte)
+	encode := func(quality int) ([]byte, error) {
+		if data, ok := outputs[quality]; ok {
+			return data, nil
+		}
+		o := opts
+		o.Quality = quality
+		data, err := encoder.Encode(img, o)
+		if err != nil {
+			return nil, err
+		}
+		outputs[quality] = data
+		return data, nil
+	}
+
+	var reference *metrics.Luma
+	scores := make(map[int]float64)
+	score := func(quality int) (float64, bool) {
+		if s, ok := scores[quality]; ok {
+			return s, true
+		}
+		decoded, _, err := image.Decode(bytes.NewReader(outputs[quality]))
+		if err != nil {
+			return 0, false
+		}
+		if reference == nil {
+			reference = metrics.NewLuma(img)
+		}
+		s, err := reference.SSIM(metrics.NewLuma(decoded))
+		if err != nil {
+			return 0, false
+		}
+		scores[quality] = s
+		return s, true
+	}
+
+	lo, hi := search.MinQuality, search.MaxQuality
+	if search.MaxBytes > 0 {
+		for lo < hi {
+			mid := (lo + hi + 1) / 2
+			data, err := encode(mid)
+			if err != nil {
+				return nil, err
+			}
+			if len(data) <= search.MaxBytes {
+				lo = mid
+			} else {
+				hi = mid - 1
+			}
+		}
+		lo, hi = search.MinQuality, lo
+	}
+
+	if search.TargetSSIM > 0 {
+		for lo < hi {
+			mid := (lo + hi) / 2
+			if _, err := encode(mid); err != nil {
+				return nil, err
+			}
+			s, ok := score(mid)
+			if !ok {
+				// Not decodable, so the target cannot be checked
+				lo = hi
+				break
+			}
+			if s >= search.TargetSSIM {
+				hi = mid
+			} else {
+				lo = mid + 1
+			}
+		}
+	}
+
+	data, err := encode(hi)
+	if err != nil {
+		return nil, err
+	}
+	s, _ := score(hi)
+	return &searchResult{data: data, quality: hi, ssim: s}, nil
+}
@@ -0,0 +1,147 @@
+package processor
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"math"
+	"testing"
+
+	"image-converting-server/config"
+	"image-converting-server/metrics"
+)
+
+// texturedImage returns a deterministic image whose encoded size and SSIM both
+// depend noticeably on the quality
+func texturedImage(w, h int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	seed := uint32(1)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			seed = seed*1664525 + 1013904223
+			noise := uint8(seed >> 27)
+			img.SetNRGBA(x, y, color.NRGBA{uint8(x*2) + noise, uint8(y*2) + noise, uint8(x+y) - noise, 255})
+		}
+	}
+	return img
+}
+
+func scoreAt(t *testing.T, img image.Image, encoder Encoder, quality int) (float64, int) {
+	t.Helper()
+	data, err := encoder.Encode(img, EncodeOptions{Quality: quality})
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := metrics.SSIM(img, decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, len(data)
+}
+
+func TestSearchQuality_TargetSSIM(t *testing.T) {
+	img := texturedImage(96, 96)
+	encoder := &jpegEncoder{}
+	search := QualitySearch{TargetSSIM: 0.9, MinQuality: 10, MaxQuality: 95}
+
+	found, err := searchQuality(img, encoder, EncodeOptions{}, search)
+	if err != nil {
+		t.Fatalf("searchQuality failed: %v", err)
+	}
+	if found.ssim < search.TargetSSIM {
+		t.Errorf("expected SSIM >= %v, got %v at quality %d", search.TargetSSIM, found.ssim, found.quality)
+	}
+	if found.quality <= search.MinQuality || found.quality >= search.MaxQuality {
+		t.Fatalf("expected a quality strictly inside the bounds, got %d", found.quality)
+	}
+	// The chosen quality is the lowest that reaches the target
+	if below, _ := scoreAt(t, img, encoder, found.quality-1); below >= search.TargetSSIM {
+		t.Errorf("quality %d already reaches the target (%v)", found.quality-1, below)
+	}
+}
+
+func TestSearchQuality_MaxBytes(t *testing.T) {
+	img := texturedImage(96, 96)
+	encoder := &jpegEncoder{}
+	_, high := scoreAt(t, img, encoder, 90)
+	_, low := scoreAt(t, img, encoder, 20)
+	budget := (high + low) / 2
+
+	found, err := searchQuality(img, encoder, EncodeOptions{}, QualitySearch{MaxBytes: budget, MinQuality: 10, MaxQuality: 95})
+	if err != nil {
+		t.Fatalf("searchQuality failed: %v", err)
+	}
+	if len(found.data) > budget {
+		t.Errorf("expected at most %d bytes, got %d", budget, len(found.data))
+	}
+	if _, above := scoreAt(t, img, encoder, found.quality+1); above <= budget {
+		t.Errorf("quality %d also fits the budget (%d bytes)", found.quality+1, above)
+	}
+	if found.ssim <= 0 {
+		t.Errorf("expected the chosen output to be scored, got %v", found.ssim)
+	}
+
+	// The byte budget caps the quality an SSIM target would choose
+	capped, err := searchQuality(img, encoder, EncodeOptions{}, QualitySearch{TargetSSIM: 0.999, MaxBytes: budget, MinQuality: 10, MaxQuality: 95})
+	if err != nil {
+		t.Fatalf("searchQuality failed: %v", err)
+	}
+	if capped.quality != found.quality {
+		t.Errorf("expected the budget's quality %d, got %d", found.quality, capped.quality)
+	}
+
+	// An unreachable budget falls back to the minimum quality
+	found, err = searchQuality(img, encoder, EncodeOptions{}, QualitySearch{MaxBytes: 10, MinQuality: 10, MaxQuality: 95})
+	if err != nil {
+		t.Fatalf("searchQuality failed: %v", err)
+	}
+	if found.quality != 10 {
+		t.Errorf("expected the minimum quality, got %d", found.quality)
+	}
+}
+
+func TestProcessor_QualitySearch(t *testing.T) {
+	input := encodePNG(t, texturedImage(64, 64))
+	p := NewProcessor(config.Config{
+		Conversion: config.ConversionConfig{
+			Formats: []string{"png"},
+			Quality: 80,
+			QualitySearch: config.QualitySearchConfig{
+				TargetDSSIM: 0.05, MinQuality: 20, MaxQuality: 90,
+			},
+		},
+	})
+
+	if search := p.qualitySearch(ProcessOptions{}); math.Abs(search.TargetSSIM-0.9) > 1e-9 || search.MinQuality != 20 {
+		t.Errorf("expected the configured DSSIM as an SSIM target, got %+v", search)
+	}
+	if search := p.qualitySearch(ProcessOptions{QualitySearch: &QualitySearch{MaxBytes: 1000}}); search.TargetSSIM != 0 || search.MaxBytes != 1000 || search.MaxQuality != 90 {
+		t.Errorf("expected the request's search with the configured bounds, got %+v", search)
+	}
+
+	for _, format := range []string{FormatWebP, FormatJPEG} {
+		result, err := p.ProcessResult(input, ProcessOptions{Format: format})
+		if err != nil {
+			t.Fatalf("%s: ProcessResult failed: %v", format, err)
+		}
+		if result.Quality < 20 || result.Quality > 90 || result.SSIM < 0.9 && result.Quality != 90 {
+			t.Errorf("%s: unexpected search result: quality %d, SSIM %v", format, result.Quality, result.SSIM)
+		}
+	}
+
+	// An explicit quality and lossless formats skip the search
+	result, err := p.ProcessResult(input, ProcessOptions{Format: FormatJPEG, Quality: 50})
+	if err != nil || result.Quality != 0 {
+		t.Errorf("explicit quality: expected no search, got quality %d (%v)", result.Quality, err)
+	}
+	for _, format := range []string{FormatPNG, FormatWebPLossless} {
+		result, err := p.ProcessResult(input, ProcessOptions{Format: format})
+		if err != nil || result.Quality != 0 {
+			t.Errorf("%s: expected no search, got quality %d (%v)", format, result.Quality, err)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package processor
+
+import (
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/color"
+	"strings"
+
+	"github.com/disintegration/imaging"
+)
+
+// Fit modes for resizing when both width and height are given
+const (
+	FitFill    = "fill"    // Stretch to the exact size (default)
+	FitCover   = "cover"   // Fill the box and crop the overflow
+	FitContain = "contain" // Fit within the box and letterbox to the exact size
+	FitInside  = "inside"  // Fit within the box, preserving aspect ratio
+	FitOutside = "outside" // Cover the box without cropping, preserving aspect ratio
+)
+
+// IsFitMode reports whether fit is a known fit mode
+func IsFitMode(fit string) bool {
+	switch fit {
+	case FitFill, FitCover, FitContain, FitInside, FitOutside:
+		return true
+	}
+	return false
+}
+
+// Gravity values for cover crops and contain placement
+const (
+	GravityCenter    = "center"
+	GravityNorth     = "north"
+	GravitySouth     = "south"
+	GravityEast      = "east"
+	GravityWest      = "west"
+	GravityNorthEast = "northeast"
+	GravityNorthWest = "northwest"
+	GravitySouthEast = "southeast"
+	GravitySouthWest = "southwest"
+)
+
+var gravityAnchors = map[string]imaging.Anchor{
+	GravityCenter:    imaging.Center,
+	GravityNorth:     imaging.Top,
+	GravitySouth:     imaging.Bottom,
+	GravityEast:      imaging.Right,
+	GravityWest:      imaging.Left,
+	GravityNorthEast: imaging.TopRight,
+	GravityNorthWest: imaging.TopLeft,
+	GravitySouthEast: imaging.BottomRight,
+	GravitySouthWest: imaging.BottomLeft,
+}
+
+// IsGravity reports whether gravity is a known gravity value
+func IsGravity(gravity string) bool {
+	_, ok := gravityAnchors[gravity]
+	return ok || gravity == GravitySmart
+}
+
+// ParseColor parses a hex color as RRGGBB or RRGGBBAA, with or without a leading '#'
+func ParseColor(s string) (color.NRGBA, error) {
+	b, err := hex.DecodeString(strings.TrimPrefix(s, "#"))
+	if err != nil || (len(b) != 3 && len(b) != 4) {
+		return color.NRGBA{}, fmt.Errorf("invalid color %q: expected RRGGBB or RRGGBBAA", s)
+	}
+	c := color.NRGBA{R: b[0], G: b[1], B: b[2], A: 255}
+	if len(b) == 4 {
+		c.A = b[3]
+	}
+	return c, nil
+}
+
+// resize applies the explicit size or the preset from options, if any.
+// An explicit fit or gravity wins over the preset's.
+func (p *Processor) resize(img image.Image, options ProcessOptions) image.Image {
+	width, height, options := p.resizeTarget(options)
+	if width == 0 && height == 0 {
+		return img
+	}
+	return resizeFit(img, width, height, options)
+}
+
+// resizeTarget returns the size to resize to, taking the size, fit mode and gravity
+// from the preset when the request sets no size. A zero size means no resize.
+func (p *Processor) resizeTarget(options ProcessOptions) (int, int, ProcessOptions) {
+	width, height := options.Width, options.Height
+	if width == 0 && height == 0 {
+		preset, ok := p.cfg.Resize.Presets[options.Preset]
+		if !ok || options.Preset == "" {
+			return 0, 0, options
+		}
+		// A pipeline-only preset has no size either
+		width, height = preset.Width, preset.Height
+		if options.Fit == "" {
+			options.Fit = preset.Fit
+		}
+		if options.Gravity == "" {
+			options.Gravity = preset.Gravity
+		}
+	}
+	return width, height, options
+}
+
+// ResizeImage resizes the image while maintaining aspect ratio if one dimension is 0
+func (p *Processor) ResizeImage(img image.Image, width, height int) image.Image {
+	// imaging.Resize maintains aspect ratio if one of the dimensions is 0
+	return imaging.Resize(img, width, height, imaging.Lanczos)
+}
+
+// ResizeImageFit resizes the image using the given fit mode, centered and with a
+// transparent letterbox. The fit mode only matters when both width and height are set.
+func (p *Processor) ResizeImageFit(img image.Image, width, height int, fit string) image.Image {
+	return resizeFit(img, width, height, ProcessOptions{Fit: fit})
+}
+
+// resizeFit resizes to width x height using the fit mode, gravity, focal point and
+// background from options
+func resizeFit(img image.Image, width, height int, options ProcessOptions) image.Image {
+	if width == 0 || height == 0 {
+		return imaging.Resize(img, width, height, imaging.Lanczos)
+	}
+	anchor, ok := gravityAnchors[options.Gravity]
+	if !ok {
+		anchor = imaging.Center
+	}
+	srcW, srcH := img.Bounds().Dx(), img.Bounds().Dy()
+
+	switch options.Fit {
+	case FitCover:
+		if options.Focal != nil || options.Gravity == GravitySmart {
+			return coverCrop(img, width, height, options.Focal)
+		}
+		return imaging.Fill(img, width, height, anchor, imaging.Lanczos)
+	case FitContain:
+		w, h := fitInside(srcW, srcH, width, height)
+		background := options.Background
+		if background == nil {
+			background = color.Transparent
+		}
+		canvas := imaging.New(width, height, background)
+		return imaging.Paste(canvas, imaging.Resize(img, w, h, imaging.Lanczos), anchorPoint(anchor, width-w, height-h))
+	case FitInside:
+		w, h := fitInside(srcW, srcH, width, height)
+		return imaging.Resize(img, w, h, imaging.Lanczos)
+	case FitOutside:
+		w, h := fitOutside(srcW, srcH, width, height)
+		return imaging.Resize(img, w, h, imaging.Lanczos)
+	default:
+		return imaging.Resize(img, width, height, imaging.Lanczos)
+	}
+}
+
+// fitInside returns the largest size with the source aspect ratio that fits in width x height
+func fitInside(srcW, srcH, width, height int) (int, int) {
+	if srcW*height > srcH*width {
+		return width, max(1, srcH*width/srcW)
+	}
+	return max(1, srcW*height/srcH), height
+}
+
+// fitOutside returns the smallest size with the source aspect ratio that covers width x height
+func fitOutside(srcW, srcH, width, height int) (int, int) {
+	if srcW*height > srcH*width {
+		return max(1, (srcW*height+srcH-1)/srcH), height
+	}
+	return width, max(1, (srcH*width+srcW-1)/srcW)
+}
+
+// anchorPoint returns the top-left position for content placed with the given
+// anchor, when freeW x freeH pixels of the box are left uncovered
+func anchorPoint(anchor imaging.Anchor, freeW, freeH int) image.Point {
+	x, y := freeW/2, freeH/2
+	switch anchor {
+	case imaging.TopLeft, imaging.Left, imaging.BottomLeft:
+		x = 0
+	case imaging.TopRight, imaging.Right, imaging.BottomRight:
+		x = freeW
+	}
+	switch anchor {
+	case imaging.TopLeft, imaging.Top, imaging.TopRight:
+		y = 0
+	case imaging.BottomLeft, imaging.Bottom, imaging.BottomRight:
+		y = freeH
+	}
+	return image.Pt(x, y)
+}
@@ -0,0 +1,110 @@
+package processor
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"image-converting-server/config"
+)
+
+// makeSplitImage returns a 200x100 image whose left half is red and right half is blue
+func makeSplitImage() image.Image {
+	img := image.NewNRGBA(image.Rect(0, 0, 200, 100))
+	for y := 0; y < 100; y++ {
+		for x := 0; x < 200; x++ {
+			if x < 100 {
+				img.Set(x, y, color.NRGBA{255, 0, 0, 255})
+			} else {
+				img.Set(x, y, color.NRGBA{0, 0, 255, 255})
+			}
+		}
+	}
+	return img
+}
+
+func nrgbaAt(img image.Image, x, y int) color.NRGBA {
+	return color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+}
+
+func TestProcessor_Resize_CoverGravity(t *testing.T) {
+	p := NewProcessor(config.Config{})
+	tests := []struct {
+		gravity string
+		want    color.NRGBA
+	}{
+		{GravityWest, color.NRGBA{255, 0, 0, 255}},
+		{GravityEast, color.NRGBA{0, 0, 255, 255}},
+	}
+	for _, tt := range tests {
+		out := p.resize(makeSplitImage(), ProcessOptions{Width: 50, Height: 50, Fit: FitCover, Gravity: tt.gravity})
+		if out.Bounds().Dx() != 50 || out.Bounds().Dy() != 50 {
+			t.Fatalf("%s: expected 50x50, got %v", tt.gravity, out.Bounds())
+		}
+		if got := nrgbaAt(out, 25, 25); got != tt.want {
+			t.Errorf("%s: expected %v at the center, got %v", tt.gravity, tt.want, got)
+		}
+	}
+}
+
+func TestProcessor_Resize_ContainBackground(t *testing.T) {
+	p := NewProcessor(config.Config{})
+	white := color.NRGBA{255, 255, 255, 255}
+
+	// 200x100 into 100x100 leaves 50 rows of letterbox
+	out := p.resize(makeSplitImage(), ProcessOptions{Width: 100, Height: 100, Fit: FitContain, Background: white})
+	if out.Bounds().Dx() != 100 || out.Bounds().Dy() != 100 {
+		t.Fatalf("expected 100x100, got %v", out.Bounds())
+	}
+	if got := nrgbaAt(out, 50, 5); got != white {
+		t.Errorf("expected white letterbox at the top, got %v", got)
+	}
+	if got := nrgbaAt(out, 10, 50); got != (color.NRGBA{255, 0, 0, 255}) {
+		t.Errorf("expected the image in the middle, got %v", got)
+	}
+
+	// North gravity places the image at the top; the default background is transparent
+	out = p.resize(makeSplitImage(), ProcessOptions{Width: 100, Height: 100, Fit: FitContain, Gravity: GravityNorth})
+	if got := nrgbaAt(out, 10, 5); got != (color.NRGBA{255, 0, 0, 255}) {
+		t.Errorf("expected the image at the top, got %v", got)
+	}
+	if got := nrgbaAt(out, 10, 95); got.A != 0 {
+		t.Errorf("expected a transparent letterbox at the bottom, got %v", got)
+	}
+}
+
+func TestProcessor_Resize_PresetFit(t *testing.T) {
+	p := NewProcessor(config.Config{
+		Resize: config.ResizeConfig{Presets: map[string]config.PresetConfig{
+			"card": {Width: 100, Height: 100, Fit: FitInside},
+		}},
+	})
+
+	out := p.resize(makeSplitImage(), ProcessOptions{Preset: "card"})
+	if out.Bounds().Dx() != 100 || out.Bounds().Dy() != 50 {
+		t.Errorf("expected the preset fit to give 100x50, got %v", out.Bounds())
+	}
+	out = p.resize(makeSplitImage(), ProcessOptions{Preset: "card", Fit: FitFill})
+	if out.Bounds().Dx() != 100 || out.Bounds().Dy() != 100 {
+		t.Errorf("expected the request fit to win with 100x100, got %v", out.Bounds())
+	}
+}
+
+func TestParseColor(t *testing.T) {
+	tests := []struct {
+		input string
+		want  color.NRGBA
+		ok    bool
+	}{
+		{"#ffffff", color.NRGBA{255, 255, 255, 255}, true},
+		{"00ff0080", color.NRGBA{0, 255, 0, 128}, true},
+		{"fff", color.NRGBA{}, false},
+		{"white", color.NRGBA{}, false},
+	}
+	for _, tt := range tests {
+		got, err := ParseColor(tt.input)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("ParseColor(%q) = %v, %v", tt.input, got, err)
+		}
+	}
+}
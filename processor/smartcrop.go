@@ -0,0 +1,163 @@
+package processor
+
+import (
+	"image"
+	"math"
+
+	"github.com/disintegration/imaging"
+)
+
+// GravitySmart crops cover fits around the most detailed region of the image
+const GravitySmart = "smart"
+
+// FocalPoint is a point of interest given as fractions (0-1) of the image width and height
+type FocalPoint struct {
+	X, Y float64
+}
+
+// saliencySize is the longest side of the thumbnail the saliency map is computed on
+const saliencySize = 256
+
+// centerBias is the score penalty for a crop at the very edge relative to a
+// centered one, so that flat images still crop around the center
+const centerBias = 0.1
+
+// coverCrop scales the image to cover width x height and crops the overflow
+// around the focal point, or around the most salient region when focal is nil
+func coverCrop(img image.Image, width, height int, focal *FocalPoint) image.Image {
+	w, h := fitOutside(img.Bounds().Dx(), img.Bounds().Dy(), width, height)
+	scaled := imaging.Resize(img, w, h, imaging.Lanczos)
+
+	var x, y int
+	if focal != nil {
+		x = clampOffset(int(math.Round(focal.X*float64(w)))-width/2, w-width)
+		y = clampOffset(int(math.Round(focal.Y*float64(h)))-height/2, h-height)
+	} else {
+		x, y = smartCropOffset(scaled, width, height)
+	}
+	return imaging.Crop(scaled, image.Rect(x, y, x+width, y+height))
+}
+
+// pinSmartCrop turns a smart cover crop in options into the focal point of the
+// window it picks on img, which must be the image as it reaches the resize. The
+// frames of an animation are then all cropped at the same place.
+func (p *Processor) pinSmartCrop(img image.Image, options ProcessOptions) ProcessOptions {
+	width, height, resolved := p.resizeTarget(options)
+	if width == 0 || height == 0 || resolved.Fit != FitCover || resolved.Gravity != GravitySmart || resolved.Focal != nil {
+		return options
+	}
+	w, h := fitOutside(img.Bounds().Dx(), img.Bounds().Dy(), width, height)
+	x, y := smartCropOffset(imaging.Resize(img, w, h, imaging.Lanczos), width, height)
+	options.Focal = &FocalPoint{
+		X: float64(x+width/2) / float64(w),
+		Y: float64(y+height/2) / float64(h),
+	}
+	return options
+}
+
+// smartCropOffset returns the top-left corner of the width x height window with the
+// highest saliency. After a cover resize only one axis overflows, so the search is 1D.
+func smartCropOffset(img *image.NRGBA, width, height int) (int, int) {
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	if w == width && h == height {
+		return 0, 0
+	}
+
+	// Work on a thumbnail and scale the result back
+	scale := 1.0
+	thumb := img
+	if longest := max(w, h); longest > saliencySize {
+		scale = float64(longest) / saliencySize
+		thumb = imaging.Resize(img, int(math.Round(float64(w)/scale)), int(math.Round(float64(h)/scale)), imaging.Box)
+	}
+	energy := saliencyMap(thumb)
+	tw, th := thumb.Bounds().Dx(), thumb.Bounds().Dy()
+
+	// Collapse the energy onto the overflowing axis
+	horizontal := w-width > h-height
+	length, window := th, int(math.Round(float64(height)/scale))
+	if horizontal {
+		length, window = tw, int(math.Round(float64(width)/scale))
+	}
+	window = min(max(window, 1), length)
+	profile := make([]float64, length)
+	for y := 0; y < th; y++ {
+		for x := 0; x < tw; x++ {
+			if horizontal {
+				profile[x] += energy[y*tw+x]
+			} else {
+				profile[y] += energy[y*tw+x]
+			}
+		}
+	}
+
+	best := bestWindow(profile, window)
+	offset := int(math.Round(float64(best) * scale))
+	if horizontal {
+		return clampOffset(offset, w-width), 0
+	}
+	return 0, clampOffset(offset, h-height)
+}
+
+// bestWindow returns the start of the window with the highest summed profile,
+// with a small bias towards the center
+func bestWindow(profile []float64, window int) int {
+	slack := len(profile) - window
+	if slack <= 0 {
+		return 0
+	}
+
+	sum, total := 0.0, 0.0
+	for i, v := range profile {
+		total += v
+		if i < window {
+			sum += v
+		}
+	}
+	if total == 0 {
+		return slack / 2
+	}
+
+	best, bestScore := 0, math.Inf(-1)
+	for start := 0; start <= slack; start++ {
+		if start > 0 {
+			sum += profile[start+window-1] - profile[start-1]
+		}
+		distance := math.Abs(float64(start)-float64(slack)/2) / (float64(slack) / 2)
+		score := sum/total - centerBias*distance
+		if score > bestScore {
+			best, bestScore = start, score
+		}
+	}
+	return best
+}
+
+// saliencyMap scores each pixel by its luminance gradient plus its saturation, so
+// that detailed and colorful regions outweigh flat or gray backgrounds
+func saliencyMap(img *image.NRGBA) []float64 {
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	luma := make([]float64, w*h)
+	energy := make([]float64, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			i := y*img.Stride + x*4
+			r, g, b, a := float64(img.Pix[i]), float64(img.Pix[i+1]), float64(img.Pix[i+2]), float64(img.Pix[i+3])/255
+			luma[y*w+x] = (0.299*r + 0.587*g + 0.114*b) * a
+			saturation := (max(r, g, b) - min(r, g, b)) * a
+			energy[y*w+x] = saturation * 0.5
+		}
+	}
+	at := func(x, y int) float64 {
+		return luma[min(max(y, 0), h-1)*w+min(max(x, 0), w-1)]
+	}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			energy[y*w+x] += math.Abs(at(x+1, y)-at(x-1, y)) + math.Abs(at(x, y+1)-at(x, y-1))
+		}
+	}
+	return energy
+}
+
+func clampOffset(v, limit int) int {
+	return min(max(v, 0), max(limit, 0))
+}
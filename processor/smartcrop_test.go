@@ -0,0 +1,102 @@
+package processor
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"image-converting-server/config"
+)
+
+// makeSubjectImage returns a flat gray image with a detailed, colorful patch
+// covering the columns [subjectX, subjectX+subjectW)
+func makeSubjectImage(width, height, subjectX, subjectW int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			c := color.NRGBA{128, 128, 128, 255}
+			if x >= subjectX && x < subjectX+subjectW {
+				if (x/4+y/4)%2 == 0 {
+					c = color.NRGBA{230, 40, 40, 255}
+				} else {
+					c = color.NRGBA{30, 30, 200, 255}
+				}
+			}
+			img.Set(x, y, c)
+		}
+	}
+	return img
+}
+
+func TestSmartCropOffset(t *testing.T) {
+	tests := []struct {
+		name          string
+		img           *image.NRGBA
+		width, height int
+		minX, maxX    int // Accepted range for the crop's left edge
+	}{
+		{"subject on the right", makeSubjectImage(300, 100, 220, 60), 100, 100, 180, 200},
+		{"subject on the left", makeSubjectImage(300, 100, 10, 60), 100, 100, 0, 10},
+		{"flat image stays centered", makeSubjectImage(300, 100, 0, 0), 100, 100, 100, 100},
+		// Larger than the saliency thumbnail, so the offset is scaled back
+		{"large image", makeSubjectImage(900, 300, 675, 150), 300, 300, 525, 600},
+	}
+	for _, tt := range tests {
+		x, y := smartCropOffset(tt.img, tt.width, tt.height)
+		if y != 0 || x < tt.minX || x > tt.maxX {
+			t.Errorf("%s: expected x in [%d, %d] and y 0, got (%d, %d)", tt.name, tt.minX, tt.maxX, x, y)
+		}
+	}
+
+	// Vertical overflow searches rows instead of columns
+	tall := imageTranspose(makeSubjectImage(300, 100, 220, 60))
+	if x, y := smartCropOffset(tall, 100, 100); x != 0 || y < 180 || y > 200 {
+		t.Errorf("tall image: expected y in [180, 200] and x 0, got (%d, %d)", x, y)
+	}
+}
+
+func imageTranspose(src *image.NRGBA) *image.NRGBA {
+	b := src.Bounds()
+	dst := image.NewNRGBA(image.Rect(0, 0, b.Dy(), b.Dx()))
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			dst.Set(y, x, src.At(x, y))
+		}
+	}
+	return dst
+}
+
+func TestProcessor_Resize_SmartAndFocal(t *testing.T) {
+	p := NewProcessor(config.Config{
+		Resize: config.ResizeConfig{Presets: map[string]config.PresetConfig{
+			"thumbnail": {Width: 100, Height: 100, Fit: FitCover, Gravity: GravitySmart},
+		}},
+	})
+	src := makeSubjectImage(300, 100, 220, 60)
+	gray := color.NRGBA{128, 128, 128, 255}
+
+	// The preset's smart gravity keeps the subject; a center crop would be all gray
+	out := p.resize(src, ProcessOptions{Preset: "thumbnail"})
+	if out.Bounds().Dx() != 100 || out.Bounds().Dy() != 100 {
+		t.Fatalf("expected 100x100, got %v", out.Bounds())
+	}
+	if nrgbaAt(out, 70, 50) == gray {
+		t.Error("expected the smart crop to contain the subject")
+	}
+
+	tests := []struct {
+		focal FocalPoint
+		x     int // Source column expected at the crop's left edge
+	}{
+		{FocalPoint{X: 0, Y: 0.5}, 0},
+		{FocalPoint{X: 0.5, Y: 0.5}, 100},
+		{FocalPoint{X: 1, Y: 0}, 200},
+	}
+	for _, tt := range tests {
+		focal := tt.focal
+		out := p.resize(src, ProcessOptions{Width: 100, Height: 100, Fit: FitCover, Focal: &focal})
+		if got, want := nrgbaAt(out, 50, 50), nrgbaAt(src, tt.x+50, 50); got != want {
+			t.Errorf("focal %v: expected %v at the center, got %v", tt.focal, want, got)
+		}
+	}
+}
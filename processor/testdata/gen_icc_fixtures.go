@@ -0,0 +1,190 @@
+//go:build ignore
+
+// This program generates the ICC fixture images used by icc_test.go.
+// Run it from the processor directory: go run testdata/gen_icc_fixtures.go
+//
+// The profiles are RGB matrix/TRC profiles built from the published colorants
+// (D50-adapted) and tone curves of each color space.
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"log"
+	"math"
+	"os"
+)
+
+// srgbCurve is the sRGB piecewise curve as an ICC parametric curve (type 3)
+var srgbCurve = paraCurve(2.4, 1/1.055, 0.055/1.055, 1/12.92, 0.04045)
+
+func main() {
+	displayP3 := makeProfile("Display P3", [3][3]float64{
+		{0.515121, 0.241196, -0.001053},
+		{0.291977, 0.692245, 0.041885},
+		{0.157104, 0.066574, 0.784073},
+	}, srgbCurve)
+	adobeRGB := makeProfile("Adobe RGB (1998)", [3][3]float64{
+		{0.609741, 0.311111, 0.019470},
+		{0.205276, 0.625671, 0.060867},
+		{0.149185, 0.063217, 0.744568},
+	}, gammaCurve(563.0/256))
+	sRGB := makeProfile("sRGB", [3][3]float64{
+		{0.4360747, 0.2225045, 0.0139322},
+		{0.3850649, 0.7168786, 0.0971045},
+		{0.1430804, 0.0606169, 0.7141733},
+	}, srgbCurve)
+
+	// Left half a saturated red, right half mid gray, split on a JPEG MCU boundary
+	split := image.NewRGBA(image.Rect(0, 0, 32, 16))
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 32; x++ {
+			if x < 16 {
+				split.Set(x, y, color.RGBA{200, 60, 60, 255})
+			} else {
+				split.Set(x, y, color.RGBA{128, 128, 128, 255})
+			}
+		}
+	}
+	solid := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	for i := 0; i < len(solid.Pix); i += 4 {
+		copy(solid.Pix[i:], []byte{150, 120, 90, 255})
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, split, &jpeg.Options{Quality: 100}); err != nil {
+		log.Fatal(err)
+	}
+	write("testdata/display-p3.jpg", withJPEGProfile(buf.Bytes(), displayP3))
+
+	write("testdata/adobe-rgb.png", withPNGProfile(encodePNG(solid), adobeRGB))
+	write("testdata/srgb.png", withPNGProfile(encodePNG(solid), sRGB))
+}
+
+func write(name string, data []byte) {
+	if err := os.WriteFile(name, data, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func encodePNG(img image.Image) []byte {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		log.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// makeProfile builds an ICC v2 display profile; colorants holds rXYZ, gXYZ and bXYZ
+func makeProfile(description string, colorants [3][3]float64, trc []byte) []byte {
+	desc := tagType("desc")
+	desc = binary.BigEndian.AppendUint32(desc, uint32(len(description)+1))
+	desc = append(desc, description...)
+	desc = append(desc, 0)
+	desc = append(desc, make([]byte, 4+4+2+1+67)...) // Empty Unicode and ScriptCode descriptions
+
+	cprt := append(tagType("text"), "No copyright, use freely\x00"...)
+
+	tags := []struct {
+		sig  string
+		data []byte
+	}{
+		{"desc", desc},
+		{"cprt", cprt},
+		{"wtpt", xyzTag(0.9642, 1.0, 0.8249)},
+		{"rXYZ", xyzTag(colorants[0][0], colorants[0][1], colorants[0][2])},
+		{"gXYZ", xyzTag(colorants[1][0], colorants[1][1], colorants[1][2])},
+		{"bXYZ", xyzTag(colorants[2][0], colorants[2][1], colorants[2][2])},
+		{"rTRC", trc},
+		{"gTRC", trc},
+		{"bTRC", trc},
+	}
+
+	header := make([]byte, 128)
+	binary.BigEndian.PutUint32(header[8:], 0x02100000) // Version 2.1
+	copy(header[12:], "mntr")
+	copy(header[16:], "RGB ")
+	copy(header[20:], "XYZ ")
+	binary.BigEndian.PutUint16(header[24:], 2024) // Creation date: 2024-01-01
+	binary.BigEndian.PutUint16(header[26:], 1)
+	binary.BigEndian.PutUint16(header[28:], 1)
+	copy(header[36:], "acsp")
+	copy(header[68:], xyzTag(0.9642, 1.0, 0.8249)[8:]) // D50 illuminant
+
+	table := binary.BigEndian.AppendUint32(nil, uint32(len(tags)))
+	offset := 128 + 4 + 12*len(tags)
+	var body []byte
+	for _, tag := range tags {
+		for len(tag.data)%4 != 0 {
+			tag.data = append(tag.data, 0)
+		}
+		table = append(table, tag.sig...)
+		table = binary.BigEndian.AppendUint32(table, uint32(offset+len(body)))
+		table = binary.BigEndian.AppendUint32(table, uint32(len(tag.data)))
+		body = append(body, tag.data...)
+	}
+
+	profile := append(append(header, table...), body...)
+	binary.BigEndian.PutUint32(profile, uint32(len(profile)))
+	return profile
+}
+
+func tagType(sig string) []byte {
+	return append([]byte(sig), 0, 0, 0, 0)
+}
+
+func xyzTag(x, y, z float64) []byte {
+	tag := tagType("XYZ ")
+	for _, v := range []float64{x, y, z} {
+		tag = binary.BigEndian.AppendUint32(tag, uint32(int32(math.Round(v*65536))))
+	}
+	return tag
+}
+
+func gammaCurve(gamma float64) []byte {
+	tag := binary.BigEndian.AppendUint32(tagType("curv"), 1)
+	return binary.BigEndian.AppendUint16(tag, uint16(math.Round(gamma*256)))
+}
+
+func paraCurve(params ...float64) []byte {
+	tag := append(tagType("para"), 0, 3, 0, 0) // Function type 3
+	for _, v := range params {
+		tag = binary.BigEndian.AppendUint32(tag, uint32(int32(math.Round(v*65536))))
+	}
+	return tag
+}
+
+// withJPEGProfile inserts the profile as a single APP2 ICC_PROFILE segment after SOI
+func withJPEGProfile(data, profile []byte) []byte {
+	payload := append([]byte("ICC_PROFILE\x00\x01\x01"), profile...)
+	segment := []byte{0xFF, 0xE2, byte((len(payload) + 2) >> 8), byte(len(payload) + 2)}
+	out := append([]byte{}, data[:2]...)
+	out = append(out, segment...)
+	out = append(out, payload...)
+	return append(out, data[2:]...)
+}
+
+// withPNGProfile inserts an iCCP chunk after IHDR
+func withPNGProfile(data, profile []byte) []byte {
+	var compressed bytes.Buffer
+	zw := zlib.NewWriter(&compressed)
+	zw.Write(profile)
+	zw.Close()
+
+	chunkData := append([]byte("ICC Profile\x00\x00"), compressed.Bytes()...)
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(chunkData)))
+	chunk = append(chunk, "iCCP"...)
+	chunk = append(chunk, chunkData...)
+	chunk = binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+
+	const ihdrEnd = 33
+	out := append([]byte{}, data[:ihdrEnd]...)
+	out = append(out, chunk...)
+	return append(out, data[ihdrEnd:]...)
+}
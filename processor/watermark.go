@@ -0,0 +1,168 @@
+package processor
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"os"
+	"time"
+
+	"image-converting-server/config"
+
+	"github.com/disintegration/imaging"
+)
+
+// ErrUnknownWatermark is returned when options name a watermark profile that is not configured
+var ErrUnknownWatermark = errors.New("unknown watermark")
+
+// watermarkLoadTimeout bounds the download of an overlay stored in R2
+const watermarkLoadTimeout = 30 * time.Second
+
+// overlayRetryDelay is how long a failed overlay load is reported before it is retried
+const overlayRetryDelay = 10 * time.Second
+
+// overlayEntry is the load of one watermark overlay, shared by every request
+// that needs it
+type overlayEntry struct {
+	ready   chan struct{} // Closed once img or err is set
+	img     image.Image
+	err     error
+	retryAt time.Time // Set for failed loads
+}
+
+// stale reports whether the entry has failed and may be loaded again
+func (e *overlayEntry) stale() bool {
+	select {
+	case <-e.ready:
+		return e.err != nil && !time.Now().Before(e.retryAt)
+	default:
+		return false
+	}
+}
+
+// OverlaySource downloads watermark overlays stored in R2
+type OverlaySource interface {
+	DownloadImage(ctx context.Context, key string) ([]byte, error)
+}
+
+// SetOverlaySource sets where watermarks with an r2_key are downloaded from
+func (p *Processor) SetOverlaySource(source OverlaySource) {
+	p.overlaySource = source
+}
+
+// watermarkName returns the watermark profile for options: the request's, then the preset's
+func (p *Processor) watermarkName(options ProcessOptions) string {
+	if options.Watermark != "" {
+		return options.Watermark
+	}
+	if preset, ok := p.cfg.Resize.Presets[options.Preset]; ok && options.Preset != "" {
+		return preset.Watermark
+	}
+	return ""
+}
+
+// applyWatermark stamps the watermark selected by options onto img, if any
+func (p *Processor) applyWatermark(img image.Image, options ProcessOptions) (image.Image, error) {
+	name := p.watermarkName(options)
+	if name == "" {
+		return img, nil
+	}
+	wm, ok := p.cfg.Watermarks[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownWatermark, name)
+	}
+	overlay, err := p.overlay(name, wm)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load watermark %s: %w", name, err)
+	}
+	return stampWatermark(img, overlay, wm), nil
+}
+
+// overlay returns the decoded overlay image for a watermark profile. Overlays are
+// loaded once and cached; failures are cached for overlayRetryDelay. The load runs
+// outside overlayMu, so a slow download only holds up requests for that profile.
+func (p *Processor) overlay(name string, wm config.WatermarkConfig) (image.Image, error) {
+	p.overlayMu.Lock()
+	entry, ok := p.overlays[name]
+	if ok && !entry.stale() {
+		p.overlayMu.Unlock()
+		<-entry.ready
+		return entry.img, entry.err
+	}
+	entry = &overlayEntry{ready: make(chan struct{})}
+	if p.overlays == nil {
+		p.overlays = make(map[string]*overlayEntry)
+	}
+	p.overlays[name] = entry
+	p.overlayMu.Unlock()
+
+	entry.img, entry.err = p.loadOverlay(wm)
+	if entry.err != nil {
+		entry.retryAt = time.Now().Add(overlayRetryDelay)
+	}
+	close(entry.ready)
+	return entry.img, entry.err
+}
+
+// loadOverlay reads and decodes the overlay of a watermark profile
+func (p *Processor) loadOverlay(wm config.WatermarkConfig) (image.Image, error) {
+	var data []byte
+	var err error
+	if wm.File != "" {
+		data, err = os.ReadFile(wm.File)
+	} else {
+		if p.overlaySource == nil {
+			return nil, fmt.Errorf("no R2 source configured for %s", wm.R2Key)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), watermarkLoadTimeout)
+		defer cancel()
+		data, err = p.overlaySource.DownloadImage(ctx, wm.R2Key)
+	}
+	if err != nil {
+		return nil, err
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode overlay: %w", err)
+	}
+	return img, nil
+}
+
+// stampWatermark draws overlay onto base with the profile's scale, opacity,
+// position and margin, or tiled across the whole image
+func stampWatermark(base, overlay image.Image, wm config.WatermarkConfig) image.Image {
+	bw, bh := base.Bounds().Dx(), base.Bounds().Dy()
+	if wm.Scale > 0 {
+		overlay = imaging.Resize(overlay, max(1, int(math.Round(float64(bw)*wm.Scale))), 0, imaging.Lanczos)
+	}
+	ow, oh := overlay.Bounds().Dx(), overlay.Bounds().Dy()
+
+	// Every stamp blends into one copy; imaging.Overlay would clone the image per tile
+	out := imaging.Clone(base)
+	mask := image.NewUniform(color.Alpha{A: uint8(math.Round(255 * min(max(wm.Opacity, 0), 1)))})
+	stamp := func(pos image.Point) {
+		r := image.Rectangle{Min: pos, Max: pos.Add(image.Pt(ow, oh))}
+		draw.DrawMask(out, r, overlay, overlay.Bounds().Min, mask, image.Point{}, draw.Over)
+	}
+
+	if wm.Tile {
+		for y := wm.Margin; y < bh; y += oh + wm.Margin {
+			for x := wm.Margin; x < bw; x += ow + wm.Margin {
+				stamp(image.Pt(x, y))
+			}
+		}
+		return out
+	}
+
+	anchor, ok := gravityAnchors[wm.Position]
+	if !ok {
+		anchor = imaging.BottomRight
+	}
+	stamp(anchorPoint(anchor, bw-ow-2*wm.Margin, bh-oh-2*wm.Margin).Add(image.Pt(wm.Margin, wm.Margin)))
+	return out
+}
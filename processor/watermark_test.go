@@ -0,0 +1,295 @@
+package processor
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"image-converting-server/config"
+
+	"github.com/disintegration/imaging"
+)
+
+var (
+	wmBase  = color.NRGBA{255, 255, 255, 255}
+	wmColor = color.NRGBA{0, 0, 0, 255}
+)
+
+func solidImage(w, h int, c color.NRGBA) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for i := 0; i < len(img.Pix); i += 4 {
+		img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = c.R, c.G, c.B, c.A
+	}
+	return img
+}
+
+func encodePNG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("failed to encode png: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestStampWatermark(t *testing.T) {
+	base := solidImage(100, 100, wmBase)
+	overlay := solidImage(10, 10, wmColor)
+
+	tests := []struct {
+		name   string
+		wm     config.WatermarkConfig
+		marked []image.Point
+		clear  []image.Point
+	}{
+		{
+			name:   "southeast with margin",
+			wm:     config.WatermarkConfig{Position: GravitySouthEast, Margin: 5, Opacity: 1},
+			marked: []image.Point{{85, 85}, {94, 94}},
+			clear:  []image.Point{{95, 95}, {84, 84}, {5, 5}},
+		},
+		{
+			name:   "northwest",
+			wm:     config.WatermarkConfig{Position: GravityNorthWest, Opacity: 1},
+			marked: []image.Point{{0, 0}, {9, 9}},
+			clear:  []image.Point{{10, 10}, {95, 95}},
+		},
+		{
+			name:   "scaled to a fraction of the width",
+			wm:     config.WatermarkConfig{Position: GravityCenter, Scale: 0.5, Opacity: 1},
+			marked: []image.Point{{26, 26}, {73, 73}},
+			clear:  []image.Point{{24, 24}, {76, 76}},
+		},
+		{
+			name:   "tiled",
+			wm:     config.WatermarkConfig{Tile: true, Margin: 10, Opacity: 1},
+			marked: []image.Point{{10, 10}, {30, 10}, {90, 90}},
+			clear:  []image.Point{{5, 5}, {25, 10}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := stampWatermark(base, overlay, tt.wm)
+			if out.Bounds() != base.Bounds() {
+				t.Fatalf("expected bounds %v, got %v", base.Bounds(), out.Bounds())
+			}
+			for _, pt := range tt.marked {
+				if got := nrgbaAt(out, pt.X, pt.Y); got != wmColor {
+					t.Errorf("pixel %v: expected the watermark, got %v", pt, got)
+				}
+			}
+			for _, pt := range tt.clear {
+				if got := nrgbaAt(out, pt.X, pt.Y); got != wmBase {
+					t.Errorf("pixel %v: expected the base image, got %v", pt, got)
+				}
+			}
+		})
+	}
+}
+
+func TestStampWatermark_Opacity(t *testing.T) {
+	out := stampWatermark(solidImage(20, 20, wmBase), solidImage(20, 20, wmColor), config.WatermarkConfig{Opacity: 0.5})
+	if got := nrgbaAt(out, 10, 10); got.R < 120 || got.R > 135 {
+		t.Errorf("expected a half-blended gray, got %v", got)
+	}
+}
+
+func TestStampWatermark_TiledBlend(t *testing.T) {
+	base := solidImage(120, 90, color.NRGBA{200, 40, 90, 255})
+	overlay := solidImage(7, 5, color.NRGBA{10, 220, 60, 180})
+	wm := config.WatermarkConfig{Tile: true, Margin: 3, Opacity: 0.6}
+
+	// Tiles blend like imaging.Overlay, which the stamp used to call per tile
+	want := imaging.Clone(base)
+	for y := wm.Margin; y < 90; y += 5 + wm.Margin {
+		for x := wm.Margin; x < 120; x += 7 + wm.Margin {
+			want = imaging.Overlay(want, overlay, image.Pt(x, y), wm.Opacity)
+		}
+	}
+	got := stampWatermark(base, overlay, wm).(*image.NRGBA)
+	for i := range want.Pix {
+		if d := int(got.Pix[i]) - int(want.Pix[i]); d < -1 || d > 1 {
+			t.Fatalf("pixel %d, channel %d: expected %d, got %d", i/4, i%4, want.Pix[i], got.Pix[i])
+		}
+	}
+}
+
+// fakeOverlaySource serves objects from memory and counts downloads. Downloads
+// of keys in block wait until the channel is closed.
+type fakeOverlaySource struct {
+	objects   map[string][]byte
+	block     map[string]chan struct{}
+	mu        sync.Mutex
+	downloads int
+}
+
+func (f *fakeOverlaySource) DownloadImage(ctx context.Context, key string) ([]byte, error) {
+	f.mu.Lock()
+	f.downloads++
+	f.mu.Unlock()
+	if wait, ok := f.block[key]; ok {
+		<-wait
+	}
+	data, ok := f.objects[key]
+	if !ok {
+		return nil, errors.New("not found")
+	}
+	return data, nil
+}
+
+func TestProcessor_ApplyWatermark(t *testing.T) {
+	overlay := encodePNG(t, solidImage(10, 10, wmColor))
+	file := filepath.Join(t.TempDir(), "logo.png")
+	if err := os.WriteFile(file, overlay, 0644); err != nil {
+		t.Fatal(err)
+	}
+	source := &fakeOverlaySource{objects: map[string][]byte{"assets/logo.png": overlay}}
+
+	p := NewProcessor(config.Config{
+		Resize: config.ResizeConfig{Presets: map[string]config.PresetConfig{
+			"stamped": {Width: 50, Height: 50, Watermark: "file"},
+		}},
+		Watermarks: map[string]config.WatermarkConfig{
+			"file":    {File: file, Position: GravityNorthWest, Opacity: 1},
+			"r2":      {R2Key: "assets/logo.png", Position: GravitySouthEast, Opacity: 1},
+			"missing": {R2Key: "assets/missing.png", Opacity: 1},
+		},
+	})
+	p.SetOverlaySource(source)
+	base := solidImage(50, 50, wmBase)
+
+	out, err := p.applyWatermark(base, ProcessOptions{Watermark: "file"})
+	if err != nil {
+		t.Fatalf("applyWatermark failed: %v", err)
+	}
+	if got := nrgbaAt(out, 0, 0); got != wmColor {
+		t.Errorf("expected the file watermark in the top-left, got %v", got)
+	}
+
+	// R2 overlays are downloaded once and cached
+	for i := 0; i < 2; i++ {
+		out, err = p.applyWatermark(base, ProcessOptions{Watermark: "r2"})
+		if err != nil {
+			t.Fatalf("applyWatermark failed: %v", err)
+		}
+	}
+	if got := nrgbaAt(out, 49, 49); got != wmColor {
+		t.Errorf("expected the R2 watermark in the bottom-right, got %v", got)
+	}
+	if source.downloads != 1 {
+		t.Errorf("expected 1 download, got %d", source.downloads)
+	}
+
+	// The preset's watermark applies unless the request sets one
+	out, err = p.applyWatermark(base, ProcessOptions{Preset: "stamped"})
+	if err != nil || nrgbaAt(out, 0, 0) != wmColor {
+		t.Errorf("expected the preset watermark, got %v (%v)", nrgbaAt(out, 0, 0), err)
+	}
+	out, _ = p.applyWatermark(base, ProcessOptions{Preset: "stamped", Watermark: "r2"})
+	if nrgbaAt(out, 0, 0) != wmBase || nrgbaAt(out, 49, 49) != wmColor {
+		t.Error("expected the request watermark to replace the preset's")
+	}
+
+	// Failures are cached briefly, then retried
+	downloads := source.downloads
+	for i := 0; i < 2; i++ {
+		if _, err := p.applyWatermark(base, ProcessOptions{Watermark: "missing"}); err == nil {
+			t.Error("expected an error for a missing overlay")
+		}
+	}
+	if source.downloads != downloads+1 {
+		t.Errorf("expected the failure to be cached, got %d downloads", source.downloads-downloads)
+	}
+	p.overlays["missing"].retryAt = time.Now()
+	p.applyWatermark(base, ProcessOptions{Watermark: "missing"})
+	if source.downloads != downloads+2 {
+		t.Errorf("expected the failed overlay to be retried, got %d downloads", source.downloads-downloads)
+	}
+	if _, err := p.applyWatermark(base, ProcessOptions{Watermark: "nope"}); !errors.Is(err, ErrUnknownWatermark) {
+		t.Errorf("expected ErrUnknownWatermark, got %v", err)
+	}
+}
+
+func TestProcessor_Overlay_SlowDownload(t *testing.T) {
+	overlay := encodePNG(t, solidImage(10, 10, wmColor))
+	release := make(chan struct{})
+	source := &fakeOverlaySource{
+		objects: map[string][]byte{"slow.png": overlay, "fast.png": overlay},
+		block:   map[string]chan struct{}{"slow.png": release},
+	}
+	p := NewProcessor(config.Config{Watermarks: map[string]config.WatermarkConfig{
+		"slow": {R2Key: "slow.png", Opacity: 1},
+		"fast": {R2Key: "fast.png", Opacity: 1},
+	}})
+	p.SetOverlaySource(source)
+	base := solidImage(20, 20, wmBase)
+
+	// Requests for the slow overlay share one download
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := p.applyWatermark(base, ProcessOptions{Watermark: "slow"}); err != nil {
+				t.Errorf("applyWatermark failed: %v", err)
+			}
+		}()
+	}
+
+	// Another profile does not wait for it
+	done := make(chan error)
+	go func() {
+		_, err := p.applyWatermark(base, ProcessOptions{Watermark: "fast"})
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("applyWatermark failed: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the fast overlay waited for the slow download")
+	}
+	close(release)
+	wg.Wait()
+	if source.downloads != 2 {
+		t.Errorf("expected 2 downloads, got %d", source.downloads)
+	}
+}
+
+func TestProcessor_Process_Watermark(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "logo.png")
+	if err := os.WriteFile(file, encodePNG(t, solidImage(4, 4, wmColor)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	p := NewProcessor(config.Config{
+		Conversion: config.ConversionConfig{Formats: []string{"png"}},
+		Watermarks: map[string]config.WatermarkConfig{
+			"logo": {File: file, Position: GravitySouthEast, Opacity: 1},
+		},
+	})
+
+	// The watermark is stamped after resizing, so it keeps its size
+	output, _, err := p.Process(encodePNG(t, solidImage(100, 100, wmBase)), ProcessOptions{Width: 20, Format: FormatPNG, Watermark: "logo"})
+	if err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+	img, _, err := image.Decode(bytes.NewReader(output))
+	if err != nil {
+		t.Fatalf("failed to decode output: %v", err)
+	}
+	if got := nrgbaAt(img, 16, 16); got != wmColor {
+		t.Errorf("expected the watermark at (16,16), got %v", got)
+	}
+	if got := nrgbaAt(img, 15, 15); got != wmBase {
+		t.Errorf("expected the base image at (15,15), got %v", got)
+	}
+}
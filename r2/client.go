@@ -3,6 +3,7 @@ package r2
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"time"
@@ -15,10 +16,14 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 )
 
+// ErrObjectTooLarge is returned by DownloadImage when an object exceeds the size limit
+var ErrObjectTooLarge = errors.New("object too large")
+
 // StorageClient defines the interface for R2 storage operations
 type StorageClient interface {
 	DownloadImage(ctx context.Context, key string) ([]byte, error)
 	UploadImage(ctx context.Context, key string, data []byte, contentType string) error
+	UploadImageWithMetadata(ctx context.Context, key string, data []byte, contentType string, metadata map[string]string) error
 	ListObjects(ctx context.Context, since time.Time) ([]string, error)
 	TestConnection(ctx context.Context) error
 	DeleteObject(ctx context.Context, key string) error
@@ -34,12 +39,14 @@ type s3API interface {
 }
 
 type r2Client struct {
-	client s3API
-	bucket string
+	client   s3API
+	bucket   string
+	maxBytes int64 // 0 means no download limit
 }
 
-// NewClient creates a new R2 storage client
-func NewClient(ctx context.Context, cfg *appConfig.R2Config) (StorageClient, error) {
+// NewClient creates a new R2 storage client.
+// Downloads larger than maxBytes fail with ErrObjectTooLarge; 0 disables the limit.
+func NewClient(ctx context.Context, cfg *appConfig.R2Config, maxBytes int64) (StorageClient, error) {
 	// Load AWS configuration with static credentials and custom endpoint
 	awsCfg, err := awsConfig.LoadDefaultConfig(ctx,
 		awsConfig.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(cfg.AccessKey, cfg.SecretKey, "")),
@@ -55,8 +62,9 @@ func NewClient(ctx context.Context, cfg *appConfig.R2Config) (StorageClient, err
 	})
 
 	return &r2Client{
-		client: s3Client,
-		bucket: cfg.Bucket,
+		client:   s3Client,
+		bucket:   cfg.Bucket,
+		maxBytes: maxBytes,
 	}, nil
 }
 
@@ -71,21 +79,40 @@ func (r *r2Client) DownloadImage(ctx context.Context, key string) ([]byte, error
 	}
 	defer output.Body.Close()
 
-	data, err := io.ReadAll(output.Body)
+	if r.maxBytes > 0 && output.ContentLength != nil && *output.ContentLength > r.maxBytes {
+		return nil, fmt.Errorf("%w (key: %s, size: %d bytes)", ErrObjectTooLarge, key, *output.ContentLength)
+	}
+
+	body := io.Reader(output.Body)
+	if r.maxBytes > 0 {
+		// Read one extra byte to detect bodies longer than the advertised length
+		body = io.LimitReader(output.Body, r.maxBytes+1)
+	}
+	data, err := io.ReadAll(body)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read image data from R2 response (key: %s): %w", key, err)
 	}
+	if r.maxBytes > 0 && int64(len(data)) > r.maxBytes {
+		return nil, fmt.Errorf("%w (key: %s)", ErrObjectTooLarge, key)
+	}
 
 	return data, nil
 }
 
 // UploadImage uploads an image to R2
 func (r *r2Client) UploadImage(ctx context.Context, key string, data []byte, contentType string) error {
+	return r.UploadImageWithMetadata(ctx, key, data, contentType, nil)
+}
+
+// UploadImageWithMetadata uploads an image to R2 with custom object metadata,
+// returned as x-amz-meta-* headers. R2 limits metadata to 2 KB per object.
+func (r *r2Client) UploadImageWithMetadata(ctx context.Context, key string, data []byte, contentType string, metadata map[string]string) error {
 	_, err := r.client.PutObject(ctx, &s3.PutObjectInput{
 		Bucket:      aws.String(r.bucket),
 		Key:         aws.String(key),
 		Body:        bytes.NewReader(data),
 		ContentType: aws.String(contentType),
+		Metadata:    metadata,
 	})
 	if err != nil {
 		return fmt.Errorf("failed to upload image to R2 (key: %s): %w", key, err)
@@ -3,6 +3,7 @@ package r2
 import (
 	"bytes"
 	"context"
+	"errors"
 	"io"
 	"testing"
 	"time"
@@ -78,6 +79,39 @@ func TestDownloadImage(t *testing.T) {
 	}
 }
 
+func TestDownloadImage_TooLarge(t *testing.T) {
+	mockData := []byte("0123456789")
+
+	tests := []struct {
+		name          string
+		contentLength *int64
+	}{
+		{"Advertised length", aws.Int64(int64(len(mockData)))},
+		{"Unknown length", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &r2Client{
+				client: &mockS3Client{
+					getObjectFunc: func(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+						return &s3.GetObjectOutput{
+							Body:          io.NopCloser(bytes.NewReader(mockData)),
+							ContentLength: tt.contentLength,
+						}, nil
+					},
+				},
+				bucket:   "test-bucket",
+				maxBytes: 5,
+			}
+
+			_, err := client.DownloadImage(context.Background(), "big.jpg")
+			if !errors.Is(err, ErrObjectTooLarge) {
+				t.Errorf("expected ErrObjectTooLarge, got %v", err)
+			}
+		})
+	}
+}
+
 func TestUploadImage(t *testing.T) {
 	mockData := []byte("new image data")
 	mockKey := "upload-test.webp"
@@ -116,6 +150,27 @@ func TestUploadImage(t *testing.T) {
 	}
 }
 
+func TestUploadImageWithMetadata(t *testing.T) {
+	var got map[string]string
+	client := &r2Client{
+		client: &mockS3Client{
+			putObjectFunc: func(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+				got = params.Metadata
+				return &s3.PutObjectOutput{}, nil
+			},
+		},
+		bucket: "test-bucket",
+	}
+
+	metadata := map[string]string{"blurhash": "L00000fQfQfQfQfQfQfQfQfQfQfQ"}
+	if err := client.UploadImageWithMetadata(context.Background(), "a.webp", []byte("data"), "image/webp", metadata); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got["blurhash"] != metadata["blurhash"] {
+		t.Errorf("expected metadata %v, got %v", metadata, got)
+	}
+}
+
 func TestListObjects(t *testing.T) {
 	mockBucket := "test-bucket"
 	now := time.Now()
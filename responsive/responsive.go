@@ -0,0 +1,212 @@
+// Package responsive renders a source image at several widths and output
+// formats, stores the results under predictable keys such as photo.w640.webp,
+// and describes them in a manifest with ready-to-use srcset strings.
+package responsive
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"image-converting-server/config"
+	"image-converting-server/processor"
+)
+
+// MaxVariants bounds the number of outputs rendered for one source
+const MaxVariants = 32
+
+// variantKeyPattern matches keys produced by Key, e.g. photo.w640.webp
+var variantKeyPattern = regexp.MustCompile(`\.w\d+\.[a-z0-9]+$`)
+
+// Uploader stores rendered variants; r2.StorageClient implements it
+type Uploader interface {
+	UploadImageWithMetadata(ctx context.Context, key string, data []byte, contentType string, metadata map[string]string) error
+}
+
+// Variant is one rendered output of a variant set
+type Variant struct {
+	Key         string `json:"key"`
+	Format      string `json:"format"`
+	ContentType string `json:"content_type"`
+	Width       int    `json:"width"`
+	Height      int    `json:"height"`
+	Size        int    `json:"size"`
+
+	data         []byte
+	placeholders *processor.Placeholders
+}
+
+// Manifest describes every variant rendered for a source
+type Manifest struct {
+	Source   string            `json:"source"`
+	Variants []Variant         `json:"variants"`
+	Srcset   map[string]string `json:"srcset"` // By output format
+
+	Placeholders *processor.Placeholders `json:"placeholders,omitempty"`
+}
+
+// Key returns the key of the variant of key at the given width, e.g.
+// photos/cat.jpg at 640 as WebP is photos/cat.w640.webp
+func Key(key string, width int, ext string) string {
+	base := strings.TrimSuffix(key, path.Ext(key))
+	return fmt.Sprintf("%s.w%d%s", base, width, ext)
+}
+
+// ManifestKey returns the key the manifest of key is stored under, e.g. photos/cat.variants.json
+func ManifestKey(key string) string {
+	return strings.TrimSuffix(key, path.Ext(key)) + ".variants.json"
+}
+
+// IsVariantKey reports whether key looks like a key produced by Key
+func IsVariantKey(key string) bool {
+	return variantKeyPattern.MatchString(strings.ToLower(key))
+}
+
+// Check returns an error if the set cannot be rendered: an unknown output format,
+// two formats sharing a file extension, or too many variants
+func Check(proc *processor.Processor, set config.VariantSetConfig) error {
+	if len(set.Widths) == 0 {
+		return fmt.Errorf("no widths given")
+	}
+	formats := formatsOf(set)
+	if len(set.Widths)*len(formats) > MaxVariants {
+		return fmt.Errorf("%d variants requested, the maximum is %d", len(set.Widths)*len(formats), MaxVariants)
+	}
+	extensions := make(map[string]string)
+	for _, format := range formats {
+		encoder, err := proc.Encoder(format)
+		if err != nil {
+			return err
+		}
+		name := proc.OutputFormat(processor.ProcessOptions{Format: format})
+		if other, ok := extensions[encoder.Extension()]; ok && other != name {
+			return fmt.Errorf("formats %s and %s would share the %s extension", other, name, encoder.Extension())
+		}
+		extensions[encoder.Extension()] = name
+	}
+	return nil
+}
+
+// CheckSets runs Check on every configured set
+func CheckSets(proc *processor.Processor, sets map[string]config.VariantSetConfig) error {
+	for name, set := range sets {
+		if err := Check(proc, set); err != nil {
+			return fmt.Errorf("set %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// Generate renders data at every width and format of set. The width replaces any
+// size in options; the height follows the aspect ratio. Widths above the source
+// width are clamped to it, so no variant is upscaled. The source is decoded once,
+// and placeholders, which look the same at every width, are computed for the
+// first variant only.
+func Generate(proc *processor.Processor, key string, data []byte, options processor.ProcessOptions, set config.VariantSetConfig) ([]Variant, error) {
+	prepared, err := proc.Prepare(data, options)
+	if err != nil {
+		return nil, err
+	}
+	var variants []Variant
+	seen := make(map[string]bool)
+	for _, format := range formatsOf(set) {
+		encoder, err := proc.Encoder(format)
+		if err != nil {
+			return nil, err
+		}
+		for _, width := range set.Widths {
+			width = min(width, prepared.Width())
+			variantKey := Key(key, width, encoder.Extension())
+			if seen[variantKey] {
+				continue
+			}
+			seen[variantKey] = true
+
+			opts := options
+			opts.Width, opts.Height, opts.Format = width, 0, format
+			if len(variants) > 0 {
+				opts.Placeholders = &config.PlaceholderConfig{}
+			}
+			result, err := proc.Render(prepared, opts)
+			if err != nil {
+				return nil, fmt.Errorf("width %d as %s: %w", width, proc.OutputFormat(opts), err)
+			}
+			variants = append(variants, Variant{
+				Key:         variantKey,
+				Format:      proc.OutputFormat(opts),
+				ContentType: encoder.ContentType(),
+				Width:       result.Width,
+				Height:      result.Height,
+				Size:        len(result.Data),
+				data:        result.Data,
+
+				placeholders: result.Placeholders,
+			})
+		}
+	}
+	return variants, nil
+}
+
+// Srcset returns a srcset string per output format, listing each variant's URL
+// (baseURL joined with its key) by ascending width
+func Srcset(variants []Variant, baseURL string) map[string]string {
+	byFormat := make(map[string][]Variant)
+	for _, v := range variants {
+		byFormat[v.Format] = append(byFormat[v.Format], v)
+	}
+	srcset := make(map[string]string, len(byFormat))
+	for format, list := range byFormat {
+		sort.Slice(list, func(i, j int) bool { return list[i].Width < list[j].Width })
+		entries := make([]string, len(list))
+		for i, v := range list {
+			entries[i] = variantURL(baseURL, v.Key) + " " + strconv.Itoa(v.Width) + "w"
+		}
+		srcset[format] = strings.Join(entries, ", ")
+	}
+	return srcset
+}
+
+// Store uploads the variants and their manifest, which is stored under ManifestKey(key).
+// Placeholders are recorded in the manifest and as metadata of every variant.
+func Store(ctx context.Context, storage Uploader, source, key string, variants []Variant, baseURL string) (*Manifest, error) {
+	var placeholders *processor.Placeholders
+	for _, v := range variants {
+		if v.placeholders != nil {
+			placeholders = v.placeholders
+			break
+		}
+	}
+	for _, v := range variants {
+		if err := storage.UploadImageWithMetadata(ctx, v.Key, v.data, v.ContentType, placeholders.Metadata()); err != nil {
+			return nil, fmt.Errorf("failed to upload %s: %w", v.Key, err)
+		}
+	}
+	manifest := &Manifest{Source: source, Variants: variants, Srcset: Srcset(variants, baseURL), Placeholders: placeholders}
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := storage.UploadImageWithMetadata(ctx, ManifestKey(key), data, "application/json", nil); err != nil {
+		return nil, fmt.Errorf("failed to upload manifest: %w", err)
+	}
+	return manifest, nil
+}
+
+func formatsOf(set config.VariantSetConfig) []string {
+	if len(set.Formats) == 0 {
+		return []string{""}
+	}
+	return set.Formats
+}
+
+func variantURL(baseURL, key string) string {
+	if baseURL == "" {
+		return key
+	}
+	return strings.TrimSuffix(baseURL, "/") + "/" + key
+}
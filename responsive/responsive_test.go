@@ -0,0 +1,226 @@
+package responsive
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"image"
+	"image/png"
+	"testing"
+
+	"image-converting-server/config"
+	"image-converting-server/processor"
+)
+
+func testProcessor() *processor.Processor {
+	return processor.NewProcessor(config.Config{
+		Conversion: config.ConversionConfig{Formats: []string{"png"}, Quality: 80},
+	})
+}
+
+func testImage(t *testing.T, w, h int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewNRGBA(image.Rect(0, 0, w, h))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// memoryUploader records uploads in memory
+type memoryUploader struct {
+	objects  map[string][]byte
+	types    map[string]string
+	metadata map[string]map[string]string
+	fail     bool
+}
+
+func (m *memoryUploader) UploadImageWithMetadata(ctx context.Context, key string, data []byte, contentType string, metadata map[string]string) error {
+	if m.fail {
+		return errors.New("upload failed")
+	}
+	m.objects[key] = data
+	m.types[key] = contentType
+	if m.metadata != nil {
+		m.metadata[key] = metadata
+	}
+	return nil
+}
+
+func TestKey(t *testing.T) {
+	tests := []struct {
+		key, ext, want string
+		width          int
+	}{
+		{"photo.jpg", ".webp", "photo.w640.webp", 640},
+		{"photos/2024/cat.PNG", ".avif", "photos/2024/cat.w320.avif", 320},
+		{"noext", ".webp", "noext.w100.webp", 100},
+		{"dir.v2/photo", ".png", "dir.v2/photo.w100.png", 100},
+	}
+	for _, tt := range tests {
+		if got := Key(tt.key, tt.width, tt.ext); got != tt.want {
+			t.Errorf("Key(%q, %d, %q) = %q, want %q", tt.key, tt.width, tt.ext, got, tt.want)
+		}
+		if !IsVariantKey(tt.want) {
+			t.Errorf("IsVariantKey(%q) = false, want true", tt.want)
+		}
+	}
+	if IsVariantKey("photo.webp") || IsVariantKey("photo.jpg") || IsVariantKey("www.example.png") {
+		t.Error("expected plain keys not to be variant keys")
+	}
+	if got := ManifestKey("photos/cat.jpg"); got != "photos/cat.variants.json" {
+		t.Errorf("ManifestKey = %q", got)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	proc := testProcessor()
+	valid := []config.VariantSetConfig{
+		{Widths: []int{320, 640}},
+		{Widths: []int{320}, Formats: []string{"webp", "png", "jpeg"}},
+	}
+	for _, set := range valid {
+		if err := Check(proc, set); err != nil {
+			t.Errorf("Check(%+v): %v", set, err)
+		}
+	}
+	invalid := []config.VariantSetConfig{
+		{},
+		{Widths: []int{320}, Formats: []string{"heic"}},
+		{Widths: []int{320}, Formats: []string{"webp", "webp-lossless"}},
+		{Widths: make([]int, MaxVariants+1)},
+	}
+	for _, set := range invalid {
+		if err := Check(proc, set); err == nil {
+			t.Errorf("Check(%+v): expected an error", set)
+		}
+	}
+}
+
+func TestGenerateAndStore(t *testing.T) {
+	proc := testProcessor()
+	set := config.VariantSetConfig{Widths: []int{100, 50, 50}, Formats: []string{"webp", "png"}}
+
+	variants, err := Generate(proc, "photos/cat.jpg", testImage(t, 200, 100), processor.ProcessOptions{Height: 999}, set)
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if len(variants) != 4 {
+		t.Fatalf("expected 4 variants (duplicate width skipped), got %d", len(variants))
+	}
+	want := map[string][2]int{
+		"photos/cat.w100.webp": {100, 50},
+		"photos/cat.w50.webp":  {50, 25},
+		"photos/cat.w100.png":  {100, 50},
+		"photos/cat.w50.png":   {50, 25},
+	}
+	for _, v := range variants {
+		size, ok := want[v.Key]
+		if !ok {
+			t.Errorf("unexpected variant %s", v.Key)
+			continue
+		}
+		if v.Width != size[0] || v.Height != size[1] {
+			t.Errorf("%s: expected %dx%d, got %dx%d", v.Key, size[0], size[1], v.Width, v.Height)
+		}
+		if v.Size == 0 || v.Size != len(v.data) {
+			t.Errorf("%s: expected size %d, got %d", v.Key, len(v.data), v.Size)
+		}
+	}
+
+	storage := &memoryUploader{objects: map[string][]byte{}, types: map[string]string{}}
+	manifest, err := Store(context.Background(), storage, "r2://bucket/photos/cat.jpg", "photos/cat.jpg", variants, "https://cdn.example.com/")
+	if err != nil {
+		t.Fatalf("Store failed: %v", err)
+	}
+	for key := range want {
+		if _, ok := storage.objects[key]; !ok {
+			t.Errorf("expected %s to be uploaded", key)
+		}
+	}
+	if storage.types["photos/cat.w50.png"] != "image/png" {
+		t.Errorf("expected image/png, got %s", storage.types["photos/cat.w50.png"])
+	}
+	if got := manifest.Srcset["webp"]; got != "https://cdn.example.com/photos/cat.w50.webp 50w, https://cdn.example.com/photos/cat.w100.webp 100w" {
+		t.Errorf("unexpected webp srcset: %s", got)
+	}
+
+	var stored Manifest
+	if err := json.Unmarshal(storage.objects["photos/cat.variants.json"], &stored); err != nil {
+		t.Fatalf("failed to parse stored manifest: %v", err)
+	}
+	if stored.Source != "r2://bucket/photos/cat.jpg" || len(stored.Variants) != 4 || stored.Srcset["png"] == "" {
+		t.Errorf("unexpected stored manifest: %+v", stored)
+	}
+
+	storage.fail = true
+	if _, err := Store(context.Background(), storage, "", "photos/cat.jpg", variants, ""); err == nil {
+		t.Error("expected an upload error")
+	}
+}
+
+func TestGenerate_NoUpscale(t *testing.T) {
+	proc := testProcessor()
+	set := config.VariantSetConfig{Widths: []int{100, 300, 400}, Formats: []string{"png"}}
+
+	variants, err := Generate(proc, "cat.png", testImage(t, 200, 100), processor.ProcessOptions{}, set)
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	var keys []string
+	for _, v := range variants {
+		keys = append(keys, v.Key)
+	}
+	if len(variants) != 2 || variants[1].Key != "cat.w200.png" || variants[1].Width != 200 || variants[1].Height != 100 {
+		t.Errorf("expected widths above the source to be clamped to 200, got %v", keys)
+	}
+
+	// The source width is the width after the operations before the resize
+	options := processor.ProcessOptions{Operations: []processor.Operation{{Name: processor.OpCrop, Args: []float64{0, 0, 80, 80}}}}
+	variants, err = Generate(proc, "cat.png", testImage(t, 200, 100), options, set)
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if len(variants) != 1 || variants[0].Key != "cat.w80.png" || variants[0].Width != 80 {
+		t.Errorf("expected a single 80px variant of the crop, got %+v", variants)
+	}
+}
+
+func TestGenerateAndStore_Placeholders(t *testing.T) {
+	proc := testProcessor()
+	set := config.VariantSetConfig{Widths: []int{100, 50}}
+	options := processor.ProcessOptions{Placeholders: &config.PlaceholderConfig{BlurHash: true}}
+
+	variants, err := Generate(proc, "cat.png", testImage(t, 200, 100), options, set)
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if variants[0].placeholders == nil || variants[1].placeholders != nil {
+		t.Fatalf("expected placeholders for the first variant only, got %v and %v", variants[0].placeholders, variants[1].placeholders)
+	}
+
+	storage := &memoryUploader{objects: map[string][]byte{}, types: map[string]string{}, metadata: map[string]map[string]string{}}
+	manifest, err := Store(context.Background(), storage, "r2://bucket/cat.png", "cat.png", variants, "")
+	if err != nil {
+		t.Fatalf("Store failed: %v", err)
+	}
+	if manifest.Placeholders == nil || manifest.Placeholders.BlurHash == "" {
+		t.Fatalf("expected a BlurHash in the manifest, got %+v", manifest.Placeholders)
+	}
+	for _, v := range variants {
+		if got := storage.metadata[v.Key]["blurhash"]; got != manifest.Placeholders.BlurHash {
+			t.Errorf("%s: expected blurhash metadata %q, got %q", v.Key, manifest.Placeholders.BlurHash, got)
+		}
+	}
+	if storage.metadata["cat.variants.json"] != nil {
+		t.Errorf("expected no metadata on the manifest, got %v", storage.metadata["cat.variants.json"])
+	}
+}
+
+func TestSrcset_NoBaseURL(t *testing.T) {
+	variants := []Variant{{Key: "a.w640.webp", Format: "webp", Width: 640}, {Key: "a.w320.webp", Format: "webp", Width: 320}}
+	if got := Srcset(variants, "")["webp"]; got != "a.w320.webp 320w, a.w640.webp 640w" {
+		t.Errorf("unexpected srcset: %s", got)
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"image-converting-server/auth"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Println("Usage: go run ./scripts/gen_api_key <name> [scope...]")
+		fmt.Println("Example: go run ./scripts/gen_api_key backend convert")
+		return
+	}
+
+	scopes := os.Args[2:]
+	if len(scopes) == 0 {
+		scopes = []string{"convert"}
+	}
+
+	key, err := auth.GenerateKey()
+	if err != nil {
+		log.Fatalf("Failed to generate key: %v", err)
+	}
+
+	// The key itself is shown once; only its hash goes into the config
+	fmt.Printf("API key (give this to the client): %s\n\n", key)
+	fmt.Println("Add to auth.keys or auth.keys_file:")
+	fmt.Printf("  - name: %q\n", os.Args[1])
+	fmt.Printf("    hash: %q\n", auth.HashKey(key))
+	quoted := make([]string, len(scopes))
+	for i, scope := range scopes {
+		quoted[i] = fmt.Sprintf("%q", scope)
+	}
+	fmt.Printf("    scopes: [%s]\n", strings.Join(quoted, ", "))
+}
@@ -0,0 +1,89 @@
+package signer
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestSignAndVerify(t *testing.T) {
+	s := New("test-secret")
+	now := time.Unix(1700000000, 0)
+
+	parse := func(t *testing.T, raw string) (string, url.Values) {
+		t.Helper()
+		u, err := url.Parse(raw)
+		if err != nil {
+			t.Fatalf("failed to parse signed URL: %v", err)
+		}
+		return u.Path, u.Query()
+	}
+
+	t.Run("Valid signature", func(t *testing.T) {
+		signed, err := s.SignURL("https://img.example.com/img/photos/cat.jpg?w=640&fit=cover", time.Time{})
+		if err != nil {
+			t.Fatalf("SignURL failed: %v", err)
+		}
+		path, query := parse(t, signed)
+		if err := s.Verify(path, query, now); err != nil {
+			t.Errorf("expected valid signature, got %v", err)
+		}
+	})
+
+	t.Run("Parameter order does not matter", func(t *testing.T) {
+		a := s.Sign("/img/a.jpg", url.Values{"w": {"10"}, "h": {"20"}})
+		b := s.Sign("/img/a.jpg", url.Values{"h": {"20"}, "w": {"10"}})
+		if a != b {
+			t.Error("expected identical signatures for reordered parameters")
+		}
+	})
+
+	t.Run("Tampered parameter", func(t *testing.T) {
+		signed, _ := s.SignURL("/img/a.jpg?w=100", time.Time{})
+		path, query := parse(t, signed)
+		query.Set("w", "4000")
+		if err := s.Verify(path, query, now); !errors.Is(err, ErrInvalidSignature) {
+			t.Errorf("expected ErrInvalidSignature, got %v", err)
+		}
+	})
+
+	t.Run("Tampered path", func(t *testing.T) {
+		signed, _ := s.SignURL("/img/a.jpg?w=100", time.Time{})
+		_, query := parse(t, signed)
+		if err := s.Verify("/img/b.jpg", query, now); !errors.Is(err, ErrInvalidSignature) {
+			t.Errorf("expected ErrInvalidSignature, got %v", err)
+		}
+	})
+
+	t.Run("Wrong secret", func(t *testing.T) {
+		signed, _ := New("other-secret").SignURL("/img/a.jpg?w=100", time.Time{})
+		path, query := parse(t, signed)
+		if err := s.Verify(path, query, now); !errors.Is(err, ErrInvalidSignature) {
+			t.Errorf("expected ErrInvalidSignature, got %v", err)
+		}
+	})
+
+	t.Run("Missing signature", func(t *testing.T) {
+		if err := s.Verify("/img/a.jpg", url.Values{"w": {"100"}}, now); !errors.Is(err, ErrMissingSignature) {
+			t.Errorf("expected ErrMissingSignature, got %v", err)
+		}
+	})
+
+	t.Run("Expiry", func(t *testing.T) {
+		signed, _ := s.SignURL("/img/a.jpg?w=100", now.Add(time.Minute))
+		path, query := parse(t, signed)
+		if err := s.Verify(path, query, now); err != nil {
+			t.Errorf("expected valid signature before expiry, got %v", err)
+		}
+		if err := s.Verify(path, query, now.Add(2*time.Minute)); !errors.Is(err, ErrExpired) {
+			t.Errorf("expected ErrExpired, got %v", err)
+		}
+
+		// Extending the expiry invalidates the signature
+		query.Set(ExpiresParam, "9999999999")
+		if err := s.Verify(path, query, now); !errors.Is(err, ErrInvalidSignature) {
+			t.Errorf("expected ErrInvalidSignature, got %v", err)
+		}
+	})
+}
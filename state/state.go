@@ -13,6 +13,7 @@ type State struct {
 	LastRunTime       time.Time `json:"last_run_time"`
 	ProcessedCount    int       `json:"processed_count"`
 	FailedCount       int       `json:"failed_count"`
+	SkippedCount      int       `json:"skipped_count"`
 }
 
 // NewState creates a new State with default values.
@@ -22,6 +23,7 @@ func NewState() *State {
 		LastRunTime:       time.Time{},
 		ProcessedCount:    0,
 		FailedCount:       0,
+		SkippedCount:      0,
 	}
 }
 
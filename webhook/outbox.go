@@ -0,0 +1,347 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"image-converting-server/config"
+)
+
+// Retry delays: the first retry waits baseDelay, doubling up to maxDelay
+const (
+	defaultBaseDelay = 5 * time.Second
+	defaultMaxDelay  = time.Hour
+)
+
+// maxInFlight bounds the deliveries sent at the same time, so that one slow
+// receiver does not hold up the events of the others
+const maxInFlight = 8
+
+// Doer sends HTTP requests; *http.Client and *fetch.Fetcher implement it
+type Doer interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// delivery is the outbox record of one event
+type delivery struct {
+	ID          string          `json:"id"`
+	Event       string          `json:"event"`
+	URL         string          `json:"url"`
+	Callback    bool            `json:"callback"` // Sent through the destination checks
+	Payload     json.RawMessage `json:"payload"`
+	Attempts    int             `json:"attempts"`
+	NextAttempt time.Time       `json:"next_attempt"`
+	LastError   string          `json:"last_error,omitempty"`
+}
+
+// Dispatcher sends events from an outbox directory, one JSON record per pending
+// delivery. A delivery succeeds on any 2xx response and is dropped after
+// webhook.max_attempts failures.
+type Dispatcher struct {
+	dir         string
+	url         string
+	secret      string
+	maxAttempts int
+	client      Doer // For webhook.url, which the operator configured
+	callbacks   Doer // For callback URLs given by API clients
+	baseDelay   time.Duration
+	maxDelay    time.Duration
+
+	mu       sync.Mutex
+	outbox   map[string]*delivery
+	inFlight map[string]bool
+	sending  sync.WaitGroup
+	wake     chan struct{}
+	ctx      context.Context
+	cancel   context.CancelFunc
+	done     chan struct{}
+}
+
+// NewDispatcher creates a dispatcher persisting to dir and loads the deliveries
+// pending there. Callback URLs are sent with callbacks, which should apply the
+// fetch destination checks.
+func NewDispatcher(dir string, cfg config.WebhookConfig, callbacks Doer) (*Dispatcher, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	d := &Dispatcher{
+		dir:         dir,
+		url:         cfg.URL,
+		secret:      cfg.Secret,
+		maxAttempts: max(cfg.MaxAttempts, 1),
+		client:      &http.Client{Timeout: time.Duration(cfg.TimeoutSeconds) * time.Second},
+		callbacks:   callbacks,
+		baseDelay:   defaultBaseDelay,
+		maxDelay:    defaultMaxDelay,
+		outbox:      make(map[string]*delivery),
+		inFlight:    make(map[string]bool),
+		wake:        make(chan struct{}, 1),
+		ctx:         ctx,
+		cancel:      cancel,
+		done:        make(chan struct{}),
+	}
+	if err := d.load(); err != nil {
+		cancel()
+		return nil, err
+	}
+	return d, nil
+}
+
+func (d *Dispatcher) load() error {
+	if err := os.MkdirAll(d.dir, 0755); err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(d.dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(d.dir, entry.Name()))
+		if err != nil {
+			return err
+		}
+		var dl delivery
+		if err := json.Unmarshal(data, &dl); err != nil || dl.ID+".json" != entry.Name() {
+			log.Printf("[WARN] Skipped unreadable webhook record %s: %v", entry.Name(), err)
+			continue
+		}
+		d.outbox[dl.ID] = &dl
+	}
+	if len(d.outbox) > 0 {
+		log.Printf("[INFO] Restored %d pending webhook deliveries", len(d.outbox))
+	}
+	return nil
+}
+
+// Enabled reports whether events are signed and sent at all
+func (d *Dispatcher) Enabled() bool {
+	return d.secret != ""
+}
+
+// Send queues event for callbackURL, or for webhook.url when callbackURL is
+// empty. It does nothing when there is no destination or no secret. The ID and
+// creation time are filled in when unset.
+func (d *Dispatcher) Send(callbackURL string, event Event) error {
+	target := callbackURL
+	if target == "" {
+		target = d.url
+	}
+	if target == "" || !d.Enabled() {
+		return nil
+	}
+	if event.ID == "" {
+		id, err := newID()
+		if err != nil {
+			return err
+		}
+		event.ID = id
+	}
+	if event.CreatedAt.IsZero() {
+		event.CreatedAt = time.Now().UTC()
+	}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	dl := &delivery{
+		ID:          event.ID,
+		Event:       event.Type,
+		URL:         target,
+		Callback:    callbackURL != "",
+		Payload:     payload,
+		NextAttempt: time.Now(),
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := d.save(dl); err != nil {
+		return err
+	}
+	d.outbox[dl.ID] = dl
+	d.notify()
+	return nil
+}
+
+// notify wakes the delivery loop
+func (d *Dispatcher) notify() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Start starts delivering in the background
+func (d *Dispatcher) Start() {
+	go d.run()
+}
+
+// Stop aborts the deliveries in flight, which stay in the outbox, and waits for
+// the background loop to exit
+func (d *Dispatcher) Stop() {
+	d.cancel()
+	<-d.done
+}
+
+func (d *Dispatcher) run() {
+	defer close(d.done)
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-d.ctx.Done():
+			d.sending.Wait()
+			return
+		case <-d.wake:
+		case <-timer.C:
+		}
+		wait := d.deliverDue()
+		timer.Reset(wait)
+	}
+}
+
+// deliverDue starts every delivery that is due, oldest first, up to maxInFlight
+// at a time, and returns how long to wait for the next one. Deliveries that are
+// due but over the limit start when a delivery in flight finishes.
+func (d *Dispatcher) deliverDue() time.Duration {
+	now := time.Now()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var due []*delivery
+	wait := d.maxDelay
+	for _, dl := range d.outbox {
+		switch {
+		case d.inFlight[dl.ID]:
+		case !dl.NextAttempt.After(now):
+			due = append(due, dl)
+		default:
+			wait = min(wait, dl.NextAttempt.Sub(now))
+		}
+	}
+	sort.Slice(due, func(i, j int) bool { return due[i].NextAttempt.Before(due[j].NextAttempt) })
+
+	for _, dl := range due {
+		if len(d.inFlight) >= maxInFlight {
+			break
+		}
+		d.inFlight[dl.ID] = true
+		d.sending.Add(1)
+		go d.attempt(dl)
+	}
+	return wait
+}
+
+// attempt delivers dl and records the outcome
+func (d *Dispatcher) attempt(dl *delivery) {
+	defer d.sending.Done()
+	err := d.deliver(dl)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.inFlight, dl.ID)
+	if d.ctx.Err() != nil {
+		// Stopping; an interrupted attempt does not count
+		return
+	}
+	defer d.notify()
+	if err == nil {
+		delete(d.outbox, dl.ID)
+		if err := os.Remove(d.path(dl.ID)); err != nil && !os.IsNotExist(err) {
+			log.Printf("[WARN] Failed to remove webhook record %s: %v", dl.ID, err)
+		}
+		return
+	}
+	dl.Attempts++
+	dl.LastError = err.Error()
+	if dl.Attempts >= d.maxAttempts {
+		log.Printf("[ERROR] Dropped webhook %s to %s after %d attempts: %v", dl.ID, dl.URL, dl.Attempts, err)
+		delete(d.outbox, dl.ID)
+		os.Remove(d.path(dl.ID))
+		return
+	}
+	dl.NextAttempt = time.Now().Add(d.backoff(dl.Attempts))
+	log.Printf("[WARN] Webhook %s to %s failed (attempt %d), retrying at %s: %v",
+		dl.ID, dl.URL, dl.Attempts, dl.NextAttempt.Format(time.RFC3339), err)
+	if err := d.save(dl); err != nil {
+		log.Printf("[ERROR] Failed to save webhook record %s: %v", dl.ID, err)
+	}
+}
+
+// deliver sends one signed POST
+func (d *Dispatcher) deliver(dl *delivery) error {
+	req, err := http.NewRequestWithContext(d.ctx, http.MethodPost, dl.URL, bytes.NewReader(dl.Payload))
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(IDHeader, dl.ID)
+	req.Header.Set(EventHeader, dl.Event)
+	req.Header.Set(TimestampHeader, fmt.Sprint(now.Unix()))
+	req.Header.Set(SignatureHeader, Sign(d.secret, now, dl.Payload))
+
+	client := d.client
+	if dl.Callback {
+		if d.callbacks == nil {
+			return fmt.Errorf("callback URLs are disabled")
+		}
+		client = d.callbacks
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// Drain a little of the body so the connection can be reused
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("bad status: %s", resp.Status)
+	}
+	return nil
+}
+
+// backoff returns the delay after the given number of failed attempts
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	delay := d.baseDelay
+	for i := 1; i < attempts && delay < d.maxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, d.maxDelay)
+}
+
+// save writes the delivery record atomically; the caller holds d.mu
+func (d *Dispatcher) save(dl *delivery) error {
+	data, err := json.MarshalIndent(dl, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmpFile := d.path(dl.ID) + ".tmp"
+	if err := os.WriteFile(tmpFile, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpFile, d.path(dl.ID))
+}
+
+func (d *Dispatcher) path(id string) string {
+	return filepath.Join(d.dir, id+".json")
+}
+
+func newID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate event id: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,224 @@
+package webhook
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"image-converting-server/config"
+)
+
+// receiver records the events it accepts and fails the first failures requests
+type receiver struct {
+	t        *testing.T
+	secret   string
+	mu       sync.Mutex
+	failures int
+	requests int
+	events   []Event
+	received chan struct{}
+}
+
+func newReceiver(t *testing.T, secret string, failures int) (*receiver, *httptest.Server) {
+	r := &receiver{t: t, secret: secret, failures: failures, received: make(chan struct{}, 10)}
+	server := httptest.NewServer(r)
+	t.Cleanup(server.Close)
+	return r, server
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := io.ReadAll(req.Body)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.requests++
+	if err := Verify(r.secret, req.Header, body, time.Now()); err != nil {
+		r.t.Errorf("delivery failed verification: %v", err)
+	}
+	if r.requests <= r.failures {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	var event Event
+	json.Unmarshal(body, &event)
+	if req.Header.Get(IDHeader) != event.ID || req.Header.Get(EventHeader) != event.Type {
+		r.t.Errorf("headers do not match the event: %v", req.Header)
+	}
+	r.events = append(r.events, event)
+	r.received <- struct{}{}
+}
+
+func (r *receiver) wait(t *testing.T) {
+	t.Helper()
+	select {
+	case <-r.received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a delivery")
+	}
+}
+
+func outboxLen(t *testing.T, dir string) int {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(entries)
+}
+
+func TestDispatcher_Delivers(t *testing.T) {
+	r, server := newReceiver(t, "secret", 0)
+	dir := t.TempDir()
+	d, err := NewDispatcher(dir, config.WebhookConfig{URL: server.URL, Secret: "secret", MaxAttempts: 3, TimeoutSeconds: 5}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Start()
+	defer d.Stop()
+
+	event := Event{Type: EventCronConverted, Status: "succeeded", Items: []Item{{Source: "r2://bucket/a.png", Destination: "r2://bucket/a.webp", OriginalSize: 10, ConvertedSize: 5}}}
+	if err := d.Send("", event); err != nil {
+		t.Fatal(err)
+	}
+	r.wait(t)
+
+	r.mu.Lock()
+	got := r.events[0]
+	r.mu.Unlock()
+	if got.ID == "" || got.CreatedAt.IsZero() || got.Items[0].Destination != "r2://bucket/a.webp" {
+		t.Errorf("unexpected event: %+v", got)
+	}
+	// The record is removed once accepted
+	deadline := time.Now().Add(5 * time.Second)
+	for outboxLen(t, dir) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected an empty outbox")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestDispatcher_Retries(t *testing.T) {
+	r, server := newReceiver(t, "secret", 2)
+	d, err := NewDispatcher(t.TempDir(), config.WebhookConfig{URL: server.URL, Secret: "secret", MaxAttempts: 5, TimeoutSeconds: 5}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.baseDelay = 10 * time.Millisecond
+	d.Start()
+	defer d.Stop()
+
+	d.Send("", Event{Type: EventJobFinished, Status: "succeeded"})
+	r.wait(t)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.requests != 3 || len(r.events) != 1 {
+		t.Errorf("expected 2 failures and 1 delivery, got %d requests and %d events", r.requests, len(r.events))
+	}
+}
+
+func TestDispatcher_GivesUp(t *testing.T) {
+	r, server := newReceiver(t, "secret", 100)
+	dir := t.TempDir()
+	d, err := NewDispatcher(dir, config.WebhookConfig{URL: server.URL, Secret: "secret", MaxAttempts: 2, TimeoutSeconds: 5}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.baseDelay = time.Millisecond
+	d.Start()
+	defer d.Stop()
+
+	d.Send("", Event{Type: EventJobFinished, Status: "failed"})
+	deadline := time.Now().Add(5 * time.Second)
+	for outboxLen(t, dir) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the delivery to be dropped")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.requests != 2 {
+		t.Errorf("expected 2 attempts, got %d", r.requests)
+	}
+}
+
+func TestDispatcher_SlowReceiver(t *testing.T) {
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		<-release
+	}))
+	t.Cleanup(slow.Close)
+	defer close(release)
+	r, server := newReceiver(t, "secret", 0)
+
+	d, err := NewDispatcher(t.TempDir(), config.WebhookConfig{URL: server.URL, Secret: "secret", MaxAttempts: 3, TimeoutSeconds: 30}, http.DefaultClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Start()
+	defer d.Stop()
+
+	// The event for the other receiver does not wait behind the slow callback
+	d.Send(slow.URL, Event{Type: EventJobFinished, Status: "succeeded"})
+	time.Sleep(20 * time.Millisecond)
+	d.Send("", Event{Type: EventCronConverted, Status: "succeeded"})
+	r.wait(t)
+}
+
+func TestDispatcher_PersistsOutbox(t *testing.T) {
+	dir := t.TempDir()
+	cfg := config.WebhookConfig{URL: "http://127.0.0.1:1/unreachable", Secret: "secret", MaxAttempts: 3, TimeoutSeconds: 5}
+	// Not started, so the delivery stays in the outbox
+	d, err := NewDispatcher(dir, cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, server := newReceiver(t, "secret", 0)
+	if err := d.Send(server.URL, Event{ID: "0123456789abcdef0123456789abcdef", Type: EventJobFinished, Status: "succeeded"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "0123456789abcdef0123456789abcdef.json")); err != nil {
+		t.Fatalf("expected an outbox record: %v", err)
+	}
+
+	// A restarted dispatcher sends it; callbacks go through the callback client
+	d, err = NewDispatcher(dir, cfg, http.DefaultClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Start()
+	defer d.Stop()
+	r.wait(t)
+	if r.events[0].ID != "0123456789abcdef0123456789abcdef" {
+		t.Errorf("unexpected event: %+v", r.events[0])
+	}
+}
+
+func TestDispatcher_Disabled(t *testing.T) {
+	dir := t.TempDir()
+	d, err := NewDispatcher(dir, config.WebhookConfig{URL: "https://hooks.example.com"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Enabled() {
+		t.Error("expected a dispatcher without a secret to be disabled")
+	}
+	if err := d.Send("", Event{Type: EventJobFinished}); err != nil || outboxLen(t, dir) != 0 {
+		t.Errorf("expected nothing to be queued, got %v", err)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	d := &Dispatcher{baseDelay: time.Second, maxDelay: 10 * time.Second}
+	for attempts, want := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 4: 8 * time.Second, 5: 10 * time.Second, 30: 10 * time.Second} {
+		if got := d.backoff(attempts); got != want {
+			t.Errorf("backoff(%d) = %v, want %v", attempts, got, want)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+// Package webhook delivers signed completion events of jobs and cron
+// conversions to HTTP endpoints. Events wait in an outbox on disk until the
+// receiver accepts them, so deliveries survive restarts and are retried with
+// exponential backoff. Receivers import it to verify signatures.
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Event types
+const (
+	EventJobFinished   = "job.finished"
+	EventCronConverted = "cron.converted"
+)
+
+// Headers sent with every delivery
+const (
+	IDHeader        = "X-Webhook-ID"
+	EventHeader     = "X-Webhook-Event"
+	TimestampHeader = "X-Webhook-Timestamp"
+	SignatureHeader = "X-Webhook-Signature"
+)
+
+// Tolerance is how far the signed timestamp may be from the receiver's clock
+const Tolerance = 5 * time.Minute
+
+// Verification errors
+var (
+	ErrMissingSignature = errors.New("signature is missing")
+	ErrInvalidSignature = errors.New("signature is invalid")
+	ErrExpired          = errors.New("signature timestamp is out of tolerance")
+)
+
+// Event is the JSON payload of a delivery
+type Event struct {
+	ID        string    `json:"id"` // Unique per event; repeated deliveries share it
+	Type      string    `json:"type"`
+	CreatedAt time.Time `json:"created_at"`
+	JobID     string    `json:"job_id,omitempty"`
+	Status    string    `json:"status"` // "succeeded", "failed" or "canceled"
+	Error     string    `json:"error,omitempty"`
+	Items     []Item    `json:"items"`
+}
+
+// Item is the outcome of one source image
+type Item struct {
+	Source        string   `json:"source"`
+	Destination   string   `json:"destination,omitempty"` // The manifest for variant sets
+	Variants      []string `json:"variants,omitempty"`    // Keys of the rendered variants
+	OriginalSize  int      `json:"original_size,omitempty"`
+	ConvertedSize int      `json:"converted_size,omitempty"`
+	Error         string   `json:"error,omitempty"`
+}
+
+// Sign returns the SignatureHeader value for a payload sent at timestamp:
+// "sha256=" and the hex HMAC-SHA256 of "{unix seconds}.{payload}"
+func Sign(secret string, timestamp time.Time, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp.Unix(), 10)))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks the signature headers of a delivery against its body
+func Verify(secret string, header http.Header, payload []byte, now time.Time) error {
+	signature := header.Get(SignatureHeader)
+	if signature == "" {
+		return ErrMissingSignature
+	}
+	unix, err := strconv.ParseInt(header.Get(TimestampHeader), 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	timestamp := time.Unix(unix, 0)
+	if !hmac.Equal([]byte(signature), []byte(Sign(secret, timestamp, payload))) {
+		return ErrInvalidSignature
+	}
+	if d := now.Sub(timestamp); d > Tolerance || d < -Tolerance {
+		return ErrExpired
+	}
+	return nil
+}
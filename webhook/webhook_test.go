@@ -0,0 +1,53 @@
+package webhook
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSignAndVerify(t *testing.T) {
+	payload := []byte(`{"id":"1"}`)
+	now := time.Unix(1700000000, 0)
+	header := http.Header{}
+	header.Set(TimestampHeader, strconv.FormatInt(now.Unix(), 10))
+	header.Set(SignatureHeader, Sign("secret", now, payload))
+
+	if err := Verify("secret", header, payload, now.Add(time.Minute)); err != nil {
+		t.Errorf("expected a valid signature, got %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		secret  string
+		payload []byte
+		now     time.Time
+		want    error
+	}{
+		{"Wrong secret", "other", payload, now, ErrInvalidSignature},
+		{"Tampered payload", "secret", []byte(`{"id":"2"}`), now, ErrInvalidSignature},
+		{"Replayed later", "secret", payload, now.Add(Tolerance + time.Second), ErrExpired},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := Verify(tt.secret, header, tt.payload, tt.now); !errors.Is(err, tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, err)
+			}
+		})
+	}
+
+	if err := Verify("secret", http.Header{}, payload, now); !errors.Is(err, ErrMissingSignature) {
+		t.Errorf("expected ErrMissingSignature, got %v", err)
+	}
+}
+
+func TestSign_Format(t *testing.T) {
+	// Receivers in other languages compute HMAC-SHA256 over "{timestamp}.{body}"
+	got := Sign("key", time.Unix(1, 0), []byte("body"))
+	want := "sha256=91b5374b153842ad05b2c4eab9349b8321b14703165bd3fb8b034dfb8be98ae5"
+	if got != want {
+		t.Errorf("expected %s, got %s", want, got)
+	}
+}